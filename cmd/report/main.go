@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cnych/admission-registry/pkg"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// 在开启准入校验之前，列出集群中现有的 Pod 哪些会被当前的白名单策略拒绝
+func main() {
+	var (
+		kubeconfig    string
+		namespace     string
+		defaultAction string
+	)
+	flag.StringVar(&kubeconfig, "kubeconfig", os.Getenv("KUBECONFIG"), "Path to a kubeconfig file, uses in-cluster config when empty.")
+	flag.StringVar(&namespace, "namespace", metav1.NamespaceAll, "Only report pods in this namespace.")
+	flag.StringVar(&defaultAction, "defaultAction", pkg.DefaultActionDeny, "Action for images matching no whitelist entry: allow or deny.")
+	flag.Parse()
+
+	clientset, err := pkg.InitKubernetesCliFromKubeconfig(kubeconfig)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	requireDigest, digestRequirements, err := pkg.ParseRequireDigest(os.Getenv("REQUIRE_DIGEST"))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	allowedTagPattern, err := pkg.ParseTagPattern(os.Getenv("ALLOWED_TAG_REGEX"))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	allowedGroupRanges, err := pkg.ParseIDRanges(os.Getenv("ALLOWED_GROUP_RANGES"))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	allowedHostPorts, err := pkg.ParseIDRanges(os.Getenv("ALLOWED_HOST_PORTS"))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	maxResourceRequests, err := pkg.ParseResourceRequests(os.Getenv("MAX_CPU"), os.Getenv("MAX_MEMORY"))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	whsrv := &pkg.WebhookServer{
+		WhiteListRegistries: pkg.SplitList(os.Getenv("WHITELIST_REGISTRIES")),
+		DefaultAction:       defaultAction,
+		DenyListRegistries:  pkg.SplitList(os.Getenv("DENYLIST_REGISTRIES")),
+		RepositoryAllowlist: pkg.SplitList(os.Getenv("REPOSITORY_ALLOWLIST")),
+		RegistryMirrors:     pkg.ParseKeyValues(os.Getenv("REGISTRY_MIRRORS")),
+		RequiredTagPrefixes: pkg.ParseKeyValues(os.Getenv("REQUIRED_TAG_PREFIXES")),
+
+		DenyInsecureRegistries:   os.Getenv("DENY_INSECURE_REGISTRIES") == "true",
+		InsecureRegistries:       pkg.SplitList(os.Getenv("INSECURE_REGISTRIES")),
+		RequireDigest:            requireDigest,
+		DigestRequirements:       digestRequirements,
+		RejectLatestTag:          os.Getenv("REJECT_LATEST_TAG") == "true",
+		AllowedTagPattern:        allowedTagPattern,
+		AllowedGroupRanges:       allowedGroupRanges,
+		CriticalNamespaces:       pkg.SplitList(os.Getenv("CRITICAL_NAMESPACES")),
+		AllowedPriorityClasses:   pkg.SplitList(os.Getenv("ALLOWED_PRIORITY_CLASSES")),
+		HostPortPolicy:           os.Getenv("HOST_PORT_POLICY"),
+		AllowedHostPorts:         allowedHostPorts,
+		RequireVerifiedPublisher: os.Getenv("REQUIRE_VERIFIED_PUBLISHER") == "true",
+		VerifiedPublishers:       pkg.SplitList(os.Getenv("VERIFIED_PUBLISHERS")),
+		DenyDockerHub:            os.Getenv("DENY_DOCKER_HUB") == "true",
+		DockerHubAllowedImages:   pkg.SplitList(os.Getenv("DOCKER_HUB_ALLOWED_IMAGES")),
+		DisallowedCapabilities:   pkg.SplitList(os.Getenv("DISALLOWED_CAPABILITIES")),
+		DeniedSecrets:            pkg.SplitList(os.Getenv("DENIED_SECRETS")),
+
+		DisallowedNodeSelectorKeys: pkg.SplitList(os.Getenv("DISALLOWED_NODE_SELECTOR_KEYS")),
+		DisallowedTolerationKeys:   pkg.SplitList(os.Getenv("DISALLOWED_TOLERATION_KEYS")),
+
+		DenyHostNamespaces:            os.Getenv("DENY_HOST_NAMESPACES") == "true",
+		HostNamespaceExemptNamespaces: pkg.SplitList(os.Getenv("HOST_NAMESPACE_EXEMPT_NAMESPACES")),
+		DenyPrivileged:                os.Getenv("DENY_PRIVILEGED") == "true",
+		PrivilegedExemptNamespaces:    pkg.SplitList(os.Getenv("PRIVILEGED_EXEMPT_NAMESPACES")),
+
+		RequireProbes:     os.Getenv("REQUIRE_PROBES") == "true",
+		RequireBothProbes: os.Getenv("REQUIRE_BOTH_PROBES") == "true",
+
+		MaxResourceRequests: maxResourceRequests,
+
+		RequiredLabels: pkg.SplitList(os.Getenv("REQUIRED_LABELS")),
+
+		DenyDefaultServiceAccount:             os.Getenv("DENY_DEFAULT_SERVICE_ACCOUNT"),
+		DefaultServiceAccountExemptNamespaces: pkg.SplitList(os.Getenv("DEFAULT_SERVICE_ACCOUNT_EXEMPT_NAMESPACES")),
+
+		RegistryClient:      pkg.NewHTTPRegistryClient(10 * time.Second),
+		RequireMultiArch:    os.Getenv("REQUIRE_MULTI_ARCH") == "true",
+		RequiredImageLabels: pkg.SplitList(os.Getenv("REQUIRED_IMAGE_LABELS")),
+	}
+	if err := whsrv.SetWhiteListRegistries(whsrv.WhiteListRegistries); err != nil {
+		log.Panic(err)
+	}
+
+	violations, err := Report(context.Background(), clientset, whsrv, namespace)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tPOD\tMESSAGE")
+	for _, v := range violations {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", v.Namespace, v.Pod, v.Message)
+	}
+	w.Flush()
+
+	if len(violations) > 0 {
+		os.Exit(1)
+	}
+}
+
+type Violation struct {
+	Namespace string
+	Pod       string
+	Message   string
+}
+
+// Report 列出所有 Pod 并逐个执行 validate 逻辑，返回会被拒绝的 Pod
+func Report(ctx context.Context, clientset kubernetes.Interface, whsrv *pkg.WebhookServer, namespace string) ([]Violation, error) {
+	var violations []Violation
+	opts := metav1.ListOptions{Limit: 500}
+	for {
+		pods, err := clientset.CoreV1().Pods(namespace).List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if v, ok := validatePod(whsrv, pod); ok {
+				violations = append(violations, v)
+			}
+		}
+		if pods.Continue == "" {
+			break
+		}
+		opts.Continue = pods.Continue
+	}
+	return violations, nil
+}
+
+func validatePod(whsrv *pkg.WebhookServer, pod *corev1.Pod) (Violation, bool) {
+	resp, err := whsrv.ValidatePod(pod)
+	if err != nil {
+		return Violation{Namespace: pod.Namespace, Pod: pod.Name, Message: err.Error()}, true
+	}
+	if resp.Allowed {
+		return Violation{}, false
+	}
+	var message string
+	if resp.Result != nil {
+		message = resp.Result.Message
+	}
+	return Violation{Namespace: pod.Namespace, Pod: pod.Name, Message: message}, true
+}
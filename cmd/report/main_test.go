@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cnych/admission-registry/pkg"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newPod(namespace, name, image string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: image}}},
+	}
+}
+
+func TestReport(t *testing.T) {
+	clientset := fake.NewSimpleClientset([]runtime.Object{
+		newPod("default", "web", "docker.io/library/nginx:1.19"),
+		newPod("default", "pause", "gcr.io/google-containers/pause:3.2"),
+		newPod("kube-system", "etcd", "quay.io/coreos/etcd:v3.4"),
+		newPod("kube-system", "dns", "docker.io/coredns/coredns:1.7.0"),
+	}...)
+	whsrv := &pkg.WebhookServer{}
+	if err := whsrv.SetWhiteListRegistries([]string{"docker.io"}); err != nil {
+		t.Fatalf("set whitelist: %v", err)
+	}
+
+	tests := []struct {
+		namespace string
+		want      []string
+	}{
+		{namespace: metav1.NamespaceAll, want: []string{"default/pause", "kube-system/etcd"}},
+		{namespace: "kube-system", want: []string{"kube-system/etcd"}},
+		{namespace: "empty"},
+	}
+	for _, tt := range tests {
+		violations, err := Report(context.Background(), clientset, whsrv, tt.namespace)
+		if err != nil {
+			t.Fatalf("report %q: %v", tt.namespace, err)
+		}
+		var got []string
+		for _, v := range violations {
+			got = append(got, v.Namespace+"/"+v.Pod)
+			if !strings.Contains(v.Message, "untrusted registry") {
+				t.Errorf("message for %s/%s = %q, want an untrusted registry message", v.Namespace, v.Pod, v.Message)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("report %q = %v, want %v", tt.namespace, got, tt.want)
+		}
+	}
+}
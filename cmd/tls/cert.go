@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/cnych/admission-registry/pkg"
+)
+
+// certOptions 是生成 CA 和服务端证书的参数
+type certOptions struct {
+	KeyType          string
+	CAValidityDays   int
+	CertValidityDays int
+	DNSNames         []string
+	CommonName       string    // 服务端证书的 CommonName，来自 Service 的 DNS 名称
+	Subject          pkix.Name // CA 和服务端证书共用的 subject，服务端证书的 CommonName 总是使用 CommonName
+}
+
+// generateCertBundle 生成自签名的 CA 以及由它签发的服务端证书
+func generateCertBundle(opts certOptions) (*pkg.CertBundle, error) {
+	// CA 配置
+	subject := opts.Subject
+	// 随机生成序列号，避免多次运行时生成序列号相同的证书
+	caSerial, err := randomSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	ca := &x509.Certificate{
+		SerialNumber:          caSerial,
+		Subject:               subject,
+		NotBefore:             now, // 有效期
+		NotAfter:              now.AddDate(0, 0, opts.CAValidityDays),
+		IsCA:                  true, // 根证书
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	// 生成CA私钥
+	caPrivKey, err := generateKey(opts.KeyType)
+	if err != nil {
+		return nil, err
+	}
+
+	// 创建自签名的 CA 证书
+	caBytes, err := x509.CreateCertificate(rand.Reader, ca, ca, caPrivKey.Public(), caPrivKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// 编码证书文件
+	caPEM := new(bytes.Buffer)
+	if err := pem.Encode(caPEM, &pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: caBytes,
+	}); err != nil {
+		return nil, err
+	}
+
+	// 服务端的证书配置
+	subject.CommonName = opts.CommonName
+	certSerial, err := randomSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	cert := &x509.Certificate{
+		DNSNames:     opts.DNSNames,
+		SerialNumber: certSerial,
+		Subject:      subject,
+		NotBefore:    now,
+		NotAfter:     now.AddDate(0, 0, opts.CertValidityDays),
+		SubjectKeyId: []byte{1, 2, 3, 4, 6},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	// 生成服务端的私钥
+	serverPrivKey, err := generateKey(opts.KeyType)
+	if err != nil {
+		return nil, err
+	}
+
+	// 对服务端私钥签名
+	serverCertBytes, err := x509.CreateCertificate(rand.Reader, cert, ca, serverPrivKey.Public(), caPrivKey)
+	if err != nil {
+		return nil, err
+	}
+	serverCertPEM := new(bytes.Buffer)
+	if err := pem.Encode(serverCertPEM, &pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: serverCertBytes,
+	}); err != nil {
+		return nil, err
+	}
+
+	serverPrivKeyBlock, err := privateKeyPEMBlock(serverPrivKey)
+	if err != nil {
+		return nil, err
+	}
+	serverPrivKeyPEM := new(bytes.Buffer)
+	if err := pem.Encode(serverPrivKeyPEM, serverPrivKeyBlock); err != nil {
+		return nil, err
+	}
+
+	return &pkg.CertBundle{
+		CertPEM: serverCertPEM.Bytes(),
+		KeyPEM:  serverPrivKeyPEM.Bytes(),
+		CAPEM:   caPEM.Bytes(),
+	}, nil
+}
+
+// serviceDNSNames 返回 webhook Service 的 DNS 名称以及证书的 CommonName，
+// service 和 namespace 为空时分别使用 admission-registry 和 default
+func serviceDNSNames(service, namespace string) ([]string, string) {
+	if service == "" {
+		service = "admission-registry"
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+	commonName := fmt.Sprintf("%s.%s.svc", service, namespace)
+	return []string{
+		service,
+		fmt.Sprintf("%s.%s", service, namespace),
+		commonName,
+		commonName + ".cluster.local",
+	}, commonName
+}
+
+// randomSerialNumber 生成 128 位的随机证书序列号
+func randomSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// reusableCertBundleFrom 依次尝试从 stores 中加载可以复用的证书，返回第一个可以复用的，
+// 并把它保存到其他的 store 中（例如从 Secret 中复用时写入为空的 emptyDir），
+// 所有 store 都不能复用时返回每个 store 的原因
+func reusableCertBundleFrom(ctx context.Context, stores []pkg.CertStore, caBundle []byte, dnsName string, now time.Time) (*pkg.CertBundle, error) {
+	var reasons []string
+	for i, store := range stores {
+		bundle, err := reusableCertBundle(ctx, store, caBundle, dnsName, now)
+		if err == nil {
+			for j, other := range stores {
+				if j == i {
+					continue
+				}
+				if err := other.Save(ctx, bundle); err != nil {
+					return nil, fmt.Errorf("save reused certificates to %T: %v", other, err)
+				}
+			}
+			return bundle, nil
+		}
+		reasons = append(reasons, fmt.Sprintf("%T: %v", store, err))
+	}
+	return nil, fmt.Errorf("%s", strings.Join(reasons, "; "))
+}
+
+// reusableCertBundle 从 store 加载已有的证书，证书和私钥匹配、由 CA 签发、包含 dnsName、没有过期，
+// 并且 CA 与 webhook 配置中的 caBundle 一致时返回它，否则返回原因
+func reusableCertBundle(ctx context.Context, store pkg.CertStore, caBundle []byte, dnsName string, now time.Time) (*pkg.CertBundle, error) {
+	bundle, err := store.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(bundle.CAPEM) == 0 {
+		return nil, fmt.Errorf("no CA certificate stored")
+	}
+	if !bytes.Equal(bytes.TrimSpace(bundle.CAPEM), bytes.TrimSpace(caBundle)) {
+		return nil, fmt.Errorf("stored CA certificate does not match the caBundle of the webhook configurations")
+	}
+	keyPair, err := bundle.KeyPair()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if now.After(cert.NotAfter) {
+		return nil, fmt.Errorf("server certificate expired at %s", cert.NotAfter)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(bundle.CAPEM) {
+		return nil, fmt.Errorf("can't parse stored CA certificate")
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		DNSName:     dnsName,
+		CurrentTime: now,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}); err != nil {
+		return nil, fmt.Errorf("server certificate is not signed by the stored CA: %v", err)
+	}
+	return bundle, nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cnych/admission-registry/pkg"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// parseBundle 解析生成的 CA 证书和服务端证书
+func parseBundle(t *testing.T, opts certOptions) (ca, cert *x509.Certificate) {
+	t.Helper()
+	bundle, err := generateCertBundle(opts)
+	if err != nil {
+		t.Fatalf("generate cert bundle: %v", err)
+	}
+	parse := func(data []byte) *x509.Certificate {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			t.Fatalf("no PEM block in %q", data)
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatalf("parse certificate: %v", err)
+		}
+		return c
+	}
+	return parse(bundle.CAPEM), parse(bundle.CertPEM)
+}
+
+func testCertOptions() certOptions {
+	dnsNames, commonName := serviceDNSNames("", "")
+	return certOptions{
+		KeyType:          keyTypeECDSA,
+		CAValidityDays:   3650,
+		CertValidityDays: 365,
+		DNSNames:         dnsNames,
+		CommonName:       commonName,
+		Subject:          pkix.Name{Organization: []string{"ydzs.io"}},
+	}
+}
+
+func TestCertValidity(t *testing.T) {
+	tests := []struct {
+		name     string
+		caDays   int
+		certDays int
+	}{
+		{name: "defaults", caDays: 3650, certDays: 365},
+		{name: "short lived", caDays: 30, certDays: 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testCertOptions()
+			opts.CAValidityDays, opts.CertValidityDays = tt.caDays, tt.certDays
+			before := time.Now()
+			ca, cert := parseBundle(t, opts)
+			// 证书时间精确到秒
+			within := func(got time.Time, days int) bool {
+				want := before.AddDate(0, 0, days)
+				return got.After(want.Add(-2*time.Second)) && got.Before(want.Add(time.Minute))
+			}
+			if !within(ca.NotAfter, tt.caDays) {
+				t.Errorf("CA NotAfter = %s, want about %d days from now", ca.NotAfter, tt.caDays)
+			}
+			if !within(cert.NotAfter, tt.certDays) {
+				t.Errorf("certificate NotAfter = %s, want about %d days from now", cert.NotAfter, tt.certDays)
+			}
+		})
+	}
+}
+
+func TestCertSerialNumbers(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		ca, cert := parseBundle(t, testCertOptions())
+		for _, serial := range []string{ca.SerialNumber.String(), cert.SerialNumber.String()} {
+			if seen[serial] {
+				t.Fatalf("serial number %s generated twice", serial)
+			}
+			seen[serial] = true
+		}
+	}
+}
+
+func TestKeyTypes(t *testing.T) {
+	tests := []struct {
+		keyType   string
+		blockType string
+	}{
+		{keyType: keyTypeRSA, blockType: "RSA PRIVATE KEY"},
+		{keyType: keyTypeECDSA, blockType: "EC PRIVATE KEY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keyType, func(t *testing.T) {
+			opts := testCertOptions()
+			opts.KeyType = tt.keyType
+			bundle, err := generateCertBundle(opts)
+			if err != nil {
+				t.Fatalf("generate cert bundle: %v", err)
+			}
+			block, _ := pem.Decode(bundle.KeyPEM)
+			if block == nil || block.Type != tt.blockType {
+				t.Fatalf("key PEM block = %+v, want type %s", block, tt.blockType)
+			}
+			// 生成的 tls.key 可以被 tls.X509KeyPair（也就是 tls.LoadX509KeyPair）加载
+			if _, err := tls.X509KeyPair(bundle.CertPEM, bundle.KeyPEM); err != nil {
+				t.Errorf("load key pair: %v", err)
+			}
+		})
+	}
+
+	if _, err := generateCertBundle(certOptions{KeyType: "dsa"}); err == nil {
+		t.Errorf("generate with an unsupported key type succeeded, want error")
+	}
+}
+
+func TestReusableCertBundle(t *testing.T) {
+	ctx := context.Background()
+	opts := testCertOptions()
+	bundle, err := generateCertBundle(opts)
+	if err != nil {
+		t.Fatalf("generate cert bundle: %v", err)
+	}
+	other, err := generateCertBundle(opts)
+	if err != nil {
+		t.Fatalf("generate cert bundle: %v", err)
+	}
+	// Job 重新运行时 emptyDir 中没有证书，只有 Secret 中保存了上一次生成的证书
+	emptyFiles := func(t *testing.T) *pkg.FileCertStore {
+		dir, err := ioutil.TempDir("", "admission-registry-tls")
+		if err != nil {
+			t.Fatalf("create temp dir: %v", err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		return &pkg.FileCertStore{
+			CertFile: filepath.Join(dir, "tls.crt"), KeyFile: filepath.Join(dir, "tls.key"), CAFile: filepath.Join(dir, "ca.crt"),
+		}
+	}
+	secret := &pkg.SecretCertStore{Clientset: fake.NewSimpleClientset(), Namespace: "default", Name: "admission-registry-tls"}
+	if err := secret.Save(ctx, bundle); err != nil {
+		t.Fatalf("save secret: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		secret   bool
+		files    bool
+		caBundle []byte
+		dnsName  string
+		now      time.Time
+		reused   bool
+	}{
+		{name: "reuse from secret", secret: true, files: true, caBundle: bundle.CAPEM, dnsName: opts.CommonName, now: time.Now(), reused: true},
+		{name: "empty file store only", files: true, caBundle: bundle.CAPEM, dnsName: opts.CommonName, now: time.Now()},
+		{name: "webhook uses another CA", secret: true, caBundle: other.CAPEM, dnsName: opts.CommonName, now: time.Now()},
+		{name: "dns name changed", secret: true, caBundle: bundle.CAPEM, dnsName: "admission-registry.other.svc", now: time.Now()},
+		{name: "expired", secret: true, caBundle: bundle.CAPEM, dnsName: opts.CommonName, now: time.Now().AddDate(0, 0, opts.CertValidityDays+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				stores []pkg.CertStore
+				files  *pkg.FileCertStore
+			)
+			if tt.secret {
+				stores = append(stores, secret)
+			}
+			if tt.files {
+				files = emptyFiles(t)
+				stores = append(stores, files)
+			}
+			got, err := reusableCertBundleFrom(ctx, stores, tt.caBundle, tt.dnsName, tt.now)
+			if tt.reused {
+				if err != nil || !bytes.Equal(got.CertPEM, bundle.CertPEM) {
+					t.Fatalf("reusable bundle = %v, %v, want the stored bundle", got, err)
+				}
+				// 复用的证书写入为空的文件 store，webhook server 启动时才能加载
+				if files != nil {
+					saved, err := files.Load(ctx)
+					if err != nil || !bytes.Equal(saved.CertPEM, bundle.CertPEM) || !bytes.Equal(saved.KeyPEM, bundle.KeyPEM) || !bytes.Equal(saved.CAPEM, bundle.CAPEM) {
+						t.Errorf("file store = %v, %v, want the reused bundle", saved, err)
+					}
+				}
+				return
+			}
+			if err == nil {
+				t.Errorf("reused a bundle that should be regenerated")
+			}
+		})
+	}
+}
+
+func TestServiceDNSNames(t *testing.T) {
+	tests := []struct {
+		name       string
+		service    string
+		namespace  string
+		dnsNames   []string
+		commonName string
+	}{
+		{name: "defaults", dnsNames: []string{
+			"admission-registry", "admission-registry.default", "admission-registry.default.svc", "admission-registry.default.svc.cluster.local",
+		}, commonName: "admission-registry.default.svc"},
+		{name: "custom service and namespace", service: "registry-webhook", namespace: "policy", dnsNames: []string{
+			"registry-webhook", "registry-webhook.policy", "registry-webhook.policy.svc", "registry-webhook.policy.svc.cluster.local",
+		}, commonName: "registry-webhook.policy.svc"},
+		{name: "custom namespace only", namespace: "policy", dnsNames: []string{
+			"admission-registry", "admission-registry.policy", "admission-registry.policy.svc", "admission-registry.policy.svc.cluster.local",
+		}, commonName: "admission-registry.policy.svc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dnsNames, commonName := serviceDNSNames(tt.service, tt.namespace)
+			if !reflect.DeepEqual(dnsNames, tt.dnsNames) || commonName != tt.commonName {
+				t.Fatalf("serviceDNSNames = %v, %s, want %v, %s", dnsNames, commonName, tt.dnsNames, tt.commonName)
+			}
+			// 生成的服务端证书对所有的 DNS 名称有效
+			opts := testCertOptions()
+			opts.DNSNames, opts.CommonName = dnsNames, commonName
+			_, cert := parseBundle(t, opts)
+			for _, name := range dnsNames {
+				if err := cert.VerifyHostname(name); err != nil {
+					t.Errorf("certificate is not valid for %s: %v", name, err)
+				}
+			}
+		})
+	}
+}
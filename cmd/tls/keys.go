@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+const (
+	keyTypeRSA   = "rsa"
+	keyTypeECDSA = "ecdsa"
+)
+
+// generateKey 生成 RSA-4096 或者 ECDSA P-256 私钥
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case keyTypeRSA:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case keyTypeECDSA:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	return nil, fmt.Errorf("unsupported key type %q, expect %s or %s", keyType, keyTypeRSA, keyTypeECDSA)
+}
+
+// privateKeyPEMBlock 根据私钥类型编码为 RSA PRIVATE KEY 或者 EC PRIVATE KEY
+func privateKeyPEMBlock(key crypto.Signer) (*pem.Block, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}, nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", key)
+}
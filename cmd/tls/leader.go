@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// runWithLeaderElection 获得 namespace/name 这个 Lease 之后执行一次 run，执行完成后释放 Lease；
+// 其他实例在获得 Lease 之前一直等待，获得之后再执行时已有的证书可以直接复用；parent 结束时停止等待，不再执行 run
+func runWithLeaderElection(parent context.Context, clientset kubernetes.Interface, namespace, name string, run func(ctx context.Context)) error {
+	identity, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Client:     clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	// 没有获得过 Lease 时退出也会调用 OnStoppedLeading，只在真正持有过 Lease 时记录释放日志
+	var leading bool
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				leading = true
+				log.Printf("%s acquired lease %s/%s", identity, namespace, name)
+				run(ctx)
+				cancel()
+			},
+			OnStoppedLeading: func() {
+				if !leading {
+					return
+				}
+				log.Printf("%s released lease %s/%s", identity, namespace, name)
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					log.Printf("lease %s/%s is held by %s, waiting", namespace, name, leader)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	elector.Run(ctx)
+	return nil
+}
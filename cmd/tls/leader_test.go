@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRunWithLeaderElection(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	var runs int32
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := runWithLeaderElection(ctx, clientset, "default", "admission-registry-tls", func(ctx context.Context) {
+		atomic.AddInt32(&runs, 1)
+	}); err != nil {
+		t.Fatalf("run with leader election: %v", err)
+	}
+	if runs != 1 {
+		t.Errorf("runs = %d, want 1", runs)
+	}
+	// 执行完成后释放 Lease，其他实例不需要等待 Lease 过期
+	lease, err := clientset.CoordinationV1().Leases("default").Get(context.Background(), "admission-registry-tls", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get lease: %v", err)
+	}
+	if lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity != "" {
+		t.Errorf("lease holder = %s, want the lease released", *lease.Spec.HolderIdentity)
+	}
+}
+
+func TestRunWithLeaderElectionNotLeader(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatalf("hostname: %v", err)
+	}
+	holder, duration := hostname+"-other", int32(3600)
+	now := metav1.NewMicroTime(time.Now())
+	clientset := fake.NewSimpleClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "admission-registry-tls"},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &duration,
+			AcquireTime:          &now,
+			RenewTime:            &now,
+		},
+	})
+	// Lease 被其他实例持有时只等待，不执行 bootstrap
+	var runs int32
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := runWithLeaderElection(ctx, clientset, "default", "admission-registry-tls", func(ctx context.Context) {
+		atomic.AddInt32(&runs, 1)
+	}); err != nil {
+		t.Fatalf("run with leader election: %v", err)
+	}
+	if runs != 0 {
+		t.Errorf("runs = %d, want 0 while another instance holds the lease", runs)
+	}
+	lease, err := clientset.CoordinationV1().Leases("default").Get(context.Background(), "admission-registry-tls", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get lease: %v", err)
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != holder {
+		t.Errorf("lease holder = %v, want %s", lease.Spec.HolderIdentity, holder)
+	}
+}
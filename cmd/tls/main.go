@@ -3,138 +3,202 @@ package main
 import (
 	"bytes"
 	"context"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
 	"crypto/x509/pkix"
-	"encoding/pem"
+	"flag"
+	"fmt"
 	"log"
-	"math/big"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/cnych/admission-registry/pkg"
 	admissionv1 "k8s.io/api/admissionregistration/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 )
 
 func main() {
-	// CA 配置
-	subject := pkix.Name{
-		Country:            []string{"CN"},
-		Province:           []string{"Beijing"},
-		Locality:           []string{"Beijing"},
-		Organization:       []string{"ydzs.io"},
-		OrganizationalUnit: []string{"ydzs.io"},
-	}
-	ca := &x509.Certificate{
-		SerialNumber:          big.NewInt(2021),
-		Subject:               subject,
-		NotBefore:             time.Now(), // 有效期
-		NotAfter:              time.Now().AddDate(10, 0, 0),
-		IsCA:                  true, // 根证书
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
-		BasicConstraintsValid: true,
-	}
-
-	// 生成CA私钥
-	caPrivKey, err := rsa.GenerateKey(rand.Reader, 4096)
-	if err != nil {
-		log.Panic(err)
+	// render 子命令只输出 webhook 配置对象的 YAML，不生成证书也不访问集群
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		if err := renderAdmissionConfig(os.Stdout, os.Getenv("CA_BUNDLE_FILE")); err != nil {
+			log.Panic(err)
+		}
+		return
 	}
 
-	// 创建自签名的 CA 证书
-	caBytes, err := x509.CreateCertificate(rand.Reader, ca, ca, &caPrivKey.PublicKey, caPrivKey)
-	if err != nil {
-		log.Panic(err)
-	}
+	var (
+		caValidityDays, certValidityDays int
+		keyType, kubeconfig, leaseName   string
+		force, enableLeaderElection      bool
+		timeout                          time.Duration
+		caOrg, caOrgUnit, caCountry      string
+		caProvince, caLocality, caCN     string
+	)
+	flag.IntVar(&caValidityDays, "ca-validity-days", 3650, "Validity period of the generated CA certificate in days.")
+	flag.IntVar(&certValidityDays, "cert-validity-days", 365, "Validity period of the generated server certificate in days.")
+	flag.StringVar(&keyType, "key-type", keyTypeRSA, "Type of the generated CA and server keys: rsa (RSA-4096) or ecdsa (ECDSA P-256).")
+	flag.BoolVar(&force, "force", false, "Always generate new certificates even if the existing ones are still valid.")
+	flag.StringVar(&kubeconfig, "kubeconfig", os.Getenv("KUBECONFIG"), "Path to a kubeconfig file, uses in-cluster config when empty.")
+	flag.DurationVar(&timeout, "timeout", 30*time.Second, "Timeout of each group of API server calls.")
+	flag.StringVar(&caOrg, "ca-org", "ydzs.io", "Organization of the CA and server certificate subjects.")
+	flag.StringVar(&caOrgUnit, "ca-org-unit", "ydzs.io", "Organizational unit of the CA and server certificate subjects.")
+	flag.StringVar(&caCountry, "ca-country", "CN", "Country of the CA and server certificate subjects.")
+	flag.StringVar(&caProvince, "ca-province", "Beijing", "Province of the CA and server certificate subjects.")
+	flag.StringVar(&caLocality, "ca-locality", "Beijing", "Locality of the CA and server certificate subjects.")
+	flag.StringVar(&caCN, "ca-common-name", "", "Common name of the CA certificate, the server certificate always uses the service DNS name.")
+	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false, "Use a Lease in WEBHOOK_NAMESPACE so only one instance generates certificates and updates the webhook configurations at a time.")
+	flag.StringVar(&leaseName, "leader-election-lease", "admission-registry-tls", "Name of the Lease used for leader election.")
+	flag.Parse()
 
-	// 编码证书文件
-	caPEM := new(bytes.Buffer)
-	if err := pem.Encode(caPEM, &pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: caBytes,
-	}); err != nil {
+	// 防止在非预期的命名空间中意外安装 webhook 配置
+	if err := checkWebhookNamespace(os.Getenv("WEBHOOK_NAMESPACE"), pkg.SplitList(os.Getenv("ALLOWED_WEBHOOK_NAMESPACES"))); err != nil {
 		log.Panic(err)
 	}
 
-	dnsNames := []string{"admission-registry",
-		"admission-registry.default",
-		"admission-registry.default.svc",
-		"admission-registry.default.svc.cluster.local",
-	}
-	commonName := "admission-registry.default.svc"
-	// 服务端的证书配置
-	subject.CommonName = commonName
-	cert := &x509.Certificate{
-		DNSNames:     dnsNames,
-		SerialNumber: big.NewInt(2020),
-		Subject:      subject,
-		NotBefore:    time.Now(),
-		NotAfter:     time.Now().AddDate(1, 0, 0),
-		SubjectKeyId: []byte{1, 2, 3, 4, 6},
-		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:     x509.KeyUsageDigitalSignature,
-	}
-
-	// 生成服务端的私钥
-	serverPrivKey, err := rsa.GenerateKey(rand.Reader, 4096)
+	clientset, err := pkg.InitKubernetesCliFromKubeconfig(kubeconfig)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	// 对服务端私钥签名
-	serverCertBytes, err := x509.CreateCertificate(rand.Reader, cert, ca, &serverPrivKey.PublicKey, caPrivKey)
-	if err != nil {
-		log.Panic(err)
-	}
-	serverCertPEM := new(bytes.Buffer)
-	if err := pem.Encode(serverCertPEM, &pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: serverCertBytes,
-	}); err != nil {
-		log.Panic(err)
-	}
+	// 生成或者复用证书，并创建 webhook 配置对象
+	bootstrap := func(ctx context.Context) {
+		dnsNames, commonName := serviceDNSNames(os.Getenv("WEBHOOK_SERVICE"), os.Getenv("WEBHOOK_NAMESPACE"))
 
-	serverPrivKeyPEM := new(bytes.Buffer)
-	if err := pem.Encode(serverPrivKeyPEM, &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(serverPrivKey),
-	}); err != nil {
-		log.Panic(err)
-	}
+		// 同时保存 CA 证书，下次运行时用来判断已有的证书是否可以继续使用
+		stores := []pkg.CertStore{
+			&pkg.FileCertStore{CertFile: "/etc/webhook/certs/tls.crt", KeyFile: "/etc/webhook/certs/tls.key", CAFile: "/etc/webhook/certs/ca.crt"},
+		}
+		// 证书还可以保存到 Secret 中，命名空间默认与 webhook 相同；
+		// /etc/webhook/certs 通常是 emptyDir，Job 重新运行时已经为空，所以复用证书时优先从 Secret 中加载
+		if secretName := os.Getenv("CERT_SECRET_NAME"); secretName != "" {
+			secretNamespace := os.Getenv("CERT_SECRET_NAMESPACE")
+			if secretNamespace == "" {
+				secretNamespace = os.Getenv("WEBHOOK_NAMESPACE")
+			}
+			stores = append([]pkg.CertStore{&pkg.SecretCertStore{Clientset: clientset, Namespace: secretNamespace, Name: secretName}}, stores...)
+		}
+		// 已有的证书仍然有效并且 webhook 配置引用的是同一个 CA 时直接复用，只重新更新 webhook 配置，
+		// 避免 Job 重新运行时替换掉 webhook 配置中已经生效的 caBundle
+		var bundle *pkg.CertBundle
+		if !force {
+			callCtx, cancel := context.WithTimeout(ctx, timeout)
+			caBundle, err := configuredCABundle(callCtx, clientset)
+			if err == nil {
+				bundle, err = reusableCertBundleFrom(callCtx, stores, caBundle, commonName, time.Now())
+			}
+			cancel()
+			if err != nil {
+				log.Printf("can't reuse existing certificates, generating new ones: %v", err)
+			}
+		}
+		if bundle != nil {
+			log.Println("existing webhook server tls is still valid, reuse it")
+		} else {
+			bundle, err = generateCertBundle(certOptions{
+				KeyType:          keyType,
+				CAValidityDays:   caValidityDays,
+				CertValidityDays: certValidityDays,
+				DNSNames:         dnsNames,
+				CommonName:       commonName,
+				Subject: pkix.Name{
+					CommonName:         caCN,
+					Country:            pkg.SplitList(caCountry),
+					Province:           pkg.SplitList(caProvince),
+					Locality:           pkg.SplitList(caLocality),
+					Organization:       pkg.SplitList(caOrg),
+					OrganizationalUnit: pkg.SplitList(caOrgUnit),
+				},
+			})
+			if err != nil {
+				log.Panic(err)
+			}
+			for _, store := range stores {
+				if err := store.Save(ctx, bundle); err != nil {
+					log.Panic(err)
+				}
+			}
+			log.Println("webhook server tls generated successfully")
+		}
 
-	// 已经生成了CA server.pem server-key.pem
+		// CA 证书可以额外发布到 ConfigMap 中，供需要信任 webhook 证书的其他组件使用
+		if configMapName := os.Getenv("CA_CONFIGMAP_NAME"); configMapName != "" {
+			configMapNamespace := os.Getenv("CA_CONFIGMAP_NAMESPACE")
+			if configMapNamespace == "" {
+				configMapNamespace = os.Getenv("WEBHOOK_NAMESPACE")
+			}
+			if err := pkg.SaveCAConfigMap(ctx, clientset, configMapNamespace, configMapName, bundle.CAPEM); err != nil {
+				log.Panic(err)
+			}
+			log.Printf("webhook CA saved to configmap %s/%s", configMapNamespace, configMapName)
+		}
 
-	if err := os.MkdirAll("/etc/webhook/certs/", 0666); err != nil {
-		log.Panic(err)
-	}
+		callCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		if err := CreateAdmissionConfig(callCtx, clientset, bytes.NewBuffer(bundle.CAPEM)); err != nil {
+			log.Panic(err)
+		}
 
-	if err := pkg.WriteFile("/etc/webhook/certs/tls.crt", serverCertPEM.Bytes()); err != nil {
-		log.Panic(err)
-	}
+		log.Println("webhook admission configuration object generated successfully")
 
-	if err := pkg.WriteFile("/etc/webhook/certs/tls.key", serverPrivKeyPEM.Bytes()); err != nil {
-		log.Panic(err)
+		// 开启 RECONCILE_WEBHOOKS 后不再退出，持续监听 webhook 配置对象，被删除或者 caBundle 被修改时重新写入，
+		// 同时处理的配置对象数量由 MAX_CONCURRENT_RECONCILES 控制（默认 1）；开启 leader election 时只有持有 Lease 的实例执行
+		if os.Getenv("RECONCILE_WEBHOOKS") == "true" {
+			workers := 1
+			if value := os.Getenv("MAX_CONCURRENT_RECONCILES"); value != "" {
+				n, err := strconv.Atoi(value)
+				if err != nil {
+					log.Panicf("invalid MAX_CONCURRENT_RECONCILES %q: %v", value, err)
+				}
+				workers = n
+			}
+			ctx, cancel := context.WithCancel(ctx)
+			signalChan := make(chan os.Signal, 1)
+			signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+			go func() {
+				<-signalChan
+				cancel()
+			}()
+			reconciler := newWebhookReconciler(clientset, os.Getenv("VALIDATE_CONFIG"), os.Getenv("MUTATE_CONFIG"), bundle.CAPEM, workers,
+				func(ctx context.Context) error {
+					callCtx, cancel := context.WithTimeout(ctx, timeout)
+					defer cancel()
+					return CreateAdmissionConfig(callCtx, clientset, bytes.NewBuffer(bundle.CAPEM))
+				})
+			log.Printf("reconciling webhook configurations with %d workers", workers)
+			if err := reconciler.Run(ctx); err != nil {
+				log.Panic(err)
+			}
+		}
 	}
 
-	log.Println("webhook server tls generated successfully")
-
-	if err := CreateAdmissionConfig(caPEM); err != nil {
+	if !enableLeaderElection {
+		bootstrap(context.Background())
+		return
+	}
+	// 多个实例同时运行时只有获得 Lease 的实例执行 bootstrap，避免同时生成不同的 CA 并写入冲突的 caBundle
+	if err := runWithLeaderElection(context.Background(), clientset, os.Getenv("WEBHOOK_NAMESPACE"), leaseName, bootstrap); err != nil {
 		log.Panic(err)
 	}
-
-	log.Println("webhook admission configuration object generated successfully")
 }
 
-func CreateAdmissionConfig(caCert *bytes.Buffer) error {
-	clientset, err := pkg.InitKubernetesCli()
-	if err != nil {
-		return err
+// checkWebhookNamespace 配置了允许的命名空间列表时，要求 WEBHOOK_NAMESPACE 在列表中
+func checkWebhookNamespace(namespace string, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	for _, ns := range allowed {
+		if ns == namespace {
+			return nil
+		}
 	}
+	return fmt.Errorf("webhook namespace %q is not in the allowed namespaces %v", namespace, allowed)
+}
 
+// admissionConfigs 根据环境变量构建 ValidatingWebhookConfiguration/MutatingWebhookConfiguration，没有配置名称的对象返回 nil
+func admissionConfigs(caBundle []byte) (*admissionv1.ValidatingWebhookConfiguration, *admissionv1.MutatingWebhookConfiguration, error) {
 	var (
 		webhookNamespace, _ = os.LookupEnv("WEBHOOK_NAMESPACE")
 		validateCfgName, _  = os.LookupEnv("VALIDATE_CONFIG")
@@ -143,45 +207,140 @@ func CreateAdmissionConfig(caCert *bytes.Buffer) error {
 		validatePath, _     = os.LookupEnv("VALIDATE_PATH")
 		mutatePath, _       = os.LookupEnv("MUTATE_PATH")
 	)
+	// 默认路径与 webhook server 注册的路径保持一致
+	if validatePath == "" {
+		validatePath = "/validate"
+	}
+	if mutatePath == "" {
+		mutatePath = "/mutate"
+	}
+
+	// 一个配置对象中可以包含多个 webhook 条目，通过 VALIDATE_WEBHOOKS_FILE/MUTATE_WEBHOOKS_FILE 定义
+	validateSpecs, err := webhookSpecs(os.Getenv("VALIDATE_WEBHOOKS_FILE"), defaultValidateWebhookSpecs(validatePath))
+	if err != nil {
+		return nil, nil, err
+	}
+	mutateSpecs, err := webhookSpecs(os.Getenv("MUTATE_WEBHOOKS_FILE"), defaultMutateWebhookSpecs(mutatePath))
+	if err != nil {
+		return nil, nil, err
+	}
+	// webhook 不可用时 apiserver 的处理方式，非关键的部署可以设置为 Ignore
+	failurePolicy, err := parseFailurePolicy(os.Getenv("FAILURE_POLICY"))
+	if err != nil {
+		return nil, nil, err
+	}
+	// 没有在 webhook 条目中设置 namespaceSelector 时，跳过 IGNORE_NAMESPACE_SELECTOR 排除的命名空间，避免拦截系统组件
+	selector, err := parseNamespaceSelector(os.Getenv("IGNORE_NAMESPACE_SELECTOR"))
+	if err != nil {
+		return nil, nil, err
+	}
+	timeoutSeconds, err := parseTimeoutSeconds(os.Getenv("WEBHOOK_TIMEOUT_SECONDS"))
+	if err != nil {
+		return nil, nil, err
+	}
+	reinvocationPolicy, err := parseReinvocationPolicy(os.Getenv("REINVOCATION_POLICY"))
+	if err != nil {
+		return nil, nil, err
+	}
+	matchPolicy, err := parseMatchPolicy(os.Getenv("MATCH_POLICY"))
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := webhookOptions{
+		CABundle:           caBundle,
+		Service:            webhookService,
+		Namespace:          webhookNamespace,
+		FailurePolicy:      failurePolicy,
+		NamespaceSelector:  selector,
+		TimeoutSeconds:     timeoutSeconds,
+		MatchPolicy:        matchPolicy,
+		ReinvocationPolicy: reinvocationPolicy,
+	}
 
-	ctx := context.Background()
+	var (
+		validateConfig *admissionv1.ValidatingWebhookConfiguration
+		mutateConfig   *admissionv1.MutatingWebhookConfiguration
+	)
 	if validateCfgName != "" {
-		// 创建 ValidatingWebhookConfiguration
-		validateConfig := &admissionv1.ValidatingWebhookConfiguration{
+		validateConfig = &admissionv1.ValidatingWebhookConfiguration{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: admissionv1.SchemeGroupVersion.String(),
+				Kind:       "ValidatingWebhookConfiguration",
+			},
 			ObjectMeta: metav1.ObjectMeta{
 				Name: validateCfgName,
 			},
-			Webhooks: []admissionv1.ValidatingWebhook{
-				{
-					Name: "io.ydzs.admission-registry",
-					ClientConfig: admissionv1.WebhookClientConfig{
-						CABundle: caCert.Bytes(),
-						Service: &admissionv1.ServiceReference{
-							Name:      webhookService,
-							Namespace: webhookNamespace,
-							Path:      &validatePath,
-						},
-					},
-					Rules: []admissionv1.RuleWithOperations{
-						{
-							Operations: []admissionv1.OperationType{admissionv1.Create},
-							Rule: admissionv1.Rule{
-								APIGroups:   []string{""},
-								APIVersions: []string{"v1"},
-								Resources:   []string{"pods"},
-							},
-						},
-					},
-					AdmissionReviewVersions: []string{"v1"},
-					SideEffects: func() *admissionv1.SideEffectClass {
-						se := admissionv1.SideEffectClassNone
-						return &se
-					}(),
-				},
+			Webhooks: buildValidatingWebhooks(validateSpecs, opts),
+		}
+	}
+	if mutateCfgName != "" {
+		mutateConfig = &admissionv1.MutatingWebhookConfiguration{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: admissionv1.SchemeGroupVersion.String(),
+				Kind:       "MutatingWebhookConfiguration",
 			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name: mutateCfgName,
+			},
+			Webhooks: buildMutatingWebhooks(mutateSpecs, opts),
+		}
+	}
+	return validateConfig, mutateConfig, nil
+}
+
+// configuredCABundle 返回集群中已有的 webhook 配置引用的 caBundle，所有 webhook 条目使用的 caBundle 必须一致
+func configuredCABundle(ctx context.Context, clientset kubernetes.Interface) ([]byte, error) {
+	var caBundles [][]byte
+	if name := os.Getenv("VALIDATE_CONFIG"); name != "" {
+		config, err := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
 		}
+		for _, webhook := range config.Webhooks {
+			caBundles = append(caBundles, webhook.ClientConfig.CABundle)
+		}
+	}
+	if name := os.Getenv("MUTATE_CONFIG"); name != "" {
+		config, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, webhook := range config.Webhooks {
+			caBundles = append(caBundles, webhook.ClientConfig.CABundle)
+		}
+	}
+	if len(caBundles) == 0 {
+		return nil, fmt.Errorf("no existing webhook configurations")
+	}
+	for _, caBundle := range caBundles[1:] {
+		if !bytes.Equal(caBundle, caBundles[0]) {
+			return nil, fmt.Errorf("webhook configurations reference different caBundles")
+		}
+	}
+	return caBundles[0], nil
+}
+
+// CreateAdmissionConfig 创建或者更新 webhook 配置对象，ctx 超时后返回包含超时原因的错误而不是一直阻塞
+func CreateAdmissionConfig(ctx context.Context, clientset kubernetes.Interface, caCert *bytes.Buffer) error {
+	if err := createAdmissionConfig(ctx, clientset, caCert); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("create webhook admission configuration: %w: %v", ctx.Err(), err)
+		}
+		return err
+	}
+	return nil
+}
+
+func createAdmissionConfig(ctx context.Context, clientset kubernetes.Interface, caCert *bytes.Buffer) error {
+	validateConfig, mutateConfig, err := admissionConfigs(caCert.Bytes())
+	if err != nil {
+		return err
+	}
+
+	if validateConfig != nil {
+		// 创建 ValidatingWebhookConfiguration
 		validateAdmissionClient := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations()
-		if _, err := validateAdmissionClient.Get(ctx, validateCfgName, metav1.GetOptions{}); err != nil {
+		if _, err := validateAdmissionClient.Get(ctx, validateConfig.Name, metav1.GetOptions{}); err != nil {
 			if errors.IsNotFound(err) {
 				if _, err := validateAdmissionClient.Create(ctx, validateConfig, metav1.CreateOptions{}); err != nil {
 					return err
@@ -196,43 +355,10 @@ func CreateAdmissionConfig(caCert *bytes.Buffer) error {
 		}
 	}
 
-	if mutateCfgName != "" {
+	if mutateConfig != nil {
 		// 创建 MutatingWebhookConfiguration
-		mutateConfig := &admissionv1.MutatingWebhookConfiguration{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: mutateCfgName,
-			},
-			Webhooks: []admissionv1.MutatingWebhook{
-				{
-					Name: "io.ydzs.admission-registry-mutate",
-					ClientConfig: admissionv1.WebhookClientConfig{
-						CABundle: caCert.Bytes(),
-						Service: &admissionv1.ServiceReference{
-							Name:      webhookService,
-							Namespace: webhookNamespace,
-							Path:      &mutatePath,
-						},
-					},
-					Rules: []admissionv1.RuleWithOperations{
-						{
-							Operations: []admissionv1.OperationType{admissionv1.Create},
-							Rule: admissionv1.Rule{
-								APIGroups:   []string{"apps", ""},
-								APIVersions: []string{"v1"},
-								Resources:   []string{"deployments", "services"},
-							},
-						},
-					},
-					AdmissionReviewVersions: []string{"v1"},
-					SideEffects: func() *admissionv1.SideEffectClass {
-						se := admissionv1.SideEffectClassNone
-						return &se
-					}(),
-				},
-			},
-		}
 		mutateAdmissionClient := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations()
-		if _, err := mutateAdmissionClient.Get(ctx, mutateCfgName, metav1.GetOptions{}); err != nil {
+		if _, err := mutateAdmissionClient.Get(ctx, mutateConfig.Name, metav1.GetOptions{}); err != nil {
 			if errors.IsNotFound(err) {
 				if _, err := mutateAdmissionClient.Create(ctx, mutateConfig, metav1.CreateOptions{}); err != nil {
 					return err
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCreateAdmissionConfig(t *testing.T) {
+	setEnv(t, "VALIDATE_CONFIG", "admission-registry")
+	setEnv(t, "MUTATE_CONFIG", "admission-registry-mutate")
+	setEnv(t, "WEBHOOK_SERVICE", "admission-registry")
+	setEnv(t, "WEBHOOK_NAMESPACE", "default")
+	clientset := fake.NewSimpleClientset()
+	// 第一次运行创建配置对象，再次运行时更新已有的对象
+	for i := 0; i < 2; i++ {
+		if err := CreateAdmissionConfig(context.Background(), clientset, bytes.NewBufferString("ca")); err != nil {
+			t.Fatalf("run %d: create admission config: %v", i, err)
+		}
+	}
+	var creates, updates int
+	for _, action := range clientset.Actions() {
+		switch action.GetVerb() {
+		case "create":
+			creates++
+		case "update":
+			updates++
+		}
+	}
+	if creates != 2 || updates != 2 {
+		t.Errorf("creates = %d, updates = %d, want 2 and 2", creates, updates)
+	}
+}
+
+func TestCreateAdmissionConfigTimeout(t *testing.T) {
+	setEnv(t, "VALIDATE_CONFIG", "admission-registry")
+	setEnv(t, "WEBHOOK_SERVICE", "admission-registry")
+	setEnv(t, "WEBHOOK_NAMESPACE", "default")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	clientset := fake.NewSimpleClientset()
+	// 模拟没有响应的 API Server：请求一直阻塞到 ctx 超时，和真实的客户端一样返回 ctx 的错误
+	clientset.PrependReactor("get", "validatingwebhookconfigurations", func(k8stesting.Action) (bool, runtime.Object, error) {
+		<-ctx.Done()
+		return true, nil, ctx.Err()
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- CreateAdmissionConfig(ctx, clientset, bytes.NewBufferString("ca"))
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("err = %v, want context.DeadlineExceeded", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("CreateAdmissionConfig blocked after the timeout")
+	}
+}
+
+func TestCheckWebhookNamespace(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		allowed   []string
+		wantErr   bool
+	}{
+		{name: "allowed namespace", namespace: "admission", allowed: []string{"kube-system", "admission"}},
+		{name: "disallowed namespace", namespace: "default", allowed: []string{"kube-system", "admission"}, wantErr: true},
+		{name: "empty namespace", namespace: "", allowed: []string{"admission"}, wantErr: true},
+		// 没有配置允许的命名空间时不限制
+		{name: "not configured", namespace: "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkWebhookNamespace(tt.namespace, tt.allowed)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	validatingKind = "validating"
+	mutatingKind   = "mutating"
+
+	// 同一个配置对象连续漂移时的退避时间，从 reconcileBaseDelay 开始翻倍，最长 reconcileMaxDelay
+	reconcileBaseDelay = time.Second
+	reconcileMaxDelay  = 5 * time.Minute
+)
+
+// webhookReconciler 监听 webhook 配置对象，被删除或者 caBundle 被修改时重新写入期望的配置；
+// 事件先进入限速的 workqueue，同一个配置对象的重复事件会被合并并按指数退避处理，避免突发的漂移事件压垮 apiserver
+type webhookReconciler struct {
+	clientset    kubernetes.Interface
+	validateName string
+	mutateName   string
+	caBundle     []byte
+	workers      int
+	apply        func(ctx context.Context) error // 重新创建或者更新 webhook 配置对象
+	queue        workqueue.RateLimitingInterface
+}
+
+func newWebhookReconciler(clientset kubernetes.Interface, validateName, mutateName string, caBundle []byte, workers int, apply func(ctx context.Context) error) *webhookReconciler {
+	if workers < 1 {
+		workers = 1
+	}
+	return &webhookReconciler{
+		clientset:    clientset,
+		validateName: validateName,
+		mutateName:   mutateName,
+		caBundle:     caBundle,
+		workers:      workers,
+		apply:        apply,
+		queue:        workqueue.NewNamedRateLimitingQueue(newReconcileRateLimiter(), "webhook-reconciler"),
+	}
+}
+
+// newReconcileRateLimiter 每个配置对象按指数退避，所有配置对象再共享一个整体的令牌桶
+func newReconcileRateLimiter() workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(reconcileBaseDelay, reconcileMaxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+	)
+}
+
+// Run 启动 informer 和 workers 个 worker，直到 ctx 结束
+func (r *webhookReconciler) Run(ctx context.Context) error {
+	defer r.queue.ShutDown()
+
+	factory := informers.NewSharedInformerFactory(r.clientset, 0)
+	factory.Admissionregistration().V1().ValidatingWebhookConfigurations().Informer().AddEventHandler(r.eventHandler(validatingKind, r.validateName))
+	factory.Admissionregistration().V1().MutatingWebhookConfigurations().Informer().AddEventHandler(r.eventHandler(mutatingKind, r.mutateName))
+	factory.Start(ctx.Done())
+	for informerType, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return fmt.Errorf("failed to sync informer for %v", informerType)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < r.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r.processNextItem(ctx) {
+			}
+		}()
+	}
+	<-ctx.Done()
+	r.queue.ShutDown()
+	wg.Wait()
+	return nil
+}
+
+// eventHandler 只把名称为 name 的配置对象的事件加入队列，没有配置名称时忽略这类对象
+func (r *webhookReconciler) eventHandler(kind, name string) cache.ResourceEventHandler {
+	enqueue := func(obj interface{}) {
+		key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil || name == "" || key != name {
+			return
+		}
+		r.queue.AddRateLimited(kind + "/" + name)
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+		DeleteFunc: enqueue,
+	}
+}
+
+// processNextItem 处理队列中的一个配置对象，队列关闭时返回 false；
+// 配置没有漂移时清除退避计数，修复过的配置对象再次漂移时等待更长的时间
+func (r *webhookReconciler) processNextItem(ctx context.Context) bool {
+	item, shutdown := r.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer r.queue.Done(item)
+
+	key := item.(string)
+	drifted, err := r.drifted(ctx, key)
+	if err == nil && drifted {
+		log.Printf("webhook configuration %s drifted, reconciling", key)
+		err = r.apply(ctx)
+	}
+	if err != nil {
+		log.Printf("failed to reconcile webhook configuration %s: %v", key, err)
+		r.queue.AddRateLimited(key)
+		return true
+	}
+	if !drifted {
+		r.queue.Forget(key)
+	}
+	return true
+}
+
+// drifted 判断配置对象是否被删除，或者其中的 webhook 没有使用当前的 CA
+func (r *webhookReconciler) drifted(ctx context.Context, key string) (bool, error) {
+	var caBundles [][]byte
+	switch key {
+	case validatingKind + "/" + r.validateName:
+		config, err := r.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, r.validateName, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		for _, webhook := range config.Webhooks {
+			caBundles = append(caBundles, webhook.ClientConfig.CABundle)
+		}
+	case mutatingKind + "/" + r.mutateName:
+		config, err := r.clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, r.mutateName, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		for _, webhook := range config.Webhooks {
+			caBundles = append(caBundles, webhook.ClientConfig.CABundle)
+		}
+	default:
+		return false, nil
+	}
+	if len(caBundles) == 0 {
+		return true, nil
+	}
+	for _, caBundle := range caBundles {
+		if !bytes.Equal(caBundle, r.caBundle) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcileRateLimiter(t *testing.T) {
+	limiter := newReconcileRateLimiter()
+	key := validatingKind + "/admission-registry"
+	// 同一个配置对象的重复事件按指数退避
+	want := reconcileBaseDelay
+	for i := 0; i < 5; i++ {
+		if got := limiter.When(key); got != want {
+			t.Fatalf("delay of event %d = %s, want %s", i+1, got, want)
+		}
+		want *= 2
+	}
+	// 其他配置对象不受影响，Forget 之后重新从最短的退避时间开始
+	if got := limiter.When(mutatingKind + "/admission-registry-mutate"); got != reconcileBaseDelay {
+		t.Errorf("delay of another config = %s, want %s", got, reconcileBaseDelay)
+	}
+	limiter.Forget(key)
+	if got := limiter.When(key); got != reconcileBaseDelay {
+		t.Errorf("delay after forget = %s, want %s", got, reconcileBaseDelay)
+	}
+
+	// 重复的事件会累加退避计数
+	r := newWebhookReconciler(fake.NewSimpleClientset(), "admission-registry", "", []byte("ca"), 1, nil)
+	defer r.queue.ShutDown()
+	for i := 0; i < 3; i++ {
+		r.queue.AddRateLimited(key)
+	}
+	if got := r.queue.NumRequeues(key); got != 3 {
+		t.Errorf("requeues = %d, want 3", got)
+	}
+}
+
+func TestReconcileDrift(t *testing.T) {
+	ca := []byte("current-ca")
+	validating := func(caBundle []byte) *admissionv1.ValidatingWebhookConfiguration {
+		return &admissionv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "admission-registry"},
+			Webhooks:   []admissionv1.ValidatingWebhook{{Name: "io.ydzs.admission-registry", ClientConfig: admissionv1.WebhookClientConfig{CABundle: caBundle}}},
+		}
+	}
+	key := validatingKind + "/admission-registry"
+	tests := []struct {
+		name     string
+		existing *admissionv1.ValidatingWebhookConfiguration
+		applied  bool
+		requeues int
+	}{
+		{name: "in sync", existing: validating(ca), requeues: 0},
+		{name: "caBundle overwritten", existing: validating([]byte("other-ca")), applied: true, requeues: 1},
+		{name: "deleted", applied: true, requeues: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			if tt.existing != nil {
+				clientset = fake.NewSimpleClientset(tt.existing)
+			}
+			applied := false
+			r := newWebhookReconciler(clientset, "admission-registry", "", ca, 1, func(ctx context.Context) error {
+				applied = true
+				return nil
+			})
+			defer r.queue.ShutDown()
+			// 之前已经收到过一次这个配置对象的事件
+			r.queue.AddRateLimited(key)
+			r.queue.Add(key)
+			if !r.processNextItem(context.Background()) {
+				t.Fatalf("queue shut down")
+			}
+			if applied != tt.applied {
+				t.Errorf("applied = %v, want %v", applied, tt.applied)
+			}
+			// 修复过的配置对象保留退避计数，再次漂移时等待更长的时间
+			if got := r.queue.NumRequeues(key); got != tt.requeues {
+				t.Errorf("requeues = %d, want %d", got, tt.requeues)
+			}
+		})
+	}
+}
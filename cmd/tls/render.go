@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"sigs.k8s.io/yaml"
+)
+
+// renderAdmissionConfig 将 CreateAdmissionConfig 会创建的 webhook 配置对象以 YAML 格式输出，
+// 方便 GitOps 工具提交和比对；caBundleFile 为空时输出的 caBundle 为空
+func renderAdmissionConfig(w io.Writer, caBundleFile string) error {
+	var caBundle []byte
+	if caBundleFile != "" {
+		data, err := ioutil.ReadFile(caBundleFile)
+		if err != nil {
+			return err
+		}
+		caBundle = data
+	}
+
+	validateConfig, mutateConfig, err := admissionConfigs(caBundle)
+	if err != nil {
+		return err
+	}
+
+	var objects []interface{}
+	if validateConfig != nil {
+		objects = append(objects, validateConfig)
+	}
+	if mutateConfig != nil {
+		objects = append(objects, mutateConfig)
+	}
+	for i, obj := range objects {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			if _, err := fmt.Fprintln(w, "---"); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// WebhookSpec 描述 webhook 配置对象中的一个 webhook 条目
+type WebhookSpec struct {
+	Name              string                           `json:"name"`
+	Path              string                           `json:"path"`
+	Rules             []admissionv1.RuleWithOperations `json:"rules"`
+	NamespaceSelector *metav1.LabelSelector            `json:"namespaceSelector,omitempty"`
+	ObjectSelector    *metav1.LabelSelector            `json:"objectSelector,omitempty"`
+}
+
+// loadWebhookSpecs 从 YAML/JSON 文件中加载 webhook 条目列表
+func loadWebhookSpecs(path string) ([]WebhookSpec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var specs []WebhookSpec
+	if err := yaml.Unmarshal(data, &specs); err != nil {
+		return nil, fmt.Errorf("parse webhook specs %s: %v", path, err)
+	}
+	for i, spec := range specs {
+		if spec.Name == "" || spec.Path == "" || len(spec.Rules) == 0 {
+			return nil, fmt.Errorf("webhook spec %d in %s must set name, path and rules", i, path)
+		}
+	}
+	return specs, nil
+}
+
+// defaultValidateWebhookSpecs 同时校验 CREATE 和 UPDATE 请求，避免通过更新工作负载绕过镜像仓库的校验
+func defaultValidateWebhookSpecs(path string) []WebhookSpec {
+	return []WebhookSpec{
+		{
+			Name: "io.ydzs.admission-registry",
+			Path: path,
+			Rules: []admissionv1.RuleWithOperations{
+				{
+					Operations: []admissionv1.OperationType{admissionv1.Create, admissionv1.Update},
+					Rule: admissionv1.Rule{
+						APIGroups:   []string{""},
+						APIVersions: []string{"v1"},
+						Resources:   []string{"pods", "services"},
+					},
+				},
+				{
+					Operations: []admissionv1.OperationType{admissionv1.Create, admissionv1.Update},
+					Rule: admissionv1.Rule{
+						APIGroups:   []string{"apps"},
+						APIVersions: []string{"v1"},
+						Resources:   []string{"deployments", "statefulsets", "daemonsets", "replicasets"},
+					},
+				},
+				{
+					Operations: []admissionv1.OperationType{admissionv1.Create, admissionv1.Update},
+					Rule: admissionv1.Rule{
+						APIGroups:   []string{"networking.k8s.io"},
+						APIVersions: []string{"v1"},
+						Resources:   []string{"ingresses"},
+					},
+				},
+				{
+					Operations: []admissionv1.OperationType{admissionv1.Create, admissionv1.Update},
+					Rule: admissionv1.Rule{
+						APIGroups:   []string{"batch"},
+						APIVersions: []string{"v1", "v1beta1"},
+						Resources:   []string{"jobs", "cronjobs"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// defaultMutateWebhookSpecs 同时拦截 CREATE 和 UPDATE 请求，这样通过更新对象设置的 force-mutate annotation 才会生效
+func defaultMutateWebhookSpecs(path string) []WebhookSpec {
+	return []WebhookSpec{
+		{
+			Name: "io.ydzs.admission-registry-mutate",
+			Path: path,
+			Rules: []admissionv1.RuleWithOperations{
+				{
+					Operations: []admissionv1.OperationType{admissionv1.Create, admissionv1.Update},
+					Rule: admissionv1.Rule{
+						APIGroups:   []string{""},
+						APIVersions: []string{"v1"},
+						Resources:   []string{"pods", "services"},
+					},
+				},
+				{
+					Operations: []admissionv1.OperationType{admissionv1.Create, admissionv1.Update},
+					Rule: admissionv1.Rule{
+						APIGroups:   []string{"apps"},
+						APIVersions: []string{"v1"},
+						Resources:   []string{"deployments", "statefulsets", "daemonsets"},
+					},
+				},
+				{
+					Operations: []admissionv1.OperationType{admissionv1.Create, admissionv1.Update},
+					Rule: admissionv1.Rule{
+						APIGroups:   []string{"batch"},
+						APIVersions: []string{"v1", "v1beta1"},
+						Resources:   []string{"jobs", "cronjobs"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// parseFailurePolicy 解析 FAILURE_POLICY，为空时默认为 Fail
+func parseFailurePolicy(s string) (admissionv1.FailurePolicyType, error) {
+	switch policy := admissionv1.FailurePolicyType(s); policy {
+	case "":
+		return admissionv1.Fail, nil
+	case admissionv1.Fail, admissionv1.Ignore:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid failure policy %q, expect %s or %s", s, admissionv1.Fail, admissionv1.Ignore)
+}
+
+// parseReinvocationPolicy 解析 REINVOCATION_POLICY，为空时默认为 Never
+func parseReinvocationPolicy(s string) (admissionv1.ReinvocationPolicyType, error) {
+	switch policy := admissionv1.ReinvocationPolicyType(s); policy {
+	case "":
+		return admissionv1.NeverReinvocationPolicy, nil
+	case admissionv1.NeverReinvocationPolicy, admissionv1.IfNeededReinvocationPolicy:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid reinvocation policy %q, expect %s or %s", s, admissionv1.NeverReinvocationPolicy, admissionv1.IfNeededReinvocationPolicy)
+}
+
+// parseMatchPolicy 解析 MATCH_POLICY，为空时默认为 Equivalent，这样通过其他等价的 API 版本（比如 apps/v1beta1）发送的请求也会被拦截
+func parseMatchPolicy(s string) (admissionv1.MatchPolicyType, error) {
+	switch policy := admissionv1.MatchPolicyType(s); policy {
+	case "":
+		return admissionv1.Equivalent, nil
+	case admissionv1.Exact, admissionv1.Equivalent:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid match policy %q, expect %s or %s", s, admissionv1.Exact, admissionv1.Equivalent)
+}
+
+// defaultIgnoreNamespaceSelector 默认跳过控制面的命名空间以及打了 admission-registry/ignore=true 标签的命名空间
+const defaultIgnoreNamespaceSelector = "kubernetes.io/metadata.name notin (kube-system,kube-node-lease),admission-registry/ignore notin (true)"
+
+// parseNamespaceSelector 将 IGNORE_NAMESPACE_SELECTOR 这样的 label selector 表达式解析为 LabelSelector，为空时使用默认值
+func parseNamespaceSelector(s string) (*metav1.LabelSelector, error) {
+	if s == "" {
+		s = defaultIgnoreNamespaceSelector
+	}
+	selector, err := metav1.ParseToLabelSelector(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace selector %q: %v", s, err)
+	}
+	return selector, nil
+}
+
+// parseTimeoutSeconds 解析 WEBHOOK_TIMEOUT_SECONDS，apiserver 要求在 1 到 30 秒之间，为空时默认为 10 秒
+func parseTimeoutSeconds(s string) (int32, error) {
+	if s == "" {
+		return 10, nil
+	}
+	seconds, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || seconds < 1 || seconds > 30 {
+		return 0, fmt.Errorf("invalid webhook timeout %q, expect an integer between 1 and 30", s)
+	}
+	return int32(seconds), nil
+}
+
+// webhookSpecs 配置了文件路径时从文件加载，否则使用默认的 webhook 条目
+func webhookSpecs(file string, defaults []WebhookSpec) ([]WebhookSpec, error) {
+	if file == "" {
+		return defaults, nil
+	}
+	return loadWebhookSpecs(file)
+}
+
+// webhookOptions 是所有 webhook 条目共用的配置
+type webhookOptions struct {
+	CABundle           []byte
+	Service            string
+	Namespace          string
+	FailurePolicy      admissionv1.FailurePolicyType
+	NamespaceSelector  *metav1.LabelSelector // webhook 条目没有设置 namespaceSelector 时使用
+	TimeoutSeconds     int32
+	MatchPolicy        admissionv1.MatchPolicyType
+	ReinvocationPolicy admissionv1.ReinvocationPolicyType // 只对 MutatingWebhook 生效
+}
+
+func clientConfig(spec WebhookSpec, opts webhookOptions) admissionv1.WebhookClientConfig {
+	path := spec.Path
+	return admissionv1.WebhookClientConfig{
+		CABundle: opts.CABundle,
+		Service: &admissionv1.ServiceReference{
+			Name:      opts.Service,
+			Namespace: opts.Namespace,
+			Path:      &path,
+		},
+	}
+}
+
+func namespaceSelector(spec WebhookSpec, opts webhookOptions) *metav1.LabelSelector {
+	if spec.NamespaceSelector != nil {
+		return spec.NamespaceSelector
+	}
+	return opts.NamespaceSelector
+}
+
+func buildValidatingWebhooks(specs []WebhookSpec, opts webhookOptions) []admissionv1.ValidatingWebhook {
+	var webhooks []admissionv1.ValidatingWebhook
+	for _, spec := range specs {
+		failurePolicy, timeoutSeconds, matchPolicy := opts.FailurePolicy, opts.TimeoutSeconds, opts.MatchPolicy
+		webhooks = append(webhooks, admissionv1.ValidatingWebhook{
+			Name:                    spec.Name,
+			ClientConfig:            clientConfig(spec, opts),
+			Rules:                   spec.Rules,
+			NamespaceSelector:       namespaceSelector(spec, opts),
+			ObjectSelector:          spec.ObjectSelector,
+			FailurePolicy:           &failurePolicy,
+			TimeoutSeconds:          &timeoutSeconds,
+			MatchPolicy:             &matchPolicy,
+			AdmissionReviewVersions: []string{"v1"},
+			SideEffects: func() *admissionv1.SideEffectClass {
+				// 非 dry-run 请求会产生 Event、审计日志这样的副作用，dry-run 请求时跳过
+				se := admissionv1.SideEffectClassNoneOnDryRun
+				return &se
+			}(),
+		})
+	}
+	return webhooks
+}
+
+func buildMutatingWebhooks(specs []WebhookSpec, opts webhookOptions) []admissionv1.MutatingWebhook {
+	var webhooks []admissionv1.MutatingWebhook
+	for _, spec := range specs {
+		failurePolicy, timeoutSeconds, matchPolicy := opts.FailurePolicy, opts.TimeoutSeconds, opts.MatchPolicy
+		webhooks = append(webhooks, admissionv1.MutatingWebhook{
+			Name:                    spec.Name,
+			ClientConfig:            clientConfig(spec, opts),
+			Rules:                   spec.Rules,
+			NamespaceSelector:       namespaceSelector(spec, opts),
+			ObjectSelector:          spec.ObjectSelector,
+			FailurePolicy:           &failurePolicy,
+			TimeoutSeconds:          &timeoutSeconds,
+			MatchPolicy:             &matchPolicy,
+			AdmissionReviewVersions: []string{"v1"},
+			SideEffects: func() *admissionv1.SideEffectClass {
+				// 非 dry-run 请求会产生 Event、审计日志这样的副作用，dry-run 请求时跳过
+				se := admissionv1.SideEffectClassNoneOnDryRun
+				return &se
+			}(),
+			ReinvocationPolicy: func() *admissionv1.ReinvocationPolicyType {
+				policy := opts.ReinvocationPolicy
+				return &policy
+			}(),
+		})
+	}
+	return webhooks
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// setEnv 在测试期间设置环境变量，测试结束后恢复原来的值
+func setEnv(t *testing.T, key, value string) {
+	t.Helper()
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// testAdmissionConfigs 使用 env 中的环境变量构建 webhook 配置对象
+func testAdmissionConfigs(t *testing.T, env map[string]string) (*admissionv1.ValidatingWebhookConfiguration, *admissionv1.MutatingWebhookConfiguration, error) {
+	t.Helper()
+	setEnv(t, "VALIDATE_CONFIG", "admission-registry")
+	setEnv(t, "MUTATE_CONFIG", "admission-registry-mutate")
+	setEnv(t, "WEBHOOK_SERVICE", "admission-registry")
+	setEnv(t, "WEBHOOK_NAMESPACE", "default")
+	for key, value := range env {
+		setEnv(t, key, value)
+	}
+	return admissionConfigs([]byte("ca"))
+}
+
+func TestFailurePolicy(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    admissionv1.FailurePolicyType
+		wantErr bool
+	}{
+		{value: "", want: admissionv1.Fail},
+		{value: "Fail", want: admissionv1.Fail},
+		{value: "Ignore", want: admissionv1.Ignore},
+		{value: "ignore", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run("FAILURE_POLICY="+tt.value, func(t *testing.T) {
+			validate, mutate, err := testAdmissionConfigs(t, map[string]string{"FAILURE_POLICY": tt.value})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			for _, webhook := range validate.Webhooks {
+				if webhook.FailurePolicy == nil || *webhook.FailurePolicy != tt.want {
+					t.Errorf("validating webhook %s failurePolicy = %v, want %s", webhook.Name, webhook.FailurePolicy, tt.want)
+				}
+			}
+			for _, webhook := range mutate.Webhooks {
+				if webhook.FailurePolicy == nil || *webhook.FailurePolicy != tt.want {
+					t.Errorf("mutating webhook %s failurePolicy = %v, want %s", webhook.Name, webhook.FailurePolicy, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNamespaceSelector(t *testing.T) {
+	tests := []struct {
+		name      string
+		selector  string
+		namespace map[string]string
+		matched   bool
+		wantErr   bool
+	}{
+		{name: "default selector matches workloads", namespace: map[string]string{"kubernetes.io/metadata.name": "default"}, matched: true},
+		{name: "default selector skips kube-system", namespace: map[string]string{"kubernetes.io/metadata.name": "kube-system"}},
+		{name: "default selector skips kube-node-lease", namespace: map[string]string{"kubernetes.io/metadata.name": "kube-node-lease"}},
+		{name: "default selector skips ignored namespaces", namespace: map[string]string{
+			"kubernetes.io/metadata.name": "monitoring", "admission-registry/ignore": "true",
+		}},
+		{name: "custom selector", selector: "team=platform", namespace: map[string]string{"team": "platform"}, matched: true},
+		{name: "custom selector mismatch", selector: "team=platform", namespace: map[string]string{"kubernetes.io/metadata.name": "default"}},
+		{name: "invalid selector", selector: "team in (", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validate, mutate, err := testAdmissionConfigs(t, map[string]string{"IGNORE_NAMESPACE_SELECTOR": tt.selector})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var selectors []*metav1.LabelSelector
+			for _, webhook := range validate.Webhooks {
+				selectors = append(selectors, webhook.NamespaceSelector)
+			}
+			for _, webhook := range mutate.Webhooks {
+				selectors = append(selectors, webhook.NamespaceSelector)
+			}
+			for _, s := range selectors {
+				selector, err := metav1.LabelSelectorAsSelector(s)
+				if err != nil {
+					t.Fatalf("convert selector %v: %v", s, err)
+				}
+				if got := selector.Matches(labels.Set(tt.namespace)); got != tt.matched {
+					t.Errorf("selector %s matches %v = %v, want %v", selector, tt.namespace, got, tt.matched)
+				}
+			}
+		})
+	}
+}
+
+func TestNamespaceSelectorFromSpec(t *testing.T) {
+	specSelector := &metav1.LabelSelector{MatchLabels: map[string]string{"team": "platform"}}
+	opts := webhookOptions{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"default": "true"}}}
+	webhooks := buildValidatingWebhooks([]WebhookSpec{
+		{Name: "with-selector", Path: "/validate", NamespaceSelector: specSelector},
+		{Name: "without-selector", Path: "/validate"},
+	}, opts)
+	// webhook 条目中设置的 namespaceSelector 优先于 IGNORE_NAMESPACE_SELECTOR
+	if webhooks[0].NamespaceSelector != specSelector || webhooks[1].NamespaceSelector != opts.NamespaceSelector {
+		t.Errorf("namespace selectors = %v, %v, want the spec selector and the default", webhooks[0].NamespaceSelector, webhooks[1].NamespaceSelector)
+	}
+}
+
+func TestTimeoutSeconds(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int32
+		wantErr bool
+	}{
+		{value: "", want: 10},
+		{value: "1", want: 1},
+		{value: "25", want: 25},
+		{value: "30", want: 30},
+		{value: "0", wantErr: true},
+		{value: "31", wantErr: true},
+		{value: "5s", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run("WEBHOOK_TIMEOUT_SECONDS="+tt.value, func(t *testing.T) {
+			validate, mutate, err := testAdmissionConfigs(t, map[string]string{"WEBHOOK_TIMEOUT_SECONDS": tt.value})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			for _, webhook := range validate.Webhooks {
+				if webhook.TimeoutSeconds == nil || *webhook.TimeoutSeconds != tt.want {
+					t.Errorf("validating webhook %s timeoutSeconds = %v, want %d", webhook.Name, webhook.TimeoutSeconds, tt.want)
+				}
+			}
+			for _, webhook := range mutate.Webhooks {
+				if webhook.TimeoutSeconds == nil || *webhook.TimeoutSeconds != tt.want {
+					t.Errorf("mutating webhook %s timeoutSeconds = %v, want %d", webhook.Name, webhook.TimeoutSeconds, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMutateWebhookRules(t *testing.T) {
+	_, mutate, err := testAdmissionConfigs(t, nil)
+	if err != nil {
+		t.Fatalf("admission configs: %v", err)
+	}
+	registered := map[string]bool{}
+	for _, webhook := range mutate.Webhooks {
+		for _, rule := range webhook.Rules {
+			// 更新对象时设置的 force-mutate annotation 需要 UPDATE 请求才能触发
+			if !reflect.DeepEqual(rule.Operations, []admissionv1.OperationType{admissionv1.Create, admissionv1.Update}) {
+				t.Errorf("mutating webhook %s rule %v operations = %v, want CREATE and UPDATE", webhook.Name, rule.Resources, rule.Operations)
+			}
+			for _, group := range rule.APIGroups {
+				for _, resource := range rule.Resources {
+					registered[group+"/"+resource] = true
+				}
+			}
+		}
+	}
+	for _, resource := range []string{"/pods", "/services", "apps/deployments", "apps/statefulsets", "apps/daemonsets", "batch/jobs", "batch/cronjobs"} {
+		if !registered[resource] {
+			t.Errorf("mutating webhook does not register %s, registered %v", resource, registered)
+		}
+	}
+}
+
+func TestValidateWebhookRules(t *testing.T) {
+	validate, _, err := testAdmissionConfigs(t, nil)
+	if err != nil {
+		t.Fatalf("admission configs: %v", err)
+	}
+	registered := map[string]bool{}
+	for _, webhook := range validate.Webhooks {
+		for _, rule := range webhook.Rules {
+			for _, group := range rule.APIGroups {
+				for _, resource := range rule.Resources {
+					registered[group+"/"+resource] = true
+				}
+			}
+		}
+	}
+	for _, resource := range []string{"/pods", "apps/deployments", "networking.k8s.io/ingresses", "batch/jobs", "batch/cronjobs"} {
+		if !registered[resource] {
+			t.Errorf("validating webhook does not register %s, registered %v", resource, registered)
+		}
+	}
+}
+
+func TestReinvocationPolicy(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    admissionv1.ReinvocationPolicyType
+		wantErr bool
+	}{
+		{value: "", want: admissionv1.NeverReinvocationPolicy},
+		{value: "Never", want: admissionv1.NeverReinvocationPolicy},
+		{value: "IfNeeded", want: admissionv1.IfNeededReinvocationPolicy},
+		{value: "Always", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run("REINVOCATION_POLICY="+tt.value, func(t *testing.T) {
+			_, mutate, err := testAdmissionConfigs(t, map[string]string{"REINVOCATION_POLICY": tt.value})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(mutate.Webhooks) == 0 {
+				t.Fatalf("no mutating webhooks")
+			}
+			for _, webhook := range mutate.Webhooks {
+				if webhook.ReinvocationPolicy == nil || *webhook.ReinvocationPolicy != tt.want {
+					t.Errorf("mutating webhook %s reinvocationPolicy = %v, want %s", webhook.Name, webhook.ReinvocationPolicy, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchPolicy(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    admissionv1.MatchPolicyType
+		wantErr bool
+	}{
+		{value: "", want: admissionv1.Equivalent},
+		{value: "Exact", want: admissionv1.Exact},
+		{value: "Equivalent", want: admissionv1.Equivalent},
+		{value: "exact", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run("MATCH_POLICY="+tt.value, func(t *testing.T) {
+			validate, mutate, err := testAdmissionConfigs(t, map[string]string{"MATCH_POLICY": tt.value})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			for _, webhook := range validate.Webhooks {
+				if webhook.MatchPolicy == nil || *webhook.MatchPolicy != tt.want {
+					t.Errorf("validating webhook %s matchPolicy = %v, want %s", webhook.Name, webhook.MatchPolicy, tt.want)
+				}
+			}
+			for _, webhook := range mutate.Webhooks {
+				if webhook.MatchPolicy == nil || *webhook.MatchPolicy != tt.want {
+					t.Errorf("mutating webhook %s matchPolicy = %v, want %s", webhook.Name, webhook.MatchPolicy, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSideEffects(t *testing.T) {
+	validate, mutate, err := testAdmissionConfigs(t, nil)
+	if err != nil {
+		t.Fatalf("admission configs: %v", err)
+	}
+	// 非 dry-run 请求会记录 Event 和审计日志，不能声明为 None
+	for _, webhook := range validate.Webhooks {
+		if webhook.SideEffects == nil || *webhook.SideEffects != admissionv1.SideEffectClassNoneOnDryRun {
+			t.Errorf("validating webhook %s sideEffects = %v, want %s", webhook.Name, webhook.SideEffects, admissionv1.SideEffectClassNoneOnDryRun)
+		}
+	}
+	for _, webhook := range mutate.Webhooks {
+		if webhook.SideEffects == nil || *webhook.SideEffects != admissionv1.SideEffectClassNoneOnDryRun {
+			t.Errorf("mutating webhook %s sideEffects = %v, want %s", webhook.Name, webhook.SideEffects, admissionv1.SideEffectClassNoneOnDryRun)
+		}
+	}
+}
@@ -3,8 +3,15 @@ module github.com/cnych/admission-registry
 go 1.15
 
 require (
+	github.com/nats-io/nats.go v1.11.0
+	github.com/prometheus/client_golang v1.7.1
+	go.opentelemetry.io/otel v0.16.0
+	go.opentelemetry.io/otel/exporters/otlp v0.16.0
+	go.opentelemetry.io/otel/sdk v0.16.0
+	golang.org/x/time v0.0.0-20200630173020-3af7569d3a1e
 	k8s.io/api v0.20.2
 	k8s.io/apimachinery v0.20.2
 	k8s.io/client-go v0.20.2
 	k8s.io/klog v1.0.0
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -6,12 +6,18 @@ import (
 	"flag"
 	"fmt"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
-	"strings"
+	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/cnych/admission-registry/pkg"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog"
 )
 
@@ -22,31 +28,506 @@ func main() {
 	flag.IntVar(&param.Port, "port", 443, "Webhook Server Port.")
 	flag.StringVar(&param.CertFile, "tlsCertFile", "/etc/webhook/certs/tls.crt", "x509 certification file")
 	flag.StringVar(&param.KeyFile, "tlsKeyFile", "/etc/webhook/certs/tls.key", "x509 private key file")
+	flag.BoolVar(&param.FailOpen, "failOpen", false, "Allow requests when the webhook panics while processing them (fail-open), deny by default (fail-closed).")
+	flag.BoolVar(&param.EnableReload, "enableReload", false, "Enable the POST /reload endpoint, requires the RELOAD_TOKEN env.")
+	flag.StringVar(&param.DefaultAction, "defaultAction", pkg.DefaultActionDeny, "Action for images matching no whitelist entry: allow or deny. Explicit deny policies always take precedence.")
+	flag.BoolVar(&param.EnableRecent, "enableRecent", false, "Enable the GET /recent and GET /debug/decisions endpoints listing the last --decision-buffer-size admission decisions.")
+	flag.IntVar(&param.DecisionBuffer, "decision-buffer-size", envInt("RECENT_DECISIONS", 100), "Number of recent admission decisions kept in memory for --enableRecent, defaults to RECENT_DECISIONS or 100.")
+	flag.BoolVar(&param.AcceptYAML, "acceptYAML", false, "Also accept AdmissionReview request bodies sent as application/yaml, responses are always JSON.")
+	flag.StringVar(&param.WhiteListFile, "whitelist-file", "", "Read the registry whitelist from this file, one registry per line, the file is re-read every WHITELIST_REFRESH_INTERVAL.")
+	flag.Int64Var(&param.MaxRequestBytes, "maxRequestBytes", pkg.DefaultMaxRequestBytes, "Maximum size of an AdmissionReview request body in bytes, larger requests are rejected with 413.")
+	flag.DurationVar(&param.ShutdownTimeout, "shutdown-timeout", 15*time.Second, "Maximum time to wait for in-flight requests to finish when shutting down.")
+	flag.BoolVar(&param.EnablePprof, "enable-pprof", false, "Serve the net/http/pprof handlers over plain HTTP on --pprof-addr.")
+	flag.StringVar(&param.PprofAddr, "pprof-addr", "localhost:6060", "Listen address of the pprof server, only used with --enable-pprof. Defaults to localhost so profiles are only reachable through kubectl port-forward.")
+	flag.StringVar(&param.LogFormat, "log-format", pkg.LogFormatText, "Format of the admission event logs: text or json.")
+	flag.StringVar(&param.ConfigFile, "config", "", "YAML config file, environment variables and flags set explicitly take precedence over its values.")
 	flag.Parse()
 
-	cert, err := tls.LoadX509KeyPair(param.CertFile, param.KeyFile)
+	if err := pkg.SetLogFormat(param.LogFormat); err != nil {
+		klog.Errorf("%v", err)
+		return
+	}
+
+	config := &pkg.Config{}
+	if param.ConfigFile != "" {
+		loaded, err := pkg.LoadConfig(param.ConfigFile)
+		if err != nil {
+			klog.Errorf("Failed to load config: %v", err)
+			return
+		}
+		config = loaded
+	}
+	// 环境变量覆盖配置文件中的值，设置了的旧环境变量在启动时输出一次弃用警告
+	warnings, err := pkg.ApplyEnv(config, os.LookupEnv)
+	if err != nil {
+		klog.Errorf("%v", err)
+		return
+	}
+	for _, warning := range warnings {
+		klog.Warning(warning)
+	}
+	// 显式设置的命令行参数优先于配置文件中的值
+	setFlags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+	if !setFlags["failOpen"] && config.FailOpen != nil {
+		param.FailOpen = *config.FailOpen
+	}
+	if !setFlags["shutdown-timeout"] && config.ShutdownTimeout.Duration > 0 {
+		param.ShutdownTimeout = config.ShutdownTimeout.Duration
+	}
+
+	if param.DefaultAction != pkg.DefaultActionAllow && param.DefaultAction != pkg.DefaultActionDeny {
+		klog.Errorf("Invalid defaultAction %q, expect allow or deny", param.DefaultAction)
+		return
+	}
+
+	// 证书内容也可以通过环境变量 TLS_CERT_PEM/TLS_KEY_PEM（base64 编码的 PEM）注入
+	certPEM, keyPEM := os.Getenv("TLS_CERT_PEM"), os.Getenv("TLS_KEY_PEM")
+	certFile, keyFile := param.CertFile, param.KeyFile
+	if certPEM != "" || keyPEM != "" {
+		certFile, keyFile = "", ""
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "tlsCertFile":
+				certFile = param.CertFile
+			case "tlsKeyFile":
+				keyFile = param.KeyFile
+			}
+		})
+	}
+	var store pkg.CertStore
+	if secretName := os.Getenv("CERT_SECRET_NAME"); secretName != "" {
+		// 从 cmd/tls 生成的 Secret 中读取证书，命名空间与 cmd/tls 的默认值保持一致
+		secretNamespace := os.Getenv("CERT_SECRET_NAMESPACE")
+		if secretNamespace == "" {
+			secretNamespace = os.Getenv("WEBHOOK_NAMESPACE")
+		}
+		clientset, err := pkg.InitKubernetesCli()
+		if err != nil {
+			klog.Errorf("Failed to init kubernetes client: %v", err)
+			return
+		}
+		store = &pkg.SecretCertStore{Clientset: clientset, Namespace: secretNamespace, Name: secretName}
+	} else if certPEM == "" && keyPEM == "" {
+		store = &pkg.FileCertStore{CertFile: certFile, KeyFile: keyFile}
+	}
+	// 证书保存在文件或者 Secret 中时定期重新加载，通过环境变量注入的证书不会变化
+	certHolder := &pkg.CertHolder{Store: store}
+	if store != nil {
+		err = certHolder.Reload(context.Background())
+	} else {
+		var cert tls.Certificate
+		if cert, err = pkg.LoadX509KeyPair(certFile, keyFile, certPEM, keyPEM); err == nil {
+			certHolder.Set(&cert)
+		}
+	}
 	if err != nil {
 		klog.Errorf("Failed to load key pair: %v", err)
 		return
 	}
 
+	staticWhiteList := config.WhiteListRegistries
+	loadWhiteList := func() ([]string, error) {
+		return staticWhiteList, nil
+	}
+	// 配置了 WHITELIST_URL 时从远端的策略服务获取白名单
+	var whiteListSource pkg.WhiteListSource
+	if whiteListURL := os.Getenv("WHITELIST_URL"); whiteListURL != "" {
+		source, err := pkg.NewHTTPWhiteListSource(whiteListURL, os.Getenv("WHITELIST_AUTHORIZATION"), os.Getenv("WHITELIST_CA_FILE"), 10*time.Second)
+		if err != nil {
+			klog.Errorf("Failed to init whitelist source: %v", err)
+			return
+		}
+		whiteListSource = source
+		loadWhiteList = func() ([]string, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			return source.Fetch(ctx)
+		}
+	}
+	// 配置了 --whitelist-file 时从文件读取白名单，比如挂载的 ConfigMap 卷
+	if param.WhiteListFile != "" {
+		source := &pkg.FileWhiteListSource{Path: param.WhiteListFile}
+		whiteListSource = source
+		loadWhiteList = func() ([]string, error) {
+			return source.Fetch(context.Background())
+		}
+	}
+	// 配置了 WHITELIST_CONFIGMAP 时从 ConfigMap 读取白名单，并在 ConfigMap 变化时自动更新
+	var whiteListConfigMap *pkg.ConfigMapWhiteListSource
+	if name := os.Getenv("WHITELIST_CONFIGMAP"); name != "" {
+		namespace := os.Getenv("WHITELIST_CONFIGMAP_NAMESPACE")
+		if namespace == "" {
+			namespace = os.Getenv("WEBHOOK_NAMESPACE")
+		}
+		clientset, err := pkg.InitKubernetesCli()
+		if err != nil {
+			klog.Errorf("Failed to init kubernetes client: %v", err)
+			return
+		}
+		whiteListConfigMap = &pkg.ConfigMapWhiteListSource{Clientset: clientset, Namespace: namespace, Name: name}
+		loadWhiteList = func() ([]string, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			return whiteListConfigMap.Fetch(ctx)
+		}
+	}
+	whiteListRegistries, err := loadWhiteList()
+	if err != nil {
+		// 启动时获取失败使用 WHITELIST_REGISTRIES 或者配置文件中的白名单
+		klog.Errorf("Failed to load whitelist, fall back to WHITELIST_REGISTRIES: %v", err)
+		whiteListRegistries = staticWhiteList
+	}
+
+	reloadToken := os.Getenv("RELOAD_TOKEN")
+	if param.EnableReload && reloadToken == "" {
+		klog.Error("RELOAD_TOKEN must be set when reload endpoint is enabled")
+		return
+	}
+
+	shutdownTracing, err := pkg.InitTracing(context.Background())
+	if err != nil {
+		klog.Errorf("Failed to init tracing: %v", err)
+		return
+	}
+
+	// ADVISORY=true 时校验不通过的请求默认只返回 Warning，不拒绝，OPERATION_MODES 中的配置优先
+	defaultMode := pkg.ModeEnforce
+	if os.Getenv("ADVISORY") == "true" {
+		defaultMode = pkg.ModeWarn
+	}
+	operationModes, err := pkg.ParseOperationModes(os.Getenv("OPERATION_MODES"))
+	if err != nil {
+		klog.Errorf("Failed to parse OPERATION_MODES: %v", err)
+		return
+	}
+
+	switch os.Getenv("RESOLVE_IMAGE_DIGESTS") {
+	case "", pkg.ImageDigestsAnnotate, pkg.ImageDigestsPin:
+	default:
+		klog.Errorf("Invalid RESOLVE_IMAGE_DIGESTS %q, expect annotate or pin", os.Getenv("RESOLVE_IMAGE_DIGESTS"))
+		return
+	}
+
+	mutatePolicy := config.MutatePolicy
+
+	// REQUIRE_DIGEST=true 要求所有镜像使用 digest，也可以配置为按仓库匹配的 docker.io/*=true
+	requireDigest, digestRequirements, err := pkg.ParseRequireDigest(os.Getenv("REQUIRE_DIGEST"))
+	if err != nil {
+		klog.Errorf("Failed to parse REQUIRE_DIGEST: %v", err)
+		return
+	}
+
+	allowedTagPattern, err := pkg.ParseTagPattern(os.Getenv("ALLOWED_TAG_REGEX"))
+	if err != nil {
+		klog.Errorf("Failed to parse ALLOWED_TAG_REGEX: %v", err)
+		return
+	}
+
+	allowedGroupRanges, err := pkg.ParseIDRanges(os.Getenv("ALLOWED_GROUP_RANGES"))
+	if err != nil {
+		klog.Errorf("Failed to parse ALLOWED_GROUP_RANGES: %v", err)
+		return
+	}
+
+	switch os.Getenv("HOST_PORT_POLICY") {
+	case "", "deny", "allowlist":
+	default:
+		klog.Errorf("Invalid HOST_PORT_POLICY %q, expect deny or allowlist", os.Getenv("HOST_PORT_POLICY"))
+		return
+	}
+	allowedHostPorts, err := pkg.ParseIDRanges(os.Getenv("ALLOWED_HOST_PORTS"))
+	if err != nil {
+		klog.Errorf("Failed to parse ALLOWED_HOST_PORTS: %v", err)
+		return
+	}
+
+	defaultImagePullPolicy, err := pkg.ParsePullPolicy(os.Getenv("DEFAULT_IMAGE_PULL_POLICY"))
+	if err != nil {
+		klog.Errorf("Failed to parse DEFAULT_IMAGE_PULL_POLICY: %v", err)
+		return
+	}
+	// INJECT_IMAGE_PULL_POLICY 根据镜像是否使用 digest 选择 Always 或 IfNotPresent，DEFAULT_IMAGE_PULL_POLICY 统一设置一个值，
+	// 两者同时设置时无法确定期望的行为，启动时直接报错
+	injectImagePullPolicy := os.Getenv("INJECT_IMAGE_PULL_POLICY") == "true"
+	if injectImagePullPolicy && defaultImagePullPolicy != "" {
+		klog.Errorf("INJECT_IMAGE_PULL_POLICY and DEFAULT_IMAGE_PULL_POLICY can't be set together, " +
+			"use INJECT_IMAGE_PULL_POLICY=true to choose the policy by image reference, or DEFAULT_IMAGE_PULL_POLICY to set a fixed policy")
+		return
+	}
+
+	// SIDECAR_FILE 定义需要注入的 Sidecar 容器
+	var sidecar *corev1.Container
+	if sidecarFile := os.Getenv("SIDECAR_FILE"); sidecarFile != "" {
+		if sidecar, err = pkg.LoadSidecar(sidecarFile); err != nil {
+			klog.Errorf("Failed to load sidecar: %v", err)
+			return
+		}
+	}
+
+	defaultResourceRequests, err := pkg.ParseResourceRequests(os.Getenv("DEFAULT_CPU_REQUEST"), os.Getenv("DEFAULT_MEM_REQUEST"))
+	if err != nil {
+		klog.Errorf("Failed to parse default resource requests: %v", err)
+		return
+	}
+
+	// MAX_CPU/MAX_MEMORY 限制单个容器可以申请的资源
+	maxResourceRequests, err := pkg.ParseResourceRequests(os.Getenv("MAX_CPU"), os.Getenv("MAX_MEMORY"))
+	if err != nil {
+		klog.Errorf("Failed to parse MAX_CPU/MAX_MEMORY: %v", err)
+		return
+	}
+
+	// 访问私有镜像仓库的认证信息，来自挂载的 docker config 文件或者 webhook 命名空间中的 imagePullSecrets
+	credentials := pkg.DockerConfigCredentials{}
+	if path := os.Getenv("REGISTRY_DOCKER_CONFIG"); path != "" {
+		if err := credentials.LoadDockerConfigFile(path); err != nil {
+			klog.Errorf("Failed to load registry docker config: %v", err)
+			return
+		}
+	}
+	if secrets := pkg.SplitList(os.Getenv("REGISTRY_PULL_SECRETS")); len(secrets) > 0 {
+		clientset, err := pkg.InitKubernetesCli()
+		if err != nil {
+			klog.Errorf("Failed to init kubernetes client: %v", err)
+			return
+		}
+		if err := credentials.LoadPullSecrets(context.Background(), clientset, os.Getenv("WEBHOOK_NAMESPACE"), secrets); err != nil {
+			klog.Errorf("Failed to load registry pull secrets: %v", err)
+			return
+		}
+	}
+
 	// 实例化一个Webhook Server
-	whsrv := pkg.WebhookServer{
+	whsrv := &pkg.WebhookServer{
 		Server: &http.Server{
 			Addr: fmt.Sprintf(":%d", param.Port),
 			TLSConfig: &tls.Config{
-				Certificates: []tls.Certificate{cert},
+				GetCertificate: certHolder.GetCertificate,
 			},
 		},
-		WhiteListRegistries: strings.Split(os.Getenv("WHITELIST_REGISTRIES"), ","),
+		WhiteListRegistries: whiteListRegistries,
+		DefaultAction:       param.DefaultAction,
+		AcceptYAML:          param.AcceptYAML,
+		FailOpen:            param.FailOpen,
+		ResponseHeaders:     pkg.ParseKeyValues(os.Getenv("RESPONSE_HEADERS")),
+		DenyListRegistries:  config.DenyListRegistries,
+		RepositoryAllowlist: pkg.SplitList(os.Getenv("REPOSITORY_ALLOWLIST")),
+		RegistryMirrors:     pkg.ParseKeyValues(os.Getenv("REGISTRY_MIRRORS")),
+		RequiredTagPrefixes: pkg.ParseKeyValues(os.Getenv("REQUIRED_TAG_PREFIXES")),
+		WhiteListLoader:     loadWhiteList,
+		ReloadToken:         reloadToken,
+		CheckResourceQuota:  os.Getenv("CHECK_RESOURCE_QUOTA") == "true",
+		DeletePolicy:        os.Getenv("DELETE_POLICY"),
+		OperationModes:      operationModes,
+		DefaultMode:         defaultMode,
+
+		RequireDeploymentLimits:     os.Getenv("REQUIRE_DEPLOYMENT_LIMITS") == "true",
+		MaxRevisionHistoryLimit:     int32(envInt("MAX_REVISION_HISTORY_LIMIT", 10)),
+		MaxProgressDeadlineSeconds:  int32(envInt("MAX_PROGRESS_DEADLINE_SECONDS", 600)),
+		RequireJobLimits:            os.Getenv("REQUIRE_JOB_LIMITS") == "true",
+		MaxJobBackoffLimit:          int32(envInt("MAX_JOB_BACKOFF_LIMIT", 6)),
+		MaxJobActiveDeadlineSeconds: int64(envInt("MAX_JOB_ACTIVE_DEADLINE_SECONDS", 86400)),
+		RequiredReadinessGates:      pkg.SplitList(os.Getenv("REQUIRED_READINESS_GATES")),
+		ReadinessGateNamespaces:     pkg.SplitList(os.Getenv("READINESS_GATE_NAMESPACES")),
+		RequirePodAntiAffinity:      os.Getenv("REQUIRE_POD_ANTI_AFFINITY") == "true",
+		PodAntiAffinityNamespaces:   pkg.SplitList(os.Getenv("POD_ANTI_AFFINITY_NAMESPACES")),
+		SubResourcePolicy:           os.Getenv("SUBRESOURCE_POLICY"),
+
+		DenyInsecureRegistries:   os.Getenv("DENY_INSECURE_REGISTRIES") == "true",
+		InsecureRegistries:       pkg.SplitList(os.Getenv("INSECURE_REGISTRIES")),
+		RequireDigest:            requireDigest,
+		DigestRequirements:       digestRequirements,
+		RejectLatestTag:          os.Getenv("REJECT_LATEST_TAG") == "true",
+		AllowedTagPattern:        allowedTagPattern,
+		AllowedGroupRanges:       allowedGroupRanges,
+		CriticalNamespaces:       pkg.SplitList(os.Getenv("CRITICAL_NAMESPACES")),
+		AllowedPriorityClasses:   pkg.SplitList(os.Getenv("ALLOWED_PRIORITY_CLASSES")),
+		MaxContainers:            envInt("MAX_CONTAINERS", 0),
+		SidecarAllowance:         envInt("SIDECAR_ALLOWANCE", 0),
+		HostPortPolicy:           os.Getenv("HOST_PORT_POLICY"),
+		AllowedHostPorts:         allowedHostPorts,
+		RequireVerifiedPublisher: os.Getenv("REQUIRE_VERIFIED_PUBLISHER") == "true",
+		VerifiedPublishers:       pkg.SplitList(os.Getenv("VERIFIED_PUBLISHERS")),
+		DenyDockerHub:            os.Getenv("DENY_DOCKER_HUB") == "true",
+		DockerHubAllowedImages:   pkg.SplitList(os.Getenv("DOCKER_HUB_ALLOWED_IMAGES")),
+		DisallowedCapabilities:   pkg.SplitList(os.Getenv("DISALLOWED_CAPABILITIES")),
+		DeniedSecrets:            pkg.SplitList(os.Getenv("DENIED_SECRETS")),
+
+		DisallowedNodeSelectorKeys: pkg.SplitList(os.Getenv("DISALLOWED_NODE_SELECTOR_KEYS")),
+		DisallowedTolerationKeys:   pkg.SplitList(os.Getenv("DISALLOWED_TOLERATION_KEYS")),
+
+		DenyHostNamespaces:            os.Getenv("DENY_HOST_NAMESPACES") == "true",
+		HostNamespaceExemptNamespaces: pkg.SplitList(os.Getenv("HOST_NAMESPACE_EXEMPT_NAMESPACES")),
+		DenyPrivileged:                os.Getenv("DENY_PRIVILEGED") == "true",
+		PrivilegedExemptNamespaces:    pkg.SplitList(os.Getenv("PRIVILEGED_EXEMPT_NAMESPACES")),
+
+		RequireProbes:     os.Getenv("REQUIRE_PROBES") == "true",
+		RequireBothProbes: os.Getenv("REQUIRE_BOTH_PROBES") == "true",
+
+		MaxResourceRequests: maxResourceRequests,
+
+		RequiredLabels: pkg.SplitList(os.Getenv("REQUIRED_LABELS")),
+
+		DenyDefaultServiceAccount:             os.Getenv("DENY_DEFAULT_SERVICE_ACCOUNT"),
+		DefaultServiceAccountExemptNamespaces: pkg.SplitList(os.Getenv("DEFAULT_SERVICE_ACCOUNT_EXEMPT_NAMESPACES")),
+
+		RegistryClient:      newRegistryClient(credentials),
+		RequireMultiArch:    os.Getenv("REQUIRE_MULTI_ARCH") == "true",
+		RequiredImageLabels: pkg.SplitList(os.Getenv("REQUIRED_IMAGE_LABELS")),
+
+		Scanner:             newScanner(),
+		ScanFreshnessWindow: envDuration("SCAN_FRESHNESS_WINDOW", 0),
+
+		// 和 /metrics 输出的 registry 保持一致
+		Registerer: pkg.MetricsRegistry,
+
+		ServiceExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyType(os.Getenv("SERVICE_EXTERNAL_TRAFFIC_POLICY")),
+		ServiceSessionAffinity:       corev1.ServiceAffinity(os.Getenv("SERVICE_SESSION_AFFINITY")),
+		InjectImagePullPolicy:        injectImagePullPolicy,
+		DefaultImagePullPolicy:       defaultImagePullPolicy,
+		DefaultResourceRequests:      defaultResourceRequests,
+		Sidecar:                      sidecar,
+		EnforceRunAsNonRoot:          os.Getenv("ENFORCE_RUN_AS_NON_ROOT") == "true",
+		MutatePolicy:                 mutatePolicy,
+		EmptyAnnotationsOptOut:       os.Getenv("EMPTY_ANNOTATIONS_OPT_OUT") == "true",
+		RemoveMutateTrigger:          os.Getenv("REMOVE_MUTATE_TRIGGER") == "true",
+		AnnotatePodTemplate:          os.Getenv("ANNOTATE_POD_TEMPLATE") == "true",
+		MutateKinds:                  pkg.SplitList(os.Getenv("MUTATE_KINDS")),
+		MutateExemptNamespaces:       config.MutateExemptNamespaces,
+		DefaultLabels:                pkg.ParseKeyValues(os.Getenv("DEFAULT_LABELS")),
+		DisallowedAnnotations:        pkg.SplitList(os.Getenv("DISALLOWED_ANNOTATIONS")),
+		ResolveImageDigests:          os.Getenv("RESOLVE_IMAGE_DIGESTS"),
+
+		ExemptUsers:  pkg.SplitList(os.Getenv("EXEMPT_USERS")),
+		ExemptGroups: pkg.SplitList(os.Getenv("EXEMPT_GROUPS")),
+
+		SlowThreshold:   envDuration("SLOW_ADMISSION_THRESHOLD", 0),
+		MaxRequestBytes: param.MaxRequestBytes,
+
+		RestrictedServiceNamespaces: pkg.SplitList(os.Getenv("RESTRICTED_SERVICE_NAMESPACES")),
+		DisallowedServiceTypes:      pkg.SplitList(os.Getenv("DISALLOWED_SERVICE_TYPES")),
+
+		AllowedIngressDomains: pkg.SplitList(os.Getenv("ALLOWED_INGRESS_DOMAINS")),
+		RequireIngressTLS:     os.Getenv("REQUIRE_INGRESS_TLS") == "true",
+	}
+	// 预先编译白名单中的 glob 和 regex: 条目
+	if err := whsrv.SetWhiteListRegistries(whiteListRegistries); err != nil {
+		klog.Errorf("Invalid whitelist: %v", err)
+		return
+	}
+
+	// WORKER_POOL_SIZE 大于 0 时使用有界的 worker pool 处理请求
+	if workers := envInt("WORKER_POOL_SIZE", 0); workers > 0 {
+		whsrv.WorkerPool = pkg.NewWorkerPool(workers, envInt("WORKER_QUEUE_SIZE", 100))
+		whsrv.WorkerDeadline = 9 * time.Second
+		if config.WorkerDeadline.Duration > 0 {
+			whsrv.WorkerDeadline = config.WorkerDeadline.Duration
+		}
+	}
+
+	// REQUIRE_SBOM_ATTESTATION=true 时要求镜像附带 SBOM attestation，类型默认为 SPDX
+	if os.Getenv("REQUIRE_SBOM_ATTESTATION") == "true" {
+		client := pkg.NewHTTPRegistryClient(10 * time.Second)
+		client.Credentials = credentials
+		whsrv.AttestationLookup = &pkg.RegistryAttestationLookup{Client: client}
+		whsrv.SBOMAttestationType = os.Getenv("SBOM_ATTESTATION_TYPE")
+		if whsrv.SBOMAttestationType == "" {
+			whsrv.SBOMAttestationType = pkg.DefaultSBOMAttestationType
+		}
+	}
+
+	// IMMUTABLE_TAGS=memory|configmap 时校验镜像 tag 指向的 digest 没有发生变化
+	switch os.Getenv("IMMUTABLE_TAGS") {
+	case "":
+	case "memory":
+		whsrv.TagDigestStore = pkg.NewMemoryTagDigestStore()
+	case "configmap":
+		clientset, err := pkg.InitKubernetesCli()
+		if err != nil {
+			klog.Errorf("Failed to init kubernetes client: %v", err)
+			return
+		}
+		name := os.Getenv("TAG_DIGEST_CONFIGMAP")
+		if name == "" {
+			name = "admission-registry-tag-digests"
+		}
+		namespace := os.Getenv("WEBHOOK_NAMESPACE")
+		if namespace == "" {
+			namespace = metav1.NamespaceDefault
+		}
+		whsrv.TagDigestStore = &pkg.ConfigMapTagDigestStore{Clientset: clientset, Namespace: namespace, Name: name}
+	default:
+		klog.Errorf("Invalid IMMUTABLE_TAGS %q, expect memory or configmap", os.Getenv("IMMUTABLE_TAGS"))
+		return
+	}
+
+	// 配置了 NATS_URL 时将准入决策异步发布到 NATS_SUBJECT
+	if natsURL := os.Getenv("NATS_URL"); natsURL != "" {
+		subject := os.Getenv("NATS_SUBJECT")
+		if subject == "" {
+			subject = "admission.decisions"
+		}
+		publisher, err := pkg.NewNATSPublisher(natsURL, subject)
+		if err != nil {
+			klog.Errorf("Failed to connect to NATS: %v", err)
+			return
+		}
+		whsrv.Publisher = pkg.NewAsyncPublisher(publisher, envInt("DECISION_PUBLISH_BUFFER", 1000))
+	}
+
+	// 审计日志的 HMAC key 一般从 Secret 注入到环境变量
+	if key := os.Getenv("AUDIT_HMAC_KEY"); key != "" {
+		whsrv.AuditLog = pkg.NewAuditLog([]byte(key))
+	}
+
+	if whsrv.CheckResourceQuota {
+		clientset, err := pkg.InitKubernetesCli()
+		if err != nil {
+			klog.Errorf("Failed to init kubernetes client: %v", err)
+			return
+		}
+		whsrv.Clientset = clientset
+	}
+
+	// EMIT_EVENTS=true 时在被拒绝的对象上记录 Event
+	if os.Getenv("EMIT_EVENTS") == "true" {
+		clientset, err := pkg.InitKubernetesCli()
+		if err != nil {
+			klog.Errorf("Failed to init kubernetes client: %v", err)
+			return
+		}
+		whsrv.EventRecorder = pkg.NewEventRecorder(clientset)
 	}
 
 	// 定义 http server handler
 	mux := http.NewServeMux()
-	mux.HandleFunc("/validate", whsrv.Handler)
-	mux.HandleFunc("/mutate", whsrv.Handler)
+	// 与 cmd/tls 注册 webhook 配置时使用相同的 VALIDATE_PATH/MUTATE_PATH，保证 apiserver 调用的路径与服务的路径一致
+	validatePath, mutatePath := envString("VALIDATE_PATH", "/validate"), envString("MUTATE_PATH", "/mutate")
+	endpoints := []string{validatePath, mutatePath, "/metrics", "/config"}
+	mux.HandleFunc(validatePath, whsrv.ServeValidate)
+	mux.HandleFunc(mutatePath, whsrv.ServeMutate)
+	// 同时输出 Go runtime/process 等默认指标和 webhook 自身的指标
+	mux.Handle("/metrics", promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, pkg.MetricsRegistry}, promhttp.HandlerOpts{}))
+	// 只读的配置摘要，方便确认运行中的 webhook 实际使用的策略
+	mux.HandleFunc("/config", whsrv.ConfigHandler)
+	if param.EnableReload {
+		mux.HandleFunc("/reload", whsrv.ReloadHandler)
+		endpoints = append(endpoints, "/reload")
+	}
+	if param.EnableRecent {
+		whsrv.RecentDecisions = pkg.NewDecisionRing(param.DecisionBuffer)
+		mux.HandleFunc("/recent", whsrv.RecentHandler)
+		mux.HandleFunc("/debug/decisions", whsrv.RecentHandler)
+		endpoints = append(endpoints, "/recent", "/debug/decisions")
+	}
 	whsrv.Server.Handler = mux
 
+	whsrv.Endpoints = endpoints
+	klog.Infof("Effective policy: %s", whsrv.Summary())
+
 	// 在一个新的 goroutine 里面去启动 webhook server
 	go func() {
 		if err := whsrv.Server.ListenAndServeTLS("", ""); err != nil {
@@ -56,14 +537,135 @@ func main() {
 
 	klog.Info("Server started")
 
+	// pprof 只在显式开启时监听，避免在生产环境中意外暴露
+	if param.EnablePprof {
+		pprofServer := newPprofServer(param.PprofAddr)
+		go func() {
+			if err := pprofServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				klog.Errorf("Failed to listen and serve pprof: %v", err)
+			}
+		}()
+		defer pprofServer.Close()
+		klog.Infof("pprof server started on %s", param.PprofAddr)
+	}
+
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	if whiteListSource != nil {
+		go whsrv.WatchWhiteList(watchCtx, whiteListSource, envDuration("WHITELIST_REFRESH_INTERVAL", time.Minute))
+	}
+	if whiteListConfigMap != nil {
+		go whsrv.WatchWhiteListConfigMap(watchCtx, whiteListConfigMap)
+	}
+	// NAMESPACE_WHITELIST_CONFIGMAP 中以命名空间为 key 保存各个命名空间额外允许（或者 replace: 替换）的镜像仓库
+	if name := os.Getenv("NAMESPACE_WHITELIST_CONFIGMAP"); name != "" {
+		namespace := os.Getenv("NAMESPACE_WHITELIST_CONFIGMAP_NAMESPACE")
+		if namespace == "" {
+			namespace = os.Getenv("WEBHOOK_NAMESPACE")
+		}
+		clientset, err := pkg.InitKubernetesCli()
+		if err != nil {
+			klog.Errorf("Failed to init kubernetes client: %v", err)
+			return
+		}
+		go whsrv.WatchNamespaceWhiteListConfigMap(watchCtx, clientset, namespace, name)
+	}
+	if store != nil {
+		go certHolder.Watch(watchCtx, envDuration("CERT_RELOAD_INTERVAL", time.Minute))
+	}
+
 	// 监听 OS 的关闭信号
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
 	<-signalChan
 
 	klog.Infof("Got OS shutdown signal, gracefully shutting down...")
-	if err := whsrv.Server.Shutdown(context.Background()); err != nil {
-		klog.Errorf("HTTP Server Shutdown error: %v", err)
+	// 超过 shutdownTimeout 还没有处理完的请求会被强制中断，避免滚动更新时 Pod 一直处于 Terminating 状态
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), param.ShutdownTimeout)
+	defer cancelShutdown()
+	if err := whsrv.Server.Shutdown(shutdownCtx); err != nil {
+		klog.Errorf("HTTP Server Shutdown error, forcing close: %v", err)
+		if err := whsrv.Server.Close(); err != nil {
+			klog.Errorf("HTTP Server Close error: %v", err)
+		}
+	} else {
+		klog.Info("HTTP Server shut down cleanly")
+	}
+	if err := shutdownTracing(context.Background()); err != nil {
+		klog.Errorf("Tracing shutdown error: %v", err)
+	}
+
+}
+
+// newPprofServer 创建只注册了 net/http/pprof handler 的 HTTP server
+func newPprofServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return &http.Server{Addr: addr, Handler: mux}
+}
+
+// newRegistryClient 创建访问镜像仓库的客户端，REGISTRY_BREAKER_THRESHOLD 大于 0 时加上熔断保护
+func newRegistryClient(credentials pkg.CredentialProvider) pkg.RegistryClient {
+	httpClient := pkg.NewHTTPRegistryClient(10 * time.Second)
+	httpClient.Credentials = credentials
+	var client pkg.RegistryClient = httpClient
+	threshold := envInt("REGISTRY_BREAKER_THRESHOLD", 0)
+	if threshold <= 0 {
+		return client
 	}
+	cooldown := envDuration("REGISTRY_BREAKER_COOLDOWN", 30*time.Second)
+	return pkg.NewBreakerRegistryClient(client, pkg.NewCircuitBreaker("registry", threshold, cooldown, pkg.MetricsRegistry))
+}
 
+// newScanner 配置了 SCANNER_URL 时创建查询镜像扫描结果的客户端，SCANNER_BREAKER_THRESHOLD 大于 0 时加上熔断保护
+func newScanner() pkg.Scanner {
+	endpoint := os.Getenv("SCANNER_URL")
+	if endpoint == "" {
+		return nil
+	}
+	var scanner pkg.Scanner = &pkg.HTTPScanner{Endpoint: endpoint, Client: &http.Client{Timeout: 10 * time.Second}}
+	if threshold := envInt("SCANNER_BREAKER_THRESHOLD", 0); threshold > 0 {
+		cooldown := envDuration("SCANNER_BREAKER_COOLDOWN", 30*time.Second)
+		scanner = pkg.NewBreakerScanner(scanner, pkg.NewCircuitBreaker("scanner", threshold, cooldown, pkg.MetricsRegistry))
+	}
+	return scanner
+}
+
+// envInt 读取整数类型的环境变量，未设置时返回默认值；
+// 设置了但是格式错误时直接退出，避免拼写错误静默地关闭对应的策略
+func envInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		klog.Exitf("Failed to parse %s %q: %v", key, value, err)
+	}
+	return v
+}
+
+// envString 读取字符串类型的环境变量，未设置或者为空时返回默认值
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envDuration 读取时间类型的环境变量（比如 30s、24h），未设置时返回默认值，设置了但是格式错误时直接退出
+func envDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	v, err := time.ParseDuration(value)
+	if err != nil {
+		klog.Exitf("Failed to parse %s %q: %v", key, value, err)
+	}
+	return v
 }
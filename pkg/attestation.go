@@ -0,0 +1,51 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// DefaultSBOMAttestationType SPDX 格式 SBOM 的 in-toto predicate type
+const DefaultSBOMAttestationType = "https://spdx.dev/Document"
+
+// AttestationLookup 查询镜像是否附带了指定类型的 attestation
+type AttestationLookup interface {
+	HasAttestation(ctx context.Context, image, predicateType string) (bool, error)
+}
+
+// RegistryAttestationLookup 查询 cosign 保存在镜像仓库中的 attestation（tag 为 sha256-<digest>.att 的 manifest），
+// 通过 layer 的 predicateType 注解判断 attestation 的类型
+type RegistryAttestationLookup struct {
+	Client *HTTPRegistryClient
+}
+
+func (l *RegistryAttestationLookup) HasAttestation(ctx context.Context, image, predicateType string) (bool, error) {
+	ref := parseImageReference(image)
+	digest := ref.Digest
+	if digest == "" {
+		var err error
+		if digest, err = l.Client.ManifestDigest(ctx, image); err != nil {
+			return false, err
+		}
+	}
+
+	var manifest struct {
+		Layers []struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"layers"`
+	}
+	tag := strings.Replace(digest, ":", "-", 1) + ".att"
+	if err := l.Client.getJSON(ctx, ref, "/manifests/"+tag, MediaTypeOCIManifest+","+MediaTypeDockerManifest, &manifest); err != nil {
+		if isRegistryNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("get attestations of %s: %v", ref, err)
+	}
+	for _, layer := range manifest.Layers {
+		if layer.Annotations["predicateType"] == predicateType {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,93 @@
+package pkg
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/klog"
+)
+
+// AuditEntry 是一条 mutate 审计记录，MAC 为 HMAC(key, Prev + 记录内容)，所有记录组成一条哈希链
+type AuditEntry struct {
+	Time      string `json:"time"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	UID       string `json:"uid"`
+	PatchHash string `json:"patchHash"`
+	Prev      string `json:"prev"`
+	MAC       string `json:"mac"`
+}
+
+// AuditLog 记录每次 mutate 输出的 patch，任何记录被篡改、删除或者调整顺序都会导致后续的 MAC 校验失败
+type AuditLog struct {
+	key  []byte
+	mu   sync.Mutex
+	last string
+	// Sink 输出审计记录，默认写入日志
+	Sink func(entry AuditEntry)
+}
+
+func NewAuditLog(key []byte) *AuditLog {
+	return &AuditLog{
+		key: key,
+		Sink: func(entry AuditEntry) {
+			data, _ := json.Marshal(entry)
+			klog.Infof("mutation audit: %s", data)
+		},
+	}
+}
+
+// Record 记录一次 mutate 的结果
+func (l *AuditLog) Record(req *admissionv1.AdmissionRequest, patch []byte) AuditEntry {
+	sum := sha256.Sum256(patch)
+	entry := AuditEntry{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Kind:      req.Kind.Kind,
+		Namespace: req.Namespace,
+		Name:      req.Name,
+		UID:       string(req.UID),
+		PatchHash: hex.EncodeToString(sum[:]),
+	}
+
+	l.mu.Lock()
+	entry.Prev = l.last
+	entry.MAC = auditMAC(l.key, entry)
+	l.last = entry.MAC
+	l.mu.Unlock()
+
+	if l.Sink != nil {
+		l.Sink(entry)
+	}
+	return entry
+}
+
+func auditMAC(key []byte, entry AuditEntry) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.Join([]string{
+		entry.Prev, entry.Time, entry.Kind, entry.Namespace, entry.Name, entry.UID, entry.PatchHash,
+	}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyAuditChain 校验一组连续的审计记录
+func VerifyAuditChain(key []byte, entries []AuditEntry) error {
+	prev := ""
+	for i, entry := range entries {
+		if i > 0 && entry.Prev != prev {
+			return fmt.Errorf("audit entry %d breaks the chain: prev %s, expect %s", i, entry.Prev, prev)
+		}
+		if !hmac.Equal([]byte(auditMAC(key, entry)), []byte(entry.MAC)) {
+			return fmt.Errorf("audit entry %d has an invalid mac", i)
+		}
+		prev = entry.MAC
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package pkg
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// recordMutations 对几个不同的 Service 执行 mutate，返回产生的审计记录
+func recordMutations(t *testing.T, key []byte) []AuditEntry {
+	t.Helper()
+	s := newTestServer(t, "docker.io")
+	s.AuditLog = NewAuditLog(key)
+	var entries []AuditEntry
+	s.AuditLog.Sink = func(entry AuditEntry) { entries = append(entries, entry) }
+	for _, name := range []string{"a", "b", "c"} {
+		service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+		resp := s.mutate(newAdmissionReview(t, "Service", service))
+		if !resp.Allowed || len(resp.Patch) == 0 {
+			t.Fatalf("mutate %s: allowed %v, patch %s", name, resp.Allowed, resp.Patch)
+		}
+	}
+	if len(entries) != 3 {
+		t.Fatalf("recorded %d audit entries, want 3", len(entries))
+	}
+	return entries
+}
+
+func TestAuditChain(t *testing.T) {
+	key := []byte("secret")
+	entries := recordMutations(t, key)
+	for i, entry := range entries {
+		if entry.Kind != "Service" || entry.Namespace != "default" || entry.UID == "" || entry.PatchHash == "" {
+			t.Errorf("entry %d is missing the object identity: %+v", i, entry)
+		}
+		if i > 0 && entry.Prev != entries[i-1].MAC {
+			t.Errorf("entry %d prev = %s, want %s", i, entry.Prev, entries[i-1].MAC)
+		}
+	}
+	if err := VerifyAuditChain(key, entries); err != nil {
+		t.Fatalf("verify audit chain: %v", err)
+	}
+}
+
+func TestAuditChainTampered(t *testing.T) {
+	key := []byte("secret")
+	tests := []struct {
+		name   string
+		key    []byte
+		tamper func([]AuditEntry) []AuditEntry
+	}{
+		{name: "wrong key", key: []byte("other"), tamper: func(e []AuditEntry) []AuditEntry { return e }},
+		{name: "modified patch hash", key: key, tamper: func(e []AuditEntry) []AuditEntry {
+			e[1].PatchHash = "0000"
+			return e
+		}},
+		{name: "modified name", key: key, tamper: func(e []AuditEntry) []AuditEntry {
+			e[0].Name = "other"
+			return e
+		}},
+		{name: "deleted entry", key: key, tamper: func(e []AuditEntry) []AuditEntry {
+			return append(e[:1], e[2:]...)
+		}},
+		{name: "reordered entries", key: key, tamper: func(e []AuditEntry) []AuditEntry {
+			e[1], e[2] = e[2], e[1]
+			return e
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := tt.tamper(recordMutations(t, key))
+			if err := VerifyAuditChain(tt.key, entries); err == nil {
+				t.Errorf("verify audit chain succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestAuditSkipsDryRun(t *testing.T) {
+	s := newTestServer(t, "docker.io")
+	s.AuditLog = NewAuditLog([]byte("secret"))
+	recorded := 0
+	s.AuditLog.Sink = func(AuditEntry) { recorded++ }
+	ar := newAdmissionReview(t, "Service", &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}})
+	dryRun := true
+	ar.Request.DryRun = &dryRun
+	if resp := s.mutate(ar); !resp.Allowed || len(resp.Patch) == 0 {
+		t.Fatalf("dry-run mutate: allowed %v, patch %s", resp.Allowed, resp.Patch)
+	}
+	if recorded != 0 {
+		t.Errorf("recorded %d audit entries for a dry-run request, want 0", recorded)
+	}
+}
@@ -0,0 +1,152 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog"
+)
+
+// ErrCircuitOpen 熔断器打开时直接返回的错误，调用方按照 FailOpen 处理
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+// CircuitBreaker 连续失败 Threshold 次后打开，打开期间的调用直接失败，
+// 经过 Cooldown 之后进入 half-open 状态放行一次调用进行探测，成功则关闭，失败则重新打开
+type CircuitBreaker struct {
+	Name      string
+	Threshold int
+	Cooldown  time.Duration
+
+	mu         sync.Mutex
+	state      breakerState
+	failures   int
+	openedAt   time.Time
+	probing    bool
+	stateGauge *prometheus.GaugeVec
+}
+
+// NewCircuitBreaker 创建熔断器，状态指标注册到 registerer 上，registerer 为空时使用 MetricsRegistry
+func NewCircuitBreaker(name string, threshold int, cooldown time.Duration, registerer prometheus.Registerer) *CircuitBreaker {
+	b := &CircuitBreaker{Name: name, Threshold: threshold, Cooldown: cooldown, stateGauge: newMetrics(registerer).circuitBreakerState}
+	b.stateGauge.WithLabelValues(name).Set(float64(breakerClosed))
+	return b
+}
+
+// Do 在熔断器的保护下执行 fn
+func (b *CircuitBreaker) Do(fn func() error) error {
+	if !b.allow() {
+		return ErrCircuitOpen
+	}
+	err := fn()
+	b.record(err)
+	return err
+}
+
+func (b *CircuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.Cooldown {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		// half-open 状态同一时间只放行一个探测请求
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+func (b *CircuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		b.setState(breakerClosed)
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.Threshold {
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+	}
+}
+
+func (b *CircuitBreaker) setState(state breakerState) {
+	if b.state != state {
+		klog.Infof("Circuit breaker %s state changed from %d to %d", b.Name, b.state, state)
+	}
+	b.state = state
+	b.stateGauge.WithLabelValues(b.Name).Set(float64(state))
+}
+
+// breakerRegistryClient 为 RegistryClient 的调用加上熔断保护
+type breakerRegistryClient struct {
+	client  RegistryClient
+	breaker *CircuitBreaker
+}
+
+func NewBreakerRegistryClient(client RegistryClient, breaker *CircuitBreaker) RegistryClient {
+	return &breakerRegistryClient{client: client, breaker: breaker}
+}
+
+func (c *breakerRegistryClient) ManifestMediaType(ctx context.Context, image string) (mediaType string, err error) {
+	err = c.breaker.Do(func() error {
+		mediaType, err = c.client.ManifestMediaType(ctx, image)
+		return err
+	})
+	return
+}
+
+func (c *breakerRegistryClient) ManifestDigest(ctx context.Context, image string) (digest string, err error) {
+	err = c.breaker.Do(func() error {
+		digest, err = c.client.ManifestDigest(ctx, image)
+		return err
+	})
+	return
+}
+
+func (c *breakerRegistryClient) ImageLabels(ctx context.Context, image string) (labels map[string]string, err error) {
+	err = c.breaker.Do(func() error {
+		labels, err = c.client.ImageLabels(ctx, image)
+		return err
+	})
+	return
+}
+
+// breakerScanner 为 Scanner 的调用加上熔断保护
+type breakerScanner struct {
+	scanner Scanner
+	breaker *CircuitBreaker
+}
+
+func NewBreakerScanner(scanner Scanner, breaker *CircuitBreaker) Scanner {
+	return &breakerScanner{scanner: scanner, breaker: breaker}
+}
+
+func (c *breakerScanner) LastScanTime(ctx context.Context, image string) (scanned time.Time, err error) {
+	err = c.breaker.Do(func() error {
+		scanned, err = c.scanner.LastScanTime(ctx, image)
+		return err
+	})
+	return
+}
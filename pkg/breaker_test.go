@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	failure := errors.New("scanner unavailable")
+	b := NewCircuitBreaker("test", 3, 20*time.Millisecond, prometheus.NewRegistry())
+	calls := 0
+	fail := func() error { calls++; return failure }
+	succeed := func() error { calls++; return nil }
+
+	assertState := func(want breakerState) {
+		t.Helper()
+		if b.state != want {
+			t.Fatalf("state = %d, want %d", b.state, want)
+		}
+		if got := testutil.ToFloat64(b.stateGauge.WithLabelValues("test")); got != float64(want) {
+			t.Fatalf("state metric = %v, want %d", got, want)
+		}
+	}
+
+	// 连续失败达到阈值之前保持关闭
+	for i := 0; i < 2; i++ {
+		if err := b.Do(fail); err != failure {
+			t.Fatalf("call %d: err = %v, want %v", i, err, failure)
+		}
+	}
+	assertState(breakerClosed)
+
+	// 第 3 次失败后打开，之后的调用不会执行 fn
+	b.Do(fail)
+	assertState(breakerOpen)
+	for i := 0; i < 5; i++ {
+		if err := b.Do(fail); err != ErrCircuitOpen {
+			t.Fatalf("err = %v, want ErrCircuitOpen", err)
+		}
+	}
+	if calls != 3 {
+		t.Fatalf("calls = %d, want 3 while the breaker is open", calls)
+	}
+
+	// cooldown 之后放行一次探测，探测失败重新打开
+	time.Sleep(30 * time.Millisecond)
+	if err := b.Do(fail); err != failure {
+		t.Fatalf("probe err = %v, want %v", err, failure)
+	}
+	assertState(breakerOpen)
+	if err := b.Do(succeed); err != ErrCircuitOpen {
+		t.Fatalf("err = %v, want ErrCircuitOpen after a failed probe", err)
+	}
+
+	// 探测成功后关闭，失败计数清零
+	time.Sleep(30 * time.Millisecond)
+	if err := b.Do(succeed); err != nil {
+		t.Fatalf("probe err = %v, want nil", err)
+	}
+	assertState(breakerClosed)
+	b.Do(fail)
+	assertState(breakerClosed)
+}
+
+func TestCircuitBreakerHalfOpenSingleProbe(t *testing.T) {
+	b := NewCircuitBreaker("test-probe", 1, time.Millisecond, nil)
+	b.Do(func() error { return errors.New("down") })
+	time.Sleep(5 * time.Millisecond)
+
+	// 探测请求执行期间的其他调用直接失败
+	var concurrent error
+	b.Do(func() error {
+		concurrent = b.Do(func() error { return nil })
+		return nil
+	})
+	if concurrent != ErrCircuitOpen {
+		t.Errorf("concurrent call err = %v, want ErrCircuitOpen", concurrent)
+	}
+	if b.state != breakerClosed {
+		t.Errorf("state = %d, want closed after a successful probe", b.state)
+	}
+}
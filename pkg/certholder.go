@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// CertHolder 保存 webhook server 当前使用的证书，通过 tls.Config.GetCertificate 提供给 TLS 握手，
+// Store 不为空时可以定期从 Store 重新加载，这样 cert-manager 轮换的证书不需要重启就能生效
+type CertHolder struct {
+	Store CertStore
+
+	cert atomic.Value // *tls.Certificate
+}
+
+// Set 原子地替换当前使用的证书
+func (h *CertHolder) Set(cert *tls.Certificate) {
+	h.cert.Store(cert)
+}
+
+// Reload 从 Store 重新加载证书，加载失败时继续使用当前的证书
+func (h *CertHolder) Reload(ctx context.Context) error {
+	if h.Store == nil {
+		return errors.New("no cert store configured")
+	}
+	bundle, err := h.Store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	cert, err := bundle.KeyPair()
+	if err != nil {
+		return err
+	}
+	if current, ok := h.cert.Load().(*tls.Certificate); ok && sameCertificate(current, &cert) {
+		return nil
+	}
+	h.Set(&cert)
+	klog.Info("Webhook server certificate loaded")
+	return nil
+}
+
+// Watch 每隔 interval 重新加载一次证书，直到 ctx 结束
+func (h *CertHolder) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.Reload(ctx); err != nil {
+				klog.Errorf("Failed to reload certificate, keep using the current one: %v", err)
+			}
+		}
+	}
+}
+
+// GetCertificate 实现 tls.Config.GetCertificate
+func (h *CertHolder) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, ok := h.cert.Load().(*tls.Certificate)
+	if !ok {
+		return nil, errors.New("no certificate loaded")
+	}
+	return cert, nil
+}
+
+func sameCertificate(a, b *tls.Certificate) bool {
+	if len(a.Certificate) != len(b.Certificate) {
+		return false
+	}
+	for i := range a.Certificate {
+		if !bytes.Equal(a.Certificate[i], b.Certificate[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+package pkg
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCertBundle 生成一个 CommonName 为 cn 的自签名证书
+func newTestCertBundle(t *testing.T, cn string) *CertBundle {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{cn},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	return &CertBundle{
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		KeyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// servedCommonName 返回 holder 当前提供给 TLS 握手的证书的 CommonName
+func servedCommonName(t *testing.T, holder *CertHolder) string {
+	t.Helper()
+	cert, err := holder.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("get certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func newFileCertStore(t *testing.T) *FileCertStore {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "admission-registry-certholder")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return &FileCertStore{CertFile: filepath.Join(dir, "tls.crt"), KeyFile: filepath.Join(dir, "tls.key")}
+}
+
+func TestCertHolderReload(t *testing.T) {
+	ctx := context.Background()
+	store := newFileCertStore(t)
+	holder := &CertHolder{Store: store}
+	if _, err := holder.GetCertificate(&tls.ClientHelloInfo{}); err == nil {
+		t.Fatalf("get certificate before loading succeeded, want error")
+	}
+
+	if err := store.Save(ctx, newTestCertBundle(t, "first")); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if err := holder.Reload(ctx); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if cn := servedCommonName(t, holder); cn != "first" {
+		t.Fatalf("served %s, want first", cn)
+	}
+
+	// 证书轮换之后使用新的证书
+	if err := store.Save(ctx, newTestCertBundle(t, "second")); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if err := holder.Reload(ctx); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if cn := servedCommonName(t, holder); cn != "second" {
+		t.Fatalf("served %s after rotation, want second", cn)
+	}
+
+	// 证书和私钥不匹配时继续使用当前的证书
+	broken := newTestCertBundle(t, "broken")
+	broken.KeyPEM = newTestCertBundle(t, "other").KeyPEM
+	if err := store.Save(ctx, broken); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if err := holder.Reload(ctx); err == nil {
+		t.Fatalf("reload of a mismatched key pair succeeded, want error")
+	}
+	if cn := servedCommonName(t, holder); cn != "second" {
+		t.Errorf("served %s after a failed reload, want second", cn)
+	}
+}
+
+func TestCertHolderWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := newFileCertStore(t)
+	if err := store.Save(ctx, newTestCertBundle(t, "first")); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	holder := &CertHolder{Store: store}
+	if err := holder.Reload(ctx); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	go holder.Watch(ctx, 5*time.Millisecond)
+
+	if err := store.Save(ctx, newTestCertBundle(t, "rotated")); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); servedCommonName(t, holder) != "rotated"; {
+		if time.Now().After(deadline) {
+			t.Fatalf("rotated certificate was not picked up")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
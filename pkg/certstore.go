@@ -0,0 +1,97 @@
+package pkg
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CertBundle 是 webhook server 使用的证书、私钥以及签发它们的 CA 证书（PEM 格式）
+type CertBundle struct {
+	CertPEM []byte
+	KeyPEM  []byte
+	CAPEM   []byte
+}
+
+// KeyPair 解析证书和私钥
+func (b *CertBundle) KeyPair() (tls.Certificate, error) {
+	return tls.X509KeyPair(b.CertPEM, b.KeyPEM)
+}
+
+// CertStore 负责证书的持久化，与证书的生成解耦
+type CertStore interface {
+	Load(ctx context.Context) (*CertBundle, error)
+	Save(ctx context.Context, bundle *CertBundle) error
+}
+
+// FileCertStore 将证书保存在本地文件中，CAFile 为空时不读写 CA 证书
+type FileCertStore struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+}
+
+func (f *FileCertStore) Load(ctx context.Context) (*CertBundle, error) {
+	bundle := &CertBundle{}
+	var err error
+	if bundle.CertPEM, err = ioutil.ReadFile(f.CertFile); err != nil {
+		return nil, err
+	}
+	if bundle.KeyPEM, err = ioutil.ReadFile(f.KeyFile); err != nil {
+		return nil, err
+	}
+	if f.CAFile != "" {
+		if bundle.CAPEM, err = ioutil.ReadFile(f.CAFile); err != nil {
+			return nil, err
+		}
+	}
+	return bundle, nil
+}
+
+func (f *FileCertStore) Save(ctx context.Context, bundle *CertBundle) error {
+	files := map[string][]byte{
+		f.CertFile: bundle.CertPEM,
+		f.KeyFile:  bundle.KeyPEM,
+	}
+	if f.CAFile != "" {
+		files[f.CAFile] = bundle.CAPEM
+	}
+	for path, data := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0666); err != nil {
+			return err
+		}
+		if err := WriteFile(path, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SecretCertStore 将证书保存在 kubernetes.io/tls 类型的 Secret 中
+type SecretCertStore struct {
+	Clientset kubernetes.Interface
+	Namespace string
+	Name      string
+}
+
+func (s *SecretCertStore) Load(ctx context.Context) (*CertBundle, error) {
+	secret, err := s.Clientset.CoreV1().Secrets(s.Namespace).Get(ctx, s.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, secretError(s.Namespace, s.Name, err)
+	}
+	return &CertBundle{
+		CertPEM: secret.Data[corev1.TLSCertKey],
+		KeyPEM:  secret.Data[corev1.TLSPrivateKeyKey],
+		CAPEM:   secret.Data[CACertKey],
+	}, nil
+}
+
+func (s *SecretCertStore) Save(ctx context.Context, bundle *CertBundle) error {
+	return SaveCertSecret(ctx, s.Clientset, s.Namespace, s.Name, bundle.CertPEM, bundle.KeyPEM, bundle.CAPEM)
+}
@@ -0,0 +1,65 @@
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCertStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admission-registry-certs")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	stores := []struct {
+		name  string
+		store CertStore
+	}{
+		{name: "file", store: &FileCertStore{
+			CertFile: filepath.Join(dir, "certs", "tls.crt"),
+			KeyFile:  filepath.Join(dir, "certs", "tls.key"),
+			CAFile:   filepath.Join(dir, "certs", "ca.crt"),
+		}},
+		{name: "secret", store: &SecretCertStore{
+			Clientset: fake.NewSimpleClientset(),
+			Namespace: "default",
+			Name:      "admission-registry-tls",
+		}},
+	}
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if _, err := tt.store.Load(ctx); err == nil {
+				t.Fatalf("load from an empty store succeeded, want error")
+			}
+
+			first := &CertBundle{CertPEM: []byte("cert-1"), KeyPEM: []byte("key-1"), CAPEM: []byte("ca-1")}
+			if err := tt.store.Save(ctx, first); err != nil {
+				t.Fatalf("save: %v", err)
+			}
+			got, err := tt.store.Load(ctx)
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			if !reflect.DeepEqual(got, first) {
+				t.Errorf("load = %+v, want %+v", got, first)
+			}
+
+			// 再次保存会覆盖已有的证书
+			second := &CertBundle{CertPEM: []byte("cert-2"), KeyPEM: []byte("key-2"), CAPEM: []byte("ca-2")}
+			if err := tt.store.Save(ctx, second); err != nil {
+				t.Fatalf("save again: %v", err)
+			}
+			if got, err = tt.store.Load(ctx); err != nil || !reflect.DeepEqual(got, second) {
+				t.Errorf("load after overwrite = %+v, %v, want %+v", got, err, second)
+			}
+		})
+	}
+}
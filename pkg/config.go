@@ -0,0 +1,94 @@
+package pkg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Config 是通过 --config 指定的 YAML 配置文件，环境变量和命令行参数的优先级高于文件中的值
+type Config struct {
+	WhiteListRegistries    []string        `json:"whiteListRegistries,omitempty"`    // 对应 WHITELIST_REGISTRIES
+	DenyListRegistries     []string        `json:"denyListRegistries,omitempty"`     // 对应 DENYLIST_REGISTRIES
+	FailOpen               *bool           `json:"failOpen,omitempty"`               // 处理请求发生 panic 时是否放行，对应 --failOpen
+	ShutdownTimeout        metav1.Duration `json:"shutdownTimeout,omitempty"`        // 对应 --shutdown-timeout
+	WorkerDeadline         metav1.Duration `json:"workerDeadline,omitempty"`         // 对应 WORKER_DEADLINE
+	MutatePolicy           string          `json:"mutatePolicy,omitempty"`           // opt-out 或 opt-in，对应 MUTATE_POLICY
+	MutateExemptNamespaces []string        `json:"mutateExemptNamespaces,omitempty"` // 对应 MUTATE_EXEMPT_NAMESPACES
+}
+
+// LoadConfig 从 YAML/JSON 文件中加载配置，未知的字段会返回错误，避免拼写错误的配置被静默忽略
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("parse config %s: %v", path, err)
+	}
+	switch config.MutatePolicy {
+	case "", MutatePolicyOptOut, MutatePolicyOptIn:
+	default:
+		return nil, fmt.Errorf("invalid mutatePolicy %q in %s, expect %s or %s", config.MutatePolicy, path, MutatePolicyOptOut, MutatePolicyOptIn)
+	}
+	return &config, nil
+}
+
+// envVars 是可以覆盖配置文件的环境变量以及对应的配置项，按照启动时检查的顺序排列；
+// Deprecated 的是早于配置文件就已经存在的旧环境变量
+var envVars = []struct {
+	Name       string
+	Field      string
+	Deprecated bool
+	apply      func(config *Config, value string) error
+}{
+	{Name: "WHITELIST_REGISTRIES", Field: "whiteListRegistries", Deprecated: true, apply: func(config *Config, value string) error {
+		config.WhiteListRegistries = SplitList(value)
+		return nil
+	}},
+	{Name: "DENYLIST_REGISTRIES", Field: "denyListRegistries", apply: func(config *Config, value string) error {
+		config.DenyListRegistries = SplitList(value)
+		return nil
+	}},
+	{Name: "WORKER_DEADLINE", Field: "workerDeadline", apply: func(config *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		config.WorkerDeadline = metav1.Duration{Duration: d}
+		return nil
+	}},
+	{Name: "MUTATE_POLICY", Field: "mutatePolicy", apply: func(config *Config, value string) error {
+		if value != MutatePolicyOptOut && value != MutatePolicyOptIn {
+			return fmt.Errorf("expect %s or %s", MutatePolicyOptOut, MutatePolicyOptIn)
+		}
+		config.MutatePolicy = value
+		return nil
+	}},
+	{Name: "MUTATE_EXEMPT_NAMESPACES", Field: "mutateExemptNamespaces", apply: func(config *Config, value string) error {
+		config.MutateExemptNamespaces = SplitList(value)
+		return nil
+	}},
+}
+
+// ApplyEnv 把设置了的环境变量的值写入 config，环境变量的优先级高于配置文件；
+// 每个设置了的旧环境变量对应一条弃用警告，由调用方在启动时输出
+func ApplyEnv(config *Config, lookup func(key string) (string, bool)) (warnings []string, err error) {
+	for _, env := range envVars {
+		value, ok := lookup(env.Name)
+		if !ok || value == "" {
+			continue
+		}
+		if err := env.apply(config, value); err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", env.Name, value, err)
+		}
+		if env.Deprecated {
+			warnings = append(warnings, fmt.Sprintf("Environment variable %s is deprecated, set %s in the --config file instead", env.Name, env.Field))
+		}
+	}
+	return warnings, nil
+}
@@ -0,0 +1,130 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "admission-registry-config")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `
+whiteListRegistries:
+- docker.io
+- "*.example.com"
+denyListRegistries:
+- legacy.example.com
+failOpen: true
+shutdownTimeout: 30s
+workerDeadline: 5s
+mutatePolicy: opt-in
+mutateExemptNamespaces:
+- kube-system
+`)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	failOpen := true
+	want := &Config{
+		WhiteListRegistries:    []string{"docker.io", "*.example.com"},
+		DenyListRegistries:     []string{"legacy.example.com"},
+		FailOpen:               &failOpen,
+		ShutdownTimeout:        metav1.Duration{Duration: 30 * time.Second},
+		WorkerDeadline:         metav1.Duration{Duration: 5 * time.Second},
+		MutatePolicy:           MutatePolicyOptIn,
+		MutateExemptNamespaces: []string{"kube-system"},
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("config = %+v, want %+v", config, want)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "unknown field", content: "whitelist: [docker.io]"},
+		{name: "invalid failOpen", content: "failOpen: sometimes"},
+		{name: "invalid mutate policy", content: "mutatePolicy: always"},
+		{name: "invalid duration", content: "shutdownTimeout: soon"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadConfig(writeConfig(t, tt.content)); err == nil {
+				t.Errorf("load config succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"WHITELIST_REGISTRIES": "docker.io,gcr.io",
+		"WORKER_DEADLINE":      "3s",
+		"MUTATE_POLICY":        "",
+	}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+	config := &Config{
+		WhiteListRegistries: []string{"quay.io"},
+		DenyListRegistries:  []string{"legacy.example.com"},
+		MutatePolicy:        MutatePolicyOptIn,
+	}
+	warnings, err := ApplyEnv(config, lookup)
+	if err != nil {
+		t.Fatalf("apply env: %v", err)
+	}
+	// 设置了的环境变量覆盖配置文件中的值，空的环境变量被忽略
+	want := &Config{
+		WhiteListRegistries: []string{"docker.io", "gcr.io"},
+		DenyListRegistries:  []string{"legacy.example.com"},
+		WorkerDeadline:      metav1.Duration{Duration: 3 * time.Second},
+		MutatePolicy:        MutatePolicyOptIn,
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("config = %+v, want %+v", config, want)
+	}
+	// 只有旧的 WHITELIST_REGISTRIES 输出弃用警告
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "WHITELIST_REGISTRIES") || !strings.Contains(warnings[0], "whiteListRegistries") {
+		t.Errorf("warnings = %q, want one for WHITELIST_REGISTRIES", warnings)
+	}
+}
+
+func TestApplyEnvInvalid(t *testing.T) {
+	for key, value := range map[string]string{
+		"WORKER_DEADLINE": "soon",
+		"MUTATE_POLICY":   "always",
+	} {
+		t.Run(key, func(t *testing.T) {
+			lookup := func(k string) (string, bool) {
+				return value, k == key
+			}
+			if _, err := ApplyEnv(&Config{}, lookup); err == nil || !strings.Contains(err.Error(), key) {
+				t.Errorf("err = %v, want an error naming %s", err, key)
+			}
+		})
+	}
+}
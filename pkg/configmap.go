@@ -0,0 +1,42 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// SaveCAConfigMap 将 CA 证书保存到 ConfigMap 的 ca.crt 中，方便其他组件信任 webhook 的证书，已存在时只更新 ca.crt
+func SaveCAConfigMap(ctx context.Context, clientset kubernetes.Interface, namespace, name string, caPEM []byte) error {
+	configMapClient := clientset.CoreV1().ConfigMaps(namespace)
+	configMap, err := configMapClient.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("get configmap %s/%s: %v", namespace, name, err)
+		}
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+			Data: map[string]string{CACertKey: string(caPEM)},
+		}
+		if _, err := configMapClient.Create(ctx, configMap, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("create configmap %s/%s: %v", namespace, name, err)
+		}
+		return nil
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[CACertKey] = string(caPEM)
+	if _, err := configMapClient.Update(ctx, configMap, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("update configmap %s/%s: %v", namespace, name, err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSaveCAConfigMap(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset()
+	if err := SaveCAConfigMap(ctx, clientset, "default", "admission-registry-ca", []byte("ca-1")); err != nil {
+		t.Fatalf("save configmap: %v", err)
+	}
+	configMap, err := clientset.CoreV1().ConfigMaps("default").Get(ctx, "admission-registry-ca", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get configmap: %v", err)
+	}
+	if len(configMap.Data) != 1 || configMap.Data[CACertKey] != "ca-1" {
+		t.Errorf("data = %v, want only ca.crt=ca-1", configMap.Data)
+	}
+
+	// 已存在时只更新 ca.crt，保留其他的 key
+	configMap.Data["extra"] = "keep"
+	if _, err := clientset.CoreV1().ConfigMaps("default").Update(ctx, configMap, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update configmap: %v", err)
+	}
+	if err := SaveCAConfigMap(ctx, clientset, "default", "admission-registry-ca", []byte("ca-2")); err != nil {
+		t.Fatalf("save configmap again: %v", err)
+	}
+	configMap, err = clientset.CoreV1().ConfigMaps("default").Get(ctx, "admission-registry-ca", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get configmap: %v", err)
+	}
+	if configMap.Data[CACertKey] != "ca-2" || configMap.Data["extra"] != "keep" {
+		t.Errorf("data = %v, want ca.crt=ca-2 and extra=keep", configMap.Data)
+	}
+}
+
+func TestSaveCAConfigMapWithoutData(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "admission-registry-ca", Namespace: "default"},
+	})
+	if err := SaveCAConfigMap(ctx, clientset, "default", "admission-registry-ca", []byte("ca")); err != nil {
+		t.Fatalf("save configmap: %v", err)
+	}
+	configMap, err := clientset.CoreV1().ConfigMaps("default").Get(ctx, "admission-registry-ca", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get configmap: %v", err)
+	}
+	if configMap.Data[CACertKey] != "ca" {
+		t.Errorf("data = %v, want ca.crt=ca", configMap.Data)
+	}
+}
@@ -0,0 +1,108 @@
+package pkg
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RegistryCredential 访问镜像仓库使用的账号密码
+type RegistryCredential struct {
+	Username string
+	Password string
+}
+
+// CredentialProvider 根据镜像仓库地址返回对应的认证信息
+type CredentialProvider interface {
+	Credential(registry string) (RegistryCredential, bool)
+}
+
+// DockerConfigCredentials 是从 docker config（~/.docker/config.json 或者 kubernetes.io/dockerconfigjson Secret）解析出来的认证信息
+type DockerConfigCredentials map[string]RegistryCredential
+
+func (c DockerConfigCredentials) Credential(registry string) (RegistryCredential, bool) {
+	cred, ok := c[normalizeRegistryHost(registry)]
+	return cred, ok
+}
+
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+}
+
+// ParseDockerConfig 解析 docker config json，后解析的内容会覆盖相同仓库的认证信息
+func (c DockerConfigCredentials) ParseDockerConfig(data []byte) error {
+	var cfg dockerConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return err
+	}
+	for host, auth := range cfg.Auths {
+		cred := RegistryCredential{Username: auth.Username, Password: auth.Password}
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return fmt.Errorf("decode auth for %s: %v", host, err)
+			}
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid auth for %s", host)
+			}
+			cred = RegistryCredential{Username: parts[0], Password: parts[1]}
+		}
+		c[normalizeRegistryHost(host)] = cred
+	}
+	return nil
+}
+
+// LoadDockerConfigFile 加载挂载到容器中的 docker config 文件
+func (c DockerConfigCredentials) LoadDockerConfigFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return c.ParseDockerConfig(data)
+}
+
+// LoadPullSecrets 加载命名空间中的 imagePullSecrets
+func (c DockerConfigCredentials) LoadPullSecrets(ctx context.Context, clientset kubernetes.Interface, namespace string, names []string) error {
+	for _, name := range names {
+		secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return secretError(namespace, name, err)
+		}
+		data, ok := secret.Data[corev1.DockerConfigJsonKey]
+		if !ok {
+			return fmt.Errorf("secret %s/%s has no %s key", namespace, name, corev1.DockerConfigJsonKey)
+		}
+		if err := c.ParseDockerConfig(data); err != nil {
+			return fmt.Errorf("parse secret %s/%s: %v", namespace, name, err)
+		}
+	}
+	return nil
+}
+
+// normalizeRegistryHost docker config 中的仓库地址可能带有协议和路径，比如 https://index.docker.io/v1/
+func normalizeRegistryHost(host string) string {
+	if strings.Contains(host, "://") {
+		if u, err := url.Parse(host); err == nil {
+			host = u.Host
+		}
+	}
+	host = strings.SplitN(host, "/", 2)[0]
+	switch host {
+	case "index.docker.io", "registry-1.docker.io":
+		return defaultRegistry
+	}
+	return host
+}
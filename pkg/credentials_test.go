@@ -0,0 +1,166 @@
+package pkg
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDockerConfigCredentials(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("robot:s3cr:et"))
+	config := `{"auths": {
+		"https://index.docker.io/v1/": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("hub-user:hub-pass")) + `"},
+		"harbor.example.com": {"auth": "` + auth + `"},
+		"registry.example.com:5000": {"username": "plain", "password": "text"}
+	}}`
+	creds := DockerConfigCredentials{}
+	if err := creds.ParseDockerConfig([]byte(config)); err != nil {
+		t.Fatalf("parse docker config: %v", err)
+	}
+
+	tests := []struct {
+		registry string
+		want     RegistryCredential
+		found    bool
+	}{
+		// index.docker.io 的认证信息用于 docker.io
+		{registry: "docker.io", want: RegistryCredential{Username: "hub-user", Password: "hub-pass"}, found: true},
+		// 密码中的冒号保留
+		{registry: "harbor.example.com", want: RegistryCredential{Username: "robot", Password: "s3cr:et"}, found: true},
+		{registry: "registry.example.com:5000", want: RegistryCredential{Username: "plain", Password: "text"}, found: true},
+		{registry: "registry.example.com", found: false},
+		{registry: "gcr.io", found: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.registry, func(t *testing.T) {
+			cred, ok := creds.Credential(tt.registry)
+			if ok != tt.found || cred != tt.want {
+				t.Errorf("credential = %+v, %v, want %+v, %v", cred, ok, tt.want, tt.found)
+			}
+		})
+	}
+
+	for _, invalid := range []string{`not json`, `{"auths": {"a.io": {"auth": "!!"}}}`, `{"auths": {"a.io": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("nocolon")) + `"}}}`} {
+		if err := (DockerConfigCredentials{}).ParseDockerConfig([]byte(invalid)); err == nil {
+			t.Errorf("parse %s succeeded, want error", invalid)
+		}
+	}
+}
+
+func TestLoadDockerConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admission-registry-dockerconfig")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{"auths": {"harbor.example.com": {"username": "robot", "password": "secret"}}}`), 0600); err != nil {
+		t.Fatalf("write docker config: %v", err)
+	}
+	creds := DockerConfigCredentials{}
+	if err := creds.LoadDockerConfigFile(path); err != nil {
+		t.Fatalf("load docker config: %v", err)
+	}
+	if cred, ok := creds.Credential("harbor.example.com"); !ok || cred.Username != "robot" {
+		t.Errorf("credential = %+v, %v, want robot", cred, ok)
+	}
+	if err := creds.LoadDockerConfigFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("load a missing docker config succeeded, want error")
+	}
+}
+
+func TestLoadPullSecrets(t *testing.T) {
+	pullSecret := func(name, config string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "admission"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(config)},
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		pullSecret("harbor", `{"auths": {"harbor.example.com": {"username": "harbor-robot", "password": "one"}}}`),
+		pullSecret("quay", `{"auths": {"quay.io": {"username": "quay-robot", "password": "two"}}}`),
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "opaque", Namespace: "admission"}, Data: map[string][]byte{"token": []byte("x")}},
+	)
+	creds := DockerConfigCredentials{}
+	if err := creds.LoadPullSecrets(context.Background(), clientset, "admission", []string{"harbor", "quay"}); err != nil {
+		t.Fatalf("load pull secrets: %v", err)
+	}
+	// 每个仓库使用各自 Secret 中的认证信息
+	for registry, username := range map[string]string{"harbor.example.com": "harbor-robot", "quay.io": "quay-robot"} {
+		if cred, ok := creds.Credential(registry); !ok || cred.Username != username {
+			t.Errorf("credential for %s = %+v, %v, want %s", registry, cred, ok, username)
+		}
+	}
+
+	for _, name := range []string{"opaque", "missing"} {
+		if err := (DockerConfigCredentials{}).LoadPullSecrets(context.Background(), clientset, "admission", []string{name}); err == nil || !strings.Contains(err.Error(), "admission/"+name) {
+			t.Errorf("load %s: err = %v, want an error naming the secret", name, err)
+		}
+	}
+}
+
+// recordingCredentials 记录被查询的仓库地址，返回固定的认证信息
+type recordingCredentials struct {
+	cred       RegistryCredential
+	registries []string
+}
+
+func (r *recordingCredentials) Credential(registry string) (RegistryCredential, bool) {
+	r.registries = append(r.registries, registry)
+	return r.cred, true
+}
+
+func TestHTTPRegistryClientCredentials(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "robot" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", "sha256:abc")
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	tests := []struct {
+		name        string
+		credentials CredentialProvider
+		wantErr     bool
+	}{
+		{name: "matching host", credentials: DockerConfigCredentials{host: {Username: "robot", Password: "secret"}}},
+		// 其他仓库的认证信息不会发送给这个仓库
+		{name: "other host only", credentials: DockerConfigCredentials{"harbor.example.com": {Username: "robot", Password: "secret"}}, wantErr: true},
+		{name: "anonymous", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &HTTPRegistryClient{Client: server.Client(), Credentials: tt.credentials}
+			digest, err := client.ManifestDigest(context.Background(), host+"/team/app:1.0")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && digest != "sha256:abc" {
+				t.Errorf("digest = %s, want sha256:abc", digest)
+			}
+		})
+	}
+
+	recorder := &recordingCredentials{cred: RegistryCredential{Username: "robot", Password: "secret"}}
+	client := &HTTPRegistryClient{Client: server.Client(), Credentials: recorder}
+	if _, err := client.ManifestDigest(context.Background(), host+"/team/app:1.0"); err != nil {
+		t.Fatalf("manifest digest: %v", err)
+	}
+	if len(recorder.registries) != 1 || recorder.registries[0] != host {
+		t.Errorf("credentials requested for %v, want [%s]", recorder.registries, host)
+	}
+}
@@ -0,0 +1,39 @@
+package pkg
+
+import (
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+// EventReasonAdmissionDenied 是拒绝准入请求时记录的 Event 的 reason
+const EventReasonAdmissionDenied = "AdmissionDenied"
+
+// NewEventRecorder 创建把 Event 写入集群的 EventRecorder，broadcaster 在后台异步发送，不会阻塞准入响应
+func NewEventRecorder(clientset kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "admission-registry"})
+}
+
+// recordDenial 在被拒绝的对象上记录一个 Warning Event，CREATE 请求的对象还不存在，Event 只关联到名称和命名空间
+func (s *WebhookServer) recordDenial(req *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse) {
+	if s.EventRecorder == nil || resp.Allowed || resp.Result == nil {
+		return
+	}
+	ref := &corev1.ObjectReference{
+		APIVersion: req.Kind.Group + "/" + req.Kind.Version,
+		Kind:       req.Kind.Kind,
+		Namespace:  req.Namespace,
+		Name:       req.Name,
+	}
+	if req.Kind.Group == "" {
+		ref.APIVersion = req.Kind.Version
+	}
+	klog.V(2).Infof("Record denial event for %s %s/%s", req.Kind.Kind, req.Namespace, req.Name)
+	s.EventRecorder.Event(ref, corev1.EventTypeWarning, EventReasonAdmissionDenied, resp.Result.Message)
+}
@@ -0,0 +1,64 @@
+package pkg
+
+import (
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// Decision 是传给 DecisionHook 的准入决策，hook 只能追加 Warnings 和 AuditAnnotations，修改 Allowed 或者已有的内容不会生效
+type Decision struct {
+	Path             string
+	Allowed          bool
+	Message          string
+	Warnings         []string
+	AuditAnnotations map[string]string
+}
+
+// DecisionHook 在核心决策之后执行的自定义逻辑，比如补充警告信息、输出自定义指标
+type DecisionHook interface {
+	AfterDecision(req *admissionv1.AdmissionRequest, decision *Decision)
+}
+
+// DecisionHookFunc 将普通函数转换为 DecisionHook
+type DecisionHookFunc func(req *admissionv1.AdmissionRequest, decision *Decision)
+
+func (f DecisionHookFunc) AfterDecision(req *admissionv1.AdmissionRequest, decision *Decision) {
+	f(req, decision)
+}
+
+// runDecisionHooks 依次执行 DecisionHooks，hook 拿到的是警告和审计注解的拷贝，
+// 执行完之后只合并新增加的警告和响应中还没有的审计注解，hook 不能修改或者删除已有的内容
+func (s *WebhookServer) runDecisionHooks(path string, req *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse) {
+	if len(s.DecisionHooks) == 0 {
+		return
+	}
+	decision := &Decision{
+		Path:             path,
+		Allowed:          resp.Allowed,
+		Warnings:         append([]string(nil), resp.Warnings...),
+		AuditAnnotations: map[string]string{},
+	}
+	if resp.Result != nil {
+		decision.Message = resp.Result.Message
+	}
+	for key, value := range resp.AuditAnnotations {
+		decision.AuditAnnotations[key] = value
+	}
+	for _, hook := range s.DecisionHooks {
+		hook.AfterDecision(req, decision)
+	}
+
+	for _, warning := range decision.Warnings {
+		if !containsString(resp.Warnings, warning) {
+			resp.Warnings = append(resp.Warnings, warning)
+		}
+	}
+	for key, value := range decision.AuditAnnotations {
+		if _, ok := resp.AuditAnnotations[key]; ok {
+			continue
+		}
+		if resp.AuditAnnotations == nil {
+			resp.AuditAnnotations = map[string]string{}
+		}
+		resp.AuditAnnotations[key] = value
+	}
+}
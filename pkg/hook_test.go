@@ -0,0 +1,77 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+func TestDecisionHooks(t *testing.T) {
+	sample := DecisionHookFunc(func(req *admissionv1.AdmissionRequest, decision *Decision) {
+		decision.Warnings = append(decision.Warnings, "reviewed by "+decision.Path)
+		decision.AuditAnnotations["team"] = "platform"
+	})
+	tests := []struct {
+		name            string
+		image           string
+		hooks           []DecisionHook
+		allowed         bool
+		warnings        []string
+		wantAnnotations map[string]string
+	}{
+		{name: "sample hook on allowed request", image: "docker.io/nginx", hooks: []DecisionHook{sample}, allowed: true,
+			warnings: []string{"reviewed by /validate"},
+			wantAnnotations: map[string]string{
+				AuditAnnotationCorrelationID: "test-test", AuditAnnotationDecision: "allow", "team": "platform",
+			}},
+		{name: "sample hook on denied request", image: "evil.example.com/nginx", hooks: []DecisionHook{sample}, allowed: false,
+			warnings: []string{"reviewed by /validate"},
+			wantAnnotations: map[string]string{
+				AuditAnnotationCorrelationID: "test-test", AuditAnnotationDecision: "deny", "team": "platform",
+			}},
+		{name: "hooks cannot flip or rewrite the decision", image: "evil.example.com/nginx", hooks: []DecisionHook{
+			DecisionHookFunc(func(req *admissionv1.AdmissionRequest, decision *Decision) {
+				decision.Allowed = true
+				decision.AuditAnnotations[AuditAnnotationDecision] = "allow"
+				delete(decision.AuditAnnotations, AuditAnnotationCorrelationID)
+			}),
+		}, allowed: false, wantAnnotations: map[string]string{
+			AuditAnnotationCorrelationID: "test-test", AuditAnnotationDecision: "deny",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.DecisionHooks = tt.hooks
+			resp := s.admit("/validate", newAdmissionReview(t, "Pod", newPod(tt.image, nil)))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v", resp.Allowed, tt.allowed)
+			}
+			if !reflect.DeepEqual(resp.Warnings, tt.warnings) {
+				t.Errorf("warnings = %q, want %q", resp.Warnings, tt.warnings)
+			}
+			delete(resp.AuditAnnotations, AuditAnnotationReason)
+			if !reflect.DeepEqual(resp.AuditAnnotations, tt.wantAnnotations) {
+				t.Errorf("audit annotations = %v, want %v", resp.AuditAnnotations, tt.wantAnnotations)
+			}
+		})
+	}
+}
+
+func TestDecisionHooksAppendOnly(t *testing.T) {
+	resp := &admissionv1.AdmissionResponse{
+		Allowed:          true,
+		Warnings:         []string{"quota almost exhausted"},
+		AuditAnnotations: map[string]string{AuditAnnotationDecision: "warn"},
+	}
+	s := &WebhookServer{DecisionHooks: []DecisionHook{DecisionHookFunc(func(req *admissionv1.AdmissionRequest, decision *Decision) {
+		// 修改拷贝中已有的警告不会影响响应中原来的警告，修改后的内容当作新的警告
+		decision.Warnings[0] = "rewritten"
+		decision.Warnings = append(decision.Warnings, "quota almost exhausted", "extra")
+	})}}
+	s.runDecisionHooks("/validate", &admissionv1.AdmissionRequest{}, resp)
+	if want := []string{"quota almost exhausted", "rewritten", "extra"}; !reflect.DeepEqual(resp.Warnings, want) {
+		t.Errorf("warnings = %q, want %q", resp.Warnings, want)
+	}
+}
@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const defaultRegistry = "docker.io"
+
+// splitImageRegistry 将镜像地址拆分为镜像仓库地址和剩余的 repository[:tag][@digest] 部分
+// 没有显式指定仓库地址的镜像（比如 nginx、library/nginx）默认属于 docker.io
+func splitImageRegistry(image string) (registry, remainder string) {
+	i := strings.IndexRune(image, '/')
+	if i == -1 {
+		return defaultRegistry, image
+	}
+	host := image[:i]
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return defaultRegistry, image
+	}
+	return host, image[i+1:]
+}
+
+// isDockerHub 判断镜像仓库是否为 Docker Hub
+func isDockerHub(registry string) bool {
+	switch registry {
+	case defaultRegistry, "index.docker.io", "registry-1.docker.io":
+		return true
+	}
+	return false
+}
+
+// resolveMirror 如果镜像所在的仓库配置了镜像加速（mirror），返回实际拉取时使用的镜像地址
+func resolveMirror(image string, mirrors map[string]string) string {
+	if len(mirrors) == 0 {
+		return image
+	}
+	registry, remainder := splitImageRegistry(image)
+	if mirror, ok := mirrors[registry]; ok && mirror != "" {
+		return strings.TrimSuffix(mirror, "/") + "/" + remainder
+	}
+	return image
+}
+
+// imageReference 是解析后的镜像地址
+type imageReference struct {
+	Registry   string // 镜像仓库地址，比如 docker.io、registry:5000
+	Repository string // 镜像名称，docker.io 的官方镜像会补全 library/ 前缀
+	Tag        string // 镜像 tag，没有指定时为空
+	Digest     string // 镜像 digest，比如 sha256:xxx，没有指定时为空
+}
+
+// parseImageReference 解析镜像地址，注意 registry:5000/app 这种带端口的地址中的冒号不是 tag 分隔符
+func parseImageReference(image string) imageReference {
+	registry, remainder := splitImageRegistry(image)
+	ref := imageReference{Registry: registry}
+
+	if i := strings.IndexRune(remainder, '@'); i != -1 {
+		ref.Digest = remainder[i+1:]
+		remainder = remainder[:i]
+	}
+	if i := strings.LastIndex(remainder, ":"); i != -1 && !strings.Contains(remainder[i+1:], "/") {
+		ref.Tag = remainder[i+1:]
+		remainder = remainder[:i]
+	}
+	if registry == defaultRegistry && !strings.Contains(remainder, "/") {
+		remainder = "library/" + remainder
+	}
+	ref.Repository = remainder
+	return ref
+}
+
+var (
+	digestPattern    = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
+	digestHexLengths = map[string]int{"sha256": 64, "sha512": 128}
+	lowerHexPattern  = regexp.MustCompile(`^[a-f0-9]+$`)
+)
+
+// validateDigest 按照 OCI 规范校验 digest 的格式，sha256/sha512 还要求是指定长度的小写十六进制
+func validateDigest(digest string) error {
+	if !digestPattern.MatchString(digest) {
+		return fmt.Errorf("malformed digest %q", digest)
+	}
+	parts := strings.SplitN(digest, ":", 2)
+	if length, ok := digestHexLengths[parts[0]]; ok {
+		if !lowerHexPattern.MatchString(parts[1]) {
+			return fmt.Errorf("malformed %s digest %q, expect lowercase hex", parts[0], digest)
+		}
+		if len(parts[1]) != length {
+			return fmt.Errorf("malformed %s digest %q, expect %d hex characters but got %d", parts[0], digest, length, len(parts[1]))
+		}
+	}
+	return nil
+}
+
+// Reference 返回用于访问 manifest 的引用，优先使用 digest
+func (r imageReference) Reference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	if r.Tag != "" {
+		return r.Tag
+	}
+	return "latest"
+}
+
+func (r imageReference) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveMirror(t *testing.T) {
+	mirrors := map[string]string{"docker.io": "mirror.internal/", "gcr.io": "gcr-mirror.internal"}
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{image: "nginx:1.19", want: "mirror.internal/nginx:1.19"},
+		{image: "docker.io/library/nginx:1.19", want: "mirror.internal/library/nginx:1.19"},
+		{image: "gcr.io/google-containers/pause:3.2", want: "gcr-mirror.internal/google-containers/pause:3.2"},
+		{image: "quay.io/coreos/etcd:v3.4", want: "quay.io/coreos/etcd:v3.4"},
+	}
+	for _, tt := range tests {
+		if got := resolveMirror(tt.image, mirrors); got != tt.want {
+			t.Errorf("resolveMirror(%s) = %s, want %s", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestValidateRegistryMirrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		allowed bool
+	}{
+		{name: "implicit docker hub through a trusted mirror", image: "nginx:1.19", allowed: true},
+		{name: "explicit docker hub through a trusted mirror", image: "docker.io/library/nginx:1.19", allowed: true},
+		{name: "mirror referenced directly", image: "mirror.internal/library/nginx:1.19", allowed: true},
+		{name: "non-mirrored trusted registry", image: "quay.io/coreos/etcd:v3.4", allowed: true},
+		{name: "non-mirrored untrusted registry", image: "gcr.io/google-containers/pause:3.2", allowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "mirror.internal", "quay.io")
+			s.RegistryMirrors = map[string]string{"docker.io": "mirror.internal"}
+			resp := s.validate(newAdmissionReview(t, "Pod", newPod(tt.image, nil)))
+			if resp.Allowed != tt.allowed {
+				t.Errorf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+		})
+	}
+
+	// 白名单中只有原始的仓库地址时，配置了 mirror 的镜像依然会被放行
+	s := newTestServer(t, "docker.io")
+	s.RegistryMirrors = map[string]string{"docker.io": "mirror.internal"}
+	if resp := s.validate(newAdmissionReview(t, "Pod", newPod("nginx:1.19", nil))); !resp.Allowed {
+		t.Errorf("mirrored image of a whitelisted registry denied, result %+v", resp.Result)
+	}
+}
+
+func TestValidateDigest(t *testing.T) {
+	valid := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		name    string
+		digest  string
+		message string
+	}{
+		{name: "valid sha256", digest: valid},
+		{name: "valid sha512", digest: "sha512:" + strings.Repeat("ab", 64)},
+		// 未知的算法只校验 OCI 格式
+		{name: "unknown algorithm", digest: "blake3:abc123"},
+		{name: "truncated sha256", digest: valid[:len(valid)-8], message: "expect 64 hex characters but got 56"},
+		{name: "too long sha256", digest: valid + "00", message: "expect 64 hex characters but got 66"},
+		{name: "uppercase hex", digest: strings.ToUpper(valid[:7]) + valid[7:], message: "malformed"},
+		{name: "non hex sha256", digest: "sha256:" + strings.Repeat("zz", 32), message: "expect lowercase hex"},
+		{name: "missing algorithm", digest: ":" + valid[7:], message: "malformed digest"},
+		{name: "missing hex", digest: "sha256:", message: "malformed digest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateDigest(tt.digest)
+			if (err == nil) != (tt.message == "") {
+				t.Fatalf("validateDigest(%s) = %v, want error %v", tt.digest, err, tt.message != "")
+			}
+			if err != nil && !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.message)
+			}
+		})
+	}
+}
+
+func TestValidateMalformedDigest(t *testing.T) {
+	digest := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		name    string
+		image   string
+		message string
+	}{
+		{name: "valid digest", image: "nginx@" + digest},
+		{name: "tag and digest", image: "nginx:1.19@" + digest},
+		{name: "truncated digest", image: "nginx@" + digest[:20], message: "image has an invalid digest"},
+		{name: "malformed digest", image: "nginx@sha256:" + strings.Repeat("g", 64), message: "image has an invalid digest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := newTestServer(t, "docker.io").validate(newAdmissionReview(t, "Pod", newPod(tt.image, nil)))
+			if resp.Allowed != (tt.message == "") {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.message == "", resp.Result)
+			}
+			if tt.message != "" && !strings.Contains(resp.Result.Message, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", resp.Result.Message, tt.message)
+			}
+		})
+	}
+}
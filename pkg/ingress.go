@@ -0,0 +1,52 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// checkIngress 校验 Ingress 的 host 是否属于允许的域名后缀，以及是否为每个 host 配置了 TLS
+func (s *WebhookServer) checkIngress(ingress *networkingv1.Ingress) string {
+	tlsHosts := map[string]bool{}
+	for _, tls := range ingress.Spec.TLS {
+		for _, host := range tls.Hosts {
+			tlsHosts[host] = true
+		}
+	}
+	if s.RequireIngressTLS && len(ingress.Spec.TLS) == 0 {
+		return fmt.Sprintf("Ingress %s must configure TLS!", ingress.Name)
+	}
+
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host == "" {
+			if len(s.AllowedIngressDomains) > 0 {
+				return fmt.Sprintf("Ingress %s has a rule without host! Hosts must be under %v.", ingress.Name, s.AllowedIngressDomains)
+			}
+			continue
+		}
+		if len(s.AllowedIngressDomains) > 0 && !hostAllowed(rule.Host, s.AllowedIngressDomains) {
+			return fmt.Sprintf("Ingress %s host %s is not under an approved domain! Allowed domains: %v", ingress.Name, rule.Host, s.AllowedIngressDomains)
+		}
+		if s.RequireIngressTLS && !tlsHosts[rule.Host] {
+			return fmt.Sprintf("Ingress %s host %s is not covered by TLS!", ingress.Name, rule.Host)
+		}
+	}
+	return ""
+}
+
+// hostAllowed 判断 host（包括 *.example.com 形式的通配 host）是否等于某个域名或者是它的子域名
+func hostAllowed(host string, domains []string) bool {
+	host = strings.ToLower(strings.TrimPrefix(host, "*."))
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "."))
+		if domain == "" {
+			continue
+		}
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
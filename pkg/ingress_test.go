@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateIngress(t *testing.T) {
+	rules := func(hosts ...string) []networkingv1.IngressRule {
+		var rules []networkingv1.IngressRule
+		for _, host := range hosts {
+			rules = append(rules, networkingv1.IngressRule{Host: host})
+		}
+		return rules
+	}
+	tests := []struct {
+		name    string
+		rules   []networkingv1.IngressRule
+		tls     []networkingv1.IngressTLS
+		message string
+	}{
+		{name: "compliant", rules: rules("web.example.com"), tls: []networkingv1.IngressTLS{{Hosts: []string{"web.example.com"}}}},
+		{name: "apex domain", rules: rules("example.com"), tls: []networkingv1.IngressTLS{{Hosts: []string{"example.com"}}}},
+		{name: "wildcard host", rules: rules("*.apps.example.com"), tls: []networkingv1.IngressTLS{{Hosts: []string{"*.apps.example.com"}}}},
+		{name: "host outside approved domains", rules: rules("web.example.com", "shop.evil.io"), tls: []networkingv1.IngressTLS{{Hosts: []string{"web.example.com", "shop.evil.io"}}}, message: "Ingress web host shop.evil.io is not under an approved domain!"},
+		// 只匹配完整的域名后缀
+		{name: "suffix lookalike", rules: rules("web.notexample.com"), tls: []networkingv1.IngressTLS{{Hosts: []string{"web.notexample.com"}}}, message: "host web.notexample.com is not under an approved domain"},
+		{name: "rule without host", rules: []networkingv1.IngressRule{{}}, tls: []networkingv1.IngressTLS{{Hosts: []string{"web.example.com"}}}, message: "has a rule without host"},
+		{name: "no TLS", rules: rules("web.example.com"), message: "Ingress web must configure TLS!"},
+		{name: "host not covered by TLS", rules: rules("web.example.com", "api.example.com"), tls: []networkingv1.IngressTLS{{Hosts: []string{"web.example.com"}}}, message: "Ingress web host api.example.com is not covered by TLS!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.AllowedIngressDomains = []string{"example.com"}
+			s.RequireIngressTLS = true
+			ingress := &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+				Spec:       networkingv1.IngressSpec{Rules: tt.rules, TLS: tt.tls},
+			}
+			resp := s.validate(newAdmissionReview(t, "Ingress", ingress))
+			if resp.Allowed != (tt.message == "") {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.message == "", resp.Result)
+			}
+			if tt.message != "" && !strings.Contains(resp.Result.Message, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", resp.Result.Message, tt.message)
+			}
+		})
+	}
+
+	// 没有配置时不校验 Ingress
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       networkingv1.IngressSpec{Rules: rules("shop.evil.io")},
+	}
+	if resp := newTestServer(t).validate(newAdmissionReview(t, "Ingress", ingress)); !resp.Allowed {
+		t.Errorf("allowed = false without ingress policies, result %+v", resp.Result)
+	}
+}
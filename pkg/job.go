@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// validateJob 校验 Job 和 CronJob（batch/v1 与 batch/v1beta1 的 CronJob 结构相同）中的 Job spec
+func (s *WebhookServer) validateJob(req *admissionv1.AdmissionRequest, mode EnforcementMode) *admissionv1.AdmissionResponse {
+	var (
+		spec     *batchv1.JobSpec
+		what     string
+		specPath string
+		err      error
+	)
+	if req.Kind.Kind == "CronJob" {
+		var cronJob batchv1beta1.CronJob
+		err = json.Unmarshal(req.Object.Raw, &cronJob)
+		spec, what, specPath = &cronJob.Spec.JobTemplate.Spec, fmt.Sprintf("CronJob %s spec.jobTemplate", req.Name), "spec.jobTemplate.spec"
+	} else {
+		var job batchv1.Job
+		err = json.Unmarshal(req.Object.Raw, &job)
+		spec, what, specPath = &job.Spec, fmt.Sprintf("Job %s", req.Name), "spec"
+	}
+	if err != nil {
+		klog.Errorf("Can't unmarshal object raw: %v", err)
+		s.recordDecodeFailure(req.Kind.Kind, "/validate")
+		return &admissionv1.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		}
+	}
+
+	var (
+		msg    string
+		causes []metav1.StatusCause
+	)
+	if s.RequireJobLimits {
+		msg = s.checkJobLimits(what, spec)
+	}
+	if msg == "" {
+		msg, causes = s.checkPodTemplate(req.Namespace, what, specPath+".template", &spec.Template.Spec)
+	}
+	var warnings []string
+	if msg != "" {
+		if mode == ModeWarn {
+			warnings = append(warnings, msg)
+		} else {
+			return forbidden(req, msg, causes, nil)
+		}
+	}
+	return &admissionv1.AdmissionResponse{
+		Allowed:  true,
+		Warnings: warnings,
+		Result: &metav1.Status{
+			Code: http.StatusOK,
+		},
+	}
+}
+
+// checkJobLimits 要求 Job 设置 backoffLimit 和 activeDeadlineSeconds，并且不超过配置的上限
+func (s *WebhookServer) checkJobLimits(what string, spec *batchv1.JobSpec) string {
+	if spec.BackoffLimit == nil {
+		return fmt.Sprintf("%s must set spec.backoffLimit!", what)
+	}
+	if s.MaxJobBackoffLimit > 0 && *spec.BackoffLimit > s.MaxJobBackoffLimit {
+		return fmt.Sprintf("%s spec.backoffLimit %d exceeds the maximum %d!", what, *spec.BackoffLimit, s.MaxJobBackoffLimit)
+	}
+	if spec.ActiveDeadlineSeconds == nil {
+		return fmt.Sprintf("%s must set spec.activeDeadlineSeconds!", what)
+	}
+	if s.MaxJobActiveDeadlineSeconds > 0 && *spec.ActiveDeadlineSeconds > s.MaxJobActiveDeadlineSeconds {
+		return fmt.Sprintf("%s spec.activeDeadlineSeconds %d exceeds the maximum %d!", what, *spec.ActiveDeadlineSeconds, s.MaxJobActiveDeadlineSeconds)
+	}
+	return ""
+}
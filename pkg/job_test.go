@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateJobLimits(t *testing.T) {
+	int32Ptr := func(i int32) *int32 { return &i }
+	int64Ptr := func(i int64) *int64 { return &i }
+	tests := []struct {
+		name           string
+		backoffLimit   *int32
+		activeDeadline *int64
+		message        string
+	}{
+		{name: "compliant", backoffLimit: int32Ptr(3), activeDeadline: int64Ptr(600)},
+		{name: "at the maximum", backoffLimit: int32Ptr(6), activeDeadline: int64Ptr(3600)},
+		{name: "missing backoffLimit", activeDeadline: int64Ptr(600), message: "must set spec.backoffLimit!"},
+		{name: "backoffLimit too large", backoffLimit: int32Ptr(7), activeDeadline: int64Ptr(600), message: "spec.backoffLimit 7 exceeds the maximum 6!"},
+		{name: "missing activeDeadlineSeconds", backoffLimit: int32Ptr(3), message: "must set spec.activeDeadlineSeconds!"},
+		{name: "activeDeadlineSeconds too large", backoffLimit: int32Ptr(3), activeDeadline: int64Ptr(3601), message: "spec.activeDeadlineSeconds 3601 exceeds the maximum 3600!"},
+	}
+	for _, tt := range tests {
+		jobSpec := batchv1.JobSpec{
+			BackoffLimit:          tt.backoffLimit,
+			ActiveDeadlineSeconds: tt.activeDeadline,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "busybox"}}},
+			},
+		}
+		meta := metav1.ObjectMeta{Name: "backup", Namespace: "default"}
+		kinds := []struct {
+			kind   string
+			obj    metav1.Object
+			prefix string
+		}{
+			{kind: "Job", obj: &batchv1.Job{ObjectMeta: meta, Spec: jobSpec}, prefix: "Job backup "},
+			// CronJob 校验嵌套在 jobTemplate 中的 Job spec
+			{kind: "CronJob", obj: &batchv1beta1.CronJob{ObjectMeta: meta, Spec: batchv1beta1.CronJobSpec{
+				Schedule:    "0 * * * *",
+				JobTemplate: batchv1beta1.JobTemplateSpec{Spec: jobSpec},
+			}}, prefix: "CronJob backup spec.jobTemplate "},
+		}
+		for _, k := range kinds {
+			t.Run(k.kind+"/"+tt.name, func(t *testing.T) {
+				s := newTestServer(t, "docker.io")
+				s.RequireJobLimits = true
+				s.MaxJobBackoffLimit = 6
+				s.MaxJobActiveDeadlineSeconds = 3600
+				resp := s.validate(newAdmissionReview(t, k.kind, k.obj))
+				if resp.Allowed != (tt.message == "") {
+					t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.message == "", resp.Result)
+				}
+				if tt.message != "" && !strings.Contains(resp.Result.Message, k.prefix+tt.message) {
+					t.Errorf("message = %q, want it to contain %q", resp.Result.Message, k.prefix+tt.message)
+				}
+			})
+		}
+	}
+}
+
+func TestValidateJobPodTemplate(t *testing.T) {
+	// 没有开启 RequireJobLimits 时仍然校验 Pod 模板中的镜像
+	cronJob := &batchv1beta1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "default"},
+		Spec: batchv1beta1.CronJobSpec{
+			Schedule: "0 * * * *",
+			JobTemplate: batchv1beta1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "evil.example.com/busybox"}}},
+			}}},
+		},
+	}
+	resp := newTestServer(t, "docker.io").validate(newAdmissionReview(t, "CronJob", cronJob))
+	if resp.Allowed {
+		t.Fatalf("allowed = true, want the untrusted image in the job template denied")
+	}
+	if resp.Result.Details == nil || len(resp.Result.Details.Causes) != 1 || !strings.HasPrefix(resp.Result.Details.Causes[0].Field, "spec.jobTemplate.spec.template.spec.containers[0]") {
+		t.Errorf("details = %+v, want a cause under spec.jobTemplate.spec.template.spec", resp.Result.Details)
+	}
+}
@@ -0,0 +1,85 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/klog"
+)
+
+const (
+	LogFormatText = "text" // 通过 klog 输出 key=value 形式的日志
+	LogFormatJSON = "json" // 每条日志输出为一行 JSON，方便日志系统建立索引
+)
+
+var (
+	logMu     sync.Mutex
+	logFormat           = LogFormatText
+	logOutput io.Writer = os.Stderr
+)
+
+// SetLogFormat 设置准入事件日志的格式，text 或 json
+func SetLogFormat(format string) error {
+	if format != LogFormatText && format != LogFormatJSON {
+		return fmt.Errorf("invalid log format %q, expect %s or %s", format, LogFormatText, LogFormatJSON)
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	logFormat = format
+	return nil
+}
+
+// logInfoS 输出一条带有 key/value 字段的结构化日志，keysAndValues 依次为 key 和 value
+func logInfoS(msg string, keysAndValues ...interface{}) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	if logFormat != LogFormatJSON {
+		var b strings.Builder
+		b.WriteString(msg)
+		for i := 0; i+1 < len(keysAndValues); i += 2 {
+			if s, ok := keysAndValues[i+1].(string); ok {
+				fmt.Fprintf(&b, " %v=%q", keysAndValues[i], s)
+			} else {
+				fmt.Fprintf(&b, " %v=%v", keysAndValues[i], keysAndValues[i+1])
+			}
+		}
+		klog.InfoDepth(1, b.String())
+		return
+	}
+
+	entry := map[string]interface{}{
+		"ts":    time.Now().UTC().Format(time.RFC3339Nano),
+		"level": "info",
+		"msg":   msg,
+	}
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		entry[fmt.Sprint(keysAndValues[i])] = keysAndValues[i+1]
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		klog.Errorf("Can't encode log entry %q: %v", msg, err)
+		return
+	}
+	logOutput.Write(append(data, '\n'))
+}
+
+// logDecision 记录准入请求的处理结果
+func logDecision(path, requestID string, req *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse, elapsed time.Duration) {
+	decision := "allowed"
+	if !resp.Allowed {
+		decision = "denied"
+	}
+	var message string
+	if resp.Result != nil {
+		message = resp.Result.Message
+	}
+	logInfoS("Admission decision", "path", path, "kind", req.Kind.Kind, "namespace", req.Namespace, "name", req.Name,
+		"uid", string(req.UID), "requestID", requestID, "decision", decision, "message", message, "patched", len(resp.Patch) > 0,
+		"warnings", len(resp.Warnings), "elapsed", elapsed.String())
+}
@@ -0,0 +1,63 @@
+package pkg
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog"
+)
+
+// MetricsRegistry 是没有指定 Registerer 时注册 webhook 指标的包内 registry，不使用全局的 prometheus.DefaultRegisterer，
+// 这样嵌入到其他程序中时不会因为重复注册而 panic
+var MetricsRegistry = prometheus.NewRegistry()
+
+// metrics 是注册在同一个 registry 上的一组 webhook 指标
+type metrics struct {
+	// circuitBreakerState 熔断器状态：0 closed、1 half-open、2 open
+	circuitBreakerState *prometheus.GaugeVec
+	// decodeFailures 请求体或者对象解析失败的次数，通常意味着 webhook 匹配到了预期之外的资源类型
+	decodeFailures *prometheus.CounterVec
+}
+
+// newMetrics 创建 webhook 指标并注册到 registerer 上，registerer 为空时使用 MetricsRegistry；
+// registerer 上已经注册了同名的指标时复用已有的指标，而不是返回错误
+func newMetrics(registerer prometheus.Registerer) *metrics {
+	if registerer == nil {
+		registerer = MetricsRegistry
+	}
+	return &metrics{
+		circuitBreakerState: registerCollector(registerer, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "admission_circuit_breaker_state",
+			Help: "State of the circuit breaker around external calls (0=closed, 1=half-open, 2=open).",
+		}, []string{"name"})).(*prometheus.GaugeVec),
+		decodeFailures: registerCollector(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "admission_decode_failures_total",
+			Help: "Number of admission requests whose review or object could not be decoded.",
+		}, []string{"kind", "path"})).(*prometheus.CounterVec),
+	}
+}
+
+// registerCollector 注册 collector，已经注册过时返回已有的 collector，其他错误只记录日志，指标依然可以正常更新
+func registerCollector(registerer prometheus.Registerer, collector prometheus.Collector) prometheus.Collector {
+	if err := registerer.Register(collector); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+		klog.Errorf("Failed to register metrics: %v", err)
+	}
+	return collector
+}
+
+// serverMetrics 返回 webhook server 的指标，第一次调用时注册到 Registerer 上
+func (s *WebhookServer) serverMetrics() *metrics {
+	s.metricsOnce.Do(func() {
+		s.metrics = newMetrics(s.Registerer)
+	})
+	return s.metrics
+}
+
+// recordDecodeFailure 记录一次解析失败，kind 未知时使用 unknown
+func (s *WebhookServer) recordDecodeFailure(kind, path string) {
+	if kind == "" {
+		kind = "unknown"
+	}
+	s.serverMetrics().decodeFailures.WithLabelValues(kind, path).Inc()
+}
@@ -0,0 +1,130 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestDecodeFailuresMetric(t *testing.T) {
+	badObject := func(kind string) *http.Request {
+		ar := newAdmissionReview(t, kind, newPod("nginx", nil))
+		// 对象的字段类型不匹配，json.Unmarshal 会失败
+		ar.Request.Object = runtime.RawExtension{Raw: []byte(`{"spec":"not a spec"}`)}
+		body, err := json.Marshal(ar)
+		if err != nil {
+			t.Fatalf("marshal review: %v", err)
+		}
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(body)))
+		request.Header.Set("Content-Type", "application/json")
+		return request
+	}
+	tests := []struct {
+		name    string
+		path    string
+		request *http.Request
+		kind    string
+	}{
+		{name: "bad pod on validate", path: "/validate", request: badObject("Pod"), kind: "Pod"},
+		{name: "bad deployment on validate", path: "/validate", request: badObject("Deployment"), kind: "Deployment"},
+		{name: "bad pod on mutate", path: "/mutate", request: badObject("Pod"), kind: "Pod"},
+		// 请求体本身无法解析时 kind 未知
+		{name: "undecodable body", path: "/validate", request: func() *http.Request {
+			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{not json"))
+			request.Header.Set("Content-Type", "application/json")
+			return request
+		}(), kind: "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			counter := s.serverMetrics().decodeFailures.WithLabelValues(tt.kind, tt.path)
+			before := testutil.ToFloat64(counter)
+			if tt.path == "/mutate" {
+				s.ServeMutate(httptest.NewRecorder(), tt.request)
+			} else {
+				s.ServeValidate(httptest.NewRecorder(), tt.request)
+			}
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("admission_decode_failures_total{kind=%q,path=%q} increased by %v, want 1", tt.kind, tt.path, got)
+			}
+		})
+	}
+
+	// 没有指定 Registerer 时指标注册在包内的 MetricsRegistry 上
+	families, err := MetricsRegistry.Gather()
+	if err != nil {
+		t.Fatalf("gather metrics: %v", err)
+	}
+	var found bool
+	for _, family := range families {
+		if family.GetName() == "admission_decode_failures_total" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("admission_decode_failures_total is not registered on MetricsRegistry")
+	}
+}
+
+func TestMetricsRegisterer(t *testing.T) {
+	gather := func(registry *prometheus.Registry) map[string]float64 {
+		t.Helper()
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatalf("gather metrics: %v", err)
+		}
+		values := map[string]float64{}
+		for _, family := range families {
+			for _, metric := range family.GetMetric() {
+				values[family.GetName()] += metric.GetCounter().GetValue() + metric.GetGauge().GetValue()
+			}
+		}
+		return values
+	}
+
+	// 每个 WebhookServer 把指标注册到自己的 registry 上，互不影响
+	first, second := prometheus.NewRegistry(), prometheus.NewRegistry()
+	a, b := &WebhookServer{Registerer: first}, &WebhookServer{Registerer: second}
+	a.recordDecodeFailure("Pod", "/validate")
+	a.recordDecodeFailure("Pod", "/validate")
+	b.recordDecodeFailure("Deployment", "/validate")
+	if got := gather(first)["admission_decode_failures_total"]; got != 2 {
+		t.Errorf("first registry decode failures = %v, want 2", got)
+	}
+	if got := gather(second)["admission_decode_failures_total"]; got != 1 {
+		t.Errorf("second registry decode failures = %v, want 1", got)
+	}
+
+	// 熔断器的状态指标注册在传入的 registry 上
+	NewCircuitBreaker("registry", 1, time.Second, first).Do(func() error { return errors.New("down") })
+	if got := gather(first)["admission_circuit_breaker_state"]; got != float64(breakerOpen) {
+		t.Errorf("circuit breaker state = %v, want %d", got, breakerOpen)
+	}
+	if _, ok := gather(second)["admission_circuit_breaker_state"]; ok {
+		t.Errorf("circuit breaker state is registered on an unrelated registry")
+	}
+
+	// 同一个 registry 上的多个 server 复用已经注册的指标，不会因为重复注册而失败
+	c := &WebhookServer{Registerer: first}
+	c.recordDecodeFailure("Pod", "/validate")
+	if got := gather(first)["admission_decode_failures_total"]; got != 3 {
+		t.Errorf("shared registry decode failures = %v, want 3", got)
+	}
+
+	// 指标没有注册到全局的 registry 上，嵌入到其他程序中时不会冲突
+	collector := a.serverMetrics().decodeFailures
+	if err := prometheus.DefaultRegisterer.Register(collector); err != nil {
+		t.Errorf("register on the default registry: %v, want the metrics not registered there", err)
+	} else {
+		prometheus.DefaultRegisterer.Unregister(collector)
+	}
+}
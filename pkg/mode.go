@@ -0,0 +1,60 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// EnforcementMode 决定校验不通过时的处理方式
+type EnforcementMode string
+
+const (
+	ModeEnforce EnforcementMode = "enforce" // 拒绝请求
+	ModeWarn    EnforcementMode = "warn"    // 放行请求，并通过 Warnings 返回原因
+	ModeOff     EnforcementMode = "off"     // 不做校验
+)
+
+// ParseOperationModes 解析形如 CREATE=enforce,UPDATE=warn 的配置
+func ParseOperationModes(s string) (map[admissionv1.Operation]EnforcementMode, error) {
+	modes := map[admissionv1.Operation]EnforcementMode{}
+	for op, mode := range ParseKeyValues(s) {
+		m := EnforcementMode(strings.ToLower(mode))
+		switch m {
+		case ModeEnforce, ModeWarn, ModeOff:
+		default:
+			return nil, fmt.Errorf("invalid mode %q for operation %s, expect enforce/warn/off", mode, op)
+		}
+		modes[admissionv1.Operation(strings.ToUpper(op))] = m
+	}
+	return modes, nil
+}
+
+// operationMode 返回操作对应的校验模式，没有配置的操作使用 DefaultMode
+func (s *WebhookServer) operationMode(op admissionv1.Operation) EnforcementMode {
+	if mode, ok := s.OperationModes[op]; ok {
+		return mode
+	}
+	return s.defaultMode()
+}
+
+func (s *WebhookServer) defaultMode() EnforcementMode {
+	if s.DefaultMode == "" {
+		return ModeEnforce
+	}
+	return s.DefaultMode
+}
+
+func (s *WebhookServer) modeSummary() string {
+	if len(s.OperationModes) == 0 {
+		return string(s.defaultMode())
+	}
+	var modes []string
+	for op, mode := range s.OperationModes {
+		modes = append(modes, fmt.Sprintf("%s=%s", op, mode))
+	}
+	sort.Strings(modes)
+	return strings.Join(append([]string{fmt.Sprintf("default=%s", s.defaultMode())}, modes...), ",")
+}
@@ -0,0 +1,61 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+func TestParseOperationModes(t *testing.T) {
+	modes, err := ParseOperationModes("create=enforce, UPDATE=Warn,DELETE=off")
+	if err != nil {
+		t.Fatalf("parse modes: %v", err)
+	}
+	want := map[admissionv1.Operation]EnforcementMode{
+		admissionv1.Create: ModeEnforce,
+		admissionv1.Update: ModeWarn,
+		admissionv1.Delete: ModeOff,
+	}
+	if !reflect.DeepEqual(modes, want) {
+		t.Errorf("modes = %v, want %v", modes, want)
+	}
+	if _, err := ParseOperationModes("CREATE=block"); err == nil {
+		t.Errorf("parse CREATE=block succeeded, want error")
+	}
+}
+
+func TestValidateOperationModes(t *testing.T) {
+	modes := map[admissionv1.Operation]EnforcementMode{admissionv1.Create: ModeEnforce, admissionv1.Update: ModeWarn, admissionv1.Connect: ModeOff}
+	tests := []struct {
+		name        string
+		operation   admissionv1.Operation
+		defaultMode EnforcementMode
+		allowed     bool
+		warnings    int
+	}{
+		{name: "create is enforced", operation: admissionv1.Create, allowed: false},
+		{name: "update only warns", operation: admissionv1.Update, allowed: true, warnings: 1},
+		{name: "off skips validation", operation: admissionv1.Connect, allowed: true},
+		{name: "unconfigured operation uses the default mode", operation: admissionv1.Delete, defaultMode: ModeWarn, allowed: true, warnings: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.OperationModes, s.DefaultMode = modes, tt.defaultMode
+			s.DeletePolicy = DeletePolicyValidate
+			ar := newAdmissionReview(t, "Pod", newPod("gcr.io/google-containers/pause:3.2", nil))
+			ar.Request.Operation = tt.operation
+			if tt.operation == admissionv1.Delete {
+				ar.Request.OldObject, ar.Request.Object.Raw = ar.Request.Object, nil
+			}
+			resp := s.validate(ar)
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if len(resp.Warnings) != tt.warnings {
+				t.Errorf("warnings = %v, want %d", resp.Warnings, tt.warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	ImageDigestsAnnotate = "annotate" // 只把解析出的 digest 记录到注解中
+	ImageDigestsPin      = "pin"      // 记录注解，并把镜像改写为 image@digest
+)
+
+// stripAnnotations 为对象上存在的禁止注解生成 remove 操作，不存在的注解不做处理
+func (s *WebhookServer) stripAnnotations(metadata *metav1.ObjectMeta) (patch []patchOperation) {
+	annotations := metadata.GetAnnotations()
+	for _, key := range s.DisallowedAnnotations {
+		if _, ok := annotations[key]; !ok {
+			continue
+		}
+		patch = append(patch, patchOperation{
+			Op:   "remove",
+			Path: "/metadata/annotations/" + escapeJSONPointer(key),
+		})
+	}
+	return
+}
+
+// missingDefaultLabels 返回对象上还没有设置的 DefaultLabels
+func (s *WebhookServer) missingDefaultLabels(metadata *metav1.ObjectMeta) map[string]string {
+	missing := map[string]string{}
+	for key, value := range s.DefaultLabels {
+		if _, ok := metadata.GetLabels()[key]; !ok {
+			missing[key] = value
+		}
+	}
+	return missing
+}
+
+// mutateTemplateAnnotations 把 annotations 同步设置到 templatePath 处的 Pod 模板上，模板没有注解时添加整个注解 map
+func mutateTemplateAnnotations(templatePath string, template *corev1.PodTemplateSpec, annotations map[string]string) []patchOperation {
+	return mutateMetadataMap(templatePath+"/metadata/annotations", template.Annotations, annotations)
+}
+
+// mutateService 根据配置生成 Service spec 相关的 patch，已经是期望值的字段不会重复修改
+func (s *WebhookServer) mutateService(service *corev1.Service) (patch []patchOperation) {
+	// externalTrafficPolicy 只对 NodePort 和 LoadBalancer 类型的 Service 生效
+	if s.ServiceExternalTrafficPolicy != "" &&
+		(service.Spec.Type == corev1.ServiceTypeNodePort || service.Spec.Type == corev1.ServiceTypeLoadBalancer) &&
+		service.Spec.ExternalTrafficPolicy != s.ServiceExternalTrafficPolicy {
+		patch = append(patch, patchOperation{
+			Op:    "add",
+			Path:  "/spec/externalTrafficPolicy",
+			Value: s.ServiceExternalTrafficPolicy,
+		})
+	}
+
+	// sessionAffinity 在 API Server 默认会被设置为 None，这里只覆盖未设置或者默认的值
+	if s.ServiceSessionAffinity != "" &&
+		(service.Spec.SessionAffinity == "" || service.Spec.SessionAffinity == corev1.ServiceAffinityNone) &&
+		service.Spec.SessionAffinity != s.ServiceSessionAffinity {
+		patch = append(patch, patchOperation{
+			Op:    "add",
+			Path:  "/spec/sessionAffinity",
+			Value: s.ServiceSessionAffinity,
+		})
+	}
+	return
+}
+
+// mutateImagePullPolicy 为没有设置 imagePullPolicy 的容器设置默认值，配置了 DefaultImagePullPolicy 时统一使用它；
+// 开启 InjectImagePullPolicy 时，使用 tag 的镜像内容可能变化，设置为 Always，使用 digest 的镜像内容不可变，设置为 IfNotPresent。
+// 两者是互斥的，main 在启动时会拒绝同时设置的配置
+func (s *WebhookServer) mutateImagePullPolicy(basePath string, spec *corev1.PodSpec) (patch []patchOperation) {
+	if !s.InjectImagePullPolicy && s.DefaultImagePullPolicy == "" {
+		return
+	}
+	for i, container := range spec.Containers {
+		if container.ImagePullPolicy != "" {
+			continue
+		}
+		policy := s.DefaultImagePullPolicy
+		if policy == "" {
+			policy = corev1.PullAlways
+			if parseImageReference(container.Image).Digest != "" {
+				policy = corev1.PullIfNotPresent
+			}
+		}
+		patch = append(patch, patchOperation{
+			Op:    "add",
+			Path:  fmt.Sprintf("%s/containers/%d/imagePullPolicy", basePath, i),
+			Value: policy,
+		})
+	}
+	return
+}
+
+// LoadSidecar 从 YAML/JSON 文件中加载需要注入的 Sidecar 容器
+func LoadSidecar(path string) (*corev1.Container, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sidecar corev1.Container
+	if err := yaml.Unmarshal(data, &sidecar); err != nil {
+		return nil, fmt.Errorf("parse sidecar %s: %v", path, err)
+	}
+	if sidecar.Name == "" || sidecar.Image == "" {
+		return nil, fmt.Errorf("sidecar in %s must set name and image", path)
+	}
+	return &sidecar, nil
+}
+
+// injectSidecar 为带有 AnnotationInjectSidecarKey=true 注解的 Pod 追加 Sidecar 容器，已经存在同名容器时不重复注入
+func (s *WebhookServer) injectSidecar(basePath string, metadata *metav1.ObjectMeta, spec *corev1.PodSpec) []patchOperation {
+	if s.Sidecar == nil || metadata.GetAnnotations()[AnnotationInjectSidecarKey] != "true" {
+		return nil
+	}
+	for _, container := range spec.Containers {
+		if container.Name == s.Sidecar.Name {
+			return nil
+		}
+	}
+	return []patchOperation{{
+		Op:    "add",
+		Path:  basePath + "/containers/-",
+		Value: s.Sidecar,
+	}}
+}
+
+// ParsePullPolicy 解析 imagePullPolicy，为空时返回空值
+func ParsePullPolicy(s string) (corev1.PullPolicy, error) {
+	switch policy := corev1.PullPolicy(s); policy {
+	case "", corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid image pull policy %q, expect %s, %s or %s", s, corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever)
+}
+
+// ParseResourceRequests 解析默认的 CPU 和内存 requests，为空的值会被忽略
+func ParseResourceRequests(cpu, memory string) (corev1.ResourceList, error) {
+	requests := corev1.ResourceList{}
+	for name, value := range map[corev1.ResourceName]string{corev1.ResourceCPU: cpu, corev1.ResourceMemory: memory} {
+		if value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s request %q: %v", name, value, err)
+		}
+		requests[name] = quantity
+	}
+	return requests, nil
+}
+
+// mutateResourceRequests 为没有设置 resources.requests 的容器添加 DefaultResourceRequests，已经设置了 requests 的容器不做修改；
+// 设置了 limits 的资源由 apiserver 把 requests 默认为 limits，不再添加默认值，避免默认值大于 limits 导致 Pod 被拒绝
+func (s *WebhookServer) mutateResourceRequests(basePath string, spec *corev1.PodSpec) (patch []patchOperation) {
+	if len(s.DefaultResourceRequests) == 0 {
+		return
+	}
+	for i, container := range spec.Containers {
+		if len(container.Resources.Requests) > 0 {
+			continue
+		}
+		requests := corev1.ResourceList{}
+		for name, quantity := range s.DefaultResourceRequests {
+			if _, ok := container.Resources.Limits[name]; !ok {
+				requests[name] = quantity
+			}
+		}
+		if len(requests) == 0 {
+			continue
+		}
+		patch = append(patch, patchOperation{
+			Op:    "add",
+			Path:  fmt.Sprintf("%s/containers/%d/resources/requests", basePath, i),
+			Value: requests,
+		})
+	}
+	return
+}
+
+// mutateRunAsNonRoot 为没有设置 runAsNonRoot 的 Pod 设置 securityContext.runAsNonRoot=true，显式设置为 false 的 Pod 不做修改
+func (s *WebhookServer) mutateRunAsNonRoot(basePath string, spec *corev1.PodSpec) []patchOperation {
+	if !s.EnforceRunAsNonRoot {
+		return nil
+	}
+	runAsNonRoot := true
+	if spec.SecurityContext == nil {
+		return []patchOperation{{
+			Op:    "add",
+			Path:  basePath + "/securityContext",
+			Value: &corev1.PodSecurityContext{RunAsNonRoot: &runAsNonRoot},
+		}}
+	}
+	if spec.SecurityContext.RunAsNonRoot != nil {
+		return nil
+	}
+	return []patchOperation{{
+		Op:    "add",
+		Path:  basePath + "/securityContext/runAsNonRoot",
+		Value: runAsNonRoot,
+	}}
+}
+
+// resolveImageDigests 通过 RegistryClient 把容器镜像的 tag 解析为当前的 digest，返回记录 digest 的注解值（容器名到 image@digest 的 JSON）
+// ResolveImageDigests 为 pin 时还会把镜像改写为固定的 digest；解析失败的镜像会被跳过，并返回对应的警告。
+// 被黑名单或者白名单等策略拒绝的镜像不会访问镜像仓库，避免把认证信息发送给 Pod 作者指定的任意仓库；
+// 与 validate 一样使用 namespace 实际生效的白名单
+func (s *WebhookServer) resolveImageDigests(namespace, basePath string, spec *corev1.PodSpec) (patch []patchOperation, annotation string, warnings []string) {
+	if s.ResolveImageDigests == "" || s.RegistryClient == nil {
+		return
+	}
+	whiteListRegistries, whiteListMatchers := s.namespaceWhiteListRegistries(namespace)
+	resolved := map[string]string{}
+	for i, container := range spec.Containers {
+		ref := parseImageReference(container.Image)
+		if ref.Digest != "" {
+			resolved[container.Name] = container.Image
+			continue
+		}
+		if msg := s.checkImagePolicy(container.Image, whiteListRegistries, whiteListMatchers); msg != "" {
+			klog.Infof("Skip resolving digest of image %s: %s", container.Image, msg)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), registryLookupTimeout)
+		digest, err := s.RegistryClient.ManifestDigest(ctx, container.Image)
+		cancel()
+		if err != nil {
+			klog.Errorf("Failed to resolve digest of image %s: %v", container.Image, err)
+			warnings = append(warnings, fmt.Sprintf("failed to resolve digest of image %s, skipped: %v", container.Image, err))
+			continue
+		}
+		pinned := container.Image + "@" + digest
+		resolved[container.Name] = pinned
+		if s.ResolveImageDigests == ImageDigestsPin {
+			patch = append(patch, patchOperation{
+				Op:    "replace",
+				Path:  fmt.Sprintf("%s/containers/%d/image", basePath, i),
+				Value: pinned,
+			})
+		}
+	}
+	if len(resolved) == 0 {
+		return
+	}
+	data, _ := json.Marshal(resolved)
+	annotation = string(data)
+	return
+}
@@ -0,0 +1,560 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// assertPatch 比较 JSON 序列化之后的 patch，避免 Value 的类型差异影响比较结果
+func assertPatch(t *testing.T, got, want []patchOperation) {
+	t.Helper()
+	gotData, _ := json.Marshal(got)
+	wantData, _ := json.Marshal(want)
+	if string(gotData) != string(wantData) {
+		t.Errorf("patch = %s, want %s", gotData, wantData)
+	}
+}
+
+func TestMutateResourceRequests(t *testing.T) {
+	defaults := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("100m"),
+		corev1.ResourceMemory: resource.MustParse("128Mi"),
+	}
+	withRequests := corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}}
+	tests := []struct {
+		name       string
+		containers []corev1.Container
+		want       []patchOperation
+	}{
+		{
+			name:       "missing requests",
+			containers: []corev1.Container{{Name: "app", Image: "nginx"}},
+			want: []patchOperation{
+				{Op: "add", Path: "/spec/containers/0/resources/requests", Value: defaults},
+			},
+		},
+		{
+			name:       "requests already set",
+			containers: []corev1.Container{{Name: "app", Image: "nginx", Resources: withRequests}},
+			want:       nil,
+		},
+		// 设置了 limits 的资源使用 limits 作为 requests，默认值可能大于 limits，不能再添加
+		{
+			name:       "limits only",
+			containers: []corev1.Container{{Name: "app", Image: "nginx", Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m"), corev1.ResourceMemory: resource.MustParse("64Mi")}}}},
+			want:       nil,
+		},
+		{
+			name:       "cpu limit only",
+			containers: []corev1.Container{{Name: "app", Image: "nginx", Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m")}}}},
+			want: []patchOperation{
+				{Op: "add", Path: "/spec/containers/0/resources/requests", Value: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")}},
+			},
+		},
+		{
+			name: "multiple containers",
+			containers: []corev1.Container{
+				{Name: "app", Image: "nginx", Resources: withRequests},
+				{Name: "sidecar", Image: "envoy"},
+				{Name: "exporter", Image: "exporter"},
+			},
+			want: []patchOperation{
+				{Op: "add", Path: "/spec/containers/1/resources/requests", Value: defaults},
+				{Op: "add", Path: "/spec/containers/2/resources/requests", Value: defaults},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.DefaultResourceRequests = defaults
+			assertPatch(t, s.mutateResourceRequests("/spec", &corev1.PodSpec{Containers: tt.containers}), tt.want)
+		})
+	}
+
+	t.Run("not configured", func(t *testing.T) {
+		spec := &corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx"}}}
+		if patch := newTestServer(t).mutateResourceRequests("/spec", spec); patch != nil {
+			t.Errorf("patch = %+v, want nil", patch)
+		}
+	})
+
+	t.Run("deployment template path", func(t *testing.T) {
+		s := newTestServer(t)
+		s.DefaultResourceRequests = defaults
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx"}}},
+			}},
+		}
+		patch := decodePatch(t, s.mutate(newAdmissionReview(t, "Deployment", deployment)))
+		// 第一个 patch 是状态注解
+		if len(patch) != 2 {
+			t.Fatalf("patch = %+v, want status annotation and requests", patch)
+		}
+		assertPatch(t, patch[1:], []patchOperation{
+			{Op: "add", Path: "/spec/template/spec/containers/0/resources/requests", Value: defaults},
+		})
+	})
+}
+
+func TestParseResourceRequests(t *testing.T) {
+	requests, err := ParseResourceRequests("250m", "")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(requests) != 1 || requests.Cpu().String() != "250m" {
+		t.Errorf("requests = %v, want only cpu 250m", requests)
+	}
+	if _, err := ParseResourceRequests("", "lots"); err == nil {
+		t.Errorf("parse invalid memory succeeded, want error")
+	}
+}
+
+func TestMutateService(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     corev1.ServiceSpec
+		policy   corev1.ServiceExternalTrafficPolicyType
+		affinity corev1.ServiceAffinity
+		want     []patchOperation
+	}{
+		{
+			name:   "external traffic policy on LoadBalancer",
+			spec:   corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeCluster},
+			policy: corev1.ServiceExternalTrafficPolicyTypeLocal,
+			want: []patchOperation{
+				{Op: "add", Path: "/spec/externalTrafficPolicy", Value: "Local"},
+			},
+		},
+		{
+			name:   "external traffic policy on NodePort",
+			spec:   corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort},
+			policy: corev1.ServiceExternalTrafficPolicyTypeLocal,
+			want: []patchOperation{
+				{Op: "add", Path: "/spec/externalTrafficPolicy", Value: "Local"},
+			},
+		},
+		// ClusterIP 类型的 Service 不支持 externalTrafficPolicy
+		{
+			name:   "external traffic policy ignored on ClusterIP",
+			spec:   corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP},
+			policy: corev1.ServiceExternalTrafficPolicyTypeLocal,
+		},
+		{
+			name:   "external traffic policy already set",
+			spec:   corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeLocal},
+			policy: corev1.ServiceExternalTrafficPolicyTypeLocal,
+		},
+		{
+			name:     "session affinity not set",
+			spec:     corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP},
+			affinity: corev1.ServiceAffinityClientIP,
+			want: []patchOperation{
+				{Op: "add", Path: "/spec/sessionAffinity", Value: "ClientIP"},
+			},
+		},
+		{
+			name:     "session affinity defaulted to None",
+			spec:     corev1.ServiceSpec{SessionAffinity: corev1.ServiceAffinityNone},
+			affinity: corev1.ServiceAffinityClientIP,
+			want: []patchOperation{
+				{Op: "add", Path: "/spec/sessionAffinity", Value: "ClientIP"},
+			},
+		},
+		{
+			name:     "session affinity already set",
+			spec:     corev1.ServiceSpec{SessionAffinity: corev1.ServiceAffinityClientIP},
+			affinity: corev1.ServiceAffinityClientIP,
+		},
+		{
+			name:     "both configured",
+			spec:     corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+			policy:   corev1.ServiceExternalTrafficPolicyTypeLocal,
+			affinity: corev1.ServiceAffinityClientIP,
+			want: []patchOperation{
+				{Op: "add", Path: "/spec/externalTrafficPolicy", Value: "Local"},
+				{Op: "add", Path: "/spec/sessionAffinity", Value: "ClientIP"},
+			},
+		},
+		{
+			name: "not configured",
+			spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.ServiceExternalTrafficPolicy = tt.policy
+			s.ServiceSessionAffinity = tt.affinity
+			service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}, Spec: tt.spec}
+			resp := s.mutate(newAdmissionReview(t, "Service", service))
+			if !resp.Allowed {
+				t.Fatalf("allowed = false, result %+v", resp.Result)
+			}
+			// 第一个 patch 是状态注解
+			want := append([]patchOperation{
+				{Op: "add", Path: "/metadata/annotations", Value: map[string]interface{}{AnnotationStatusKey: "mutated"}},
+			}, tt.want...)
+			assertPatch(t, decodePatch(t, resp), want)
+		})
+	}
+}
+
+func TestStripAnnotations(t *testing.T) {
+	statusPath := "/metadata/annotations/" + escapeJSONPointer(AnnotationStatusKey)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        []patchOperation
+	}{
+		{
+			name:        "present keys",
+			annotations: map[string]string{"internal.example.com/owner": "me", "internal.example.com/team~id": "1", "team": "infra"},
+			// key 中的 / 和 ~ 按照 JSON pointer 转义
+			want: []patchOperation{
+				{Op: "remove", Path: "/metadata/annotations/internal.example.com~1owner"},
+				{Op: "remove", Path: "/metadata/annotations/internal.example.com~1team~0id"},
+				{Op: "add", Path: statusPath, Value: "mutated"},
+			},
+		},
+		{
+			name:        "absent keys",
+			annotations: map[string]string{"team": "infra"},
+			want: []patchOperation{
+				{Op: "add", Path: statusPath, Value: "mutated"},
+			},
+		},
+		// 已经 mutate 过的对象仍然会移除禁止的注解
+		{
+			name:        "already mutated",
+			annotations: map[string]string{AnnotationStatusKey: "mutated", "internal.example.com/owner": "me"},
+			want: []patchOperation{
+				{Op: "remove", Path: "/metadata/annotations/internal.example.com~1owner"},
+			},
+		},
+		{
+			name:        "already mutated without disallowed keys",
+			annotations: map[string]string{AnnotationStatusKey: "mutated"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.DisallowedAnnotations = []string{"internal.example.com/owner", "internal.example.com/team~id", "internal.example.com/absent"}
+			resp := s.mutate(newAdmissionReview(t, "Pod", newPod("nginx", tt.annotations)))
+			if !resp.Allowed {
+				t.Fatalf("allowed = false, result %+v", resp.Result)
+			}
+			assertPatch(t, decodePatch(t, resp), tt.want)
+		})
+	}
+}
+
+func TestAnnotatePodTemplate(t *testing.T) {
+	statusKey := escapeJSONPointer(AnnotationStatusKey)
+	tests := []struct {
+		name                string
+		templateAnnotations map[string]string
+		want                patchOperation
+	}{
+		// 模板没有注解时添加整个 map
+		{
+			name: "nil template annotations",
+			want: patchOperation{Op: "add", Path: "/spec/template/metadata/annotations", Value: map[string]string{AnnotationStatusKey: "mutated"}},
+		},
+		{
+			name:                "existing template annotations",
+			templateAnnotations: map[string]string{"prometheus.io/scrape": "true"},
+			want:                patchOperation{Op: "add", Path: "/spec/template/metadata/annotations/" + statusKey, Value: "mutated"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.AnnotatePodTemplate = true
+			deployment := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+				Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Annotations: tt.templateAnnotations},
+					Spec:       newPod("nginx", nil).Spec,
+				}},
+			}
+			resp := s.mutate(newAdmissionReview(t, "Deployment", deployment))
+			if !resp.Allowed {
+				t.Fatalf("allowed = false, result %+v", resp.Result)
+			}
+			var metadataPatch, templatePatch []patchOperation
+			for _, op := range decodePatch(t, resp) {
+				switch {
+				case strings.HasPrefix(op.Path, "/metadata/annotations"):
+					metadataPatch = append(metadataPatch, op)
+				case strings.HasPrefix(op.Path, "/spec/template/metadata/annotations"):
+					templatePatch = append(templatePatch, op)
+				}
+			}
+			// Deployment 自己的 metadata 和 Pod 模板上都有状态注解
+			assertPatch(t, metadataPatch, []patchOperation{{Op: "add", Path: "/metadata/annotations", Value: map[string]string{AnnotationStatusKey: "mutated"}}})
+			assertPatch(t, templatePatch, []patchOperation{tt.want})
+		})
+	}
+
+	// 没有开启时不修改 Pod 模板
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: newPod("nginx", nil).Spec}},
+	}
+	for _, op := range decodePatch(t, newTestServer(t).mutate(newAdmissionReview(t, "Deployment", deployment))) {
+		if strings.HasPrefix(op.Path, "/spec/template/metadata") {
+			t.Errorf("patch %+v touches the pod template with AnnotatePodTemplate disabled", op)
+		}
+	}
+}
+
+func TestMutateImagePullPolicy(t *testing.T) {
+	containers := []corev1.Container{
+		{Name: "tagged", Image: "nginx:1.19"},
+		{Name: "explicit", Image: "nginx:1.19", ImagePullPolicy: corev1.PullNever},
+		{Name: "pinned", Image: "nginx@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"},
+	}
+	tests := []struct {
+		name   string
+		inject bool
+		policy corev1.PullPolicy
+		want   []patchOperation
+	}{
+		{
+			name:   "default policy",
+			policy: corev1.PullIfNotPresent,
+			want: []patchOperation{
+				{Op: "add", Path: "/spec/containers/0/imagePullPolicy", Value: corev1.PullIfNotPresent},
+				{Op: "add", Path: "/spec/containers/2/imagePullPolicy", Value: corev1.PullIfNotPresent},
+			},
+		},
+		{
+			name:   "inject by image reference",
+			inject: true,
+			want: []patchOperation{
+				{Op: "add", Path: "/spec/containers/0/imagePullPolicy", Value: corev1.PullAlways},
+				{Op: "add", Path: "/spec/containers/2/imagePullPolicy", Value: corev1.PullIfNotPresent},
+			},
+		},
+		{
+			name: "not configured",
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.InjectImagePullPolicy = tt.inject
+			s.DefaultImagePullPolicy = tt.policy
+			assertPatch(t, s.mutateImagePullPolicy("/spec", &corev1.PodSpec{Containers: containers}), tt.want)
+		})
+	}
+}
+
+func TestParsePullPolicy(t *testing.T) {
+	for _, value := range []string{"", "Always", "IfNotPresent", "Never"} {
+		if policy, err := ParsePullPolicy(value); err != nil || string(policy) != value {
+			t.Errorf("ParsePullPolicy(%q) = %q, %v, want %q", value, policy, err, value)
+		}
+	}
+	if _, err := ParsePullPolicy("ifnotpresent"); err == nil {
+		t.Errorf("ParsePullPolicy(%q) succeeded, want error", "ifnotpresent")
+	}
+}
+
+func TestInjectSidecar(t *testing.T) {
+	sidecar := &corev1.Container{Name: "log-agent", Image: "fluent-bit:1.7"}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		containers  []corev1.Container
+		injected    bool
+	}{
+		{name: "annotated pod", annotations: map[string]string{AnnotationInjectSidecarKey: "true"}, injected: true},
+		{name: "not annotated", annotations: map[string]string{"team": "infra"}, injected: false},
+		{name: "annotation disabled", annotations: map[string]string{AnnotationInjectSidecarKey: "false"}, injected: false},
+		// 已经处理过的 Pod 不会再次注入
+		{name: "already mutated", annotations: map[string]string{AnnotationInjectSidecarKey: "true", AnnotationStatusKey: "mutated"}, injected: false},
+		{
+			name:        "sidecar already present",
+			annotations: map[string]string{AnnotationInjectSidecarKey: "true"},
+			containers:  []corev1.Container{{Name: "app", Image: "nginx"}, *sidecar},
+			injected:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.Sidecar = sidecar
+			pod := newPod("nginx", tt.annotations)
+			if tt.containers != nil {
+				pod.Spec.Containers = tt.containers
+			}
+			resp := s.mutate(newAdmissionReview(t, "Pod", pod))
+			if !resp.Allowed {
+				t.Fatalf("allowed = false, result %+v", resp.Result)
+			}
+			var injected bool
+			for _, op := range decodePatch(t, resp) {
+				if op.Path == "/spec/containers/-" {
+					injected = true
+					data, _ := json.Marshal(op.Value)
+					var container corev1.Container
+					if err := json.Unmarshal(data, &container); err != nil || container.Name != sidecar.Name || container.Image != sidecar.Image {
+						t.Errorf("injected container = %s, want %s", data, sidecar.Name)
+					}
+				}
+			}
+			if injected != tt.injected {
+				t.Errorf("injected = %v, want %v, patch %s", injected, tt.injected, resp.Patch)
+			}
+		})
+	}
+}
+
+func TestLoadSidecar(t *testing.T) {
+	sidecar, err := LoadSidecar(writeConfig(t, "name: log-agent\nimage: fluent-bit:1.7\n"))
+	if err != nil {
+		t.Fatalf("load sidecar: %v", err)
+	}
+	if sidecar.Name != "log-agent" || sidecar.Image != "fluent-bit:1.7" {
+		t.Errorf("sidecar = %+v, want log-agent fluent-bit:1.7", sidecar)
+	}
+	if _, err := LoadSidecar(writeConfig(t, "name: log-agent\n")); err == nil {
+		t.Errorf("load sidecar without image succeeded, want error")
+	}
+}
+
+func TestMutateRunAsNonRoot(t *testing.T) {
+	runAsUser, runAsNonRoot := int64(1000), false
+	tests := []struct {
+		name            string
+		annotations     map[string]string
+		securityContext *corev1.PodSecurityContext
+		want            []patchOperation
+	}{
+		{
+			name: "no securityContext",
+			want: []patchOperation{
+				{Op: "add", Path: "/spec/securityContext", Value: map[string]interface{}{"runAsNonRoot": true}},
+			},
+		},
+		{
+			name:            "existing securityContext",
+			securityContext: &corev1.PodSecurityContext{RunAsUser: &runAsUser},
+			want: []patchOperation{
+				{Op: "add", Path: "/spec/securityContext/runAsNonRoot", Value: true},
+			},
+		},
+		{
+			name:            "explicitly disabled",
+			securityContext: &corev1.PodSecurityContext{RunAsNonRoot: &runAsNonRoot},
+			want:            nil,
+		},
+		{
+			name:        "already mutated",
+			annotations: map[string]string{AnnotationStatusKey: "mutated"},
+			want:        nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.EnforceRunAsNonRoot = true
+			pod := newPod("nginx", tt.annotations)
+			pod.Spec.SecurityContext = tt.securityContext
+			var got []patchOperation
+			for _, op := range decodePatch(t, s.mutate(newAdmissionReview(t, "Pod", pod))) {
+				if strings.HasPrefix(op.Path, "/spec/securityContext") {
+					got = append(got, op)
+				}
+			}
+			assertPatch(t, got, tt.want)
+		})
+	}
+}
+
+func TestResolveImageDigests(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	tests := []struct {
+		name        string
+		mode        string
+		image       string
+		namespace   string
+		annotations map[string]string
+		err         error
+		lookups     int
+		annotation  string
+		pinned      bool
+		warned      bool
+	}{
+		{name: "annotate", mode: ImageDigestsAnnotate, image: "docker.io/nginx:1.19", lookups: 1, annotation: `{"app":"docker.io/nginx:1.19@` + digest + `"}`},
+		{name: "pin", mode: ImageDigestsPin, image: "docker.io/nginx:1.19", lookups: 1, annotation: `{"app":"docker.io/nginx:1.19@` + digest + `"}`, pinned: true},
+		{name: "already pinned", mode: ImageDigestsPin, image: "docker.io/nginx@" + digest, lookups: 0, annotation: `{"app":"docker.io/nginx@` + digest + `"}`},
+		// 解析失败时跳过这个镜像，并通过 Warning 告知用户
+		{name: "resolution failure", mode: ImageDigestsPin, image: "docker.io/nginx:1.19", err: errors.New("connection refused"), lookups: 1, warned: true},
+		{name: "disabled", mode: "", image: "docker.io/nginx:1.19", lookups: 0},
+		// 不会被放行的镜像和不需要修改的对象都不会访问镜像仓库
+		{name: "untrusted registry", mode: ImageDigestsPin, image: "evil.example.com/nginx:1.19", lookups: 0},
+		{name: "denied registry", mode: ImageDigestsPin, image: "docker.io/legacy/app:1.0", lookups: 0},
+		{name: "mutation opted out", mode: ImageDigestsPin, image: "docker.io/nginx:1.19", annotations: map[string]string{AnnotationMutateKey: "off"}, lookups: 0},
+		// 与 validate 一样使用命名空间的白名单覆盖配置
+		{name: "namespace allows registry", mode: ImageDigestsPin, namespace: "team-a", image: "quay.io/app:1.0", lookups: 1, annotation: `{"app":"quay.io/app:1.0@` + digest + `"}`, pinned: true},
+		{name: "namespace replaces whitelist", mode: ImageDigestsPin, namespace: "team-b", image: "docker.io/nginx:1.19", lookups: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeRegistryClient{digest: digest, err: tt.err}
+			s := newTestServer(t, "docker.io")
+			s.DenyListRegistries = []string{"docker.io/legacy"}
+			s.ResolveImageDigests = tt.mode
+			s.RegistryClient = client
+			if err := s.SetNamespaceWhiteLists(map[string]string{"team-a": "quay.io", "team-b": "replace:quay.io"}); err != nil {
+				t.Fatalf("set namespace whitelists: %v", err)
+			}
+			pod := newPod(tt.image, tt.annotations)
+			if tt.namespace != "" {
+				pod.Namespace = tt.namespace
+			}
+			resp := s.mutate(newAdmissionReview(t, "Pod", pod))
+			if !resp.Allowed {
+				t.Fatalf("allowed = false, result %+v", resp.Result)
+			}
+			if len(client.calls) != tt.lookups {
+				t.Errorf("registry lookups = %v, want %d", client.calls, tt.lookups)
+			}
+
+			var annotation string
+			var pinned bool
+			for _, op := range decodePatch(t, resp) {
+				switch op.Path {
+				case "/metadata/annotations":
+					annotations, _ := op.Value.(map[string]interface{})
+					annotation, _ = annotations[AnnotationImageDigestKey].(string)
+				case "/spec/containers/0/image":
+					pinned = op.Op == "replace" && op.Value == tt.image+"@"+digest
+				}
+			}
+			if annotation != tt.annotation {
+				t.Errorf("digest annotation = %q, want %q", annotation, tt.annotation)
+			}
+			if pinned != tt.pinned {
+				t.Errorf("pinned = %v, want %v, patch %s", pinned, tt.pinned, resp.Patch)
+			}
+			if warned := len(resp.Warnings) == 1 && strings.Contains(resp.Warnings[0], "failed to resolve digest"); warned != tt.warned {
+				t.Errorf("warnings = %v, want resolution warning %v", resp.Warnings, tt.warned)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+// namespaceWhiteListReplacePrefix 开头的覆盖配置替换全局白名单，否则追加到全局白名单之后
+const namespaceWhiteListReplacePrefix = "replace:"
+
+// namespaceWhiteList 是单个命名空间的白名单覆盖配置
+type namespaceWhiteList struct {
+	registries []string
+	matchers   []*regexp.Regexp
+	replace    bool
+}
+
+// parseNamespaceWhiteLists 解析命名空间到白名单的配置，比如 ConfigMap 的 data：
+//
+//	team-a: "gcr.io,quay.io/team-a"      在全局白名单之外再允许这些仓库
+//	team-b: "replace:registry.team-b.io" 只允许这些仓库，不使用全局白名单
+func parseNamespaceWhiteLists(data map[string]string) (map[string]namespaceWhiteList, error) {
+	overrides := make(map[string]namespaceWhiteList, len(data))
+	for namespace, value := range data {
+		value = strings.TrimSpace(value)
+		override := namespaceWhiteList{replace: strings.HasPrefix(value, namespaceWhiteListReplacePrefix)}
+		override.registries = SplitList(strings.TrimPrefix(value, namespaceWhiteListReplacePrefix))
+		matchers, err := compileWhiteList(override.registries)
+		if err != nil {
+			return nil, fmt.Errorf("whitelist of namespace %s: %v", namespace, err)
+		}
+		override.matchers = matchers
+		overrides[namespace] = override
+	}
+	return overrides, nil
+}
+
+// SetNamespaceWhiteLists 原子地替换所有命名空间的白名单覆盖配置
+func (s *WebhookServer) SetNamespaceWhiteLists(data map[string]string) error {
+	overrides, err := parseNamespaceWhiteLists(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.namespaceWhiteLists = overrides
+	return nil
+}
+
+// namespaceWhiteListRegistries 返回命名空间实际使用的白名单：没有覆盖配置时为全局白名单，
+// replace 时只使用命名空间自己的白名单，否则把命名空间的白名单追加到全局白名单之后
+func (s *WebhookServer) namespaceWhiteListRegistries(namespace string) ([]string, []*regexp.Regexp) {
+	registries, matchers := s.whiteListRegistries()
+	s.mu.RLock()
+	override, ok := s.namespaceWhiteLists[namespace]
+	s.mu.RUnlock()
+	if !ok {
+		return registries, matchers
+	}
+	if override.replace {
+		return override.registries, override.matchers
+	}
+	merged := make([]string, 0, len(registries)+len(override.registries))
+	merged = append(append(merged, registries...), override.registries...)
+	mergedMatchers := make([]*regexp.Regexp, 0, len(merged))
+	mergedMatchers = append(append(mergedMatchers, matchers...), override.matchers...)
+	return merged, mergedMatchers
+}
+
+// WatchNamespaceWhiteListConfigMap 监听保存命名空间白名单覆盖配置的 ConfigMap，每个 key 是一个命名空间，直到 ctx 结束
+func (s *WebhookServer) WatchNamespaceWhiteListConfigMap(ctx context.Context, clientset kubernetes.Interface, namespace, name string) {
+	watchConfigMap(ctx, clientset, namespace, name, func(configMap *corev1.ConfigMap) {
+		if err := s.SetNamespaceWhiteLists(configMap.Data); err != nil {
+			klog.Errorf("Invalid namespace whitelists in configmap %s/%s, keep using the last known good config: %v", namespace, name, err)
+			return
+		}
+		klog.Infof("Namespace whitelists updated from configmap %s/%s for %d namespaces", namespace, name, len(configMap.Data))
+	})
+}
@@ -0,0 +1,966 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// registryLookupTimeout 单次查询镜像仓库的超时时间
+const registryLookupTimeout = 5 * time.Second
+
+// checkPodSpec 对 Pod 中的所有容器执行校验，返回第一个不满足策略的原因，全部通过时返回空字符串
+func (s *WebhookServer) checkPodSpec(namespace string, spec *corev1.PodSpec) string {
+	if causes := s.podViolations(namespace, spec); len(causes) > 0 {
+		return causes[0].Message
+	}
+	return ""
+}
+
+// podViolations 返回 Pod 不满足的所有策略，Pod 级别的每个策略以及每个容器最多返回一个原因，Field 为对应的字段路径
+func (s *WebhookServer) podViolations(namespace string, spec *corev1.PodSpec) (causes []metav1.StatusCause) {
+	addCause := func(field, msg string) {
+		causes = append(causes, metav1.StatusCause{
+			Type:    metav1.CauseTypeFieldValueInvalid,
+			Field:   field,
+			Message: msg,
+		})
+	}
+
+	if msg := s.checkServiceAccount(namespace, spec); msg != "" {
+		addCause("spec.serviceAccountName", msg)
+	}
+	if msg := s.checkScheduling(spec); msg != "" {
+		addCause("spec", msg)
+	}
+	if msg := s.checkGroups(spec); msg != "" {
+		addCause("spec.securityContext", msg)
+	}
+	if msg := s.checkPriorityClass(namespace, spec); msg != "" {
+		addCause("spec.priorityClassName", msg)
+	}
+	if field, msg := s.checkHostNamespaces(namespace, spec); msg != "" {
+		addCause(field, msg)
+	}
+	if msg := s.checkContainerCount(spec); msg != "" {
+		addCause("spec.containers", msg)
+	}
+
+	whiteListRegistries, whiteListMatchers := s.namespaceWhiteListRegistries(namespace)
+	for i, container := range spec.Containers {
+		if field, msg := s.checkContainer(namespace, &container, whiteListRegistries, whiteListMatchers); msg != "" {
+			addCause(fmt.Sprintf("spec.containers[%d]%s", i, field), msg)
+		}
+	}
+	// initContainers 和 ephemeralContainers 不支持探针，只校验普通容器
+	if s.RequireProbes {
+		for i, container := range spec.Containers {
+			if msg := s.checkProbes(&container); msg != "" {
+				addCause(fmt.Sprintf("spec.containers[%d]", i), msg)
+			}
+		}
+	}
+	// initContainers 和 ephemeralContainers 同样需要校验，否则可以通过它们绕过白名单
+	for i, container := range spec.InitContainers {
+		if field, msg := s.checkContainer(namespace, &container, whiteListRegistries, whiteListMatchers); msg != "" {
+			addCause(fmt.Sprintf("spec.initContainers[%d]%s", i, field), fmt.Sprintf("init container %s: %s", container.Name, msg))
+		}
+	}
+	for i := range spec.EphemeralContainers {
+		container := corev1.Container(spec.EphemeralContainers[i].EphemeralContainerCommon)
+		if field, msg := s.checkContainer(namespace, &container, whiteListRegistries, whiteListMatchers); msg != "" {
+			addCause(fmt.Sprintf("spec.ephemeralContainers[%d]%s", i, field), fmt.Sprintf("ephemeral container %s: %s", container.Name, msg))
+		}
+	}
+	return
+}
+
+// checkContainer 返回容器第一个不满足的策略以及相对于容器的字段路径
+func (s *WebhookServer) checkContainer(namespace string, container *corev1.Container, whiteListRegistries []string, whiteListMatchers []*regexp.Regexp) (string, string) {
+	if msg := s.checkImage(container.Image, whiteListRegistries, whiteListMatchers); msg != "" {
+		return ".image", msg
+	}
+	if s.RequireDigest && parseImageReference(container.Image).Digest == "" {
+		return ".image", fmt.Sprintf("Container %s image %s must be pinned by digest (@sha256:...)!", container.Name, container.Image)
+	}
+	if msg := s.checkTagPrefix(namespace, container.Image); msg != "" {
+		return ".image", msg
+	}
+	if msg := s.checkCapabilities(container); msg != "" {
+		return ".securityContext.capabilities.add", msg
+	}
+	if s.DenyPrivileged && !containsString(s.PrivilegedExemptNamespaces, namespace) {
+		if msg := checkPrivileged(container); msg != "" {
+			return ".securityContext", msg
+		}
+	}
+	if msg := s.checkSecretRefs(container); msg != "" {
+		return ".env", msg
+	}
+	if field, msg := s.checkResourceCeilings(container); msg != "" {
+		return field, msg
+	}
+	if msg := s.checkHostPorts(container); msg != "" {
+		return ".ports", msg
+	}
+	return "", ""
+}
+
+// checkTagPrefix 要求配置了 RequiredTagPrefixes 的命名空间中，镜像 tag 必须使用指定的前缀；
+// 只使用 digest 没有 tag 的镜像已经固定了内容，不检查 tag，同时带有 tag 和 digest 时 tag 仍然需要满足前缀
+func (s *WebhookServer) checkTagPrefix(namespace, image string) string {
+	prefix, ok := s.RequiredTagPrefixes[namespace]
+	if !ok || prefix == "" {
+		return ""
+	}
+	ref := parseImageReference(image)
+	if ref.Digest != "" && ref.Tag == "" {
+		return ""
+	}
+	if !strings.HasPrefix(ref.Tag, prefix) {
+		return fmt.Sprintf("Image %s tag %q in namespace %s must start with %q!", image, ref.Tag, namespace, prefix)
+	}
+	return ""
+}
+
+// checkSecretRefs 检查容器的 env/envFrom 是否引用了禁止使用的 Secret
+func (s *WebhookServer) checkSecretRefs(container *corev1.Container) string {
+	if len(s.DeniedSecrets) == 0 {
+		return ""
+	}
+	denied := func(name string) bool {
+		for _, secret := range s.DeniedSecrets {
+			if secret == name {
+				return true
+			}
+		}
+		return false
+	}
+	for _, env := range container.Env {
+		if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil && denied(env.ValueFrom.SecretKeyRef.Name) {
+			return fmt.Sprintf("container %s references disallowed secret %s in env %s!", container.Name, env.ValueFrom.SecretKeyRef.Name, env.Name)
+		}
+	}
+	for _, envFrom := range container.EnvFrom {
+		if envFrom.SecretRef != nil && denied(envFrom.SecretRef.Name) {
+			return fmt.Sprintf("container %s references disallowed secret %s in envFrom!", container.Name, envFrom.SecretRef.Name)
+		}
+	}
+	return ""
+}
+
+// checkHostPorts 检查容器的 hostPort：HostPortPolicy 为 deny 时禁止使用任何 hostPort，为 allowlist 时只允许 AllowedHostPorts 中的端口
+func (s *WebhookServer) checkHostPorts(container *corev1.Container) string {
+	if s.HostPortPolicy == "" {
+		return ""
+	}
+	for _, port := range container.Ports {
+		if port.HostPort == 0 {
+			continue
+		}
+		if s.HostPortPolicy == "deny" {
+			return fmt.Sprintf("container %s uses hostPort %d! Host ports are not allowed.", container.Name, port.HostPort)
+		}
+		if !inIDRanges(int64(port.HostPort), s.AllowedHostPorts) {
+			return fmt.Sprintf("container %s uses hostPort %d! Only host ports in %v are allowed.", container.Name, port.HostPort, s.AllowedHostPorts)
+		}
+	}
+	return ""
+}
+
+// checkServiceAccount 禁止 Pod 使用 default ServiceAccount，DenyDefaultServiceAccount 为 automount 时只在挂载了 token 的情况下拒绝
+func (s *WebhookServer) checkServiceAccount(namespace string, spec *corev1.PodSpec) string {
+	if s.DenyDefaultServiceAccount == "" || containsString(s.DefaultServiceAccountExemptNamespaces, namespace) {
+		return ""
+	}
+	if spec.ServiceAccountName != "" && spec.ServiceAccountName != "default" {
+		return ""
+	}
+	if s.DenyDefaultServiceAccount == "automount" {
+		// 没有显式设置时 token 默认会被挂载
+		if spec.AutomountServiceAccountToken != nil && !*spec.AutomountServiceAccountToken {
+			return ""
+		}
+		return "Pod uses the default service account with automountServiceAccountToken enabled! Use a dedicated service account or disable automountServiceAccountToken."
+	}
+	return "Pod uses the default service account! Use a dedicated least-privilege service account."
+}
+
+// checkScheduling 禁止 Pod 通过 nodeSelector 或者 tolerations 调度到受限的节点上
+func (s *WebhookServer) checkScheduling(spec *corev1.PodSpec) string {
+	for key := range spec.NodeSelector {
+		if containsString(s.DisallowedNodeSelectorKeys, key) {
+			return fmt.Sprintf("Pod uses disallowed nodeSelector key %s!", key)
+		}
+	}
+	if len(s.DisallowedTolerationKeys) == 0 {
+		return ""
+	}
+	for _, toleration := range spec.Tolerations {
+		// key 为空并且 operator 为 Exists 的 toleration 会容忍所有的污点
+		if toleration.Key == "" && toleration.Operator == corev1.TolerationOpExists {
+			return "Pod tolerates all taints, which includes restricted taints!"
+		}
+		if containsString(s.DisallowedTolerationKeys, toleration.Key) {
+			return fmt.Sprintf("Pod tolerates restricted taint %s!", toleration.Key)
+		}
+	}
+	return ""
+}
+
+// checkContainerCount 限制 Pod 中的容器数量，上限为 MaxContainers 加上允许的 sidecar 数量 SidecarAllowance
+func (s *WebhookServer) checkContainerCount(spec *corev1.PodSpec) string {
+	if s.MaxContainers <= 0 {
+		return ""
+	}
+	limit := s.MaxContainers + s.SidecarAllowance
+	if count := len(spec.Containers); count > limit {
+		return fmt.Sprintf("Pod has %d containers, exceeding the maximum of %d (%d plus %d sidecars)!", count, limit, s.MaxContainers, s.SidecarAllowance)
+	}
+	return ""
+}
+
+// checkHostNamespaces 禁止 Pod 使用宿主机的 network/PID/IPC 命名空间，返回设置的字段和原因
+func (s *WebhookServer) checkHostNamespaces(namespace string, spec *corev1.PodSpec) (string, string) {
+	if !s.DenyHostNamespaces || containsString(s.HostNamespaceExemptNamespaces, namespace) {
+		return "", ""
+	}
+	switch {
+	case spec.HostNetwork:
+		return "spec.hostNetwork", "Pod sets hostNetwork: true! Using the host network namespace is not allowed."
+	case spec.HostPID:
+		return "spec.hostPID", "Pod sets hostPID: true! Using the host PID namespace is not allowed."
+	case spec.HostIPC:
+		return "spec.hostIPC", "Pod sets hostIPC: true! Using the host IPC namespace is not allowed."
+	}
+	return "", ""
+}
+
+// checkPriorityClass 要求关键命名空间中的 Pod 设置 priorityClassName，配置了 AllowedPriorityClasses 时还必须在列表中
+func (s *WebhookServer) checkPriorityClass(namespace string, spec *corev1.PodSpec) string {
+	if !containsString(s.CriticalNamespaces, namespace) {
+		return ""
+	}
+	if spec.PriorityClassName == "" {
+		return fmt.Sprintf("Pod in critical namespace %s must set priorityClassName!", namespace)
+	}
+	if len(s.AllowedPriorityClasses) > 0 && !containsString(s.AllowedPriorityClasses, spec.PriorityClassName) {
+		return fmt.Sprintf("Pod in critical namespace %s uses priorityClassName %s! Allowed priority classes: %v", namespace, spec.PriorityClassName, s.AllowedPriorityClasses)
+	}
+	return ""
+}
+
+// IDRange 是一个闭区间的 ID 范围
+type IDRange struct {
+	Min int64
+	Max int64
+}
+
+func (r IDRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// ParseIDRanges 解析形如 1000-2000,5000 的 ID 范围列表，单个数字表示只包含它自己的范围
+func ParseIDRanges(s string) ([]IDRange, error) {
+	var ranges []IDRange
+	for _, item := range SplitList(s) {
+		bounds := strings.SplitN(item, "-", 2)
+		lower, err := strconv.ParseInt(strings.TrimSpace(bounds[0]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id range %q: %v", item, err)
+		}
+		upper := lower
+		if len(bounds) == 2 {
+			if upper, err = strconv.ParseInt(strings.TrimSpace(bounds[1]), 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid id range %q: %v", item, err)
+			}
+		}
+		if lower > upper {
+			return nil, fmt.Errorf("invalid id range %q: min is greater than max", item)
+		}
+		ranges = append(ranges, IDRange{Min: lower, Max: upper})
+	}
+	return ranges, nil
+}
+
+func inIDRanges(id int64, ranges []IDRange) bool {
+	for _, r := range ranges {
+		if id >= r.Min && id <= r.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// checkGroups 要求 Pod securityContext 中的 fsGroup 和 supplementalGroups 在允许的范围内
+func (s *WebhookServer) checkGroups(spec *corev1.PodSpec) string {
+	if len(s.AllowedGroupRanges) == 0 || spec.SecurityContext == nil {
+		return ""
+	}
+	sc := spec.SecurityContext
+	if sc.FSGroup != nil && !inIDRanges(*sc.FSGroup, s.AllowedGroupRanges) {
+		return fmt.Sprintf("Pod securityContext.fsGroup %d is outside the allowed ranges %v!", *sc.FSGroup, s.AllowedGroupRanges)
+	}
+	for _, group := range sc.SupplementalGroups {
+		if !inIDRanges(group, s.AllowedGroupRanges) {
+			return fmt.Sprintf("Pod securityContext.supplementalGroups %d is outside the allowed ranges %v!", group, s.AllowedGroupRanges)
+		}
+	}
+	return ""
+}
+
+// checkProbes 要求容器设置 livenessProbe/readinessProbe，RequireBothProbes 为 false 时设置其中一个即可
+func (s *WebhookServer) checkProbes(container *corev1.Container) string {
+	liveness, readiness := container.LivenessProbe != nil, container.ReadinessProbe != nil
+	if s.RequireBothProbes && (!liveness || !readiness) {
+		return fmt.Sprintf("container %s must define both livenessProbe and readinessProbe!", container.Name)
+	}
+	if !liveness && !readiness {
+		return fmt.Sprintf("container %s must define a livenessProbe or readinessProbe!", container.Name)
+	}
+	return ""
+}
+
+// checkResourceCeilings 要求容器的 CPU 和内存 requests 不超过 MaxResourceRequests，没有设置 requests 的容器不做限制；
+// 只设置了 limits 的容器，apiserver 会把 requests 默认设置为 limits，所以这时使用 limits 比较
+func (s *WebhookServer) checkResourceCeilings(container *corev1.Container) (string, string) {
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		ceiling, ok := s.MaxResourceRequests[name]
+		if !ok {
+			continue
+		}
+		// field 是实际比较的字段，消息中使用同一个字段名
+		field := ".resources.requests"
+		value, ok := container.Resources.Requests[name]
+		if !ok {
+			if value, ok = container.Resources.Limits[name]; !ok {
+				continue
+			}
+			field = ".resources.limits"
+		}
+		if value.Cmp(ceiling) > 0 {
+			return field, fmt.Sprintf("container %s sets %s.%s to %s, which exceeds the maximum %s!", container.Name, strings.TrimPrefix(field, "."), name, value.String(), ceiling.String())
+		}
+	}
+	return "", ""
+}
+
+// checkPrivileged 禁止容器以特权模式运行或者允许提权
+func checkPrivileged(container *corev1.Container) string {
+	sc := container.SecurityContext
+	if sc == nil {
+		return ""
+	}
+	if sc.Privileged != nil && *sc.Privileged {
+		return fmt.Sprintf("container %s runs in privileged mode! Privileged containers are not allowed.", container.Name)
+	}
+	if sc.AllowPrivilegeEscalation != nil && *sc.AllowPrivilegeEscalation {
+		return fmt.Sprintf("container %s allows privilege escalation! Set securityContext.allowPrivilegeEscalation to false.", container.Name)
+	}
+	return ""
+}
+
+// checkCapabilities 检查容器 securityContext.capabilities.add 中是否包含禁止的 Linux capabilities
+func (s *WebhookServer) checkCapabilities(container *corev1.Container) string {
+	if len(s.DisallowedCapabilities) == 0 || container.SecurityContext == nil || container.SecurityContext.Capabilities == nil {
+		return ""
+	}
+	for _, added := range container.SecurityContext.Capabilities.Add {
+		capability := normalizeCapability(string(added))
+		for _, disallowed := range s.DisallowedCapabilities {
+			if capability == "ALL" || capability == normalizeCapability(disallowed) {
+				return fmt.Sprintf("container %s adds disallowed capability %s! Disallowed capabilities: %v", container.Name, added, s.DisallowedCapabilities)
+			}
+		}
+	}
+	return ""
+}
+
+func normalizeCapability(capability string) string {
+	return strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(capability)), "CAP_")
+}
+
+func (s *WebhookServer) checkImage(image string, whiteListRegistries []string, whiteListMatchers []*regexp.Regexp) string {
+	if msg := s.checkImagePolicy(image, whiteListRegistries, whiteListMatchers); msg != "" {
+		return msg
+	}
+
+	// 下面的检查需要访问镜像仓库等外部服务，只对通过了白名单的镜像执行，
+	// 避免为马上就会被拒绝的镜像访问不可信的仓库，并把认证信息发送给它们
+	if s.RequireMultiArch {
+		if msg := s.checkMultiArch(image); msg != "" {
+			return msg
+		}
+	}
+	if msg := s.checkScanFreshness(image); msg != "" {
+		return msg
+	}
+	if msg := s.checkImageLabels(image); msg != "" {
+		return msg
+	}
+	if msg := s.checkTagDrift(image); msg != "" {
+		return msg
+	}
+	if msg := s.checkAttestation(image); msg != "" {
+		return msg
+	}
+	return ""
+}
+
+// checkImagePolicy 执行 checkImage 中不需要访问外部服务的检查：黑名单、镜像引用相关的策略以及白名单，
+// 访问镜像仓库之前先用它确认镜像不会因为这些策略被拒绝
+func (s *WebhookServer) checkImagePolicy(image string, whiteListRegistries []string, whiteListMatchers []*regexp.Regexp) string {
+	// 黑名单优先于其他所有策略，匹配的镜像即使在白名单中也会被拒绝
+	if entry := denyListMatch(image, s.DenyListRegistries); entry != "" {
+		return fmt.Sprintf("%s image comes from a banned registry %s!", image, entry)
+	}
+
+	// 格式错误的 digest 可能被用来绕过前缀匹配，直接拒绝
+	if ref := parseImageReference(image); ref.Digest != "" {
+		if err := validateDigest(ref.Digest); err != nil {
+			return fmt.Sprintf("%s image has an invalid digest: %v", image, err)
+		}
+	}
+
+	if s.DenyInsecureRegistries {
+		if registry, _ := splitImageRegistry(image); s.isInsecureRegistry(registry) {
+			return fmt.Sprintf("%s image comes from an insecure registry %s! Images must be pulled from a TLS enabled registry.", image, registry)
+		}
+	}
+
+	// 和白名单一样校验 mirror 之后实际拉取的地址
+	resolved := resolveMirror(image, s.RegistryMirrors)
+	if s.RequireVerifiedPublisher {
+		if registry, _ := splitImageRegistry(resolved); !containsString(s.VerifiedPublishers, registry) {
+			return fmt.Sprintf("%s image comes from registry %s which is not a verified publisher! Verified publishers: %v", image, registry, s.VerifiedPublishers)
+		}
+	}
+
+	if s.DenyDockerHub {
+		if msg := s.checkDockerHub(resolved); msg != "" {
+			return msg
+		}
+	}
+
+	if msg := s.checkDigestRequirement(image); msg != "" {
+		return msg
+	}
+	if s.RejectLatestTag {
+		if msg := checkLatestTag(image); msg != "" {
+			return msg
+		}
+	}
+
+	if msg := s.checkTagPattern(image); msg != "" {
+		return msg
+	}
+
+	// 优先级：上面的显式拒绝策略 > 白名单匹配放行 > 没有匹配时的 DefaultAction
+	// 空的白名单条目会被忽略，避免 HasPrefix(image, "") 意外放行所有镜像
+	// 镜像地址本身或者 mirror 之后实际拉取的地址匹配任意一个白名单条目即可
+	var whitelisted = false
+	for i, reg := range whiteListRegistries {
+		if reg == "" {
+			continue
+		}
+		for _, candidate := range []string{image, resolved} {
+			if isWhiteListPattern(reg) {
+				if whiteListMatchers[i] != nil && whiteListMatchers[i].MatchString(imageName(candidate)) {
+					whitelisted = true
+				}
+			} else if registryMatches(candidate, reg) {
+				whitelisted = true
+			}
+		}
+	}
+	if !whitelisted && s.DefaultAction != DefaultActionAllow {
+		if len(whiteListRegistries) == 0 {
+			return fmt.Sprintf("%s image is not allowed! No whitelisted registries are configured.", image)
+		}
+		return fmt.Sprintf("%s image comes from an untrusted registry! Only images from %v are allowed.", image, whiteListRegistries)
+	}
+	// 仓库地址在白名单中时，还要求镜像的 repository 路径在允许的列表中，和白名单一样校验 mirror 之后的地址
+	if whitelisted && len(s.RepositoryAllowlist) > 0 && !repositoryAllowed(resolved, s.RepositoryAllowlist) {
+		return fmt.Sprintf("%s image repository is not allowed! Only repositories matching %v are allowed.", image, s.RepositoryAllowlist)
+	}
+	return ""
+}
+
+// denyListMatch 返回镜像匹配的第一个黑名单条目，没有匹配时返回空字符串，条目的格式和普通的白名单条目相同
+func denyListMatch(image string, denyList []string) string {
+	for _, entry := range denyList {
+		if entry != "" && registryMatches(image, entry) {
+			return entry
+		}
+	}
+	return ""
+}
+
+// registryMatches 判断镜像是否匹配白名单条目：
+//   - CIDR 格式的条目（比如 10.0.0.0/8）匹配仓库地址为该网段内 IP 的镜像
+//   - 只有主机名的条目（比如 myregistry.com、registry:5000）匹配相同的仓库地址或者它的子域名，
+//     myregistry.com 不会匹配 myregistry.com.evil.io
+//   - 带路径的条目（比如 myregistry.com/team）在路径边界上匹配 registry/repository，
+//     docker.io 下只有一级路径的条目同时按照补全 library/ 之后的路径匹配，比如 docker.io/nginx 也匹配 nginx
+//
+// 没有指定仓库地址的镜像（比如 nginx）按照 docker.io/library/nginx 匹配
+func registryMatches(image, entry string) bool {
+	ref := parseImageReference(image)
+	if _, network, err := net.ParseCIDR(entry); err == nil {
+		host := ref.Registry
+		if h, _, err := net.SplitHostPort(ref.Registry); err == nil {
+			host = h
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && network.Contains(ip)
+	}
+
+	entry = strings.TrimSuffix(entry, "/")
+	if !strings.Contains(entry, "/") {
+		return ref.Registry == entry || strings.HasSuffix(ref.Registry, "."+entry)
+	}
+	name := ref.Registry + "/" + ref.Repository
+	if name == entry || strings.HasPrefix(name, entry+"/") {
+		return true
+	}
+	// docker.io/nginx 既可能是用户命名空间也可能是官方镜像，按照 docker.io/library/nginx 再匹配一次
+	if official := officialImagePath(entry); official != "" {
+		return name == official || strings.HasPrefix(name, official+"/")
+	}
+	return false
+}
+
+// officialImagePath 按照 parseImageReference 的规则为 docker.io 下只有一级路径的条目补全 library/，
+// 其他条目返回空字符串
+func officialImagePath(entry string) string {
+	registry, path := splitImageRegistry(entry)
+	if registry != defaultRegistry || strings.Contains(path, "/") {
+		return ""
+	}
+	return registry + "/library/" + path
+}
+
+// whiteListRegexPrefix 开头的白名单条目是匹配 registry/repository 的正则表达式
+const whiteListRegexPrefix = "regex:"
+
+// isWhiteListPattern 判断白名单条目是否为 glob 或者正则表达式
+func isWhiteListPattern(entry string) bool {
+	return strings.HasPrefix(entry, whiteListRegexPrefix) || strings.ContainsAny(entry, "*?[")
+}
+
+// compileWhiteList 编译白名单中的 glob 和 regex: 条目，返回和 registries 一一对应的匹配规则
+func compileWhiteList(registries []string) ([]*regexp.Regexp, error) {
+	matchers := make([]*regexp.Regexp, len(registries))
+	for i, entry := range registries {
+		matcher, err := compileWhiteListEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		matchers[i] = matcher
+	}
+	return matchers, nil
+}
+
+// compileWhiteListEntry 将白名单条目编译为匹配 registry/repository 的正则表达式，普通条目返回 nil：
+//   - regex:<expr> 需要完整匹配 registry/repository，比如 regex:harbor\.example\.com/.* 匹配 harbor.example.com 下的所有镜像，
+//     已有的 ^ 和 $ 不影响结果
+//   - glob 中的 * 和 ? 不跨越 /，比如 *.registry.example.com 匹配 eu-1.registry.example.com 下的所有镜像，
+//     和普通条目一样在路径边界上匹配
+func compileWhiteListEntry(entry string) (*regexp.Regexp, error) {
+	if !isWhiteListPattern(entry) {
+		return nil, nil
+	}
+	if strings.HasPrefix(entry, whiteListRegexPrefix) {
+		// 正则表达式总是完整匹配 registry/repository，避免 evil.io/harbor.example.com/x 这样把可信地址放在路径中的镜像绕过白名单
+		matcher, err := regexp.Compile("^(?:" + strings.TrimPrefix(entry, whiteListRegexPrefix) + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid whitelist regex %q: %v", entry, err)
+		}
+		return matcher, nil
+	}
+
+	var expr strings.Builder
+	glob := strings.TrimSuffix(entry, "/")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			expr.WriteString("[^/]*")
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end == -1 {
+				return nil, fmt.Errorf("invalid whitelist pattern %q: missing ]", entry)
+			}
+			expr.WriteString(glob[i : i+end+1])
+			i += end
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	matcher, err := regexp.Compile("^" + expr.String() + "(/.*)?$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid whitelist pattern %q: %v", entry, err)
+	}
+	return matcher, nil
+}
+
+// imageName 返回镜像的 registry/repository，不包含 tag 和 digest
+func imageName(image string) string {
+	ref := parseImageReference(image)
+	return ref.Registry + "/" + ref.Repository
+}
+
+// repositoryAllowed 使用 glob 匹配 registry/repository（比如 myregistry.io/approved/*），以 /** 结尾的模式匹配所有子路径
+func repositoryAllowed(image string, patterns []string) bool {
+	ref := parseImageReference(image)
+	name := ref.Registry + "/" + ref.Repository
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "/**") {
+			if strings.HasPrefix(name, strings.TrimSuffix(pattern, "**")) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDockerHub 禁止直接从 Docker Hub 拉取镜像（包括 nginx 这种没有指定仓库的镜像），DockerHubAllowedImages 中的镜像除外
+// DockerHubAllowedImages 的条目可以是 nginx、library/nginx 这样的镜像名，也可以是 bitnami/ 这样以 / 结尾的前缀
+func (s *WebhookServer) checkDockerHub(image string) string {
+	ref := parseImageReference(image)
+	if !isDockerHub(ref.Registry) {
+		return ""
+	}
+	for _, allowed := range s.DockerHubAllowedImages {
+		if strings.HasSuffix(allowed, "/") {
+			if strings.HasPrefix(ref.Repository, allowed) {
+				return ""
+			}
+			continue
+		}
+		if parseImageReference(allowed).Repository == ref.Repository {
+			return ""
+		}
+	}
+	return fmt.Sprintf("%s image is pulled from Docker Hub (%s)! Direct Docker Hub pulls are not allowed, use an internal registry or mirror.", image, ref.String())
+}
+
+// ParseRequireDigest 解析 REQUIRE_DIGEST：true/false 对所有镜像生效，
+// 也可以配置为按仓库匹配的 docker.io/*=true,registry.ydzs.io/*=false，两种形式不能混用
+func ParseRequireDigest(s string) (requireAll bool, requirements map[string]bool, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return false, nil, nil
+	}
+	if !strings.Contains(s, "=") {
+		if requireAll, err = strconv.ParseBool(s); err != nil {
+			return false, nil, fmt.Errorf("invalid value %q, expect true/false or a list of <pattern>=true/false", s)
+		}
+		return requireAll, nil, nil
+	}
+	requirements = map[string]bool{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return false, nil, fmt.Errorf("invalid digest requirement %q, expect <pattern>=true/false", pair)
+		}
+		pattern, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		required, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, nil, fmt.Errorf("invalid digest requirement %q for %s, expect true/false", value, pattern)
+		}
+		requirements[pattern] = required
+	}
+	return false, requirements, nil
+}
+
+// checkLatestTag 拒绝可变的 latest tag，没有指定 tag 的镜像默认也是 latest；使用 digest 引用的镜像不受影响
+func checkLatestTag(image string) string {
+	ref := parseImageReference(image)
+	if ref.Digest != "" {
+		return ""
+	}
+	if ref.Tag == "" {
+		return fmt.Sprintf("%s image has no tag and defaults to latest! Images must be pinned to an explicit tag or digest.", image)
+	}
+	if ref.Tag == "latest" {
+		return fmt.Sprintf("%s image uses the mutable latest tag! Images must be pinned to an explicit tag or digest.", image)
+	}
+	return ""
+}
+
+// ParseTagPattern 编译 ALLOWED_TAG_REGEX，tag 需要完整匹配表达式，为空时返回 nil
+func ParseTagPattern(s string) (*regexp.Regexp, error) {
+	if s == "" {
+		return nil, nil
+	}
+	pattern, err := regexp.Compile("^(?:" + s + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid tag regex %q: %v", s, err)
+	}
+	return pattern, nil
+}
+
+// checkTagPattern 要求镜像的 tag 匹配 AllowedTagPattern，没有指定 tag 的镜像按照 latest 处理，使用 digest 引用的镜像不受影响
+func (s *WebhookServer) checkTagPattern(image string) string {
+	if s.AllowedTagPattern == nil {
+		return ""
+	}
+	ref := parseImageReference(image)
+	if ref.Digest != "" {
+		return ""
+	}
+	tag := ref.Tag
+	if tag == "" {
+		tag = "latest"
+	}
+	if !s.AllowedTagPattern.MatchString(tag) {
+		return fmt.Sprintf("%s image tag %q does not match the allowed pattern %s!", image, tag, s.AllowedTagPattern)
+	}
+	return ""
+}
+
+// checkDigestRequirement 根据 DigestRequirements 判断镜像是否必须使用 digest 引用
+// 匹配时使用 registry/repository 的完整名称（比如 docker.io/library/nginx），以 * 结尾的模式按前缀匹配，
+// 多个模式同时匹配时最长的模式生效，这样可以在 * 的基础上为内部仓库单独放开
+func (s *WebhookServer) checkDigestRequirement(image string) string {
+	if len(s.DigestRequirements) == 0 {
+		return ""
+	}
+	ref := parseImageReference(image)
+	name := ref.Registry + "/" + ref.Repository
+
+	var (
+		matched string
+		require bool
+		found   bool
+	)
+	for pattern, required := range s.DigestRequirements {
+		ok := pattern == name
+		if strings.HasSuffix(pattern, "*") {
+			ok = strings.HasPrefix(name, strings.TrimSuffix(pattern, "*"))
+		}
+		if ok && (!found || len(pattern) > len(matched)) {
+			matched, require, found = pattern, required, true
+		}
+	}
+	if require && ref.Digest == "" {
+		return fmt.Sprintf("%s image must be pinned by digest! Repositories matching %s require a digest reference.", image, matched)
+	}
+	return ""
+}
+
+// isInsecureRegistry 判断镜像仓库是否为 localhost、回环地址或者配置的不安全仓库
+func (s *WebhookServer) isInsecureRegistry(registry string) bool {
+	host := registry
+	if h, _, err := net.SplitHostPort(registry); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil && (ip.IsLoopback() || ip.IsUnspecified()) {
+		return true
+	}
+	for _, insecure := range s.InsecureRegistries {
+		if insecure != "" && (insecure == registry || insecure == host) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMultiArch 要求镜像使用 digest 引用，并且 digest 指向多架构的 image index
+func (s *WebhookServer) checkMultiArch(image string) string {
+	ref := parseImageReference(image)
+	if ref.Digest == "" {
+		return fmt.Sprintf("%s image must be referenced by a digest of a multi-arch image index!", image)
+	}
+	if s.RegistryClient == nil {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), registryLookupTimeout)
+	defer cancel()
+	mediaType, err := s.RegistryClient.ManifestMediaType(ctx, image)
+	if err != nil {
+		return s.externalCheckFailed(fmt.Sprintf("failed to inspect manifest of image %s", image), err)
+	}
+	if !isImageIndex(mediaType) {
+		return fmt.Sprintf("%s image digest refers to a single-arch manifest (%s), a multi-arch image index is required!", image, mediaType)
+	}
+	return ""
+}
+
+// checkImageLabels 要求镜像 config 中包含 RequiredImageLabels 中的所有 label，比如 org.opencontainers.image.source
+func (s *WebhookServer) checkImageLabels(image string) string {
+	if len(s.RequiredImageLabels) == 0 || s.RegistryClient == nil {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), registryLookupTimeout)
+	defer cancel()
+	labels, err := s.RegistryClient.ImageLabels(ctx, image)
+	if err != nil {
+		return s.externalCheckFailed(fmt.Sprintf("failed to inspect labels of image %s", image), err)
+	}
+	var missing []string
+	for _, label := range s.RequiredImageLabels {
+		if labels[label] == "" {
+			missing = append(missing, label)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Sprintf("%s image is missing required labels %v!", image, missing)
+	}
+	return ""
+}
+
+// checkTagDrift 同一个 tag 指向了与第一次出现时不同的 digest 时拒绝，防止 tag 被覆盖；
+// 第一次出现的 tag 在请求被放行之后才由 recordTagDigests 记录
+func (s *WebhookServer) checkTagDrift(image string) string {
+	if s.TagDigestStore == nil || s.RegistryClient == nil {
+		return ""
+	}
+	ref := parseImageReference(image)
+	if ref.Digest != "" {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), registryLookupTimeout)
+	defer cancel()
+	recorded, ok, err := s.TagDigestStore.Get(ctx, ref.String())
+	if err != nil {
+		return s.externalCheckFailed(fmt.Sprintf("failed to load recorded digest of image %s", image), err)
+	}
+	if !ok {
+		return ""
+	}
+	digest, err := s.RegistryClient.ManifestDigest(ctx, image)
+	if err != nil {
+		return s.externalCheckFailed(fmt.Sprintf("failed to resolve digest of image %s", image), err)
+	}
+	if recorded != digest {
+		return fmt.Sprintf("%s image tag now resolves to %s, but it was first seen as %s! Image tags must be immutable.", image, digest, recorded)
+	}
+	return ""
+}
+
+// checkAttestation 要求镜像附带 SBOMAttestationType 类型的 attestation
+func (s *WebhookServer) checkAttestation(image string) string {
+	if s.AttestationLookup == nil || s.SBOMAttestationType == "" {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), registryLookupTimeout)
+	defer cancel()
+	ok, err := s.AttestationLookup.HasAttestation(ctx, image, s.SBOMAttestationType)
+	if err != nil {
+		return s.externalCheckFailed(fmt.Sprintf("failed to look up attestations of image %s", image), err)
+	}
+	if !ok {
+		return fmt.Sprintf("%s image has no %s attestation! Images must carry an SBOM attestation.", image, s.SBOMAttestationType)
+	}
+	return ""
+}
+
+// checkDeployment 校验 Deployment 级别的策略
+func (s *WebhookServer) checkDeployment(namespace string, deployment *appsv1.Deployment) (string, []metav1.StatusCause) {
+	if s.RequireDeploymentLimits {
+		if msg := s.checkDeploymentLimits(deployment); msg != "" {
+			return msg, nil
+		}
+	}
+	// Pod 模板和 Pod 使用相同的校验，包括镜像白名单、宿主机命名空间、容器数量等
+	if msg, causes := s.checkPodTemplate(namespace, "Deployment "+deployment.Name, "spec.template", &deployment.Spec.Template.Spec); msg != "" {
+		return msg, causes
+	}
+	if containsString(s.ReadinessGateNamespaces, namespace) {
+		if msg := s.checkReadinessGates(deployment); msg != "" {
+			return msg, nil
+		}
+	}
+	if s.RequirePodAntiAffinity && (len(s.PodAntiAffinityNamespaces) == 0 || containsString(s.PodAntiAffinityNamespaces, namespace)) {
+		if msg := checkPodAntiAffinity(deployment); msg != "" {
+			return msg, nil
+		}
+	}
+	return "", nil
+}
+
+// checkReadinessGates 要求 Deployment 的 Pod 模板包含所有 RequiredReadinessGates 中的 readinessGate
+func (s *WebhookServer) checkReadinessGates(deployment *appsv1.Deployment) string {
+	for _, required := range s.RequiredReadinessGates {
+		found := false
+		for _, gate := range deployment.Spec.Template.Spec.ReadinessGates {
+			if string(gate.ConditionType) == required {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Sprintf("Deployment %s pod template is missing readinessGate %s!", deployment.Name, required)
+		}
+	}
+	return ""
+}
+
+// checkPodAntiAffinity 要求多副本的 Deployment 配置 podAntiAffinity，让副本分散到不同的节点上
+func checkPodAntiAffinity(deployment *appsv1.Deployment) string {
+	// replicas 没有设置时默认为 1
+	if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas <= 1 {
+		return ""
+	}
+	affinity := deployment.Spec.Template.Spec.Affinity
+	if affinity == nil || affinity.PodAntiAffinity == nil ||
+		(len(affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution) == 0 &&
+			len(affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution) == 0) {
+		return fmt.Sprintf("Deployment %s has %d replicas but no spec.template.spec.affinity.podAntiAffinity! Spread replicas across nodes with pod anti-affinity.", deployment.Name, *deployment.Spec.Replicas)
+	}
+	return ""
+}
+
+// checkDeploymentLimits 要求 revisionHistoryLimit 和 progressDeadlineSeconds 都被设置并且不超过配置的上限
+func (s *WebhookServer) checkDeploymentLimits(deployment *appsv1.Deployment) string {
+	spec := deployment.Spec
+	if spec.RevisionHistoryLimit == nil {
+		return fmt.Sprintf("Deployment %s must set spec.revisionHistoryLimit!", deployment.Name)
+	}
+	if s.MaxRevisionHistoryLimit > 0 && *spec.RevisionHistoryLimit > s.MaxRevisionHistoryLimit {
+		return fmt.Sprintf("Deployment %s spec.revisionHistoryLimit %d exceeds the maximum %d!", deployment.Name, *spec.RevisionHistoryLimit, s.MaxRevisionHistoryLimit)
+	}
+	if spec.ProgressDeadlineSeconds == nil {
+		return fmt.Sprintf("Deployment %s must set spec.progressDeadlineSeconds!", deployment.Name)
+	}
+	if s.MaxProgressDeadlineSeconds > 0 && *spec.ProgressDeadlineSeconds > s.MaxProgressDeadlineSeconds {
+		return fmt.Sprintf("Deployment %s spec.progressDeadlineSeconds %d exceeds the maximum %d!", deployment.Name, *spec.ProgressDeadlineSeconds, s.MaxProgressDeadlineSeconds)
+	}
+	return ""
+}
+
+// externalCheckFailed 外部服务（镜像仓库、扫描器等）调用失败时，FailOpen 为 true 放行，否则拒绝
+func (s *WebhookServer) externalCheckFailed(message string, err error) string {
+	klog.Errorf("%s: %v", message, err)
+	if s.FailOpen {
+		return ""
+	}
+	return fmt.Sprintf("%s: %v", message, err)
+}
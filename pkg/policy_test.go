@@ -0,0 +1,1301 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRegistryMatches(t *testing.T) {
+	tests := []struct {
+		name  string
+		image string
+		entry string
+		want  bool
+	}{
+		{name: "exact host", image: "myregistry.com/app:1.0", entry: "myregistry.com", want: true},
+		{name: "lookalike host suffix", image: "myregistry.com.evil.io/bad:1.0", entry: "myregistry.com", want: false},
+		{name: "lookalike host prefix", image: "evilmyregistry.com/bad:1.0", entry: "myregistry.com", want: false},
+		{name: "subdomain", image: "eu.myregistry.com/app:1.0", entry: "myregistry.com", want: true},
+		{name: "host as repository path", image: "evil.io/myregistry.com/bad:1.0", entry: "myregistry.com", want: false},
+		{name: "no tag", image: "myregistry.com/app", entry: "myregistry.com", want: true},
+		{name: "digest", image: "myregistry.com/app@sha256:" + strings.Repeat("a", 64), entry: "myregistry.com", want: true},
+		{name: "port", image: "registry:5000/app", entry: "registry:5000", want: true},
+		{name: "port mismatch", image: "registry:5001/app", entry: "registry:5000", want: false},
+		{name: "entry without port", image: "registry:5000/app", entry: "registry", want: false},
+		{name: "localhost", image: "localhost/app:1.0", entry: "localhost", want: true},
+		{name: "docker hub shorthand", image: "nginx", entry: "docker.io", want: true},
+		{name: "docker hub user image", image: "library/nginx:1.19", entry: "docker.io", want: true},
+		{name: "docker hub shorthand is not another host", image: "nginx", entry: "myregistry.com", want: false},
+		{name: "path entry", image: "myregistry.com/team/app:1.0", entry: "myregistry.com/team", want: true},
+		{name: "path entry with trailing slash", image: "myregistry.com/team/app:1.0", entry: "myregistry.com/team/", want: true},
+		{name: "path entry boundary", image: "myregistry.com/teamevil/app:1.0", entry: "myregistry.com/team", want: false},
+		{name: "docker hub path entry", image: "nginx", entry: "docker.io/library", want: true},
+		// docker.io 下只有一级路径的条目也按照补全 library/ 之后的官方镜像匹配
+		{name: "docker hub official image entry", image: "nginx", entry: "docker.io/nginx", want: true},
+		{name: "docker hub official image entry with registry", image: "docker.io/nginx:1.0", entry: "docker.io/nginx", want: true},
+		{name: "docker hub official image entry with library", image: "docker.io/library/nginx:1.0", entry: "docker.io/nginx", want: true},
+		{name: "docker hub official image entry boundary", image: "nginx-evil:1.0", entry: "docker.io/nginx", want: false},
+		{name: "docker hub user namespace entry", image: "bitnami/redis:6.0", entry: "docker.io/bitnami", want: true},
+		{name: "docker hub user entry", image: "bitnami/redis:6.0", entry: "docker.io/bitnami/redis", want: true},
+		{name: "cidr", image: "10.0.0.5:5000/app", entry: "10.0.0.0/24", want: true},
+		{name: "cidr mismatch", image: "10.0.1.5:5000/app", entry: "10.0.0.0/24", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := registryMatches(tt.image, tt.entry); got != tt.want {
+				t.Errorf("registryMatches(%q, %q) = %v, want %v", tt.image, tt.entry, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateAllContainerTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		set     func(pod *corev1.Pod)
+		field   string
+		message string
+	}{
+		{name: "compliant pod", set: func(pod *corev1.Pod) {
+			pod.Spec.InitContainers = []corev1.Container{{Name: "init", Image: "docker.io/busybox:1.32"}}
+		}},
+		{name: "untrusted init container", set: func(pod *corev1.Pod) {
+			pod.Spec.InitContainers = []corev1.Container{{Name: "init", Image: "evil.example.com/busybox:1.32"}}
+		}, field: "spec.initContainers[0].image", message: "init container init"},
+		{name: "untrusted ephemeral container", set: func(pod *corev1.Pod) {
+			pod.Spec.EphemeralContainers = []corev1.EphemeralContainer{{
+				EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debug", Image: "evil.example.com/debug:1.0"},
+			}}
+		}, field: "spec.ephemeralContainers[0].image", message: "ephemeral container debug"},
+		{name: "second init container", set: func(pod *corev1.Pod) {
+			pod.Spec.InitContainers = []corev1.Container{
+				{Name: "init", Image: "docker.io/busybox:1.32"},
+				{Name: "migrate", Image: "evil.example.com/migrate:1.0"},
+			}
+		}, field: "spec.initContainers[1].image", message: "init container migrate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			pod := newPod("docker.io/nginx:1.19", nil)
+			tt.set(pod)
+			resp := s.validate(newAdmissionReview(t, "Pod", pod))
+			if resp.Allowed != (tt.field == "") {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.field == "", resp.Result)
+			}
+			if tt.field == "" {
+				return
+			}
+			if !strings.Contains(resp.Result.Message, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", resp.Result.Message, tt.message)
+			}
+			if resp.Result.Details == nil || len(resp.Result.Details.Causes) != 1 || resp.Result.Details.Causes[0].Field != tt.field {
+				t.Errorf("details = %+v, want one cause for %s", resp.Result.Details, tt.field)
+			}
+		})
+	}
+}
+
+func TestRejectLatestTag(t *testing.T) {
+	digest := "@sha256:" + strings.Repeat("a", 64)
+	tests := []struct {
+		name    string
+		image   string
+		message string
+	}{
+		{name: "untagged", image: "nginx", message: "has no tag"},
+		{name: "latest tag", image: "nginx:latest", message: "mutable latest tag"},
+		{name: "registry with port and no tag", image: "registry:5000/app", message: "has no tag"},
+		{name: "registry with port and latest tag", image: "registry:5000/app:latest", message: "mutable latest tag"},
+		{name: "pinned tag", image: "nginx:1.19"},
+		{name: "digest", image: "nginx" + digest},
+		{name: "latest tag with digest", image: "nginx:latest" + digest},
+		{name: "tag containing latest", image: "nginx:latest-alpine"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io", "registry:5000")
+			s.RejectLatestTag = true
+			resp := s.validate(newAdmissionReview(t, "Pod", newPod(tt.image, nil)))
+			if resp.Allowed != (tt.message == "") {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.message == "", resp.Result)
+			}
+			if tt.message != "" && !strings.Contains(resp.Result.Message, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", resp.Result.Message, tt.message)
+			}
+		})
+	}
+
+	// 没有开启 RejectLatestTag 时不检查 tag
+	s := newTestServer(t, "docker.io")
+	if resp := s.validate(newAdmissionReview(t, "Pod", newPod("nginx:latest", nil))); !resp.Allowed {
+		t.Errorf("latest tag denied with RejectLatestTag disabled: %+v", resp.Result)
+	}
+}
+
+func TestDenyPrivileged(t *testing.T) {
+	privileged, escalation, disabled := true, true, false
+	tests := []struct {
+		name            string
+		namespace       string
+		securityContext *corev1.SecurityContext
+		allowed         bool
+		message         string
+	}{
+		{name: "privileged container", securityContext: &corev1.SecurityContext{Privileged: &privileged}, message: "privileged mode"},
+		{name: "privilege escalation", securityContext: &corev1.SecurityContext{AllowPrivilegeEscalation: &escalation}, message: "privilege escalation"},
+		{name: "normal container", securityContext: &corev1.SecurityContext{Privileged: &disabled, AllowPrivilegeEscalation: &disabled}, allowed: true},
+		{name: "nil securityContext", securityContext: nil, allowed: true},
+		{name: "exempt namespace", namespace: "kube-system", securityContext: &corev1.SecurityContext{Privileged: &privileged}, allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.DenyPrivileged = true
+			s.PrivilegedExemptNamespaces = []string{"kube-system"}
+			pod := newPod("nginx", nil)
+			if tt.namespace != "" {
+				pod.Namespace = tt.namespace
+			}
+			// Pod 级别的 securityContext 为空时也不会出错
+			pod.Spec.SecurityContext = nil
+			pod.Spec.Containers[0].SecurityContext = tt.securityContext
+
+			resp := s.validate(newAdmissionReview(t, "Pod", pod))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if tt.allowed {
+				return
+			}
+			if !strings.Contains(resp.Result.Message, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", resp.Result.Message, tt.message)
+			}
+			if resp.Result.Details == nil || len(resp.Result.Details.Causes) != 1 || resp.Result.Details.Causes[0].Field != "spec.containers[0].securityContext" {
+				t.Errorf("details = %+v, want one cause for spec.containers[0].securityContext", resp.Result.Details)
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		pod := newPod("nginx", nil)
+		pod.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{Privileged: &privileged}
+		if resp := newTestServer(t, "docker.io").validate(newAdmissionReview(t, "Pod", pod)); !resp.Allowed {
+			t.Errorf("allowed = false without DenyPrivileged, result %+v", resp.Result)
+		}
+	})
+}
+
+func TestCheckProbes(t *testing.T) {
+	probe := &corev1.Probe{Handler: corev1.Handler{TCPSocket: &corev1.TCPSocketAction{}}}
+	tests := []struct {
+		name       string
+		liveness   *corev1.Probe
+		readiness  *corev1.Probe
+		bothProbes bool
+		allowed    bool
+	}{
+		{name: "both probes", liveness: probe, readiness: probe, allowed: true},
+		{name: "only readiness", readiness: probe, allowed: true},
+		{name: "only liveness when both are required", liveness: probe, bothProbes: true, allowed: false},
+		{name: "both probes when both are required", liveness: probe, readiness: probe, bothProbes: true, allowed: true},
+		{name: "no probes", allowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.RequireProbes, s.RequireBothProbes = true, tt.bothProbes
+			pod := newPod("nginx", nil)
+			pod.Spec.Containers[0].LivenessProbe = tt.liveness
+			pod.Spec.Containers[0].ReadinessProbe = tt.readiness
+			// init 容器不能设置探针，不参与校验
+			pod.Spec.InitContainers = []corev1.Container{{Name: "init", Image: "busybox"}}
+
+			resp := s.validate(newAdmissionReview(t, "Pod", pod))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if !tt.allowed && resp.Result.Details.Causes[0].Field != "spec.containers[0]" {
+				t.Errorf("field = %s, want spec.containers[0]", resp.Result.Details.Causes[0].Field)
+			}
+		})
+	}
+}
+
+func TestCheckResourceCeilings(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources corev1.ResourceRequirements
+		allowed   bool
+		field     string
+		message   string
+	}{
+		{name: "no requests", allowed: true},
+		{
+			name:      "below the ceiling",
+			resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("1Gi")}},
+			allowed:   true,
+		},
+		{
+			name:      "equal to the ceiling",
+			resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2"), corev1.ResourceMemory: resource.MustParse("4Gi")}},
+			allowed:   true,
+		},
+		{
+			name:      "cpu above the ceiling",
+			resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2500m")}},
+			field:     "spec.containers[0].resources.requests",
+			message:   "container app sets resources.requests.cpu to 2500m, which exceeds the maximum 2!",
+		},
+		{
+			name:      "memory above the ceiling",
+			resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("5000Mi")}},
+			field:     "spec.containers[0].resources.requests",
+			message:   "container app sets resources.requests.memory to 5000Mi, which exceeds the maximum 4Gi!",
+		},
+		{
+			name:      "limits used as requests",
+			resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("8Gi")}},
+			field:     "spec.containers[0].resources.limits",
+			message:   "container app sets resources.limits.memory to 8Gi, which exceeds the maximum 4Gi!",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.MaxResourceRequests = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2"), corev1.ResourceMemory: resource.MustParse("4Gi")}
+			pod := newPod("nginx", nil)
+			pod.Spec.Containers[0].Resources = tt.resources
+
+			resp := s.validate(newAdmissionReview(t, "Pod", pod))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if tt.allowed {
+				return
+			}
+			if field := resp.Result.Details.Causes[0].Field; field != tt.field {
+				t.Errorf("field = %s, want %s", field, tt.field)
+			}
+			// 消息中给出实际超出的是 requests 还是 limits
+			if !strings.Contains(resp.Result.Message, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", resp.Result.Message, tt.message)
+			}
+		})
+	}
+}
+
+func TestCheckHostNamespaces(t *testing.T) {
+	tests := []struct {
+		name  string
+		kind  string
+		set   func(spec *corev1.PodSpec)
+		field string
+	}{
+		{name: "compliant pod", kind: "Pod", set: func(spec *corev1.PodSpec) {}},
+		{name: "pod hostNetwork", kind: "Pod", set: func(spec *corev1.PodSpec) { spec.HostNetwork = true }, field: "spec.hostNetwork"},
+		{name: "pod hostPID", kind: "Pod", set: func(spec *corev1.PodSpec) { spec.HostPID = true }, field: "spec.hostPID"},
+		{name: "pod hostIPC", kind: "Pod", set: func(spec *corev1.PodSpec) { spec.HostIPC = true }, field: "spec.hostIPC"},
+		{name: "compliant deployment", kind: "Deployment", set: func(spec *corev1.PodSpec) {}},
+		{name: "deployment hostNetwork", kind: "Deployment", set: func(spec *corev1.PodSpec) { spec.HostNetwork = true }, field: "spec.template.spec.hostNetwork"},
+		{name: "statefulset hostPID", kind: "StatefulSet", set: func(spec *corev1.PodSpec) { spec.HostPID = true }, field: "spec.template.spec.hostPID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.DenyHostNamespaces = true
+			spec := newPod("nginx", nil).Spec
+			tt.set(&spec)
+			meta := metav1.ObjectMeta{Name: "test", Namespace: "default"}
+			var obj metav1.Object
+			switch tt.kind {
+			case "Deployment":
+				obj = &appsv1.Deployment{ObjectMeta: meta, Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: spec}}}
+			case "StatefulSet":
+				obj = &appsv1.StatefulSet{ObjectMeta: meta, Spec: appsv1.StatefulSetSpec{Template: corev1.PodTemplateSpec{Spec: spec}}}
+			default:
+				obj = &corev1.Pod{ObjectMeta: meta, Spec: spec}
+			}
+
+			resp := s.validate(newAdmissionReview(t, tt.kind, obj))
+			if allowed := tt.field == ""; resp.Allowed != allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, allowed, resp.Result)
+			}
+			if tt.field == "" {
+				return
+			}
+			if field := resp.Result.Details.Causes[0].Field; field != tt.field {
+				t.Errorf("field = %s, want %s", field, tt.field)
+			}
+			if name := tt.field[strings.LastIndex(tt.field, ".")+1:]; !strings.Contains(resp.Result.Message, name) {
+				t.Errorf("message = %q, want it to name %s", resp.Result.Message, name)
+			}
+		})
+	}
+}
+
+func TestCheckTagPattern(t *testing.T) {
+	pattern, err := ParseTagPattern(`v\d+\.\d+\.\d+|\d{4}-\d{2}-\d{2}`)
+	if err != nil {
+		t.Fatalf("parse tag pattern: %v", err)
+	}
+	tests := []struct {
+		name    string
+		image   string
+		allowed bool
+	}{
+		{name: "semver tag", image: "nginx:v1.2.3", allowed: true},
+		{name: "date tag", image: "nginx:2024-01-01", allowed: true},
+		{name: "non-matching tag", image: "nginx:1.19", allowed: false},
+		{name: "partial match", image: "nginx:v1.2.3-rc1", allowed: false},
+		{name: "no tag", image: "nginx", allowed: false},
+		{name: "digest reference", image: "nginx@sha256:" + strings.Repeat("a", 64), allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.AllowedTagPattern = pattern
+			resp := s.validate(newAdmissionReview(t, "Pod", newPod(tt.image, nil)))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if !tt.allowed && !strings.Contains(resp.Result.Message, tt.image) {
+				t.Errorf("message = %q, want it to name image %s", resp.Result.Message, tt.image)
+			}
+		})
+	}
+}
+
+// fakeRegistryClient 返回预先设置好的镜像元数据，并记录被查询过的镜像
+type fakeRegistryClient struct {
+	mediaType string
+	digest    string
+	labels    map[string]string
+	err       error
+	calls     []string
+}
+
+func (c *fakeRegistryClient) ManifestMediaType(ctx context.Context, image string) (string, error) {
+	c.calls = append(c.calls, image)
+	return c.mediaType, c.err
+}
+
+func (c *fakeRegistryClient) ManifestDigest(ctx context.Context, image string) (string, error) {
+	c.calls = append(c.calls, image)
+	return c.digest, c.err
+}
+
+func (c *fakeRegistryClient) ImageLabels(ctx context.Context, image string) (map[string]string, error) {
+	c.calls = append(c.calls, image)
+	return c.labels, c.err
+}
+
+func TestCheckMultiArch(t *testing.T) {
+	digest := "@sha256:" + strings.Repeat("a", 64)
+	tests := []struct {
+		name      string
+		image     string
+		mediaType string
+		err       error
+		allowed   bool
+		lookups   int
+	}{
+		{name: "docker manifest list", image: "docker.io/nginx" + digest, mediaType: MediaTypeDockerManifestList, allowed: true, lookups: 1},
+		{name: "oci image index", image: "docker.io/nginx" + digest, mediaType: MediaTypeOCIIndex, allowed: true, lookups: 1},
+		{name: "single-arch manifest", image: "docker.io/nginx" + digest, mediaType: MediaTypeDockerManifest, allowed: false, lookups: 1},
+		{name: "registry error", image: "docker.io/nginx" + digest, err: errors.New("connection refused"), allowed: false, lookups: 1},
+		{name: "no digest", image: "docker.io/nginx:1.19", allowed: false, lookups: 0},
+		{name: "untrusted registry", image: "evil.example.com/nginx" + digest, mediaType: MediaTypeOCIIndex, allowed: false, lookups: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeRegistryClient{mediaType: tt.mediaType, err: tt.err}
+			s := newTestServer(t, "docker.io")
+			s.RequireMultiArch = true
+			s.RegistryClient = client
+			resp := s.validate(newAdmissionReview(t, "Pod", newPod(tt.image, nil)))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if len(client.calls) != tt.lookups {
+				t.Errorf("registry lookups = %v, want %d", client.calls, tt.lookups)
+			}
+		})
+	}
+}
+
+// fakeScanner 返回预先设置好的扫描时间，并记录被查询过的镜像
+type fakeScanner struct {
+	scanned time.Time
+	err     error
+	calls   []string
+}
+
+func (f *fakeScanner) LastScanTime(ctx context.Context, image string) (time.Time, error) {
+	f.calls = append(f.calls, image)
+	return f.scanned, f.err
+}
+
+func TestCheckScanFreshness(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		scanned time.Time
+		err     error
+		allowed bool
+		lookups int
+	}{
+		{name: "recently scanned", image: "docker.io/nginx:1.19", scanned: time.Now().Add(-time.Hour), allowed: true, lookups: 1},
+		{name: "stale scan", image: "docker.io/nginx:1.19", scanned: time.Now().Add(-48 * time.Hour), allowed: false, lookups: 1},
+		{name: "never scanned", image: "docker.io/nginx:1.19", allowed: false, lookups: 1},
+		{name: "scanner error", image: "docker.io/nginx:1.19", err: errors.New("timeout"), allowed: false, lookups: 1},
+		{name: "untrusted registry", image: "evil.example.com/nginx:1.19", scanned: time.Now(), allowed: false, lookups: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scanner := &fakeScanner{scanned: tt.scanned, err: tt.err}
+			s := newTestServer(t, "docker.io")
+			s.Scanner = scanner
+			s.ScanFreshnessWindow = 24 * time.Hour
+			resp := s.validate(newAdmissionReview(t, "Pod", newPod(tt.image, nil)))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if len(scanner.calls) != tt.lookups {
+				t.Errorf("scanner lookups = %v, want %d", scanner.calls, tt.lookups)
+			}
+		})
+	}
+}
+
+func TestCheckImageLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		labels  map[string]string
+		err     error
+		allowed bool
+		lookups int
+	}{
+		{name: "all labels present", image: "docker.io/nginx:1.19", labels: map[string]string{
+			"org.opencontainers.image.source": "https://github.com/nginx/nginx",
+			"maintainer":                      "team@example.com",
+		}, allowed: true, lookups: 1},
+		{name: "missing label", image: "docker.io/nginx:1.19", labels: map[string]string{
+			"org.opencontainers.image.source": "https://github.com/nginx/nginx",
+		}, allowed: false, lookups: 1},
+		{name: "empty label value", image: "docker.io/nginx:1.19", labels: map[string]string{
+			"org.opencontainers.image.source": "",
+			"maintainer":                      "team@example.com",
+		}, allowed: false, lookups: 1},
+		{name: "registry error", image: "docker.io/nginx:1.19", err: errors.New("unauthorized"), allowed: false, lookups: 1},
+		{name: "untrusted registry", image: "evil.example.com/nginx:1.19", allowed: false, lookups: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeRegistryClient{labels: tt.labels, err: tt.err}
+			s := newTestServer(t, "docker.io")
+			s.RegistryClient = client
+			s.RequiredImageLabels = []string{"org.opencontainers.image.source", "maintainer"}
+			resp := s.validate(newAdmissionReview(t, "Pod", newPod(tt.image, nil)))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if len(client.calls) != tt.lookups {
+				t.Errorf("registry lookups = %v, want %d", client.calls, tt.lookups)
+			}
+		})
+	}
+}
+
+// fakeAttestationLookup 返回预先设置好的结果，并记录被查询过的镜像
+type fakeAttestationLookup struct {
+	found bool
+	err   error
+	calls []string
+}
+
+func (f *fakeAttestationLookup) HasAttestation(ctx context.Context, image, predicateType string) (bool, error) {
+	f.calls = append(f.calls, image)
+	return f.found, f.err
+}
+
+func TestCheckAttestation(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		found   bool
+		err     error
+		allowed bool
+		lookups int
+	}{
+		{name: "has attestation", image: "docker.io/nginx:1.19", found: true, allowed: true, lookups: 1},
+		{name: "no attestation", image: "docker.io/nginx:1.19", allowed: false, lookups: 1},
+		{name: "lookup error", image: "docker.io/nginx:1.19", err: errors.New("timeout"), allowed: false, lookups: 1},
+		{name: "untrusted registry", image: "evil.example.com/nginx:1.19", found: true, allowed: false, lookups: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookup := &fakeAttestationLookup{found: tt.found, err: tt.err}
+			s := newTestServer(t, "docker.io")
+			s.AttestationLookup = lookup
+			s.SBOMAttestationType = "https://spdx.dev/Document"
+			resp := s.validate(newAdmissionReview(t, "Pod", newPod(tt.image, nil)))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if len(lookup.calls) != tt.lookups {
+				t.Errorf("attestation lookups = %v, want %d", lookup.calls, tt.lookups)
+			}
+		})
+	}
+}
+
+func TestWhiteListPatterns(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   string
+		image   string
+		allowed bool
+	}{
+		{name: "wildcard match", entry: "*.registry.example.com", image: "eu-1.registry.example.com/app:1.0", allowed: true},
+		{name: "wildcard match with path", entry: "*.registry.example.com", image: "us-2.registry.example.com/team/app:1.0", allowed: true},
+		{name: "wildcard does not cross path", entry: "*.registry.example.com", image: "evil.io/x.registry.example.com/app:1.0", allowed: false},
+		{name: "wildcard lookalike", entry: "*.registry.example.com", image: "eu-1.registry.example.com.evil.io/app:1.0", allowed: false},
+		{name: "wildcard non-match", entry: "*.registry.example.com", image: "registry.example.org/app:1.0", allowed: false},
+		{name: "single character glob", entry: "eu-?.registry.example.com", image: "eu-1.registry.example.com/app:1.0", allowed: true},
+		{name: "character class glob", entry: "eu-[12].registry.example.com", image: "eu-3.registry.example.com/app:1.0", allowed: false},
+		{name: "regex match", entry: `regex:(eu|us)-\d+\.registry\.example\.com/.*`, image: "us-2.registry.example.com/app:1.0", allowed: true},
+		{name: "regex non-match", entry: `regex:(eu|us)-\d+\.registry\.example\.com/.*`, image: "ap-1.registry.example.com/app:1.0", allowed: false},
+		{name: "regex matches registry/repository", entry: `regex:docker\.io/library/.*`, image: "nginx:1.19", allowed: true},
+		{name: "regex with explicit anchors", entry: `regex:^docker\.io/library/.*$`, image: "nginx:1.19", allowed: true},
+		// 正则表达式需要完整匹配，可信地址出现在其他仓库的路径中不会放行
+		{name: "regex lookalike in path", entry: `regex:harbor\.example\.com/.*`, image: "evil.com/harbor.example.com/x:1.0", allowed: false},
+		{name: "regex lookalike host suffix", entry: `regex:harbor\.example\.com/.*`, image: "harbor.example.com.evil.io/x:1.0", allowed: false},
+		{name: "regex prefix only", entry: `regex:harbor\.example\.com/`, image: "harbor.example.com/x:1.0", allowed: false},
+		{name: "literal entry still works", entry: "docker.io", image: "nginx:1.19", allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.entry)
+			resp := s.validate(newAdmissionReview(t, "Pod", newPod(tt.image, nil)))
+			if resp.Allowed != tt.allowed {
+				t.Errorf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+		})
+	}
+}
+
+func TestCompileWhiteList(t *testing.T) {
+	matchers, err := compileWhiteList([]string{"docker.io", "*.example.com", "regex:^gcr\\.io/"})
+	if err != nil {
+		t.Fatalf("compile whitelist: %v", err)
+	}
+	// 普通条目没有匹配规则，pattern 在加载时编译一次
+	if len(matchers) != 3 || matchers[0] != nil || matchers[1] == nil || matchers[2] == nil {
+		t.Errorf("matchers = %v, want nil for the literal entry only", matchers)
+	}
+	for _, entry := range []string{"regex:[invalid", "eu-[12.example.com"} {
+		if _, err := compileWhiteList([]string{entry}); err == nil {
+			t.Errorf("compile %q succeeded, want error", entry)
+		}
+	}
+	s := &WebhookServer{}
+	if err := s.SetWhiteListRegistries([]string{"regex:[invalid"}); err == nil {
+		t.Errorf("SetWhiteListRegistries accepted an invalid regex")
+	}
+}
+
+func TestParseRequireDigest(t *testing.T) {
+	tests := []struct {
+		name         string
+		value        string
+		requireAll   bool
+		requirements map[string]bool
+		wantErr      bool
+	}{
+		{name: "unset", value: ""},
+		{name: "true", value: "true", requireAll: true},
+		{name: "false", value: "false"},
+		{name: "per registry", value: "docker.io/*=true, registry.ydzs.io/*=false", requirements: map[string]bool{
+			"docker.io/*": true, "registry.ydzs.io/*": false,
+		}},
+		{name: "invalid bool", value: "yes-please", wantErr: true},
+		{name: "invalid per registry value", value: "docker.io/*=maybe", wantErr: true},
+		{name: "mixed forms", value: "true,docker.io/*=false", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requireAll, requirements, err := ParseRequireDigest(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if requireAll != tt.requireAll || !reflect.DeepEqual(requirements, tt.requirements) {
+				t.Errorf("ParseRequireDigest(%q) = %v, %v, want %v, %v", tt.value, requireAll, requirements, tt.requireAll, tt.requirements)
+			}
+		})
+	}
+}
+
+func TestRequireDigest(t *testing.T) {
+	digest := "@sha256:" + strings.Repeat("a", 64)
+	tests := []struct {
+		name    string
+		image   string
+		allowed bool
+		message string
+	}{
+		{name: "digest pinned", image: "docker.io/nginx" + digest, allowed: true},
+		{name: "tag only", image: "docker.io/nginx:1.19", message: "Container app image docker.io/nginx:1.19 must be pinned by digest"},
+		{name: "untrusted registry with digest", image: "evil.example.com/nginx" + digest, message: "untrusted registry"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.RequireDigest = true
+			resp := s.validate(newAdmissionReview(t, "Pod", newPod(tt.image, nil)))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if !tt.allowed && !strings.Contains(resp.Result.Message, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", resp.Result.Message, tt.message)
+			}
+		})
+	}
+}
+
+func TestDenyInsecureRegistries(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		allowed bool
+	}{
+		{name: "localhost", image: "localhost/app:1.0", allowed: false},
+		{name: "localhost with port", image: "localhost:5000/app:1.0", allowed: false},
+		{name: "loopback IP", image: "127.0.0.1:5000/app:1.0", allowed: false},
+		{name: "unspecified IP", image: "0.0.0.0:5000/app:1.0", allowed: false},
+		{name: "configured insecure host", image: "registry.lan:5000/app:1.0", allowed: false},
+		{name: "configured insecure registry with port", image: "10.0.0.8:5000/app:1.0", allowed: false},
+		{name: "same host on another port", image: "10.0.0.8:443/app:1.0", allowed: true},
+		{name: "private IP", image: "10.0.0.9:5000/app:1.0", allowed: true},
+		{name: "normal registry", image: "docker.io/library/nginx:1.19", allowed: true},
+		{name: "docker hub short name", image: "nginx:1.19", allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 白名单放行所有仓库，拒绝只来自不安全仓库的校验
+			s := newTestServer(t, "regex:.*")
+			s.DenyInsecureRegistries = true
+			s.InsecureRegistries = []string{"registry.lan", "10.0.0.8:5000"}
+			resp := s.validate(newAdmissionReview(t, "Pod", newPod(tt.image, nil)))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if !tt.allowed && !strings.Contains(resp.Result.Message, "insecure registry") {
+				t.Errorf("message = %q, want an insecure registry denial", resp.Result.Message)
+			}
+		})
+	}
+
+	// 没有开启时不校验
+	s := newTestServer(t, "localhost")
+	if resp := s.validate(newAdmissionReview(t, "Pod", newPod("localhost/app:1.0", nil))); !resp.Allowed {
+		t.Errorf("allowed = false with the check disabled, result %+v", resp.Result)
+	}
+}
+
+func TestRepositoryAllowlist(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		mirrors map[string]string
+		allowed bool
+	}{
+		{name: "allowed repository", image: "myregistry.io/approved/app:1.0", allowed: true},
+		{name: "allowed nested repository", image: "myregistry.io/approved/team/app:1.0", allowed: true},
+		{name: "single segment pattern", image: "myregistry.io/base:1.0", allowed: true},
+		{name: "disallowed repository", image: "myregistry.io/other/app:1.0", allowed: false},
+		{name: "prefix lookalike", image: "myregistry.io/approved-evil/app:1.0", allowed: false},
+		// 配置了 mirror 时白名单和 repository 路径都按 mirror 之后的地址校验
+		{name: "mirrored allowed repository", image: "docker.io/approved/app:1.0", mirrors: map[string]string{"docker.io": "myregistry.io"}, allowed: true},
+		{name: "mirrored disallowed repository", image: "docker.io/other/app:1.0", mirrors: map[string]string{"docker.io": "myregistry.io"}, allowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "myregistry.io")
+			s.RepositoryAllowlist = []string{"myregistry.io/approved/**", "myregistry.io/base"}
+			s.RegistryMirrors = tt.mirrors
+			resp := s.validate(newAdmissionReview(t, "Pod", newPod(tt.image, nil)))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if !tt.allowed && !strings.Contains(resp.Result.Message, "repository is not allowed") {
+				t.Errorf("message = %q, want a repository allowlist denial", resp.Result.Message)
+			}
+		})
+	}
+}
+
+func TestDisallowedCapabilities(t *testing.T) {
+	tests := []struct {
+		name         string
+		capabilities *corev1.Capabilities
+		allowed      bool
+		message      string
+	}{
+		{name: "disallowed capability", capabilities: &corev1.Capabilities{Add: []corev1.Capability{"NET_BIND_SERVICE", "NET_ADMIN"}}, message: "container app adds disallowed capability NET_ADMIN"},
+		{name: "CAP_ prefix and lower case", capabilities: &corev1.Capabilities{Add: []corev1.Capability{"cap_sys_admin"}}, message: "container app adds disallowed capability cap_sys_admin"},
+		// ALL 包含了所有禁止的 capabilities
+		{name: "ALL", capabilities: &corev1.Capabilities{Add: []corev1.Capability{"ALL"}}, message: "container app adds disallowed capability ALL"},
+		{name: "allowed capability", capabilities: &corev1.Capabilities{Add: []corev1.Capability{"NET_BIND_SERVICE"}}, allowed: true},
+		{name: "drop only", capabilities: &corev1.Capabilities{Drop: []corev1.Capability{"NET_ADMIN"}}, allowed: true},
+		{name: "nil capabilities", allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.DisallowedCapabilities = []string{"NET_ADMIN", "CAP_SYS_ADMIN"}
+			pod := newPod("nginx", nil)
+			pod.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{Capabilities: tt.capabilities}
+
+			resp := s.validate(newAdmissionReview(t, "Pod", pod))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if tt.allowed {
+				return
+			}
+			if !strings.Contains(resp.Result.Message, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", resp.Result.Message, tt.message)
+			}
+			if resp.Result.Details == nil || len(resp.Result.Details.Causes) != 1 || resp.Result.Details.Causes[0].Field != "spec.containers[0].securityContext.capabilities.add" {
+				t.Errorf("details = %+v, want one cause for spec.containers[0].securityContext.capabilities.add", resp.Result.Details)
+			}
+		})
+	}
+
+	t.Run("not configured", func(t *testing.T) {
+		pod := newPod("nginx", nil)
+		pod.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"SYS_ADMIN"}}}
+		if resp := newTestServer(t, "docker.io").validate(newAdmissionReview(t, "Pod", pod)); !resp.Allowed {
+			t.Errorf("allowed = false without disallowed capabilities, result %+v", resp.Result)
+		}
+	})
+}
+
+func TestDeniedSecrets(t *testing.T) {
+	secretEnv := func(name, secret string) corev1.EnvVar {
+		return corev1.EnvVar{Name: name, ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: secret}, Key: "value"},
+		}}
+	}
+	tests := []struct {
+		name    string
+		env     []corev1.EnvVar
+		envFrom []corev1.EnvFromSource
+		allowed bool
+		message string
+	}{
+		{name: "env secretKeyRef", env: []corev1.EnvVar{secretEnv("TOKEN", "cluster-admin-token")}, message: "container app references disallowed secret cluster-admin-token in env TOKEN"},
+		{name: "envFrom secretRef", envFrom: []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "cluster-admin-token"}}}}, message: "container app references disallowed secret cluster-admin-token in envFrom"},
+		{name: "allowed secret", env: []corev1.EnvVar{secretEnv("TOKEN", "app-token")}, envFrom: []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "app-config"}}}}, allowed: true},
+		// 同名的 ConfigMap 不受限制
+		{name: "configmap with a denied name", envFrom: []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "cluster-admin-token"}}}}, allowed: true},
+		{name: "plain value", env: []corev1.EnvVar{{Name: "TOKEN", Value: "cluster-admin-token"}}, allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.DeniedSecrets = []string{"cluster-admin-token", "registry-root"}
+			pod := newPod("nginx", nil)
+			pod.Spec.Containers[0].Env = tt.env
+			pod.Spec.Containers[0].EnvFrom = tt.envFrom
+
+			resp := s.validate(newAdmissionReview(t, "Pod", pod))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if !tt.allowed && !strings.Contains(resp.Result.Message, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", resp.Result.Message, tt.message)
+			}
+		})
+	}
+}
+
+func TestDeploymentLimits(t *testing.T) {
+	int32Ptr := func(i int32) *int32 { return &i }
+	tests := []struct {
+		name             string
+		revisionHistory  *int32
+		progressDeadline *int32
+		message          string
+	}{
+		{name: "compliant", revisionHistory: int32Ptr(5), progressDeadline: int32Ptr(300)},
+		{name: "at the maximum", revisionHistory: int32Ptr(10), progressDeadline: int32Ptr(600)},
+		{name: "missing revisionHistoryLimit", progressDeadline: int32Ptr(300), message: "must set spec.revisionHistoryLimit"},
+		{name: "revisionHistoryLimit too large", revisionHistory: int32Ptr(11), progressDeadline: int32Ptr(300), message: "spec.revisionHistoryLimit 11 exceeds the maximum 10"},
+		{name: "missing progressDeadlineSeconds", revisionHistory: int32Ptr(5), message: "must set spec.progressDeadlineSeconds"},
+		{name: "progressDeadlineSeconds too large", revisionHistory: int32Ptr(5), progressDeadline: int32Ptr(601), message: "spec.progressDeadlineSeconds 601 exceeds the maximum 600"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.RequireDeploymentLimits = true
+			s.MaxRevisionHistoryLimit = 10
+			s.MaxProgressDeadlineSeconds = 600
+			deployment := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+				Spec: appsv1.DeploymentSpec{
+					RevisionHistoryLimit:    tt.revisionHistory,
+					ProgressDeadlineSeconds: tt.progressDeadline,
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx"}}},
+					},
+				},
+			}
+			resp := s.validate(newAdmissionReview(t, "Deployment", deployment))
+			if resp.Allowed != (tt.message == "") {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.message == "", resp.Result)
+			}
+			if tt.message != "" && !strings.Contains(resp.Result.Message, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", resp.Result.Message, tt.message)
+			}
+		})
+	}
+
+	// 没有开启时不要求设置
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx"}}},
+		}},
+	}
+	if resp := newTestServer(t, "docker.io").validate(newAdmissionReview(t, "Deployment", deployment)); !resp.Allowed {
+		t.Errorf("allowed = false with the check disabled, result %+v", resp.Result)
+	}
+}
+
+func TestDenyDefaultServiceAccount(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name           string
+		policy         string
+		namespace      string
+		serviceAccount string
+		automount      *bool
+		allowed        bool
+		message        string
+	}{
+		{name: "implicit default", policy: "always", message: "Pod uses the default service account!"},
+		{name: "explicit default", policy: "always", serviceAccount: "default", message: "Pod uses the default service account!"},
+		{name: "default without token", policy: "always", serviceAccount: "default", automount: &disabled, message: "Pod uses the default service account!"},
+		{name: "explicit service account", policy: "always", serviceAccount: "web", allowed: true},
+		{name: "exempt namespace", policy: "always", namespace: "kube-system", allowed: true},
+		// automount 只在 default ServiceAccount 挂载了 token 时拒绝，没有设置时默认会挂载
+		{name: "automount default token", policy: "automount", automount: &enabled, message: "automountServiceAccountToken enabled"},
+		{name: "automount unset", policy: "automount", serviceAccount: "default", message: "automountServiceAccountToken enabled"},
+		{name: "automount disabled", policy: "automount", automount: &disabled, allowed: true},
+		{name: "automount explicit service account", policy: "automount", serviceAccount: "web", automount: &enabled, allowed: true},
+		{name: "not configured", allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.DenyDefaultServiceAccount = tt.policy
+			s.DefaultServiceAccountExemptNamespaces = []string{"kube-system"}
+			pod := newPod("nginx", nil)
+			if tt.namespace != "" {
+				pod.Namespace = tt.namespace
+			}
+			pod.Spec.ServiceAccountName = tt.serviceAccount
+			pod.Spec.AutomountServiceAccountToken = tt.automount
+
+			resp := s.validate(newAdmissionReview(t, "Pod", pod))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if !tt.allowed && !strings.Contains(resp.Result.Message, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", resp.Result.Message, tt.message)
+			}
+		})
+	}
+}
+
+func TestCheckScheduling(t *testing.T) {
+	tests := []struct {
+		name         string
+		nodeSelector map[string]string
+		tolerations  []corev1.Toleration
+		message      string
+	}{
+		{name: "allowed nodeSelector", nodeSelector: map[string]string{"kubernetes.io/os": "linux"}},
+		{name: "disallowed nodeSelector", nodeSelector: map[string]string{"kubernetes.io/os": "linux", "nvidia.com/gpu": "true"}, message: "Pod uses disallowed nodeSelector key nvidia.com/gpu!"},
+		{name: "allowed toleration", tolerations: []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "batch", Effect: corev1.TaintEffectNoSchedule}}},
+		{name: "restricted toleration", tolerations: []corev1.Toleration{{Key: "node-role.kubernetes.io/master", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}}, message: "Pod tolerates restricted taint node-role.kubernetes.io/master!"},
+		// 不带 key 的 Exists 容忍所有污点，也包括受限的污点
+		{name: "tolerate everything", tolerations: []corev1.Toleration{{Operator: corev1.TolerationOpExists}}, message: "Pod tolerates all taints"},
+		{name: "no scheduling hints"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.DisallowedNodeSelectorKeys = []string{"nvidia.com/gpu"}
+			s.DisallowedTolerationKeys = []string{"node-role.kubernetes.io/master", "node-role.kubernetes.io/control-plane"}
+			pod := newPod("nginx", nil)
+			pod.Spec.NodeSelector = tt.nodeSelector
+			pod.Spec.Tolerations = tt.tolerations
+
+			resp := s.validate(newAdmissionReview(t, "Pod", pod))
+			if resp.Allowed != (tt.message == "") {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.message == "", resp.Result)
+			}
+			if tt.message != "" && !strings.Contains(resp.Result.Message, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", resp.Result.Message, tt.message)
+			}
+		})
+	}
+
+	// 没有配置受限的污点时不检查 tolerations
+	pod := newPod("nginx", nil)
+	pod.Spec.Tolerations = []corev1.Toleration{{Operator: corev1.TolerationOpExists}}
+	if resp := newTestServer(t, "docker.io").validate(newAdmissionReview(t, "Pod", pod)); !resp.Allowed {
+		t.Errorf("allowed = false without restricted taints, result %+v", resp.Result)
+	}
+}
+
+func TestDenyDockerHub(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		mirrors map[string]string
+		allowed bool
+	}{
+		// 没有指定仓库的镜像默认来自 Docker Hub
+		{name: "unqualified official image", image: "nginx:1.19", allowed: false},
+		{name: "unqualified user image", image: "grafana/grafana:7.3.0", allowed: false},
+		{name: "qualified docker.io", image: "docker.io/library/nginx:1.19", allowed: false},
+		{name: "index.docker.io", image: "index.docker.io/library/nginx:1.19", allowed: false},
+		{name: "registry-1.docker.io", image: "registry-1.docker.io/grafana/grafana:7.3.0", allowed: false},
+		{name: "internal registry", image: "harbor.example.com/library/nginx:1.19", allowed: true},
+		{name: "registry with port", image: "localhost:5000/nginx:1.19", allowed: true},
+		{name: "allowed official image", image: "busybox:1.32", allowed: true},
+		{name: "allowed qualified official image", image: "docker.io/library/busybox:1.32", allowed: true},
+		{name: "allowed namespace prefix", image: "bitnami/redis:6.0", allowed: true},
+		// 配置了 mirror 时按照实际拉取的地址判断是否来自 Docker Hub
+		{name: "mirrored official image", image: "nginx:1.19", mirrors: map[string]string{"docker.io": "mirror.example.com"}, allowed: true},
+		{name: "mirrored qualified image", image: "docker.io/grafana/grafana:7.3.0", mirrors: map[string]string{"docker.io": "mirror.example.com"}, allowed: true},
+		{name: "other registry mirrored to Docker Hub", image: "quay.io/nginx:1.19", mirrors: map[string]string{"quay.io": "docker.io"}, allowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "regex:.*")
+			s.DenyDockerHub = true
+			s.DockerHubAllowedImages = []string{"busybox", "bitnami/"}
+			s.RegistryMirrors = tt.mirrors
+			resp := s.validate(newAdmissionReview(t, "Pod", newPod(tt.image, nil)))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if !tt.allowed && !strings.Contains(resp.Result.Message, "is pulled from Docker Hub") {
+				t.Errorf("message = %q, want a Docker Hub denial", resp.Result.Message)
+			}
+		})
+	}
+}
+
+func TestDigestRequirements(t *testing.T) {
+	digest := "@sha256:" + strings.Repeat("a", 64)
+	tests := []struct {
+		name    string
+		image   string
+		allowed bool
+	}{
+		// 外部仓库必须使用 digest 引用
+		{name: "external tag", image: "docker.io/library/nginx:1.19", allowed: false},
+		{name: "external unqualified tag", image: "nginx:1.19", allowed: false},
+		{name: "external digest", image: "docker.io/library/nginx" + digest, allowed: true},
+		{name: "external tag and digest", image: "quay.io/coreos/etcd:v3.4" + digest, allowed: true},
+		{name: "other external tag", image: "quay.io/coreos/etcd:v3.4", allowed: false},
+		// 内部仓库可以继续使用 tag
+		{name: "internal tag", image: "registry.ydzs.io/team/app:1.0", allowed: true},
+		// 最长的模式生效，内部仓库中的第三方镜像仍然要求 digest
+		{name: "internal third-party tag", image: "registry.ydzs.io/thirdparty/redis:6.0", allowed: false},
+		{name: "internal third-party digest", image: "registry.ydzs.io/thirdparty/redis" + digest, allowed: true},
+		{name: "unmatched repository", image: "gcr.io/distroless/static:nonroot", allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "regex:.*")
+			s.DigestRequirements = map[string]bool{
+				"docker.io/*":                   true,
+				"quay.io/*":                     true,
+				"registry.ydzs.io/*":            false,
+				"registry.ydzs.io/thirdparty/*": true,
+			}
+			resp := s.validate(newAdmissionReview(t, "Pod", newPod(tt.image, nil)))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if !tt.allowed && !strings.Contains(resp.Result.Message, "must be pinned by digest") {
+				t.Errorf("message = %q, want a digest requirement denial", resp.Result.Message)
+			}
+		})
+	}
+}
+
+func TestPodAntiAffinity(t *testing.T) {
+	int32Ptr := func(i int32) *int32 { return &i }
+	antiAffinity := &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{
+		PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{{
+			Weight: 100,
+			PodAffinityTerm: corev1.PodAffinityTerm{
+				LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+				TopologyKey:   "kubernetes.io/hostname",
+			},
+		}},
+	}}
+	tests := []struct {
+		name      string
+		namespace string
+		replicas  *int32
+		affinity  *corev1.Affinity
+		allowed   bool
+	}{
+		{name: "multiple replicas with anti-affinity", namespace: "prod", replicas: int32Ptr(3), affinity: antiAffinity, allowed: true},
+		{name: "multiple replicas without affinity", namespace: "prod", replicas: int32Ptr(3), allowed: false},
+		{name: "node affinity only", namespace: "prod", replicas: int32Ptr(3), affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{}}, allowed: false},
+		{name: "empty anti-affinity", namespace: "prod", replicas: int32Ptr(3), affinity: &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{}}, allowed: false},
+		{name: "single replica", namespace: "prod", replicas: int32Ptr(1), allowed: true},
+		// replicas 没有设置时默认为 1
+		{name: "replicas unset", namespace: "prod", allowed: true},
+		{name: "namespace not scoped", namespace: "dev", replicas: int32Ptr(3), allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.RequirePodAntiAffinity = true
+			s.PodAntiAffinityNamespaces = []string{"prod"}
+			deployment := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: tt.namespace},
+				Spec: appsv1.DeploymentSpec{
+					Replicas: tt.replicas,
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{Affinity: tt.affinity, Containers: []corev1.Container{{Name: "app", Image: "nginx"}}},
+					},
+				},
+			}
+			resp := s.validate(newAdmissionReview(t, "Deployment", deployment))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if !tt.allowed && !strings.Contains(resp.Result.Message, "Deployment web has 3 replicas but no spec.template.spec.affinity.podAntiAffinity") {
+				t.Errorf("message = %q, want it to name the missing podAntiAffinity", resp.Result.Message)
+			}
+		})
+	}
+}
+
+func TestParseIDRanges(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []IDRange
+		wantErr bool
+	}{
+		{value: "", want: nil},
+		{value: "1000-2000, 5000", want: []IDRange{{Min: 1000, Max: 2000}, {Min: 5000, Max: 5000}}},
+		{value: "2000-1000", wantErr: true},
+		{value: "1000-abc", wantErr: true},
+		{value: "many", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseIDRanges(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseIDRanges(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckGroups(t *testing.T) {
+	int64Ptr := func(i int64) *int64 { return &i }
+	tests := []struct {
+		name            string
+		securityContext *corev1.PodSecurityContext
+		message         string
+	}{
+		{name: "in range", securityContext: &corev1.PodSecurityContext{FSGroup: int64Ptr(1500), SupplementalGroups: []int64{1000, 2000, 5000}}},
+		{name: "fsGroup out of range", securityContext: &corev1.PodSecurityContext{FSGroup: int64Ptr(0)}, message: "Pod securityContext.fsGroup 0 is outside the allowed ranges [1000-2000 5000-5000]!"},
+		{name: "fsGroup just above range", securityContext: &corev1.PodSecurityContext{FSGroup: int64Ptr(2001)}, message: "securityContext.fsGroup 2001"},
+		{name: "supplementalGroups out of range", securityContext: &corev1.PodSecurityContext{FSGroup: int64Ptr(1000), SupplementalGroups: []int64{1000, 4999}}, message: "Pod securityContext.supplementalGroups 4999 is outside the allowed ranges"},
+		{name: "no groups", securityContext: &corev1.PodSecurityContext{}},
+		{name: "nil securityContext"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.AllowedGroupRanges = []IDRange{{Min: 1000, Max: 2000}, {Min: 5000, Max: 5000}}
+			pod := newPod("nginx", nil)
+			pod.Spec.SecurityContext = tt.securityContext
+
+			resp := s.validate(newAdmissionReview(t, "Pod", pod))
+			if resp.Allowed != (tt.message == "") {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.message == "", resp.Result)
+			}
+			if tt.message != "" && !strings.Contains(resp.Result.Message, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", resp.Result.Message, tt.message)
+			}
+		})
+	}
+}
+
+func TestCheckHostPorts(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		ports   []corev1.ContainerPort
+		message string
+	}{
+		{name: "deny hostPort", policy: "deny", ports: []corev1.ContainerPort{{ContainerPort: 80}, {ContainerPort: 443, HostPort: 443}}, message: "container app uses hostPort 443! Host ports are not allowed."},
+		{name: "deny without hostPort", policy: "deny", ports: []corev1.ContainerPort{{ContainerPort: 80}}},
+		{name: "allowlisted hostPort", policy: "allowlist", ports: []corev1.ContainerPort{{ContainerPort: 9100, HostPort: 9100}, {ContainerPort: 30080, HostPort: 30080}}},
+		{name: "hostPort outside allowlist", policy: "allowlist", ports: []corev1.ContainerPort{{ContainerPort: 22, HostPort: 22}}, message: "container app uses hostPort 22! Only host ports in [9100-9100 30000-32767] are allowed."},
+		{name: "not configured", ports: []corev1.ContainerPort{{ContainerPort: 22, HostPort: 22}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.HostPortPolicy = tt.policy
+			s.AllowedHostPorts = []IDRange{{Min: 9100, Max: 9100}, {Min: 30000, Max: 32767}}
+			pod := newPod("nginx", nil)
+			pod.Spec.Containers[0].Ports = tt.ports
+
+			resp := s.validate(newAdmissionReview(t, "Pod", pod))
+			if resp.Allowed != (tt.message == "") {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.message == "", resp.Result)
+			}
+			if tt.message != "" && !strings.Contains(resp.Result.Message, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", resp.Result.Message, tt.message)
+			}
+		})
+	}
+}
+
+func TestCheckPriorityClass(t *testing.T) {
+	tests := []struct {
+		name          string
+		namespace     string
+		priorityClass string
+		message       string
+	}{
+		{name: "critical namespace with allowed class", namespace: "payments", priorityClass: "business-critical"},
+		{name: "critical namespace without class", namespace: "payments", message: "Pod in critical namespace payments must set priorityClassName!"},
+		{name: "critical namespace with other class", namespace: "payments", priorityClass: "best-effort", message: "Pod in critical namespace payments uses priorityClassName best-effort!"},
+		{name: "normal namespace without class", namespace: "default"},
+		{name: "normal namespace with other class", namespace: "default", priorityClass: "best-effort"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.CriticalNamespaces = []string{"payments", "kube-system"}
+			s.AllowedPriorityClasses = []string{"business-critical", "system-cluster-critical"}
+			pod := newPod("nginx", nil)
+			pod.Namespace = tt.namespace
+			pod.Spec.PriorityClassName = tt.priorityClass
+
+			resp := s.validate(newAdmissionReview(t, "Pod", pod))
+			if resp.Allowed != (tt.message == "") {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.message == "", resp.Result)
+			}
+			if tt.message != "" && !strings.Contains(resp.Result.Message, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", resp.Result.Message, tt.message)
+			}
+		})
+	}
+}
+
+func TestRequireVerifiedPublisher(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		mirrors map[string]string
+		message string
+	}{
+		{name: "verified and whitelisted", image: "harbor.example.com/team/app:1.0"},
+		// 通过认证仓库的 mirror 拉取的镜像按照 mirror 之后的地址校验
+		{name: "mirrored through a verified publisher", image: "quay.io/coreos/etcd:v3.4", mirrors: map[string]string{"quay.io": "harbor.example.com/quay"}},
+		{name: "unqualified docker.io image", image: "nginx:1.19"},
+		{name: "whitelisted but unverified", image: "quay.io/coreos/etcd:v3.4", message: "quay.io/coreos/etcd:v3.4 image comes from registry quay.io which is not a verified publisher!"},
+		// 认证仓库不会绕过白名单
+		{name: "verified but not whitelisted", image: "gcr.io/distroless/static:nonroot", message: "untrusted registry"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io", "quay.io", "harbor.example.com")
+			s.RequireVerifiedPublisher = true
+			s.VerifiedPublishers = []string{"docker.io", "harbor.example.com", "gcr.io"}
+			s.RegistryMirrors = tt.mirrors
+			resp := s.validate(newAdmissionReview(t, "Pod", newPod(tt.image, nil)))
+			if resp.Allowed != (tt.message == "") {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.message == "", resp.Result)
+			}
+			if tt.message != "" && !strings.Contains(resp.Result.Message, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", resp.Result.Message, tt.message)
+			}
+		})
+	}
+
+	// 没有开启时只校验白名单
+	s := newTestServer(t, "quay.io")
+	s.VerifiedPublishers = []string{"docker.io"}
+	if resp := s.validate(newAdmissionReview(t, "Pod", newPod("quay.io/coreos/etcd:v3.4", nil))); !resp.Allowed {
+		t.Errorf("allowed = false with the policy disabled, result %+v", resp.Result)
+	}
+}
+
+func TestRequiredTagPrefixes(t *testing.T) {
+	digest := "@sha256:" + strings.Repeat("a", 64)
+	tests := []struct {
+		name      string
+		namespace string
+		image     string
+		message   string
+	}{
+		{name: "matching prefix", namespace: "production", image: "nginx:release-1.19"},
+		{name: "other prefix", namespace: "production", image: "nginx:dev-1.19", message: `tag "dev-1.19" in namespace production must start with "release-"`},
+		{name: "untagged", namespace: "production", image: "nginx", message: "must start with"},
+		// 只有 digest 时比任何 tag 都更严格，不要求 tag 前缀
+		{name: "digest only", namespace: "production", image: "nginx" + digest},
+		// 同时有 tag 和 digest 时 tag 依然要满足前缀，避免用 digest 绕过前缀检查
+		{name: "matching prefix with digest", namespace: "production", image: "nginx:release-1.19" + digest},
+		{name: "other prefix with digest", namespace: "production", image: "nginx:dev-1.19" + digest, message: `tag "dev-1.19" in namespace production must start with "release-"`},
+		{name: "namespace without prefix", namespace: "default", image: "nginx:dev-1.19"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.RequiredTagPrefixes = map[string]string{"production": "release-"}
+			pod := newPod(tt.image, nil)
+			pod.Namespace = tt.namespace
+			resp := s.validate(newAdmissionReview(t, "Pod", pod))
+			if resp.Allowed != (tt.message == "") {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.message == "", resp.Result)
+			}
+			if tt.message != "" && !strings.Contains(resp.Result.Message, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", resp.Result.Message, tt.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// ErrQueueFull 工作队列已满
+var ErrQueueFull = errors.New("admission work queue is full")
+
+// WorkerPool 使用固定数量的 worker 处理准入请求，等待处理的请求数量受队列长度限制
+type WorkerPool struct {
+	queue chan func()
+}
+
+func NewWorkerPool(workers, queueSize int) *WorkerPool {
+	p := &WorkerPool{queue: make(chan func(), queueSize)}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range p.queue {
+				job()
+			}
+		}()
+	}
+	return p
+}
+
+// Do 将 fn 放入队列并等待结果，队列已满时返回 ErrQueueFull，ctx 超时时返回 ctx.Err()
+// 超时后 fn 仍然可能在 worker 中执行完，但结果会被丢弃
+func (p *WorkerPool) Do(ctx context.Context, fn func() *admissionv1.AdmissionResponse) (*admissionv1.AdmissionResponse, error) {
+	result := make(chan *admissionv1.AdmissionResponse, 1)
+	job := func() {
+		// 排队期间已经超时的请求不再处理
+		if ctx.Err() != nil {
+			return
+		}
+		result <- fn()
+	}
+	select {
+	case p.queue <- job:
+	default:
+		return nil, ErrQueueFull
+	}
+	select {
+	case resp := <-result:
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
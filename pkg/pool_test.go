@@ -0,0 +1,132 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// blockPool 让 pool 中唯一的 worker 阻塞在一个任务上，并用另一个任务占满长度为 1 的队列，
+// 返回的函数用于释放 worker
+func blockPool(t *testing.T, p *WorkerPool) func() {
+	t.Helper()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	blocking := func() *admissionv1.AdmissionResponse {
+		close(started)
+		<-release
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+	go p.Do(context.Background(), blocking)
+	<-started
+	go p.Do(context.Background(), func() *admissionv1.AdmissionResponse {
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	})
+	for deadline := time.Now().Add(time.Second); len(p.queue) < 1; {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue was not filled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return func() { close(release) }
+}
+
+func TestWorkerPool(t *testing.T) {
+	p := NewWorkerPool(2, 1)
+	resp, err := p.Do(context.Background(), func() *admissionv1.AdmissionResponse {
+		return &admissionv1.AdmissionResponse{UID: "ok", Allowed: true}
+	})
+	if err != nil || resp.UID != "ok" {
+		t.Fatalf("Do = %+v, %v, want the job response", resp, err)
+	}
+}
+
+func TestWorkerPoolQueueFull(t *testing.T) {
+	p := NewWorkerPool(1, 1)
+	release := blockPool(t, p)
+	defer release()
+
+	called := false
+	_, err := p.Do(context.Background(), func() *admissionv1.AdmissionResponse {
+		called = true
+		return nil
+	})
+	if err != ErrQueueFull {
+		t.Fatalf("err = %v, want ErrQueueFull", err)
+	}
+	if called {
+		t.Errorf("job was run although the queue was full")
+	}
+}
+
+func TestWorkerPoolDeadline(t *testing.T) {
+	p := NewWorkerPool(1, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	release := make(chan struct{})
+	defer close(release)
+	_, err := p.Do(ctx, func() *admissionv1.AdmissionResponse {
+		<-release
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestAdmitInPool(t *testing.T) {
+	slowHook := DecisionHookFunc(func(req *admissionv1.AdmissionRequest, decision *Decision) {
+		time.Sleep(200 * time.Millisecond)
+	})
+	tests := []struct {
+		name     string
+		full     bool
+		slow     bool
+		timeout  string
+		failOpen bool
+		allowed  bool
+		message  string
+	}{
+		{name: "processed", timeout: "10s", allowed: true},
+		{name: "queue full fail closed", full: true, allowed: false, message: ErrQueueFull.Error()},
+		{name: "queue full fail open", full: true, failOpen: true, allowed: true, message: ErrQueueFull.Error()},
+		{name: "deadline exceeded fail closed", slow: true, timeout: "50ms", allowed: false, message: context.DeadlineExceeded.Error()},
+		{name: "deadline exceeded fail open", slow: true, timeout: "50ms", failOpen: true, allowed: true, message: context.DeadlineExceeded.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.FailOpen = tt.failOpen
+			s.WorkerPool = NewWorkerPool(1, 1)
+			if tt.full {
+				release := blockPool(t, s.WorkerPool)
+				defer release()
+			}
+			if tt.slow {
+				s.DecisionHooks = []DecisionHook{slowHook}
+			}
+			request := httptest.NewRequest(http.MethodPost, "/validate?timeout="+tt.timeout, nil)
+			resp := s.admitInPool("/validate", request, newAdmissionReview(t, "Pod", newPod("docker.io/nginx", nil)))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, response %+v", resp.Allowed, tt.allowed, resp)
+			}
+			if tt.message == "" {
+				return
+			}
+			if tt.failOpen {
+				if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], tt.message) {
+					t.Errorf("warnings = %v, want %q", resp.Warnings, tt.message)
+				}
+				return
+			}
+			if resp.Result == nil || resp.Result.Code != http.StatusInternalServerError || !strings.Contains(resp.Result.Message, tt.message) {
+				t.Errorf("result = %+v, want a 500 containing %q", resp.Result, tt.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/klog"
+)
+
+// DecisionRecord 是一次准入决策的记录，用于实时的审计流水线
+type DecisionRecord struct {
+	Time      string   `json:"time"`
+	Path      string   `json:"path"`
+	UID       string   `json:"uid"`
+	Kind      string   `json:"kind"`
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Operation string   `json:"operation"`
+	User      string   `json:"user"`
+	Allowed   bool     `json:"allowed"`
+	Message   string   `json:"message,omitempty"`
+	Patched   bool     `json:"patched"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+func newDecisionRecord(path string, req *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse) DecisionRecord {
+	record := DecisionRecord{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Path:      path,
+		UID:       string(req.UID),
+		Kind:      req.Kind.Kind,
+		Namespace: req.Namespace,
+		Name:      req.Name,
+		Operation: string(req.Operation),
+		User:      req.UserInfo.Username,
+		Allowed:   resp.Allowed,
+		Patched:   len(resp.Patch) > 0,
+		Warnings:  resp.Warnings,
+	}
+	if resp.Result != nil {
+		record.Message = resp.Result.Message
+	}
+	return record
+}
+
+// Publisher 将准入决策发送到消息队列
+type Publisher interface {
+	Publish(ctx context.Context, record DecisionRecord) error
+}
+
+// NoopPublisher 不发送任何记录
+type NoopPublisher struct{}
+
+func (NoopPublisher) Publish(context.Context, DecisionRecord) error { return nil }
+
+// NATSPublisher 将决策记录以 JSON 格式发布到 NATS 的 Subject 上
+type NATSPublisher struct {
+	Conn    *nats.Conn
+	Subject string
+}
+
+func NewNATSPublisher(url, subject string) (*NATSPublisher, error) {
+	conn, err := nats.Connect(url, nats.Name("admission-registry"))
+	if err != nil {
+		return nil, err
+	}
+	return &NATSPublisher{Conn: conn, Subject: subject}, nil
+}
+
+func (p *NATSPublisher) Publish(ctx context.Context, record DecisionRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return p.Conn.Publish(p.Subject, data)
+}
+
+// AsyncPublisher 在后台的 goroutine 中发送记录，缓冲区满或者发送失败时丢弃记录，不会阻塞准入请求
+type AsyncPublisher struct {
+	publisher Publisher
+	records   chan DecisionRecord
+}
+
+func NewAsyncPublisher(publisher Publisher, bufferSize int) *AsyncPublisher {
+	p := &AsyncPublisher{publisher: publisher, records: make(chan DecisionRecord, bufferSize)}
+	go func() {
+		for record := range p.records {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := p.publisher.Publish(ctx, record); err != nil {
+				klog.Errorf("Failed to publish decision for UID %s: %v", record.UID, err)
+			}
+			cancel()
+		}
+	}()
+	return p
+}
+
+func (p *AsyncPublisher) Publish(ctx context.Context, record DecisionRecord) error {
+	select {
+	case p.records <- record:
+	default:
+		klog.Errorf("Decision publish buffer is full, drop decision for UID %s", record.UID)
+	}
+	return nil
+}
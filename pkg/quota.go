@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// deploymentResourceUsage 计算 Deployment 所有副本会占用的配额资源
+func deploymentResourceUsage(deployment *appsv1.Deployment) corev1.ResourceList {
+	replicas := int64(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = int64(*deployment.Spec.Replicas)
+	}
+
+	usage := corev1.ResourceList{
+		corev1.ResourcePods: *resource.NewQuantity(replicas, resource.DecimalSI),
+	}
+	add := func(name corev1.ResourceName, q resource.Quantity) {
+		total := usage[name]
+		for i := int64(0); i < replicas; i++ {
+			total.Add(q)
+		}
+		usage[name] = total
+	}
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		for name, q := range container.Resources.Requests {
+			add(name, q)
+			add(corev1.ResourceName("requests."+string(name)), q)
+		}
+		for name, q := range container.Resources.Limits {
+			add(corev1.ResourceName("limits."+string(name)), q)
+		}
+	}
+	return usage
+}
+
+// quotaWarnings 检查 Deployment 新增的资源需求是否会超出所在命名空间的 ResourceQuota 剩余额度
+// 这里只返回警告信息，最终是否拒绝仍然交给 ResourceQuota 准入控制器
+func (s *WebhookServer) quotaWarnings(namespace string, deployment, oldDeployment *appsv1.Deployment) []string {
+	quotas, err := s.Clientset.CoreV1().ResourceQuotas(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("Failed to list resource quotas in namespace %s: %v", namespace, err)
+		return nil
+	}
+	if len(quotas.Items) == 0 {
+		return nil
+	}
+
+	requested := deploymentResourceUsage(deployment)
+	if oldDeployment != nil {
+		// 更新操作只需要关注新增的部分，旧对象的用量已经计入 used
+		for name, q := range deploymentResourceUsage(oldDeployment) {
+			r := requested[name]
+			r.Sub(q)
+			requested[name] = r
+		}
+	}
+
+	var warnings []string
+	for _, quota := range quotas.Items {
+		for name, hard := range quota.Status.Hard {
+			req, ok := requested[name]
+			if !ok || req.Sign() <= 0 {
+				continue
+			}
+			remaining := hard.DeepCopy()
+			used := quota.Status.Used[name]
+			remaining.Sub(used)
+			if req.Cmp(remaining) > 0 {
+				warnings = append(warnings, fmt.Sprintf("Deployment %s would exceed ResourceQuota %s/%s for %s: requested %s, remaining %s",
+					deployment.Name, namespace, quota.Name, name, req.String(), remaining.String()))
+			}
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newDeployment(namespace string, replicas int32, cpu string) *appsv1.Deployment {
+	spec := newPod("nginx", nil).Spec
+	spec.Containers[0].Resources.Requests = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: namespace},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: corev1.PodTemplateSpec{Spec: spec},
+		},
+	}
+}
+
+func TestQuotaWarnings(t *testing.T) {
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "compute", Namespace: "default"},
+		Status: corev1.ResourceQuotaStatus{
+			Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("2")},
+			Used: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("1500m")},
+		},
+	}
+	tests := []struct {
+		name       string
+		deployment *appsv1.Deployment
+		old        *appsv1.Deployment
+		warning    bool
+	}{
+		{name: "within the remaining quota", deployment: newDeployment("default", 1, "250m")},
+		{name: "exceeds the remaining quota", deployment: newDeployment("default", 2, "500m"), warning: true},
+		{name: "update only counts the increase", deployment: newDeployment("default", 2, "500m"), old: newDeployment("default", 1, "500m")},
+		{name: "namespace without quota", deployment: newDeployment("other", 10, "1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.CheckResourceQuota = true
+			s.Clientset = fake.NewSimpleClientset(quota)
+			ar := newAdmissionReview(t, "Deployment", tt.deployment)
+			if tt.old != nil {
+				raw, _ := json.Marshal(tt.old)
+				ar.Request.Operation = admissionv1.Update
+				ar.Request.OldObject.Raw = raw
+			}
+
+			resp := s.validate(ar)
+			if !resp.Allowed {
+				t.Fatalf("allowed = false, want quota problems to only warn, result %+v", resp.Result)
+			}
+			if warned := len(resp.Warnings) > 0; warned != tt.warning {
+				t.Fatalf("warnings = %v, want warning %v", resp.Warnings, tt.warning)
+			}
+			if tt.warning && !strings.Contains(resp.Warnings[0], "ResourceQuota default/compute") {
+				t.Errorf("warning = %q, want it to name the quota", resp.Warnings[0])
+			}
+		})
+	}
+}
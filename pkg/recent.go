@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"k8s.io/klog"
+)
+
+// RecentDecision 是 /recent 和 /debug/decisions 接口返回的一条决策，不包含用户信息和对象内容
+type RecentDecision struct {
+	Time      string `json:"time"`
+	Path      string `json:"path"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Allowed   bool   `json:"allowed"`
+	Decision  string `json:"decision"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// DecisionRing 是保存最近 N 条决策的环形缓冲区
+type DecisionRing struct {
+	mu        sync.Mutex
+	decisions []RecentDecision
+	next      int
+	full      bool
+}
+
+func NewDecisionRing(size int) *DecisionRing {
+	if size <= 0 {
+		size = 1
+	}
+	return &DecisionRing{decisions: make([]RecentDecision, size)}
+}
+
+func (r *DecisionRing) Add(record DecisionRecord) {
+	decision := RecentDecision{
+		Time:      record.Time,
+		Path:      record.Path,
+		Kind:      record.Kind,
+		Namespace: record.Namespace,
+		Name:      record.Name,
+		Allowed:   record.Allowed,
+		Decision:  "deny",
+		Reason:    record.Message,
+	}
+	if record.Allowed {
+		decision.Decision = "allow"
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.decisions[r.next] = decision
+	r.next = (r.next + 1) % len(r.decisions)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// List 按照时间顺序返回缓冲区中的决策，最新的在最后
+func (r *DecisionRing) List() []RecentDecision {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]RecentDecision(nil), r.decisions[:r.next]...)
+	}
+	return append(append([]RecentDecision(nil), r.decisions[r.next:]...), r.decisions[:r.next]...)
+}
+
+// RecentHandler 处理 GET /recent 和 GET /debug/decisions 请求，返回最近的准入决策
+func (s *WebhookServer) RecentHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var decisions []RecentDecision
+	if s.RecentDecisions != nil {
+		decisions = s.RecentDecisions.List()
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(writer).Encode(decisions); err != nil {
+		klog.Errorf("Can't write response: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestDecisionRing(t *testing.T) {
+	ring := NewDecisionRing(3)
+	for i := 0; i < 5; i++ {
+		ring.Add(DecisionRecord{Kind: "Pod", Namespace: "default", Name: fmt.Sprintf("pod-%d", i), Allowed: i%2 == 0})
+	}
+	decisions := ring.List()
+	if len(decisions) != 3 {
+		t.Fatalf("len = %d, want 3", len(decisions))
+	}
+	for i, decision := range decisions {
+		if want := fmt.Sprintf("pod-%d", i+2); decision.Name != want {
+			t.Errorf("decisions[%d].Name = %s, want %s", i, decision.Name, want)
+		}
+	}
+}
+
+func TestDecisionRingConcurrent(t *testing.T) {
+	ring := NewDecisionRing(10)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ring.Add(DecisionRecord{Name: "pod"})
+				ring.List()
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(ring.List()); n != 10 {
+		t.Errorf("len = %d, want 10", n)
+	}
+}
+
+func TestRecentHandler(t *testing.T) {
+	s := newTestServer(t)
+	s.RecentDecisions = NewDecisionRing(2)
+	s.RecentDecisions.Add(DecisionRecord{Time: "2024-01-01T00:00:00Z", Path: "/validate", Kind: "Pod", Namespace: "default", Name: "web", Allowed: false, Message: "untrusted registry"})
+
+	recorder := httptest.NewRecorder()
+	s.RecentHandler(recorder, httptest.NewRequest(http.MethodGet, "/debug/decisions", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("code = %d, body %s", recorder.Code, recorder.Body)
+	}
+	var got []map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal %s: %v", recorder.Body, err)
+	}
+	want := map[string]interface{}{
+		"time":      "2024-01-01T00:00:00Z",
+		"path":      "/validate",
+		"kind":      "Pod",
+		"namespace": "default",
+		"name":      "web",
+		"allowed":   false,
+		"decision":  "deny",
+		"reason":    "untrusted registry",
+	}
+	if len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+		t.Errorf("decisions = %v, want [%v]", got, want)
+	}
+}
@@ -0,0 +1,259 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+// RegistryClient 查询镜像仓库中的镜像元数据，校验逻辑只依赖这个接口，方便替换实现
+type RegistryClient interface {
+	// ManifestMediaType 返回镜像引用对应 manifest 的 media type
+	ManifestMediaType(ctx context.Context, image string) (string, error)
+	// ManifestDigest 返回镜像引用当前指向的 manifest digest，比如 sha256:xxx
+	ManifestDigest(ctx context.Context, image string) (string, error)
+	// ImageLabels 返回镜像 config 中的 labels，多架构镜像使用 linux/amd64（没有时使用第一个）的镜像
+	ImageLabels(ctx context.Context, image string) (map[string]string, error)
+}
+
+// isImageIndex 判断 manifest 是否为多架构的 manifest list / OCI image index
+func isImageIndex(mediaType string) bool {
+	return mediaType == MediaTypeDockerManifestList || mediaType == MediaTypeOCIIndex
+}
+
+// HTTPRegistryClient 基于 Docker Registry HTTP API V2 实现的 RegistryClient，支持 Basic 和 Bearer token 认证
+type HTTPRegistryClient struct {
+	Client      *http.Client
+	Credentials CredentialProvider // 私有仓库的认证信息，为空时匿名访问
+}
+
+func NewHTTPRegistryClient(timeout time.Duration) *HTTPRegistryClient {
+	return &HTTPRegistryClient{
+		Client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (c *HTTPRegistryClient) ManifestMediaType(ctx context.Context, image string) (string, error) {
+	ref := parseImageReference(image)
+	resp, err := c.do(ctx, http.MethodHead, ref, "/manifests/"+ref.Reference(), strings.Join([]string{
+		MediaTypeDockerManifestList, MediaTypeOCIIndex, MediaTypeDockerManifest, MediaTypeOCIManifest,
+	}, ","))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	mediaType := resp.Header.Get("Content-Type")
+	if i := strings.IndexRune(mediaType, ';'); i != -1 {
+		mediaType = mediaType[:i]
+	}
+	return strings.TrimSpace(mediaType), nil
+}
+
+func (c *HTTPRegistryClient) ManifestDigest(ctx context.Context, image string) (string, error) {
+	ref := parseImageReference(image)
+	resp, err := c.do(ctx, http.MethodHead, ref, "/manifests/"+ref.Reference(), strings.Join([]string{
+		MediaTypeDockerManifestList, MediaTypeOCIIndex, MediaTypeDockerManifest, MediaTypeOCIManifest,
+	}, ","))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("registry returned no Docker-Content-Digest for %s", ref)
+	}
+	return digest, nil
+}
+
+func (c *HTTPRegistryClient) ImageLabels(ctx context.Context, image string) (map[string]string, error) {
+	ref := parseImageReference(image)
+	var manifest struct {
+		MediaType string `json:"mediaType"`
+		Config    struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+		Manifests []struct {
+			Digest   string `json:"digest"`
+			Platform struct {
+				OS           string `json:"os"`
+				Architecture string `json:"architecture"`
+			} `json:"platform"`
+		} `json:"manifests"`
+	}
+	reference := ref.Reference()
+	// 最多解析一层 image index
+	for i := 0; i < 2; i++ {
+		if err := c.getJSON(ctx, ref, "/manifests/"+reference, strings.Join([]string{
+			MediaTypeDockerManifestList, MediaTypeOCIIndex, MediaTypeDockerManifest, MediaTypeOCIManifest,
+		}, ","), &manifest); err != nil {
+			return nil, err
+		}
+		if len(manifest.Manifests) == 0 {
+			break
+		}
+		reference = manifest.Manifests[0].Digest
+		for _, m := range manifest.Manifests {
+			if m.Platform.OS == "linux" && m.Platform.Architecture == "amd64" {
+				reference = m.Digest
+				break
+			}
+		}
+		manifest.Manifests = nil
+	}
+	if manifest.Config.Digest == "" {
+		return nil, fmt.Errorf("manifest of %s has no config", ref)
+	}
+
+	var config struct {
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"config"`
+	}
+	if err := c.getJSON(ctx, ref, "/blobs/"+manifest.Config.Digest, "", &config); err != nil {
+		return nil, err
+	}
+	return config.Config.Labels, nil
+}
+
+func (c *HTTPRegistryClient) getJSON(ctx context.Context, ref imageReference, path, accept string, v interface{}) error {
+	resp, err := c.do(ctx, http.MethodGet, ref, path, accept)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// do 请求仓库的 /v2/<repository><path>，遇到 401 时按照 WWW-Authenticate 获取 token 后重试
+func (c *HTTPRegistryClient) do(ctx context.Context, method string, ref imageReference, path, accept string) (*http.Response, error) {
+	endpoint := fmt.Sprintf("https://%s/v2/%s%s", registryAPIHost(ref.Registry), ref.Repository, path)
+
+	cred, hasCred := RegistryCredential{}, false
+	if c.Credentials != nil {
+		cred, hasCred = c.Credentials.Credential(ref.Registry)
+	}
+
+	send := func(token string) (*http.Response, error) {
+		req, err := http.NewRequest(method, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else if hasCred {
+			req.SetBasicAuth(cred.Username, cred.Password)
+		}
+		return c.Client.Do(req)
+	}
+
+	resp, err := send("")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && strings.HasPrefix(resp.Header.Get("WWW-Authenticate"), "Bearer ") {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		token, err := c.fetchToken(ctx, challenge, cred, hasCred)
+		if err != nil {
+			return nil, err
+		}
+		if resp, err = send(token); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &registryStatusError{code: resp.StatusCode, message: fmt.Sprintf("registry returned %s for %s", resp.Status, ref)}
+	}
+	return resp, nil
+}
+
+// fetchToken 解析 Bearer realm="...",service="...",scope="..." 并获取 token，有认证信息时使用 Basic 认证获取
+func (c *HTTPRegistryClient) fetchToken(ctx context.Context, challenge string, cred RegistryCredential, hasCred bool) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", fmt.Errorf("unsupported registry auth challenge: %q", challenge)
+	}
+	params := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 {
+			params[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid registry auth realm in challenge: %q", challenge)
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if hasCred {
+		req.SetBasicAuth(cred.Username, cred.Password)
+	}
+	resp, err := c.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetch registry token: %s", resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+// registryStatusError 镜像仓库返回了非 200 的状态码
+type registryStatusError struct {
+	code    int
+	message string
+}
+
+func (e *registryStatusError) Error() string {
+	return e.message
+}
+
+func isRegistryNotFound(err error) bool {
+	statusErr, ok := err.(*registryStatusError)
+	return ok && statusErr.code == http.StatusNotFound
+}
+
+// registryAPIHost docker.io 的 API 地址与镜像中使用的仓库地址不同
+func registryAPIHost(registry string) string {
+	if registry == defaultRegistry {
+		return "registry-1.docker.io"
+	}
+	return registry
+}
@@ -0,0 +1,159 @@
+package pkg
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// ConfigSummary 是当前生效配置的摘要，不包含证书、token 等敏感信息
+type ConfigSummary struct {
+	Mode                string              `json:"mode"`
+	WhiteListRegistries []string            `json:"whiteListRegistries"`
+	DenyListRegistries  []string            `json:"denyListRegistries"`
+	DefaultAction       string              `json:"defaultAction"`
+	RegistryMirrors     map[string]string   `json:"registryMirrors,omitempty"`
+	FailOpen            bool                `json:"failOpen"`
+	MutatePolicy        string              `json:"mutatePolicy"`
+	ExemptNamespaces    map[string][]string `json:"exemptNamespaces,omitempty"` // 各项策略豁免的命名空间，只包含配置了豁免的策略
+	Endpoints           []string            `json:"endpoints,omitempty"`
+}
+
+// SetWhiteListRegistries 原子地替换当前使用的镜像仓库白名单，glob 和 regex: 条目在这里预先编译
+func (s *WebhookServer) SetWhiteListRegistries(registries []string) error {
+	matchers, err := compileWhiteList(registries)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.WhiteListRegistries = registries
+	s.whiteListMatchers = matchers
+	return nil
+}
+
+// whiteListRegistries 返回当前的白名单以及一一对应的编译后的匹配规则，普通条目对应的匹配规则为 nil
+func (s *WebhookServer) whiteListRegistries() ([]string, []*regexp.Regexp) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.whiteListMatchers) != len(s.WhiteListRegistries) {
+		// 直接设置 WhiteListRegistries 字段而没有调用 SetWhiteListRegistries，无法编译的条目不会匹配任何镜像
+		matchers := make([]*regexp.Regexp, len(s.WhiteListRegistries))
+		for i, entry := range s.WhiteListRegistries {
+			matchers[i], _ = compileWhiteListEntry(entry)
+		}
+		return s.WhiteListRegistries, matchers
+	}
+	return s.WhiteListRegistries, s.whiteListMatchers
+}
+
+// Reload 从 WhiteListLoader 重新加载白名单
+func (s *WebhookServer) Reload() error {
+	if s.WhiteListLoader == nil {
+		return fmt.Errorf("no whitelist loader configured")
+	}
+	registries, err := s.WhiteListLoader()
+	if err != nil {
+		return err
+	}
+	return s.SetWhiteListRegistries(registries)
+}
+
+func (s *WebhookServer) defaultAction() string {
+	if s.DefaultAction == DefaultActionAllow {
+		return DefaultActionAllow
+	}
+	return DefaultActionDeny
+}
+
+func (s *WebhookServer) mutatePolicy() string {
+	if s.MutatePolicy == MutatePolicyOptIn {
+		return MutatePolicyOptIn
+	}
+	return MutatePolicyOptOut
+}
+
+// Summary 返回当前生效配置的摘要，白名单可能在运行时被重新加载，通过 whiteListRegistries 在锁内读取
+func (s *WebhookServer) Summary() ConfigSummary {
+	whiteListRegistries, _ := s.whiteListRegistries()
+	return ConfigSummary{
+		Mode:                s.modeSummary(),
+		WhiteListRegistries: whiteListRegistries,
+		DenyListRegistries:  s.DenyListRegistries,
+		DefaultAction:       s.defaultAction(),
+		RegistryMirrors:     s.RegistryMirrors,
+		FailOpen:            s.FailOpen,
+		MutatePolicy:        s.mutatePolicy(),
+		ExemptNamespaces:    s.exemptNamespaces(),
+		Endpoints:           s.Endpoints,
+	}
+}
+
+// exemptNamespaces 返回各项策略豁免的命名空间，没有配置豁免的策略不会出现在结果中
+func (s *WebhookServer) exemptNamespaces() map[string][]string {
+	exempt := map[string][]string{}
+	for policy, namespaces := range map[string][]string{
+		"mutate":                s.MutateExemptNamespaces,
+		"privileged":            s.PrivilegedExemptNamespaces,
+		"hostNamespaces":        s.HostNamespaceExemptNamespaces,
+		"defaultServiceAccount": s.DefaultServiceAccountExemptNamespaces,
+	} {
+		if len(namespaces) > 0 {
+			exempt[policy] = namespaces
+		}
+	}
+	if len(exempt) == 0 {
+		return nil
+	}
+	return exempt
+}
+
+func (c ConfigSummary) String() string {
+	return fmt.Sprintf("mode=%s whitelist=%v denylist=%v defaultAction=%s mirrors=%v failOpen=%t mutatePolicy=%s exemptNamespaces=%v endpoints=%v",
+		c.Mode, c.WhiteListRegistries, c.DenyListRegistries, c.DefaultAction, c.RegistryMirrors, c.FailOpen, c.MutatePolicy, c.ExemptNamespaces, c.Endpoints)
+}
+
+// ConfigHandler 处理 GET /config 请求，返回当前生效的配置摘要
+func (s *WebhookServer) ConfigHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(writer).Encode(s.Summary()); err != nil {
+		klog.Errorf("Can't write response: %v", err)
+	}
+}
+
+// ReloadHandler 处理 POST /reload 请求，需要携带 Authorization: Bearer <token>
+func (s *WebhookServer) ReloadHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+	if s.ReloadToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.ReloadToken)) != 1 {
+		http.Error(writer, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := s.Reload(); err != nil {
+		klog.Errorf("Failed to reload config: %v", err)
+		http.Error(writer, fmt.Sprintf("failed to reload config: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	summary := s.Summary()
+	klog.Infof("Config reloaded, whitelist registries: %v", summary.WhiteListRegistries)
+
+	writer.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(writer).Encode(summary); err != nil {
+		klog.Errorf("Can't write response: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReloadHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		token     string
+		loaderErr error
+		code      int
+		whitelist []string
+	}{
+		{name: "reload with a valid token", method: http.MethodPost, token: "secret-token", code: http.StatusOK, whitelist: []string{"quay.io"}},
+		{name: "wrong token", method: http.MethodPost, token: "wrong", code: http.StatusUnauthorized, whitelist: []string{"docker.io"}},
+		{name: "not a POST", method: http.MethodGet, token: "secret-token", code: http.StatusMethodNotAllowed, whitelist: []string{"docker.io"}},
+		{name: "loader failure keeps the old whitelist", method: http.MethodPost, token: "secret-token", loaderErr: fmt.Errorf("unavailable"), code: http.StatusInternalServerError, whitelist: []string{"docker.io"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.ReloadToken = "secret-token"
+			s.WhiteListLoader = func() ([]string, error) {
+				if tt.loaderErr != nil {
+					return nil, tt.loaderErr
+				}
+				return []string{"quay.io"}, nil
+			}
+
+			request := httptest.NewRequest(tt.method, "/reload", nil)
+			request.Header.Set("Authorization", "Bearer "+tt.token)
+			recorder := httptest.NewRecorder()
+			s.ReloadHandler(recorder, request)
+			if recorder.Code != tt.code {
+				t.Fatalf("code = %d, want %d, body %s", recorder.Code, tt.code, recorder.Body)
+			}
+			if whitelist, _ := s.whiteListRegistries(); !reflect.DeepEqual(whitelist, tt.whitelist) {
+				t.Errorf("whitelist = %v, want %v", whitelist, tt.whitelist)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var summary ConfigSummary
+			if err := json.Unmarshal(recorder.Body.Bytes(), &summary); err != nil {
+				t.Fatalf("unmarshal %s: %v", recorder.Body, err)
+			}
+			if !reflect.DeepEqual(summary.WhiteListRegistries, tt.whitelist) {
+				t.Errorf("summary whitelist = %v, want %v", summary.WhiteListRegistries, tt.whitelist)
+			}
+			// 新的白名单立即生效
+			if resp := s.validate(newAdmissionReview(t, "Pod", newPod("quay.io/coreos/etcd:v3.4", nil))); !resp.Allowed {
+				t.Errorf("quay.io image denied after reload: %+v", resp.Result)
+			}
+		})
+	}
+}
+
+func TestConfigHandler(t *testing.T) {
+	s := newTestServer(t, "docker.io")
+	s.DenyListRegistries = []string{"legacy.example.com"}
+	s.FailOpen = true
+	s.MutatePolicy = MutatePolicyOptIn
+	s.ReloadToken = "secret-token"
+	// 模拟运行时重新加载白名单
+	if err := s.SetWhiteListRegistries([]string{"docker.io", "*.example.com"}); err != nil {
+		t.Fatalf("set whitelist: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	s.ConfigHandler(recorder, httptest.NewRequest(http.MethodGet, "/config", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("code = %d, body %s", recorder.Code, recorder.Body)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal %s: %v", recorder.Body, err)
+	}
+	want := map[string]interface{}{
+		"mode":                "enforce",
+		"whiteListRegistries": []interface{}{"docker.io", "*.example.com"},
+		"denyListRegistries":  []interface{}{"legacy.example.com"},
+		"defaultAction":       DefaultActionDeny,
+		"failOpen":            true,
+		"mutatePolicy":        MutatePolicyOptIn,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("config = %v, want %v", got, want)
+	}
+
+	recorder = httptest.NewRecorder()
+	s.ConfigHandler(recorder, httptest.NewRequest(http.MethodPost, "/config", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST code = %d, want %d", recorder.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestConfigSummaryString(t *testing.T) {
+	s := newTestServer(t, "docker.io", "*.example.com")
+	s.DenyListRegistries = []string{"legacy.example.com"}
+	s.MutatePolicy = MutatePolicyOptIn
+	s.MutateExemptNamespaces = []string{"kube-system"}
+	s.PrivilegedExemptNamespaces = []string{"monitoring"}
+	s.Endpoints = []string{"/validate", "/mutate", "/metrics"}
+	summary := s.Summary().String()
+	for _, want := range []string{
+		"mode=enforce",
+		"whitelist=[docker.io *.example.com]",
+		"denylist=[legacy.example.com]",
+		"defaultAction=deny",
+		"failOpen=false",
+		"mutatePolicy=opt-in",
+		"exemptNamespaces=map[mutate:[kube-system] privileged:[monitoring]]",
+		"endpoints=[/validate /mutate /metrics]",
+	} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("summary %q does not contain %q", summary, want)
+		}
+	}
+
+	// 没有配置豁免的命名空间时不输出空的 map
+	if exempt := newTestServer(t).Summary().ExemptNamespaces; exempt != nil {
+		t.Errorf("exempt namespaces = %v, want nil", exempt)
+	}
+}
@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Scanner 查询镜像的安全扫描信息
+type Scanner interface {
+	// LastScanTime 返回镜像最近一次被扫描的时间，从未被扫描过时返回零值
+	LastScanTime(ctx context.Context, image string) (time.Time, error)
+}
+
+// HTTPScanner 通过 HTTP 接口查询扫描信息：GET <Endpoint>?image=<image>，返回 {"lastScanTime": "<RFC3339>"}
+type HTTPScanner struct {
+	Endpoint string
+	Client   *http.Client
+}
+
+func (s *HTTPScanner) LastScanTime(ctx context.Context, image string) (time.Time, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return time.Time{}, err
+	}
+	query := u.Query()
+	query.Set("image", image)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp, err := s.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return time.Time{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, fmt.Errorf("scanner returned %s for %s", resp.Status, image)
+	}
+
+	var body struct {
+		LastScanTime time.Time `json:"lastScanTime"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return time.Time{}, err
+	}
+	return body.LastScanTime, nil
+}
+
+// checkScanFreshness 要求镜像在 ScanFreshnessWindow 时间内被扫描过
+func (s *WebhookServer) checkScanFreshness(image string) string {
+	if s.Scanner == nil || s.ScanFreshnessWindow <= 0 {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), registryLookupTimeout)
+	defer cancel()
+	scanned, err := s.Scanner.LastScanTime(ctx, image)
+	if err != nil {
+		return s.externalCheckFailed(fmt.Sprintf("failed to query scan result of image %s", image), err)
+	}
+	if scanned.IsZero() {
+		return fmt.Sprintf("%s image has never been scanned!", image)
+	}
+	if age := time.Since(scanned); age > s.ScanFreshnessWindow {
+		return fmt.Sprintf("%s image was last scanned %s ago, which exceeds the freshness window %s!", image, age.Round(time.Second), s.ScanFreshnessWindow)
+	}
+	return ""
+}
@@ -0,0 +1,51 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const CACertKey = "ca.crt"
+
+// SaveCertSecret 将证书保存到 kubernetes.io/tls 类型的 Secret 中，已存在时更新
+func SaveCertSecret(ctx context.Context, clientset kubernetes.Interface, namespace, name string, certPEM, keyPEM, caPEM []byte) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       certPEM,
+			corev1.TLSPrivateKeyKey: keyPEM,
+			CACertKey:               caPEM,
+		},
+	}
+
+	secretClient := clientset.CoreV1().Secrets(namespace)
+	if _, err := secretClient.Get(ctx, name, metav1.GetOptions{}); err != nil {
+		if !errors.IsNotFound(err) {
+			return secretError(namespace, name, err)
+		}
+		if _, err := secretClient.Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+			return secretError(namespace, name, err)
+		}
+		return nil
+	}
+	if _, err := secretClient.Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		return secretError(namespace, name, err)
+	}
+	return nil
+}
+
+func secretError(namespace, name string, err error) error {
+	if errors.IsForbidden(err) {
+		return fmt.Errorf("forbidden to access secret %s/%s, make sure the service account has RBAC permissions on secrets in namespace %s: %v", namespace, name, namespace, err)
+	}
+	return fmt.Errorf("access secret %s/%s: %v", namespace, name, err)
+}
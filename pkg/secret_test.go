@@ -0,0 +1,66 @@
+package pkg
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestSaveCertSecret(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset()
+	if err := SaveCertSecret(ctx, clientset, "cert-system", "admission-registry-tls", []byte("cert-1"), []byte("key-1"), []byte("ca-1")); err != nil {
+		t.Fatalf("save secret: %v", err)
+	}
+	// Secret 创建在配置的命名空间中，而不是 default
+	secret, err := clientset.CoreV1().Secrets("cert-system").Get(ctx, "admission-registry-tls", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get secret: %v", err)
+	}
+	if secret.Type != corev1.SecretTypeTLS {
+		t.Errorf("type = %s, want %s", secret.Type, corev1.SecretTypeTLS)
+	}
+	if string(secret.Data[corev1.TLSCertKey]) != "cert-1" || string(secret.Data[corev1.TLSPrivateKeyKey]) != "key-1" || string(secret.Data[CACertKey]) != "ca-1" {
+		t.Errorf("data = %v, want the saved cert, key and ca", secret.Data)
+	}
+	if _, err := clientset.CoreV1().Secrets("default").Get(ctx, "admission-registry-tls", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("get secret in default: %v, want not found", err)
+	}
+
+	// 已存在时更新
+	if err := SaveCertSecret(ctx, clientset, "cert-system", "admission-registry-tls", []byte("cert-2"), []byte("key-2"), []byte("ca-2")); err != nil {
+		t.Fatalf("save secret again: %v", err)
+	}
+	secret, err = clientset.CoreV1().Secrets("cert-system").Get(ctx, "admission-registry-tls", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get secret: %v", err)
+	}
+	if string(secret.Data[corev1.TLSCertKey]) != "cert-2" {
+		t.Errorf("cert = %s, want the updated cert", secret.Data[corev1.TLSCertKey])
+	}
+}
+
+func TestSaveCertSecretForbidden(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("get", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewForbidden(schema.GroupResource{Resource: "secrets"}, "admission-registry-tls", nil)
+	})
+	err := SaveCertSecret(context.Background(), clientset, "cert-system", "admission-registry-tls", []byte("cert"), []byte("key"), []byte("ca"))
+	if err == nil {
+		t.Fatalf("save secret succeeded, want a forbidden error")
+	}
+	// 跨命名空间没有权限时提示检查 RBAC
+	for _, want := range []string{"cert-system/admission-registry-tls", "RBAC", "namespace cert-system"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("err = %v, want it to contain %q", err, want)
+		}
+	}
+}
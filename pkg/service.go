@@ -0,0 +1,54 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// checkService 禁止受限命名空间中的 Service 使用 DisallowedServiceTypes 中的类型，比如 NodePort、LoadBalancer
+func (s *WebhookServer) checkService(namespace string, service *corev1.Service) string {
+	if !containsString(s.RestrictedServiceNamespaces, namespace) {
+		return ""
+	}
+	if containsString(s.DisallowedServiceTypes, string(service.Spec.Type)) {
+		return fmt.Sprintf("Service %s uses type %s which is not allowed in namespace %s! Disallowed service types: %v", service.Name, service.Spec.Type, namespace, s.DisallowedServiceTypes)
+	}
+	return ""
+}
+
+func (s *WebhookServer) validateService(req *admissionv1.AdmissionRequest, mode EnforcementMode) *admissionv1.AdmissionResponse {
+	var service corev1.Service
+	if err := json.Unmarshal(req.Object.Raw, &service); err != nil {
+		klog.Errorf("Can't unmarshal object raw: %v", err)
+		s.recordDecodeFailure(req.Kind.Kind, "/validate")
+		return &admissionv1.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		}
+	}
+
+	var warnings []string
+	if msg := s.checkService(req.Namespace, &service); msg != "" {
+		if mode == ModeWarn {
+			warnings = append(warnings, msg)
+		} else {
+			return forbidden(req, msg, nil, nil)
+		}
+	}
+	return &admissionv1.AdmissionResponse{
+		Allowed:  true,
+		Warnings: warnings,
+		Result: &metav1.Status{
+			Code: http.StatusOK,
+		},
+	}
+}
@@ -0,0 +1,199 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"sync"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+)
+
+// TagDigestStore 记录镜像 tag 第一次出现时指向的 digest
+type TagDigestStore interface {
+	// Get 返回镜像 tag 记录的 digest，没有记录时 ok 为 false
+	Get(ctx context.Context, image string) (digest string, ok bool, err error)
+	Put(ctx context.Context, image, digest string) error
+}
+
+// MemoryTagDigestStore 保存在内存中的 TagDigestStore，进程重启后记录会丢失
+type MemoryTagDigestStore struct {
+	mu      sync.RWMutex
+	digests map[string]string
+}
+
+func NewMemoryTagDigestStore() *MemoryTagDigestStore {
+	return &MemoryTagDigestStore{digests: map[string]string{}}
+}
+
+func (m *MemoryTagDigestStore) Get(ctx context.Context, image string) (string, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	digest, ok := m.digests[image]
+	return digest, ok, nil
+}
+
+func (m *MemoryTagDigestStore) Put(ctx context.Context, image, digest string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.digests[image] = digest
+	return nil
+}
+
+// ConfigMapTagDigestStore 将记录保存在 ConfigMap 中，多个副本之间共享
+// ConfigMap 的 key 不能包含 / 和 :，所以使用镜像名称的 sha256 作为 key
+type ConfigMapTagDigestStore struct {
+	Clientset  kubernetes.Interface
+	Namespace  string
+	Name       string
+	MaxEntries int // 最多保存的记录数，为 0 时使用 DefaultTagDigestMaxEntries
+}
+
+// DefaultTagDigestMaxEntries 每条记录约 140 字节（64 字节的 key 加上 sha256 digest），
+// 5000 条记录远小于 ConfigMap 1MiB 的上限
+const DefaultTagDigestMaxEntries = 5000
+
+// ErrTagDigestStoreFull 记录数已经达到上限
+var ErrTagDigestStoreFull = stderrors.New("tag digest store is full")
+
+func tagDigestKey(image string) string {
+	sum := sha256.Sum256([]byte(image))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *ConfigMapTagDigestStore) Get(ctx context.Context, image string) (string, bool, error) {
+	configMap, err := c.Clientset.CoreV1().ConfigMaps(c.Namespace).Get(ctx, c.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("get configmap %s/%s: %v", c.Namespace, c.Name, err)
+	}
+	digest, ok := configMap.Data[tagDigestKey(image)]
+	return digest, ok, nil
+}
+
+// Put 和其他副本并发更新 ConfigMap 时会在冲突后重试；ConfigMap 大小上限为 1MiB，
+// 记录数达到 MaxEntries 之后不再记录新的 tag
+func (c *ConfigMapTagDigestStore) Put(ctx context.Context, image, digest string) error {
+	maxEntries := c.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = DefaultTagDigestMaxEntries
+	}
+	key := tagDigestKey(image)
+	configMapClient := c.Clientset.CoreV1().ConfigMaps(c.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := configMapClient.Get(ctx, c.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: c.Name, Namespace: c.Namespace},
+				Data:       map[string]string{key: digest},
+			}
+			_, err = configMapClient.Create(ctx, configMap, metav1.CreateOptions{})
+			if errors.IsAlreadyExists(err) {
+				// 其他副本刚刚创建了 ConfigMap，按照冲突处理重新读取
+				return errors.NewConflict(corev1.Resource("configmaps"), c.Name, err)
+			}
+			if err != nil {
+				return fmt.Errorf("create configmap %s/%s: %v", c.Namespace, c.Name, err)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("get configmap %s/%s: %v", c.Namespace, c.Name, err)
+		}
+		if _, ok := configMap.Data[key]; ok {
+			// 其他副本已经记录了这个 tag，保留第一次记录的 digest
+			return nil
+		}
+		if len(configMap.Data) >= maxEntries {
+			return fmt.Errorf("configmap %s/%s already holds %d tag digests: %w", c.Namespace, c.Name, len(configMap.Data), ErrTagDigestStoreFull)
+		}
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		configMap.Data[key] = digest
+		_, err = configMapClient.Update(ctx, configMap, metav1.UpdateOptions{})
+		if errors.IsConflict(err) {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("update configmap %s/%s: %v", c.Namespace, c.Name, err)
+		}
+		return nil
+	})
+}
+
+// recordTagDigests 记录被放行的对象中第一次出现的镜像 tag 指向的 digest，
+// 被拒绝的请求和 dry-run 请求由调用方跳过，不会写入 TagDigestStore
+func (s *WebhookServer) recordTagDigests(req *admissionv1.AdmissionRequest) {
+	if s.TagDigestStore == nil || s.RegistryClient == nil || req.Operation == admissionv1.Delete {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), registryLookupTimeout)
+	defer cancel()
+	for _, image := range objectImages(req.Object.Raw) {
+		ref := parseImageReference(image)
+		if ref.Digest != "" {
+			continue
+		}
+		name := ref.String()
+		if _, ok, err := s.TagDigestStore.Get(ctx, name); err != nil || ok {
+			continue
+		}
+		digest, err := s.RegistryClient.ManifestDigest(ctx, image)
+		if err != nil {
+			klog.Errorf("Failed to resolve digest of image %s: %v", name, err)
+			continue
+		}
+		if err := s.TagDigestStore.Put(ctx, name, digest); err != nil {
+			klog.Errorf("Failed to record digest %s of image %s: %v", digest, name, err)
+		}
+	}
+}
+
+// objectImages 返回对象中所有 containers、initContainers 和 ephemeralContainers 使用的镜像，
+// 不需要关心对象的类型，Pod、各种工作负载以及 CronJob 的 Pod 模板都会被找到
+func objectImages(raw []byte) []string {
+	var obj interface{}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil
+	}
+	var images []string
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, value := range v {
+				if key == "containers" || key == "initContainers" || key == "ephemeralContainers" {
+					if containers, ok := value.([]interface{}); ok {
+						for _, c := range containers {
+							if container, ok := c.(map[string]interface{}); ok {
+								if image, ok := container["image"].(string); ok && image != "" {
+									images = append(images, image)
+								}
+							}
+						}
+						continue
+					}
+				}
+				walk(value)
+			}
+		case []interface{}:
+			for _, value := range v {
+				walk(value)
+			}
+		}
+	}
+	walk(obj)
+	return images
+}
@@ -0,0 +1,147 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const (
+	digestA = "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	digestB = "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+)
+
+func TestTagDrift(t *testing.T) {
+	client := &fakeRegistryClient{digest: digestA}
+	store := NewMemoryTagDigestStore()
+	s := newTestServer(t, "docker.io")
+	s.RegistryClient = client
+	s.TagDigestStore = store
+	admit := func(image string, dryRun bool) bool {
+		ar := newAdmissionReview(t, "Pod", newPod(image, nil))
+		ar.Request.DryRun = &dryRun
+		return s.admit("/validate", ar).Allowed
+	}
+	recorded := func(image string) (string, bool) {
+		digest, ok, _ := store.Get(context.Background(), parseImageReference(image).String())
+		return digest, ok
+	}
+
+	// dry-run 请求不会记录 digest
+	if !admit("docker.io/nginx:1.19", true) {
+		t.Fatalf("dry-run request denied")
+	}
+	if _, ok := recorded("docker.io/nginx:1.19"); ok {
+		t.Fatalf("digest recorded for a dry-run request")
+	}
+
+	// 被拒绝的镜像不会记录 digest，也不会访问镜像仓库
+	client.calls = nil
+	if admit("evil.example.com/nginx:1.19", false) {
+		t.Fatalf("untrusted image allowed")
+	}
+	if _, ok := recorded("evil.example.com/nginx:1.19"); ok || len(client.calls) != 0 {
+		t.Fatalf("untrusted image recorded %v, registry lookups %v", ok, client.calls)
+	}
+
+	// 第一次放行时记录 digest，之后 digest 不变时继续放行
+	if !admit("docker.io/nginx:1.19", false) {
+		t.Fatalf("first admission denied")
+	}
+	if digest, ok := recorded("docker.io/nginx:1.19"); !ok || digest != digestA {
+		t.Fatalf("recorded digest = %q, %v, want %s", digest, ok, digestA)
+	}
+	if !admit("docker.io/nginx:1.19", false) {
+		t.Fatalf("admission with the same digest denied")
+	}
+
+	// tag 被覆盖之后拒绝，并且不会覆盖第一次的记录
+	client.digest = digestB
+	if admit("docker.io/nginx:1.19", false) {
+		t.Fatalf("admission after the tag moved allowed")
+	}
+	if digest, _ := recorded("docker.io/nginx:1.19"); digest != digestA {
+		t.Errorf("recorded digest = %q after drift, want %s", digest, digestA)
+	}
+	// 其他 tag 不受影响，使用 digest 引用的镜像不检查
+	if !admit("docker.io/nginx:1.20", false) {
+		t.Errorf("new tag denied")
+	}
+	if !admit("docker.io/nginx@"+digestA, false) {
+		t.Errorf("digest reference denied")
+	}
+}
+
+func TestObjectImages(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{{Name: "init", Image: "busybox"}},
+					Containers:     []corev1.Container{{Name: "app", Image: "nginx"}, {Name: "sidecar", Image: "envoy"}},
+				},
+			},
+		},
+	}
+	ar := newAdmissionReview(t, "Deployment", deployment)
+	images := objectImages(ar.Request.Object.Raw)
+	sort.Strings(images)
+	if want := []string{"busybox", "envoy", "nginx"}; !reflect.DeepEqual(images, want) {
+		t.Errorf("images = %v, want %v", images, want)
+	}
+}
+
+func TestConfigMapTagDigestStore(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset()
+	store := &ConfigMapTagDigestStore{Clientset: clientset, Namespace: "default", Name: "tag-digests", MaxEntries: 2}
+
+	if _, ok, err := store.Get(ctx, "docker.io/nginx:1.19"); ok || err != nil {
+		t.Fatalf("get without configmap = %v, %v, want not found", ok, err)
+	}
+	if err := store.Put(ctx, "docker.io/nginx:1.19", digestA); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	// 已经记录过的 tag 保留第一次的 digest
+	if err := store.Put(ctx, "docker.io/nginx:1.19", digestB); err != nil {
+		t.Fatalf("put again: %v", err)
+	}
+	if digest, ok, err := store.Get(ctx, "docker.io/nginx:1.19"); !ok || err != nil || digest != digestA {
+		t.Fatalf("get = %q, %v, %v, want %s", digest, ok, err, digestA)
+	}
+
+	// Update 冲突时重新读取后重试
+	conflicts := 0
+	clientset.PrependReactor("update", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts == 0 {
+			conflicts++
+			return true, nil, apierrors.NewConflict(corev1.Resource("configmaps"), "tag-digests", errors.New("modified"))
+		}
+		return false, nil, nil
+	})
+	if err := store.Put(ctx, "docker.io/nginx:1.20", digestB); err != nil {
+		t.Fatalf("put with conflict: %v", err)
+	}
+	if digest, ok, _ := store.Get(ctx, "docker.io/nginx:1.20"); conflicts != 1 || !ok || digest != digestB {
+		t.Fatalf("get after conflict = %q, %v, conflicts %d", digest, ok, conflicts)
+	}
+
+	// 记录数达到上限后不再记录新的 tag
+	if err := store.Put(ctx, "docker.io/nginx:1.21", digestA); !errors.Is(err, ErrTagDigestStoreFull) {
+		t.Fatalf("put over the limit err = %v, want ErrTagDigestStoreFull", err)
+	}
+	if _, ok, _ := store.Get(ctx, "docker.io/nginx:1.21"); ok {
+		t.Errorf("digest recorded over the limit")
+	}
+}
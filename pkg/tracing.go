@@ -0,0 +1,77 @@
+package pkg
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+const tracerName = "github.com/cnych/admission-registry"
+
+// InitTracing 根据 OTEL_EXPORTER_OTLP_ENDPOINT 环境变量配置 OTLP trace exporter
+// 没有配置 endpoint 时保持 otel 默认的 no-op TracerProvider，返回的 shutdown 函数用于退出前刷新 span
+func InitTracing(ctx context.Context) (func(context.Context) error, error) {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlpgrpc.Option{otlpgrpc.WithEndpoint(endpoint)}
+	if os.Getenv("OTEL_EXPORTER_OTLP_INSECURE") == "true" {
+		opts = append(opts, otlpgrpc.WithInsecure())
+	}
+	exporter, err := otlp.NewExporter(ctx, otlpgrpc.NewDriver(opts...))
+	if err != nil {
+		return nil, err
+	}
+
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "admission-registry"
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(sdkresource.NewWithAttributes(semconv.ServiceNameKey.String(serviceName))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return tp.Shutdown, nil
+}
+
+func traceAdmissionRequest(span trace.Span, req *admissionv1.AdmissionRequest) {
+	if req == nil {
+		return
+	}
+	span.SetAttributes(
+		label.String("admission.kind", req.Kind.Kind),
+		label.String("admission.namespace", req.Namespace),
+		label.String("admission.name", req.Name),
+		label.String("admission.operation", string(req.Operation)),
+		label.String("admission.uid", string(req.UID)),
+	)
+}
+
+func traceAdmissionResponse(span trace.Span, resp *admissionv1.AdmissionResponse) {
+	if resp == nil {
+		return
+	}
+	decision := "deny"
+	if resp.Allowed {
+		decision = "allow"
+	}
+	span.SetAttributes(
+		label.String("admission.decision", decision),
+		label.Bool("admission.patched", len(resp.Patch) > 0),
+	)
+}
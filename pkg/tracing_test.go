@@ -0,0 +1,63 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/export/trace/tracetest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestServeTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	})
+
+	s := newTestServer(t, "docker.io")
+	body, _ := json.Marshal(newAdmissionReview(t, "Pod", newPod("gcr.io/google-containers/pause:3.2", nil)))
+	request := httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(string(body)))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	s.ServeValidate(httptest.NewRecorder(), request)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Name != "admission /validate" || span.SpanKind != trace.SpanKindServer {
+		t.Errorf("span = %s %v, want admission /validate server span", span.Name, span.SpanKind)
+	}
+	if traceID := span.SpanContext.TraceID.String(); traceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace id = %s, want the propagated trace id", traceID)
+	}
+	if parent := span.ParentSpanID.String(); parent != "00f067aa0ba902b7" {
+		t.Errorf("parent span id = %s, want the propagated span id", parent)
+	}
+	attributes := map[string]string{}
+	for _, kv := range span.Attributes {
+		attributes[string(kv.Key)] = kv.Value.Emit()
+	}
+	for key, want := range map[string]string{
+		"admission.kind":      "Pod",
+		"admission.namespace": "default",
+		"admission.operation": "CREATE",
+		"admission.decision":  "deny",
+		"admission.patched":   "false",
+	} {
+		if attributes[key] != want {
+			t.Errorf("attribute %s = %q, want %q", key, attributes[key], want)
+		}
+	}
+}
@@ -1,39 +0,0 @@
-package pkg
-
-import (
-	"os"
-
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-)
-
-func WriteFile(filePath string, bts []byte) error {
-	f, err := os.Create(filePath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	if _, err := f.Write(bts); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func InitKubernetesCli() (*kubernetes.Clientset, error) {
-	var (
-		err    error
-		config *rest.Config
-	)
-	if config, err = rest.InClusterConfig(); err != nil {
-		return nil, err
-	}
-
-	// 创建ClientSet 对象
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, err
-	}
-	return clientset, nil
-}
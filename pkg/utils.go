@@ -0,0 +1,176 @@
+package pkg
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// WriteFile 创建（或者覆盖）文件并写入内容
+func WriteFile(filePath string, bts []byte) error {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(bts); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InitKubernetesCli 优先使用 InClusterConfig 创建 ClientSet，不在集群中运行时使用 KUBECONFIG 或者 ~/.kube/config
+func InitKubernetesCli() (*kubernetes.Clientset, error) {
+	config, err := rest.InClusterConfig()
+	if err == rest.ErrNotInCluster {
+		kubeconfig := defaultKubeconfig()
+		if kubeconfig == "" {
+			return nil, err
+		}
+		if config, err = clientcmd.BuildConfigFromFlags("", kubeconfig); err != nil {
+			return nil, fmt.Errorf("not running in cluster, load kubeconfig %s: %v", kubeconfig, err)
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if err := applyClientOptions(config); err != nil {
+		return nil, err
+	}
+
+	// 创建ClientSet 对象
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return clientset, nil
+}
+
+// defaultKubeconfig 返回 KUBECONFIG 环境变量中的第一个文件，没有设置时使用存在的 ~/.kube/config
+func defaultKubeconfig() string {
+	if kubeconfig := os.Getenv("KUBECONFIG"); kubeconfig != "" {
+		return filepath.SplitList(kubeconfig)[0]
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	kubeconfig := filepath.Join(home, ".kube", "config")
+	if _, err := os.Stat(kubeconfig); err != nil {
+		return ""
+	}
+	return kubeconfig
+}
+
+// InitKubernetesCliFromKubeconfig 使用 kubeconfig 文件在集群外创建 ClientSet，kubeconfig 为空时使用 InClusterConfig
+func InitKubernetesCliFromKubeconfig(kubeconfig string) (*kubernetes.Clientset, error) {
+	if kubeconfig == "" {
+		return InitKubernetesCli()
+	}
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyClientOptions(config); err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// SplitList 解析逗号分隔的配置，忽略空白项
+func SplitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func containsString(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseKeyValues 解析形如 key1=value1,key2=value2 的配置
+func ParseKeyValues(s string) map[string]string {
+	kvs := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		kvs[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return kvs
+}
+
+// LoadX509KeyPair 加载 webhook server 使用的证书，证书可以来自文件，也可以来自 base64 编码的 PEM 内容
+// 两种方式只能同时使用一种
+func LoadX509KeyPair(certFile, keyFile, certPEMBase64, keyPEMBase64 string) (tls.Certificate, error) {
+	if certPEMBase64 == "" && keyPEMBase64 == "" {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+	if certFile != "" || keyFile != "" {
+		return tls.Certificate{}, fmt.Errorf("tls cert/key files and PEM contents are mutually exclusive")
+	}
+	if certPEMBase64 == "" || keyPEMBase64 == "" {
+		return tls.Certificate{}, fmt.Errorf("both tls cert and key PEM contents must be provided")
+	}
+
+	certPEM, err := base64.StdEncoding.DecodeString(certPEMBase64)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("decode tls cert PEM: %v", err)
+	}
+	keyPEM, err := base64.StdEncoding.DecodeString(keyPEMBase64)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("decode tls key PEM: %v", err)
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// applyClientOptions 根据环境变量设置客户端的超时时间和限流参数，避免集群异常时请求一直阻塞
+// KUBE_CLIENT_TIMEOUT（比如 30s）、KUBE_CLIENT_QPS、KUBE_CLIENT_BURST
+func applyClientOptions(config *rest.Config) error {
+	if v := os.Getenv("KUBE_CLIENT_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid KUBE_CLIENT_TIMEOUT %q: %v", v, err)
+		}
+		config.Timeout = timeout
+	}
+	if v := os.Getenv("KUBE_CLIENT_QPS"); v != "" {
+		qps, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return fmt.Errorf("invalid KUBE_CLIENT_QPS %q: %v", v, err)
+		}
+		config.QPS = float32(qps)
+	}
+	if v := os.Getenv("KUBE_CLIENT_BURST"); v != "" {
+		burst, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid KUBE_CLIENT_BURST %q: %v", v, err)
+		}
+		config.Burst = burst
+	}
+	return nil
+}
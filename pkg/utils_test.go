@@ -0,0 +1,192 @@
+package pkg
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+func TestLoadX509KeyPair(t *testing.T) {
+	bundle := newTestCertBundle(t, "admission-registry.default.svc")
+	certPEM := base64.StdEncoding.EncodeToString(bundle.CertPEM)
+	keyPEM := base64.StdEncoding.EncodeToString(bundle.KeyPEM)
+
+	dir, err := ioutil.TempDir("", "admission-registry-keypair")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	if err := ioutil.WriteFile(certFile, bundle.CertPEM, 0600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := ioutil.WriteFile(keyFile, bundle.KeyPEM, 0600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		certPEM  string
+		keyPEM   string
+		err      string
+	}{
+		{name: "from files", certFile: certFile, keyFile: keyFile},
+		{name: "from env PEM", certPEM: certPEM, keyPEM: keyPEM},
+		// 文件和 PEM 内容不能同时配置
+		{name: "files and PEM", certFile: certFile, keyFile: keyFile, certPEM: certPEM, keyPEM: keyPEM, err: "mutually exclusive"},
+		{name: "cert PEM only", certPEM: certPEM, err: "both tls cert and key PEM"},
+		{name: "key PEM only", keyPEM: keyPEM, err: "both tls cert and key PEM"},
+		{name: "invalid base64", certPEM: "not base64!", keyPEM: keyPEM, err: "decode tls cert PEM"},
+		{name: "key does not match", certPEM: certPEM, keyPEM: base64.StdEncoding.EncodeToString(newTestCertBundle(t, "other").KeyPEM), err: "private key does not match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, err := LoadX509KeyPair(tt.certFile, tt.keyFile, tt.certPEM, tt.keyPEM)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("load key pair: %v", err)
+			}
+			if len(cert.Certificate) != 1 || cert.PrivateKey == nil {
+				t.Errorf("certificate = %+v, want one certificate with a private key", cert)
+			}
+		})
+	}
+}
+
+func TestInitKubernetesCliFromKubeconfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admission-registry-kubeconfig")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "config")
+	kubeconfig := `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://127.0.0.1:6443
+users:
+- name: test
+  user:
+    token: test-token
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+`
+	if err := ioutil.WriteFile(path, []byte(kubeconfig), 0600); err != nil {
+		t.Fatalf("write kubeconfig: %v", err)
+	}
+	clientset, err := InitKubernetesCliFromKubeconfig(path)
+	if err != nil {
+		t.Fatalf("init client: %v", err)
+	}
+	if clientset == nil {
+		t.Fatalf("clientset = nil")
+	}
+	if _, err := InitKubernetesCliFromKubeconfig(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("init client from a missing kubeconfig succeeded, want error")
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admission-registry-utils")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "tls.crt")
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "create", content: "first content"},
+		// 覆盖时截断旧的内容，不会残留更长的旧数据
+		{name: "overwrite with shorter content", content: "second"},
+		{name: "empty", content: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := WriteFile(path, []byte(tt.content)); err != nil {
+				t.Fatalf("write file: %v", err)
+			}
+			got, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read file: %v", err)
+			}
+			if string(got) != tt.content {
+				t.Errorf("read back %q, want %q", got, tt.content)
+			}
+		})
+	}
+
+	if err := WriteFile(filepath.Join(dir, "missing", "tls.crt"), []byte("x")); err == nil {
+		t.Errorf("write into a missing directory succeeded, want error")
+	}
+}
+
+func TestApplyClientOptions(t *testing.T) {
+	setEnv(t, "KUBE_CLIENT_TIMEOUT", "15s")
+	setEnv(t, "KUBE_CLIENT_QPS", "25.5")
+	setEnv(t, "KUBE_CLIENT_BURST", "50")
+	config := &rest.Config{}
+	if err := applyClientOptions(config); err != nil {
+		t.Fatalf("apply client options: %v", err)
+	}
+	if config.Timeout != 15*time.Second || config.QPS != 25.5 || config.Burst != 50 {
+		t.Errorf("config timeout = %v, qps = %v, burst = %v, want 15s, 25.5, 50", config.Timeout, config.QPS, config.Burst)
+	}
+
+	for _, key := range []string{"KUBE_CLIENT_TIMEOUT", "KUBE_CLIENT_QPS", "KUBE_CLIENT_BURST"} {
+		t.Run("invalid "+key, func(t *testing.T) {
+			setEnv(t, key, "invalid")
+			if err := applyClientOptions(&rest.Config{}); err == nil || !strings.Contains(err.Error(), key) {
+				t.Errorf("err = %v, want an error naming %s", err, key)
+			}
+		})
+	}
+}
+
+func TestApplyClientOptionsDefaults(t *testing.T) {
+	for _, key := range []string{"KUBE_CLIENT_TIMEOUT", "KUBE_CLIENT_QPS", "KUBE_CLIENT_BURST"} {
+		setEnv(t, key, "")
+	}
+	// 没有配置时保留 client-go 的默认值
+	config := &rest.Config{QPS: 5, Burst: 10}
+	if err := applyClientOptions(config); err != nil {
+		t.Fatalf("apply client options: %v", err)
+	}
+	if config.Timeout != 0 || config.QPS != 5 || config.Burst != 10 {
+		t.Errorf("config timeout = %v, qps = %v, burst = %v, want the values unchanged", config.Timeout, config.QPS, config.Burst)
+	}
+}
+
+func setEnv(t *testing.T, key, value string) {
+	t.Helper()
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
@@ -1,19 +1,39 @@
 package pkg
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"regexp"
+	"runtime/debug"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 	admissionv1 "k8s.io/api/admission/v1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
 )
 
 var (
@@ -22,15 +42,113 @@ var (
 	deserializer  = codeFactory.UniversalDeserializer()
 )
 
+func init() {
+	// 同时支持 admission.k8s.io/v1 和 v1beta1，兼容较老版本的集群
+	utilruntime.Must(admissionv1.AddToScheme(runtimeScheme))
+	utilruntime.Must(admissionv1beta1.AddToScheme(runtimeScheme))
+}
+
+// decodeAdmissionReview 解析请求体中的 AdmissionReview，v1beta1 的请求会被转换为 v1 处理；
+// 两个版本的 JSON 结构相同，响应时回显请求的 apiVersion 即可
+func decodeAdmissionReview(body []byte, review *admissionv1.AdmissionReview) (*schema.GroupVersionKind, error) {
+	obj, gvk, err := deserializer.Decode(body, nil, review)
+	if err != nil {
+		return gvk, err
+	}
+	switch o := obj.(type) {
+	case *admissionv1.AdmissionReview:
+		if o != review {
+			*review = *o
+		}
+	case *admissionv1beta1.AdmissionReview:
+		review.TypeMeta = o.TypeMeta
+		review.Request = nil
+		if o.Request != nil {
+			data, err := json.Marshal(o.Request)
+			if err != nil {
+				return gvk, err
+			}
+			review.Request = &admissionv1.AdmissionRequest{}
+			if err := json.Unmarshal(data, review.Request); err != nil {
+				return gvk, err
+			}
+		}
+	default:
+		return gvk, fmt.Errorf("unsupported object %s, expect AdmissionReview", gvk)
+	}
+	return gvk, nil
+}
+
+// isConnectivityProbe 判断没有 request 字段的请求体是否为 apiserver 的探测请求：
+// 探测请求必须在请求体中显式声明 kind: AdmissionReview 以及支持的 apiVersion，只有 kind 的请求体当作无效请求处理
+func isConnectivityProbe(body []byte) bool {
+	var typeMeta metav1.TypeMeta
+	if err := json.Unmarshal(body, &typeMeta); err != nil || typeMeta.Kind != "AdmissionReview" {
+		return false
+	}
+	switch typeMeta.APIVersion {
+	case admissionv1.SchemeGroupVersion.String(), admissionv1beta1.SchemeGroupVersion.String():
+		return true
+	}
+	return false
+}
+
 const (
 	AnnotationMutateKey = "io.ydzs.admission-registry/mutate" // io.ydzs.admission-registry/mutate=no/off/false/n
 	AnnotationStatusKey = "io.ydzs.admission-registry/status" // io.ydzs.admission-registry/status=mutated
+	// io.ydzs.admission-registry/force-mutate=true，即使已经 mutated 也重新执行 mutate，执行后会移除该 annotation
+	AnnotationForceMutateKey = "io.ydzs.admission-registry/force-mutate"
+	AnnotationImageDigestKey = "io.ydzs.admission-registry/image-digests"
+	// io.ydzs.admission-registry/inject-sidecar=true，在 Pod 中注入 Sidecar 容器
+	AnnotationInjectSidecarKey = "io.ydzs.admission-registry/inject-sidecar"
+
+	// AuditAnnotationCorrelationID 写入 apiserver 审计日志的关联 ID，validate 和 mutate 对同一个请求使用相同的值
+	AuditAnnotationCorrelationID = "correlation-id"
+	// AuditAnnotationPolicyBypass 请求来自豁免的用户或者用户组，跳过了所有策略
+	AuditAnnotationPolicyBypass = "policy-bypass"
+	// AuditAnnotationDecision 准入决策：allow、deny，或者 warn（放行但是返回了警告）
+	AuditAnnotationDecision = "decision"
+	// AuditAnnotationReason 拒绝或者警告的原因
+	AuditAnnotationReason = "reason"
+	// AuditAnnotationMutated mutate 请求返回了 patch 时为 true
+	AuditAnnotationMutated = "mutated"
 )
 
+// DeletePolicyValidate 对 DELETE 请求使用 OldObject 执行校验
+const DeletePolicyValidate = "validate"
+
+// MutatePolicy 决定没有设置 AnnotationMutateKey 的对象是否需要 mutate
+const (
+	MutatePolicyOptOut = "opt-out" // 默认 mutate 所有对象，AnnotationMutateKey=n/no/false/off 时跳过
+	MutatePolicyOptIn  = "opt-in"  // 只 mutate AnnotationMutateKey=y/yes/true/on 的对象
+)
+
+const (
+	DefaultActionAllow = "allow"
+	DefaultActionDeny  = "deny"
+)
+
+// DefaultMaxRequestBytes 是默认的请求体大小限制，大于 apiserver 发送的 AdmissionReview 通常的大小
+const DefaultMaxRequestBytes = 3 << 20
+
 type WhSvrParam struct {
 	Port     int
 	CertFile string
 	KeyFile  string
+	FailOpen bool
+
+	EnableReload    bool
+	DefaultAction   string
+	AcceptYAML      bool
+	EnableRecent    bool
+	DecisionBuffer  int
+	WhiteListFile   string
+	LogFormat       string
+	MaxRequestBytes int64
+	ShutdownTimeout time.Duration
+	EnablePprof     bool
+	PprofAddr       string
+	ConfigFile      string
 }
 
 type patchOperation struct {
@@ -40,27 +158,226 @@ type patchOperation struct {
 }
 
 type WebhookServer struct {
-	Server              *http.Server // http server
-	WhiteListRegistries []string     // 白名单的镜像仓库列表
+	Server              *http.Server      // http server
+	ResponseHeaders     map[string]string // 添加到所有响应中的 header
+	WhiteListRegistries []string          // 白名单的镜像仓库列表
+	DenyListRegistries  []string          // 黑名单的镜像仓库列表，优先级高于白名单
+	RepositoryAllowlist []string          // 白名单仓库中允许使用的 repository 路径（glob），为空时不限制
+	DefaultAction       string            // 镜像没有匹配任何白名单条目时的处理方式：allow 或 deny（默认）
+	AcceptYAML          bool              // 是否接受 application/yaml 格式的请求体
+	FailOpen            bool              // 处理请求发生 panic 时是否放行（fail-open），默认拒绝（fail-closed）
+	RegistryMirrors     map[string]string // 镜像仓库到 mirror 的映射，白名单校验前先替换为 mirror 地址
+	RequiredTagPrefixes map[string]string // 命名空间到镜像 tag 前缀的映射，比如 prod=prod- 要求 prod 命名空间只能使用 prod-* tag
+
+	WhiteListLoader func() ([]string, error) // 重新加载白名单的数据源
+	ReloadToken     string                   // 调用 /reload 接口需要的 token
+	Endpoints       []string                 // 注册了的 HTTP 接口，只用于配置摘要
+
+	DenyInsecureRegistries   bool            // 是否拒绝来自 localhost、回环地址等不安全镜像仓库的镜像
+	InsecureRegistries       []string        // 额外配置的不安全镜像仓库列表
+	RequireVerifiedPublisher bool            // 是否要求镜像仓库在 VerifiedPublishers 中，在白名单之外额外校验
+	VerifiedPublishers       []string        // 经过认证的镜像仓库列表
+	DenyDockerHub            bool            // 是否禁止直接从 Docker Hub 拉取镜像
+	DockerHubAllowedImages   []string        // DenyDockerHub 开启时仍然允许的 Docker Hub 镜像
+	RequireDigest            bool            // 是否要求所有镜像都使用 digest 引用，和白名单同时生效
+	DigestRequirements       map[string]bool // 镜像仓库模式到是否要求 digest 引用的映射，比如 docker.io/*=true
+	RejectLatestTag          bool            // 是否拒绝 latest tag 以及没有指定 tag 和 digest 的镜像
+	AllowedTagPattern        *regexp.Regexp  // 镜像 tag 必须完整匹配的正则表达式，比如 v\d+\.\d+\.\d+，为空时不限制
+	DisallowedCapabilities   []string        // 容器禁止添加的 Linux capabilities，比如 NET_ADMIN、SYS_ADMIN
+	DeniedSecrets            []string        // 容器 env/envFrom 禁止引用的 Secret 名称
+
+	AllowedGroupRanges []IDRange // Pod fsGroup/supplementalGroups 允许使用的范围，为空时不限制
+
+	CriticalNamespaces     []string // 要求 Pod 设置 priorityClassName 的关键命名空间
+	AllowedPriorityClasses []string // 关键命名空间中允许使用的 priorityClassName，为空时不限制
+
+	MaxContainers    int // Pod 中容器数量的上限，为 0 时不限制
+	SidecarAllowance int // 在 MaxContainers 之外允许的 sidecar 容器数量
+
+	HostPortPolicy   string    // 容器 hostPort 的策略：deny 禁止所有 hostPort，allowlist 只允许 AllowedHostPorts，为空时不校验
+	AllowedHostPorts []IDRange // HostPortPolicy 为 allowlist 时允许使用的 hostPort 范围
+
+	DisallowedNodeSelectorKeys []string // Pod 禁止使用的 nodeSelector key
+	DisallowedTolerationKeys   []string // Pod 禁止容忍的污点 key
+
+	DenyHostNamespaces            bool     // 是否禁止 Pod 和工作负载的 Pod 模板使用 hostNetwork/hostPID/hostIPC
+	HostNamespaceExemptNamespaces []string // 允许使用宿主机命名空间的命名空间
+
+	DenyPrivileged             bool     // 是否拒绝特权容器以及允许提权的容器
+	PrivilegedExemptNamespaces []string // 不校验特权容器的命名空间
+
+	RequireProbes     bool // 是否要求容器至少设置 livenessProbe 或者 readinessProbe 中的一个
+	RequireBothProbes bool // RequireProbes 为 true 时，是否要求同时设置 livenessProbe 和 readinessProbe
+
+	MaxResourceRequests corev1.ResourceList // 容器的 CPU 和内存 requests 的上限，为空时不限制
+
+	RequiredLabels []string // 工作负载和 Service 必须带有的 label，比如 team、app
+
+	DenyDefaultServiceAccount             string   // 禁止使用 default ServiceAccount：always 总是拒绝，automount 挂载 token 时拒绝，为空时不校验
+	DefaultServiceAccountExemptNamespaces []string // 不校验 default ServiceAccount 的命名空间
+
+	RegistryClient      RegistryClient    // 查询镜像仓库元数据的客户端
+	RequireMultiArch    bool              // 是否要求镜像使用指向多架构 image index 的 digest
+	AttestationLookup   AttestationLookup // 查询镜像 attestation 的客户端
+	SBOMAttestationType string            // 镜像必须附带的 SBOM attestation 类型，为空时不校验
+	TagDigestStore      TagDigestStore    // 不为空时记录镜像 tag 第一次出现时的 digest，拒绝 digest 发生变化的 tag
+	RequiredImageLabels []string          // 镜像 config 中必须包含的 label，比如 org.opencontainers.image.source
+
+	Scanner             Scanner       // 查询镜像扫描结果
+	ScanFreshnessWindow time.Duration // 镜像最近一次扫描距今的最长时间，超过则拒绝
+
+	Clientset          kubernetes.Interface // 访问集群的客户端，部分校验需要查询集群中的资源
+	CheckResourceQuota bool                 // 是否检查 Deployment 的资源需求超出命名空间配额（只警告不拒绝）
+
+	RequireDeploymentLimits    bool  // 是否要求 Deployment 设置 revisionHistoryLimit 和 progressDeadlineSeconds
+	MaxRevisionHistoryLimit    int32 // revisionHistoryLimit 的上限
+	MaxProgressDeadlineSeconds int32 // progressDeadlineSeconds 的上限
+
+	RequireJobLimits            bool  // 是否要求 Job/CronJob 设置 backoffLimit 和 activeDeadlineSeconds
+	MaxJobBackoffLimit          int32 // backoffLimit 的上限
+	MaxJobActiveDeadlineSeconds int64 // activeDeadlineSeconds 的上限
+
+	RequiredReadinessGates  []string // Deployment 的 Pod 模板必须包含的 readinessGate conditionType
+	ReadinessGateNamespaces []string // 需要校验 readinessGate 的命名空间
+
+	RequirePodAntiAffinity    bool     // 是否要求多副本的 Deployment 配置 podAntiAffinity
+	PodAntiAffinityNamespaces []string // 需要校验 podAntiAffinity 的命名空间，为空时校验所有命名空间
+
+	ExemptUsers  []string // 豁免所有策略的用户名，比如 system:serviceaccount:kube-system:xxx
+	ExemptGroups []string // 豁免所有策略的用户组，比如 system:masters
+
+	DeletePolicy      string                                    // DELETE 请求的处理方式：validate 使用 OldObject 校验，默认直接放行
+	OperationModes    map[admissionv1.Operation]EnforcementMode // 不同操作（CREATE/UPDATE...）使用的校验模式
+	DefaultMode       EnforcementMode                           // 没有在 OperationModes 中配置的操作使用的校验模式，为空时为 enforce
+	SubResourcePolicy string                                    // 子资源请求的处理方式：skip（默认）或 validate
+
+	ServiceExternalTrafficPolicy corev1.ServiceExternalTrafficPolicyType // NodePort/LoadBalancer 类型的 Service 强制设置的 externalTrafficPolicy
+	ServiceSessionAffinity       corev1.ServiceAffinity                  // Service 没有设置 sessionAffinity 时使用的默认值
+	InjectImagePullPolicy        bool                                    // 是否为没有设置 imagePullPolicy 的容器注入默认值
+	DefaultImagePullPolicy       corev1.PullPolicy                       // 为没有设置 imagePullPolicy 的容器统一设置的值，比如 IfNotPresent，为空时不设置
+	DefaultResourceRequests      corev1.ResourceList                     // 为没有设置 resources.requests 的容器添加的默认 requests
+	Sidecar                      *corev1.Container                       // 注入到带有 AnnotationInjectSidecarKey 注解的 Pod 中的容器
+	EnforceRunAsNonRoot          bool                                    // 是否为没有设置 runAsNonRoot 的 Pod 设置 securityContext.runAsNonRoot=true
+	MutatePolicy                 string                                  // opt-out（默认）或 opt-in，见 MutatePolicyOptOut 和 MutatePolicyOptIn
+	EmptyAnnotationsOptOut       bool                                    // 显式设置为空的 annotations（annotations: {}）是否表示不需要 mutate
+	RemoveMutateTrigger          bool                                    // mutate 之后是否移除 AnnotationMutateKey 触发注解
+	AnnotatePodTemplate          bool                                    // 是否同时在工作负载的 Pod 模板上添加 mutate 状态注解
+	MutateKinds                  []string                                // 允许 mutate 的资源类型，比如 Deployment，为空时处理所有支持的类型
+	MutateExemptNamespaces       []string                                // 不执行 mutate 的命名空间，比如 kube-system
+	DefaultLabels                map[string]string                       // 为对象添加的默认 labels，对象上已经存在的 key 不会被覆盖
+	DisallowedAnnotations        []string                                // mutate 时从对象上移除的注解，这些注解不允许用户设置
+	ResolveImageDigests          string                                  // 解析 Pod 和工作负载镜像的 digest：annotate 记录到注解，pin 同时改写镜像，为空时不解析
+
+	RestrictedServiceNamespaces []string // 限制 Service 类型的命名空间
+	DisallowedServiceTypes      []string // 受限命名空间中禁止使用的 Service 类型，比如 NodePort、LoadBalancer
+
+	AllowedIngressDomains []string // Ingress host 允许使用的域名后缀，为空时不限制
+	RequireIngressTLS     bool     // 是否要求 Ingress 的每个 host 都配置 TLS
+
+	AuditLog *AuditLog // 记录每次 mutate 输出的 patch 的审计日志，为空时不记录
+
+	DecisionHooks   []DecisionHook       // 在核心决策之后执行的 hook
+	Publisher       Publisher            // 将每次的准入决策发送到消息队列，应该使用 AsyncPublisher 避免阻塞请求
+	RecentDecisions *DecisionRing        // 保存最近的准入决策，通过 /recent 或 /debug/decisions 查看
+	EventRecorder   record.EventRecorder // 不为空时在被拒绝的对象上记录 Warning Event
+
+	SlowThreshold   time.Duration // 处理时间超过这个值时在响应中添加 Warning，为 0 时不添加
+	MaxRequestBytes int64         // 请求体的最大字节数，超过时返回 413，为 0 时使用 DefaultMaxRequestBytes
+
+	WorkerPool     *WorkerPool   // 不为空时在 worker pool 中处理请求
+	WorkerDeadline time.Duration // 请求没有携带 timeout 参数时，在 worker pool 中处理的截止时间
+
+	Registerer prometheus.Registerer // 注册 webhook 指标的 registry，为空时使用 MetricsRegistry
+
+	whiteListMatchers   []*regexp.Regexp              // 和 WhiteListRegistries 一一对应，glob 和 regex: 条目编译后的匹配规则
+	namespaceWhiteLists map[string]namespaceWhiteList // 命名空间的白名单覆盖配置，通过 SetNamespaceWhiteLists 设置
+	mu                  sync.RWMutex
+	metrics             *metrics // 通过 serverMetrics 获取，第一次使用时注册到 Registerer 上
+	metricsOnce         sync.Once
+}
+
+// ServeValidate 处理 validating webhook 的请求，可以注册在任意路径上
+func (s *WebhookServer) ServeValidate(writer http.ResponseWriter, request *http.Request) {
+	s.serve("/validate", writer, request)
+}
+
+// ServeMutate 处理 mutating webhook 的请求，可以注册在任意路径上
+func (s *WebhookServer) ServeMutate(writer http.ResponseWriter, request *http.Request) {
+	s.serve("/mutate", writer, request)
 }
 
+// Handler 根据请求路径分发到 validate 或 mutate，只能注册在 /validate 和 /mutate 上
+//
+// Deprecated: 使用 ServeValidate 和 ServeMutate
 func (s *WebhookServer) Handler(writer http.ResponseWriter, request *http.Request) {
+	s.serve(request.URL.Path, writer, request)
+}
+
+// serve 解码请求中的 AdmissionReview，交给 path 对应的 validate 或 mutate 处理并写回响应，path 为 /validate 或 /mutate
+func (s *WebhookServer) serve(path string, writer http.ResponseWriter, request *http.Request) {
+	// 从请求头中提取上游的 trace context，每个准入请求对应一个 span
+	ctx := otel.GetTextMapPropagator().Extract(request.Context(), request.Header)
+	_, span := otel.Tracer(tracerName).Start(ctx, "admission "+path, trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	// 设置自定义的响应头，并回显请求的 X-Request-Id 方便关联 apiserver 和 webhook 的日志，没有携带时生成一个新的
+	for key, value := range s.ResponseHeaders {
+		writer.Header().Set(key, value)
+	}
+	requestID := request.Header.Get("X-Request-Id")
+	if requestID == "" {
+		requestID = string(uuid.NewUUID())
+	}
+	writer.Header().Set("X-Request-Id", requestID)
+
+	if request.Method != http.MethodPost {
+		klog.Errorf("Method %s is not allowed, expect POST requestID=%s", request.Method, requestID)
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
 	var body []byte
 	if request.Body != nil {
-		if data, err := ioutil.ReadAll(request.Body); err == nil {
+		// 限制请求体的大小，避免超大的请求耗尽内存
+		limit := s.MaxRequestBytes
+		if limit <= 0 {
+			limit = DefaultMaxRequestBytes
+		}
+		data, err := ioutil.ReadAll(http.MaxBytesReader(writer, request.Body, limit))
+		if err != nil && int64(len(data)) >= limit {
+			klog.Errorf("Request body exceeds %d bytes requestID=%s", limit, requestID)
+			http.Error(writer, fmt.Sprintf("request body too large, limit is %d bytes", limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err == nil {
 			body = data
 		}
 	}
 	if len(body) == 0 {
-		klog.Error("empty data body")
+		klog.Errorf("empty data body requestID=%s", requestID)
 		http.Error(writer, "empty data body", http.StatusBadRequest)
 		return
 	}
 
-	// 校验 content-type
-	contentType := request.Header.Get("Content-Type")
+	// 校验 content-type，允许携带 charset 等参数，比如 application/json; charset=utf-8
+	contentType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil {
+		klog.Errorf("Can't parse Content-Type %q: %v requestID=%s", request.Header.Get("Content-Type"), err, requestID)
+		http.Error(writer, "Content-Type invalid, expect application/json", http.StatusBadRequest)
+		return
+	}
+	if s.AcceptYAML && (contentType == "application/yaml" || contentType == "application/x-yaml") {
+		// 兼容提交 YAML 格式 AdmissionReview 的客户端，响应仍然使用 JSON
+		data, err := yaml.YAMLToJSON(body)
+		if err != nil {
+			klog.Errorf("Can't convert yaml body: %v requestID=%s", err, requestID)
+			http.Error(writer, fmt.Sprintf("invalid yaml body: %v", err), http.StatusBadRequest)
+			return
+		}
+		body, contentType = data, "application/json"
+	}
 	if contentType != "application/json" {
-		klog.Errorf("Content-Type is %s, but expect application/json", contentType)
+		klog.Errorf("Content-Type is %s, but expect application/json requestID=%s", contentType, requestID)
 		http.Error(writer, "Content-Type invalid, expect application/json", http.StatusBadRequest)
 		return
 	}
@@ -68,23 +385,67 @@ func (s *WebhookServer) Handler(writer http.ResponseWriter, request *http.Reques
 	// 数据序列化（validate、mutate）请求的数据都是 AdmissionReview
 	var admissionResponse *admissionv1.AdmissionResponse
 	requestedAdmissionReview := admissionv1.AdmissionReview{}
-	if _, _, err := deserializer.Decode(body, nil, &requestedAdmissionReview); err != nil {
-		klog.Errorf("Can't decode body: %v", err)
-		admissionResponse = &admissionv1.AdmissionResponse{
-			Result: &metav1.Status{
-				Code:    http.StatusInternalServerError,
-				Message: err.Error(),
-			},
+	if gvk, err := decodeAdmissionReview(body, &requestedAdmissionReview); err != nil {
+		klog.Errorf("Can't decode body: %v requestID=%s", err, requestID)
+		var kind string
+		if gvk != nil {
+			kind = gvk.Kind
 		}
+		s.recordDecodeFailure(kind, path)
+		http.Error(writer, fmt.Sprintf("request body is not an AdmissionReview: %v", err), http.StatusBadRequest)
+		return
+	}
+	// 序列化成功，也就是说获取到了请求的 AdmissionReview 的数据
+	traceAdmissionRequest(span, requestedAdmissionReview.Request)
+	if requestedAdmissionReview.Request == nil && !isConnectivityProbe(body) {
+		// 没有 request 字段的任意 JSON 也能解码成功，这种请求既不是准入请求也不是探测请求
+		klog.Errorf("Request body on %s is not an AdmissionReview requestID=%s", path, requestID)
+		s.recordDecodeFailure("", path)
+		http.Error(writer, "request body is not an AdmissionReview, the request field is missing", http.StatusBadRequest)
+		return
+	}
+	if requestedAdmissionReview.Request == nil {
+		// 完整声明了 apiVersion 和 kind 但是没有 Request 的 AdmissionReview 只是用来探测 endpoint 是否可用，直接放行
+		klog.V(4).Infof("Got connectivity probe on %s requestID=%s", path, requestID)
+		s.writeResponse(writer, &requestedAdmissionReview, &admissionv1.AdmissionResponse{Allowed: true})
+		return
+	}
+	correlate(requestedAdmissionReview.Request)
+	// validate 和 mutate 中的日志使用 UID，这里记录 requestID 和 UID 的对应关系
+	logInfoS("Admission request", "path", path, "requestID", requestID, "uid", string(requestedAdmissionReview.Request.UID))
+	start := time.Now()
+	if s.WorkerPool != nil {
+		admissionResponse = s.admitInPool(path, request, &requestedAdmissionReview)
 	} else {
-		// 序列化成功，也就是说获取到了请求的 AdmissionReview 的数据
-		if request.URL.Path == "/mutate" {
-			admissionResponse = s.mutate(&requestedAdmissionReview)
-		} else if request.URL.Path == "/validate" {
-			admissionResponse = s.validate(&requestedAdmissionReview)
+		admissionResponse = s.admit(path, &requestedAdmissionReview)
+	}
+	elapsed := time.Since(start)
+	// 处理时间超过阈值时通过 Warning 告知用户 webhook 响应较慢，
+	// 在记录日志和发布决策之前追加，保证记录下来的内容和返回给 apiserver 的一致
+	if s.SlowThreshold > 0 && elapsed > s.SlowThreshold && admissionResponse != nil {
+		admissionResponse.Warnings = append(admissionResponse.Warnings,
+			fmt.Sprintf("admission webhook %s took %s to respond (threshold %s)", path, elapsed.Round(time.Millisecond), s.SlowThreshold))
+	}
+	if admissionResponse != nil {
+		logDecision(path, requestID, requestedAdmissionReview.Request, admissionResponse, elapsed)
+	}
+	// dry-run 请求不会真正持久化对象，决策只记录日志，不发布到消息队列也不计入最近的决策
+	if admissionResponse != nil && !isDryRun(requestedAdmissionReview.Request) && (s.Publisher != nil || s.RecentDecisions != nil) {
+		record := newDecisionRecord(path, requestedAdmissionReview.Request, admissionResponse)
+		if s.Publisher != nil {
+			_ = s.Publisher.Publish(ctx, record)
+		}
+		if s.RecentDecisions != nil {
+			s.RecentDecisions.Add(record)
 		}
 	}
+	traceAdmissionResponse(span, admissionResponse)
 
+	s.writeResponse(writer, &requestedAdmissionReview, admissionResponse)
+}
+
+// writeResponse 构造返回的 AdmissionReview 并写入响应
+func (s *WebhookServer) writeResponse(writer http.ResponseWriter, requestedAdmissionReview *admissionv1.AdmissionReview, admissionResponse *admissionv1.AdmissionResponse) {
 	// 构造返回的 AdmissionReview 这个结构体
 	responseAdmissionReview := admissionv1.AdmissionReview{}
 	// admission/v1
@@ -98,7 +459,7 @@ func (s *WebhookServer) Handler(writer http.ResponseWriter, request *http.Reques
 
 	}
 
-	klog.Info(fmt.Sprintf("sending response: %v", responseAdmissionReview.Response))
+	klog.Info(fmt.Sprintf("sending response: %v requestID=%s", responseAdmissionReview.Response, writer.Header().Get("X-Request-Id")))
 	// send response
 	respBytes, err := json.Marshal(responseAdmissionReview)
 	if err != nil {
@@ -108,13 +469,177 @@ func (s *WebhookServer) Handler(writer http.ResponseWriter, request *http.Reques
 	}
 	klog.Info("Ready to write response...")
 
+	// 请求体是 YAML 时也使用 JSON 响应
+	writer.Header().Set("Content-Type", "application/json")
 	if _, err := writer.Write(respBytes); err != nil {
 		klog.Errorf("Can't write response: %v", err)
 		http.Error(writer, fmt.Sprintf("Can't write reponse: %v", err), http.StatusBadRequest)
 	}
 }
 
-func (s *WebhookServer) validate(ar *admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+// admit 根据请求路径分发到 validate 或 mutate，并将处理过程中的 panic 转换为结构化的 AdmissionResponse
+func (s *WebhookServer) admit(path string, ar *admissionv1.AdmissionReview) (resp *admissionv1.AdmissionResponse) {
+	defer func() {
+		if r := recover(); r != nil {
+			klog.Errorf("Recovered from panic while handling %s: %v\n%s", path, r, debug.Stack())
+			resp = s.panicResponse(r)
+		}
+	}()
+
+	if ar.Request != nil && ar.Request.SubResource != "" && !s.handlesSubResource(path, ar.Request) {
+		klog.Infof("Skip %s for subresource %s/%s of %s/%s", path, ar.Request.Resource.Resource, ar.Request.SubResource, ar.Request.Namespace, ar.Request.Name)
+		return &admissionv1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
+
+	if exempt, reason := s.exemptUser(ar.Request); exempt {
+		klog.Infof("Bypass %s for %s/%s: %s", path, ar.Request.Namespace, ar.Request.Name, reason)
+		resp = &admissionv1.AdmissionResponse{
+			Allowed:          true,
+			AuditAnnotations: map[string]string{AuditAnnotationPolicyBypass: reason},
+		}
+	} else if path == "/mutate" {
+		resp = s.mutate(ar)
+	} else if path == "/validate" {
+		resp = s.validate(ar)
+	}
+	if resp != nil && ar.Request != nil {
+		if resp.AuditAnnotations == nil {
+			resp.AuditAnnotations = map[string]string{}
+		}
+		resp.AuditAnnotations[AuditAnnotationCorrelationID] = string(ar.Request.UID)
+		annotateDecision(path, resp)
+		s.runDecisionHooks(path, ar.Request, resp)
+		// dry-run 请求只返回决策，不产生 Event 这样的副作用，与 webhook 声明的 sideEffects: NoneOnDryRun 保持一致
+		if path == "/validate" && !isDryRun(ar.Request) {
+			s.recordDenial(ar.Request, resp)
+			if resp.Allowed {
+				s.recordTagDigests(ar.Request)
+			}
+		}
+	}
+	return resp
+}
+
+// annotateDecision 把决策结果写入审计注解，方便在 apiserver 的审计日志中查看是哪条策略做出的决策
+func annotateDecision(path string, resp *admissionv1.AdmissionResponse) {
+	switch {
+	case !resp.Allowed:
+		resp.AuditAnnotations[AuditAnnotationDecision] = "deny"
+		if resp.Result != nil && resp.Result.Message != "" {
+			resp.AuditAnnotations[AuditAnnotationReason] = resp.Result.Message
+		}
+	case len(resp.Warnings) > 0:
+		resp.AuditAnnotations[AuditAnnotationDecision] = "warn"
+		resp.AuditAnnotations[AuditAnnotationReason] = strings.Join(resp.Warnings, "; ")
+	default:
+		resp.AuditAnnotations[AuditAnnotationDecision] = "allow"
+	}
+	if path == "/mutate" && len(resp.Patch) > 0 {
+		resp.AuditAnnotations[AuditAnnotationMutated] = "true"
+	}
+}
+
+func isDryRun(req *admissionv1.AdmissionRequest) bool {
+	return req.DryRun != nil && *req.DryRun
+}
+
+// correlate 使用请求的 UID 作为关联 ID：apiserver 调用同一个请求的所有 webhook 时 UID 相同，
+// 因此 validate 和 mutate 的日志可以通过它关联起来；UID 为空时（比如手工构造的请求）生成一个新的
+func correlate(req *admissionv1.AdmissionRequest) {
+	if req.UID == "" {
+		req.UID = uuid.NewUUID()
+	}
+}
+
+// exemptUser 判断请求的用户或者用户组是否在豁免列表中，比如 break-glass 账号、系统组件的 ServiceAccount
+func (s *WebhookServer) exemptUser(req *admissionv1.AdmissionRequest) (bool, string) {
+	if req == nil {
+		return false, ""
+	}
+	if containsString(s.ExemptUsers, req.UserInfo.Username) {
+		return true, fmt.Sprintf("user %s is exempt", req.UserInfo.Username)
+	}
+	for _, group := range req.UserInfo.Groups {
+		if containsString(s.ExemptGroups, group) {
+			return true, fmt.Sprintf("user %s in group %s is exempt", req.UserInfo.Username, group)
+		}
+	}
+	return false, ""
+}
+
+// handlesSubResource 判断子资源请求是否需要继续校验
+// 默认跳过所有子资源（比如 pods/exec、deployments/scale），它们携带的对象不是父资源本身；
+// SubResourcePolicy=validate 时，校验携带完整 Pod 对象的 pods/ephemeralcontainers
+func (s *WebhookServer) handlesSubResource(path string, req *admissionv1.AdmissionRequest) bool {
+	if s.SubResourcePolicy != "validate" || path != "/validate" {
+		return false
+	}
+	return req.Resource.Resource == "pods" && req.SubResource == "ephemeralcontainers"
+}
+
+// admitInPool 在 WorkerPool 中处理请求，截止时间来自 apiserver 请求 webhook 时携带的 timeout 参数，
+// 队列已满或者超时时按照 FailOpen 放行或者拒绝
+func (s *WebhookServer) admitInPool(path string, request *http.Request, ar *admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+	timeout := s.WorkerDeadline
+	if d, err := time.ParseDuration(request.URL.Query().Get("timeout")); err == nil && d > 0 {
+		// 预留一部分时间用于返回响应
+		timeout = d * 9 / 10
+	}
+	ctx := request.Context()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// 超时之后 worker 可能仍在使用 ar，这里传入一份拷贝
+	review := ar.DeepCopy()
+	resp, err := s.WorkerPool.Do(ctx, func() *admissionv1.AdmissionResponse {
+		return s.admit(path, review)
+	})
+	if err != nil {
+		klog.Errorf("Failed to process %s for UID %s in worker pool: %v", path, ar.Request.UID, err)
+		return s.failureResponse(fmt.Sprintf("admission request not processed: %v", err))
+	}
+	return resp
+}
+
+func (s *WebhookServer) panicResponse(r interface{}) *admissionv1.AdmissionResponse {
+	return s.failureResponse(fmt.Sprintf("internal error while processing admission request: %v", r))
+}
+
+// failureResponse 请求没有被正常处理时的响应，FailOpen 为 true 时放行并返回警告，否则拒绝
+func (s *WebhookServer) failureResponse(message string) *admissionv1.AdmissionResponse {
+	if s.FailOpen {
+		return &admissionv1.AdmissionResponse{
+			Allowed:  true,
+			Warnings: []string{message},
+		}
+	}
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Code:    http.StatusInternalServerError,
+			Message: message,
+		},
+	}
+}
+
+// emptyObjectResponse 在请求没有携带对象时返回 400，而不是把空的请求体当作一个空对象处理
+func (s *WebhookServer) emptyObjectResponse(req *admissionv1.AdmissionRequest, path string) *admissionv1.AdmissionResponse {
+	klog.Errorf("Request %s for %s %s/%s has an empty object", req.Operation, req.Kind.Kind, req.Namespace, req.Name)
+	s.recordDecodeFailure(req.Kind.Kind, path)
+	return &admissionv1.AdmissionResponse{
+		Result: &metav1.Status{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("%s request for %s %s/%s has no object to admit", req.Operation, req.Kind.Kind, req.Namespace, req.Name),
+		},
+	}
+}
+
+func (s *WebhookServer) validate(ar *admissionv1.AdmissionReview) (resp *admissionv1.AdmissionResponse) {
 	req := ar.Request
 	var (
 		allowed = true
@@ -122,12 +647,74 @@ func (s *WebhookServer) validate(ar *admissionv1.AdmissionReview) *admissionv1.A
 		message = ""
 	)
 
-	klog.Infof("AdmissionReview for Kind=%s, Namespace=%s Name=%s UID=%s",
-		req.Kind.Kind, req.Namespace, req.Name, req.UID)
+	logInfoS("AdmissionReview received", "path", "/validate", "kind", req.Kind.Kind,
+		"namespace", req.Namespace, "name", req.Name, "uid", string(req.UID), "operation", string(req.Operation))
+
+	mode := s.operationMode(req.Operation)
+	if mode == ModeOff {
+		klog.Infof("Validation is off for operation %s correlationID=%s", req.Operation, req.UID)
+		return &admissionv1.AdmissionResponse{
+			Allowed: true,
+			Result: &metav1.Status{
+				Code: http.StatusOK,
+			},
+		}
+	}
+
+	if req.Operation == admissionv1.Delete {
+		// 默认没有删除相关的策略，直接放行；DeletePolicy=validate 时使用 OldObject 校验被删除的对象
+		if s.DeletePolicy != DeletePolicyValidate {
+			return &admissionv1.AdmissionResponse{
+				Allowed: true,
+				Result: &metav1.Status{
+					Code: http.StatusOK,
+				},
+			}
+		}
+		if len(req.Object.Raw) == 0 {
+			deleted := *req
+			deleted.Object = req.OldObject
+			req = &deleted
+		}
+	}
+	if len(req.Object.Raw) == 0 {
+		return s.emptyObjectResponse(req, "/validate")
+	}
+
+	// 工作负载和 Service 必须带有 RequiredLabels 中的 label，warn 模式下把原因追加到最终响应的警告中
+	if msg := s.checkRequiredLabels(req); msg != "" {
+		if mode != ModeWarn {
+			return forbidden(req, msg, []metav1.StatusCause{{
+				Type:    metav1.CauseTypeFieldValueRequired,
+				Field:   "metadata.labels",
+				Message: msg,
+			}}, nil)
+		}
+		defer func() {
+			resp.Warnings = append(resp.Warnings, msg)
+		}()
+	}
+
+	if req.Kind.Kind == "Deployment" {
+		return s.validateDeployment(req, mode)
+	}
+	if req.Kind.Kind == "StatefulSet" || req.Kind.Kind == "DaemonSet" || req.Kind.Kind == "ReplicaSet" {
+		return s.validateWorkload(req, mode)
+	}
+	if req.Kind.Kind == "Ingress" {
+		return s.validateIngress(req, mode)
+	}
+	if req.Kind.Kind == "Job" || req.Kind.Kind == "CronJob" {
+		return s.validateJob(req, mode)
+	}
+	if req.Kind.Kind == "Service" {
+		return s.validateService(req, mode)
+	}
 
 	var pod corev1.Pod
 	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
 		klog.Errorf("Can't unmarshal object raw: %v", err)
+		s.recordDecodeFailure(req.Kind.Kind, "/validate")
 		allowed = false
 		code = http.StatusBadRequest
 		return &admissionv1.AdmissionResponse{
@@ -140,23 +727,21 @@ func (s *WebhookServer) validate(ar *admissionv1.AdmissionReview) *admissionv1.A
 	}
 
 	// 处理真正的业务逻辑
-	for _, container := range pod.Spec.Containers {
-		var whitelisted = false
-		for _, reg := range s.WhiteListRegistries {
-			if strings.HasPrefix(container.Image, reg) {
-				whitelisted = true
+	var warnings []string
+	if causes := s.podViolations(req.Namespace, &pod.Spec); len(causes) > 0 {
+		if mode == ModeWarn {
+			for _, cause := range causes {
+				warnings = append(warnings, cause.Message)
 			}
-		}
-		if !whitelisted {
-			allowed = false
-			code = http.StatusForbidden
-			message = fmt.Sprintf("%s image comes from an untrusted registry! Only images from %v are allowed.", container.Image, s.WhiteListRegistries)
-			break
+		} else {
+			// 每个违反策略的字段对应一个 cause，方便客户端按字段处理
+			return forbidden(req, causes[0].Message, causes, nil)
 		}
 	}
 
 	return &admissionv1.AdmissionResponse{
-		Allowed: allowed,
+		Allowed:  allowed,
+		Warnings: warnings,
 		Result: &metav1.Status{
 			Code:    int32(code),
 			Message: message,
@@ -164,43 +749,181 @@ func (s *WebhookServer) validate(ar *admissionv1.AdmissionReview) *admissionv1.A
 	}
 }
 
+// ValidatePod 使用与 validate 相同的逻辑校验一个已经存在的 Pod，用于生成 dry-run 报告
+func (s *WebhookServer) ValidatePod(pod *corev1.Pod) (*admissionv1.AdmissionResponse, error) {
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+	return s.validate(&admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			UID:       pod.UID,
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}), nil
+}
+
+func (s *WebhookServer) validateDeployment(req *admissionv1.AdmissionRequest, mode EnforcementMode) *admissionv1.AdmissionResponse {
+	var deployment appsv1.Deployment
+	if err := json.Unmarshal(req.Object.Raw, &deployment); err != nil {
+		klog.Errorf("Can't unmarshal object raw: %v", err)
+		s.recordDecodeFailure(req.Kind.Kind, "/validate")
+		return &admissionv1.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		}
+	}
+
+	var warnings []string
+	if s.CheckResourceQuota && s.Clientset != nil {
+		var oldDeployment *appsv1.Deployment
+		if len(req.OldObject.Raw) > 0 {
+			oldDeployment = &appsv1.Deployment{}
+			if err := json.Unmarshal(req.OldObject.Raw, oldDeployment); err != nil {
+				klog.Errorf("Can't unmarshal old object raw: %v", err)
+				s.recordDecodeFailure(req.Kind.Kind, "/validate")
+				oldDeployment = nil
+			}
+		}
+		warnings = append(warnings, s.quotaWarnings(req.Namespace, &deployment, oldDeployment)...)
+	}
+
+	if msg, causes := s.checkDeployment(req.Namespace, &deployment); msg != "" {
+		if mode == ModeWarn {
+			warnings = append(warnings, msg)
+		} else {
+			return forbidden(req, msg, causes, warnings)
+		}
+	}
+
+	return &admissionv1.AdmissionResponse{
+		Allowed:  true,
+		Warnings: warnings,
+		Result: &metav1.Status{
+			Code: http.StatusOK,
+		},
+	}
+}
+
+func (s *WebhookServer) validateIngress(req *admissionv1.AdmissionRequest, mode EnforcementMode) *admissionv1.AdmissionResponse {
+	var ingress networkingv1.Ingress
+	if err := json.Unmarshal(req.Object.Raw, &ingress); err != nil {
+		klog.Errorf("Can't unmarshal object raw: %v", err)
+		s.recordDecodeFailure(req.Kind.Kind, "/validate")
+		return &admissionv1.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		}
+	}
+
+	var warnings []string
+	if msg := s.checkIngress(&ingress); msg != "" {
+		if mode == ModeWarn {
+			warnings = append(warnings, msg)
+		} else {
+			return forbidden(req, msg, nil, nil)
+		}
+	}
+
+	return &admissionv1.AdmissionResponse{
+		Allowed:  true,
+		Warnings: warnings,
+		Result: &metav1.Status{
+			Code: http.StatusOK,
+		},
+	}
+}
+
 func (s *WebhookServer) mutate(ar *admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
-	// Deployment、Service -> annotations： AnnotationMutateKey， AnnotationStatusKey
+	// Pod、工作负载、Service -> annotations： AnnotationMutateKey， AnnotationStatusKey
 	req := ar.Request
 
 	var (
-		objectMeta *metav1.ObjectMeta
+		objectMeta  *metav1.ObjectMeta
+		specPatch   []patchOperation
+		annotations = map[string]string{
+			AnnotationStatusKey: "mutated",
+		}
+		warnings []string
 	)
 
-	klog.Infof("AdmissionReview for Kind=%s, Namespace=%s Name=%s UID=%s",
-		req.Kind.Kind, req.Namespace, req.Name, req.UID)
+	logInfoS("AdmissionReview received", "path", "/mutate", "kind", req.Kind.Kind,
+		"namespace", req.Namespace, "name", req.Name, "uid", string(req.UID), "operation", string(req.Operation))
 
+	// 只修改 MutateKinds 中的资源类型，即使 webhook 配置匹配到了其他类型
+	if len(s.MutateKinds) > 0 && !containsString(s.MutateKinds, req.Kind.Kind) {
+		klog.Infof("Mutation is disabled for kind %s", req.Kind.Kind)
+		return &admissionv1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
+
+	// 与 webhook 配置中的 namespaceSelector 一起，双重保证豁免的命名空间中的对象不会被修改
+	if containsString(s.MutateExemptNamespaces, req.Namespace) {
+		klog.Infof("Mutation is skipped for exempt namespace %s", req.Namespace)
+		return &admissionv1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
+
+	// 删除请求没有可以修改的对象
+	if req.Operation == admissionv1.Delete {
+		return &admissionv1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
+	if len(req.Object.Raw) == 0 {
+		return s.emptyObjectResponse(req, "/mutate")
+	}
+
+	// Pod 以及带有 Pod 模板的工作负载还会修改 Pod spec，templatePath 为空表示对象本身就是 Pod
+	var (
+		podSpec      *corev1.PodSpec
+		template     *corev1.PodTemplateSpec
+		templatePath string
+		err          error
+	)
 	switch req.Kind.Kind {
 	case "Deployment":
 		var deployment appsv1.Deployment
-		if err := json.Unmarshal(req.Object.Raw, &deployment); err != nil {
-			klog.Errorf("Can't not unmarshal raw object: %v", err)
-			return &admissionv1.AdmissionResponse{
-				Result: &metav1.Status{
-					Code:    http.StatusBadRequest,
-					Message: err.Error(),
-				},
-			}
-
-		}
-		objectMeta = &deployment.ObjectMeta
+		err = json.Unmarshal(req.Object.Raw, &deployment)
+		objectMeta, template, templatePath = &deployment.ObjectMeta, &deployment.Spec.Template, "/spec/template"
+	case "StatefulSet":
+		var statefulSet appsv1.StatefulSet
+		err = json.Unmarshal(req.Object.Raw, &statefulSet)
+		objectMeta, template, templatePath = &statefulSet.ObjectMeta, &statefulSet.Spec.Template, "/spec/template"
+	case "DaemonSet":
+		var daemonSet appsv1.DaemonSet
+		err = json.Unmarshal(req.Object.Raw, &daemonSet)
+		objectMeta, template, templatePath = &daemonSet.ObjectMeta, &daemonSet.Spec.Template, "/spec/template"
+	case "Job":
+		var job batchv1.Job
+		err = json.Unmarshal(req.Object.Raw, &job)
+		objectMeta, template, templatePath = &job.ObjectMeta, &job.Spec.Template, "/spec/template"
+	case "CronJob":
+		var cronJob batchv1beta1.CronJob
+		err = json.Unmarshal(req.Object.Raw, &cronJob)
+		objectMeta, template, templatePath = &cronJob.ObjectMeta, &cronJob.Spec.JobTemplate.Spec.Template, "/spec/jobTemplate/spec/template"
+	case "Pod":
+		var pod corev1.Pod
+		err = json.Unmarshal(req.Object.Raw, &pod)
+		objectMeta, podSpec = &pod.ObjectMeta, &pod.Spec
 	case "Service":
 		var service corev1.Service
-		if err := json.Unmarshal(req.Object.Raw, &service); err != nil {
-			klog.Errorf("Can't not unmarshal raw object: %v", err)
-			return &admissionv1.AdmissionResponse{
-				Result: &metav1.Status{
-					Code:    http.StatusBadRequest,
-					Message: err.Error(),
-				},
-			}
+		if err = json.Unmarshal(req.Object.Raw, &service); err == nil {
+			objectMeta = &service.ObjectMeta
+			specPatch = s.mutateService(&service)
 		}
-		objectMeta = &service.ObjectMeta
 	default:
 		return &admissionv1.AdmissionResponse{
 			Result: &metav1.Status{
@@ -209,22 +932,77 @@ func (s *WebhookServer) mutate(ar *admissionv1.AdmissionReview) *admissionv1.Adm
 			},
 		}
 	}
-
-	// 判断是否需要真的执行 mutate 操作
-	if !mutationRequired(objectMeta) {
+	if err != nil {
+		klog.Errorf("Can't not unmarshal raw object: %v", err)
+		s.recordDecodeFailure(req.Kind.Kind, "/mutate")
 		return &admissionv1.AdmissionResponse{
-			Allowed: true,
+			Result: &metav1.Status{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		}
+	}
+
+	// 判断是否需要真的执行 mutate 操作，不需要时不生成 spec 相关的 patch，也不会访问镜像仓库
+	required := mutationRequired(objectMeta, s.MutatePolicy, s.EmptyAnnotationsOptOut)
+
+	specPath := "/spec"
+	if template != nil {
+		podSpec, specPath = &template.Spec, templatePath+"/spec"
+	}
+	if podSpec != nil && required {
+		specPatch = s.mutateImagePullPolicy(specPath, podSpec)
+		specPatch = append(specPatch, s.mutateResourceRequests(specPath, podSpec)...)
+		specPatch = append(specPatch, s.mutateRunAsNonRoot(specPath, podSpec)...)
+		// 工作负载根据 Pod 模板上的注解判断是否注入 Sidecar
+		podMeta := objectMeta
+		if template != nil {
+			podMeta = &template.ObjectMeta
+		}
+		specPatch = append(specPatch, s.injectSidecar(specPath, podMeta, podSpec)...)
+		digestPatch, digests, digestWarnings := s.resolveImageDigests(req.Namespace, specPath, podSpec)
+		specPatch = append(specPatch, digestPatch...)
+		warnings = append(warnings, digestWarnings...)
+		if digests != "" {
+			annotations[AnnotationImageDigestKey] = digests
 		}
 	}
+	if template != nil && s.AnnotatePodTemplate {
+		specPatch = append(specPatch, mutateTemplateAnnotations(templatePath, template, map[string]string{
+			AnnotationStatusKey: "mutated",
+		})...)
+	}
 
-	// 需要执行 mutate 操作
+	// 禁止用户设置的注解不受 mutate 开关的影响，总是会被移除
+	stripPatch := s.stripAnnotations(objectMeta)
 
-	annotations := map[string]string{
-		AnnotationStatusKey: "mutated",
+	if !required && len(stripPatch) == 0 {
+		return &admissionv1.AdmissionResponse{
+			Allowed: true,
+		}
 	}
 
-	var patch []patchOperation
-	patch = append(patch, mutateAnnotations(objectMeta.GetAnnotations(), annotations)...)
+	patch := stripPatch
+	if required {
+		// 需要执行 mutate 操作
+		if forceMutation(objectMeta) && !containsString(s.DisallowedAnnotations, AnnotationForceMutateKey) {
+			// 先移除 force-mutate 标记，后续的 annotations 操作才不会覆盖掉这个路径
+			patch = append(patch, patchOperation{
+				Op:   "remove",
+				Path: "/metadata/annotations/" + escapeJSONPointer(AnnotationForceMutateKey),
+			})
+		}
+		if _, ok := objectMeta.GetAnnotations()[AnnotationMutateKey]; ok && s.RemoveMutateTrigger && !containsString(s.DisallowedAnnotations, AnnotationMutateKey) {
+			// 处理完成后移除用户设置的触发注解，只保留状态注解
+			patch = append(patch, patchOperation{
+				Op:   "remove",
+				Path: "/metadata/annotations/" + escapeJSONPointer(AnnotationMutateKey),
+			})
+		}
+		patch = append(patch, mutateAnnotations(objectMeta.GetAnnotations(), annotations)...)
+		patch = append(patch, mutateLabels(objectMeta.GetLabels(), s.missingDefaultLabels(objectMeta))...)
+		patch = append(patch, specPatch...)
+	}
 
 	patchBytes, err := json.Marshal(patch)
 	if err != nil {
@@ -237,9 +1015,14 @@ func (s *WebhookServer) mutate(ar *admissionv1.AdmissionReview) *admissionv1.Adm
 		}
 	}
 
+	if s.AuditLog != nil && !isDryRun(req) {
+		s.AuditLog.Record(req, patchBytes)
+	}
+
 	return &admissionv1.AdmissionResponse{
-		Allowed: true,
-		Patch:   patchBytes,
+		Allowed:  true,
+		Warnings: warnings,
+		Patch:    patchBytes,
 		PatchType: func() *admissionv1.PatchType {
 			pt := admissionv1.PatchTypeJSONPatch
 			return &pt
@@ -247,23 +1030,39 @@ func (s *WebhookServer) mutate(ar *admissionv1.AdmissionReview) *admissionv1.Adm
 	}
 }
 
-func mutationRequired(metadata *metav1.ObjectMeta) bool {
+// mutationRequired 判断对象是否需要 mutate：
+//   - 没有 annotations（nil）时需要 mutate
+//   - annotations 显式设置为空 map（annotations: {}）时，emptyAnnotationsOptOut 为 true 表示用户选择不 mutate，否则与 nil 相同
+//   - opt-out 策略下 AnnotationMutateKey 为 n/no/false/off 时不需要 mutate，opt-in 策略下只有 y/yes/true/on 时才需要 mutate
+//   - 已经 mutate 过的对象除非设置了 force-mutate 也不再处理
+func mutationRequired(metadata *metav1.ObjectMeta, policy string, emptyAnnotationsOptOut bool) bool {
 	annotations := metadata.GetAnnotations()
+	if annotations != nil && len(annotations) == 0 && emptyAnnotationsOptOut {
+		klog.Infof("Mutation policy for %s/%s: required: false (empty annotations)", metadata.Name, metadata.Namespace)
+		return false
+	}
 	if annotations == nil {
 		annotations = map[string]string{}
 	}
 
 	var required bool
 
-	switch strings.ToLower(annotations[AnnotationMutateKey]) {
-	case "n", "no", "false", "off":
-		required = false
+	switch value := strings.ToLower(annotations[AnnotationMutateKey]); policy {
+	case MutatePolicyOptIn:
+		switch value {
+		case "y", "yes", "true", "on":
+			required = true
+		}
 	default:
-		required = true
+		switch value {
+		case "n", "no", "false", "off":
+		default:
+			required = true
+		}
 	}
 
 	status := annotations[AnnotationStatusKey]
-	if strings.ToLower(status) == "mutated" {
+	if strings.ToLower(status) == "mutated" && !forceMutation(metadata) {
 		required = false
 	}
 
@@ -272,24 +1071,50 @@ func mutationRequired(metadata *metav1.ObjectMeta) bool {
 	return required
 }
 
-func mutateAnnotations(target map[string]string, added map[string]string) (patch []patchOperation) {
-	for key, value := range added {
-		if target == nil || target[key] == "" {
-			target = map[string]string{}
-			patch = append(patch, patchOperation{
-				Op:   "add",
-				Path: "/metadata/annotations",
-				Value: map[string]string{
-					key: value,
-				},
-			})
-		} else {
-			patch = append(patch, patchOperation{
-				Op:    "replace",
-				Path:  "/metadata/annotations/" + key,
-				Value: value,
-			})
-		}
+func forceMutation(metadata *metav1.ObjectMeta) bool {
+	return strings.ToLower(metadata.GetAnnotations()[AnnotationForceMutateKey]) == "true"
+}
+
+// escapeJSONPointer 按照 RFC 6901 转义 JSON Pointer 中的 ~ 和 /
+func escapeJSONPointer(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
+
+// mutateAnnotations 生成添加 annotations 的 patch：对象上没有 annotations 时一次性添加完整的 map，
+// 否则逐个添加 key，不会影响对象上已有的其他 annotations
+func mutateAnnotations(target map[string]string, added map[string]string) []patchOperation {
+	return mutateMetadataMap("/metadata/annotations", target, added)
+}
+
+// mutateLabels 与 mutateAnnotations 相同，生成添加 labels 的 patch
+func mutateLabels(target map[string]string, added map[string]string) []patchOperation {
+	return mutateMetadataMap("/metadata/labels", target, added)
+}
+
+// mutateMetadataMap 生成向 path 处的 map 添加 key 的 patch，target 为 nil 时一次性添加完整的 map
+func mutateMetadataMap(path string, target map[string]string, added map[string]string) (patch []patchOperation) {
+	if len(added) == 0 {
+		return nil
+	}
+	if target == nil {
+		return []patchOperation{{
+			Op:    "add",
+			Path:  path,
+			Value: added,
+		}}
+	}
+	keys := make([]string, 0, len(added))
+	for key := range added {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		// add 操作对已经存在的 key 会直接替换，即使前面的 patch 刚刚移除了这个 key 也可以正常执行
+		patch = append(patch, patchOperation{
+			Op:    "add",
+			Path:  path + "/" + escapeJSONPointer(key),
+			Value: added[key],
+		})
 	}
 	return
 }
@@ -0,0 +1,1185 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/yaml"
+)
+
+// newAdmissionReview 把 obj 序列化到 req.Object.Raw 中，构造一个 CREATE 请求的 AdmissionReview
+func newAdmissionReview(t *testing.T, kind string, obj metav1.Object) *admissionv1.AdmissionReview {
+	t.Helper()
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatalf("marshal %s: %v", kind, err)
+	}
+	return &admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			UID:       types.UID("test-" + obj.GetName()),
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: kind},
+			Namespace: obj.GetNamespace(),
+			Name:      obj.GetName(),
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+}
+
+func newPod(image string, annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Namespace:   "default",
+			Annotations: annotations,
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app", Image: image}},
+		},
+	}
+}
+
+func newTestServer(t *testing.T, registries ...string) *WebhookServer {
+	t.Helper()
+	s := &WebhookServer{}
+	if err := s.SetWhiteListRegistries(registries); err != nil {
+		t.Fatalf("set whitelist: %v", err)
+	}
+	return s
+}
+
+// decodePatch 解析 mutate 返回的 JSONPatch
+func decodePatch(t *testing.T, resp *admissionv1.AdmissionResponse) []patchOperation {
+	t.Helper()
+	if len(resp.Patch) == 0 {
+		return nil
+	}
+	var patch []patchOperation
+	if err := json.Unmarshal(resp.Patch, &patch); err != nil {
+		t.Fatalf("unmarshal patch %s: %v", resp.Patch, err)
+	}
+	return patch
+}
+
+func TestAdmitRecoversPanic(t *testing.T) {
+	tests := []struct {
+		name     string
+		failOpen bool
+		allowed  bool
+	}{
+		{name: "fail closed", failOpen: false, allowed: false},
+		{name: "fail open", failOpen: true, allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.FailOpen = tt.failOpen
+			s.DecisionHooks = []DecisionHook{DecisionHookFunc(func(req *admissionv1.AdmissionRequest, decision *Decision) {
+				panic("boom")
+			})}
+			resp := s.admit("/validate", newAdmissionReview(t, "Pod", newPod("nginx", nil)))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, response %+v", resp.Allowed, tt.allowed, resp)
+			}
+			if tt.allowed {
+				if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "boom") {
+					t.Errorf("warnings = %v, want the panic message", resp.Warnings)
+				}
+				return
+			}
+			if resp.Result == nil || resp.Result.Code != http.StatusInternalServerError || !strings.Contains(resp.Result.Message, "boom") {
+				t.Errorf("result = %+v, want a 500 with the panic message", resp.Result)
+			}
+		})
+	}
+}
+
+func TestServeV1beta1(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		allowed bool
+	}{
+		{name: "allowed", image: "docker.io/nginx:1.19", allowed: true},
+		{name: "denied", image: "evil.example.com/nginx:1.19", allowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, _ := json.Marshal(newPod(tt.image, nil))
+			review := admissionv1beta1.AdmissionReview{
+				TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1beta1", Kind: "AdmissionReview"},
+				Request: &admissionv1beta1.AdmissionRequest{
+					UID:       "v1beta1-uid",
+					Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+					Namespace: "default",
+					Name:      "test",
+					Operation: admissionv1beta1.Create,
+					Object:    runtime.RawExtension{Raw: raw},
+				},
+			}
+			body, _ := json.Marshal(review)
+			request := httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(string(body)))
+			request.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			newTestServer(t, "docker.io").ServeValidate(recorder, request)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("code = %d, body %s", recorder.Code, recorder.Body)
+			}
+
+			var resp admissionv1beta1.AdmissionReview
+			if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("unmarshal response %s: %v", recorder.Body, err)
+			}
+			// 响应使用与请求相同的 apiVersion，UID 与请求一致
+			if resp.APIVersion != "admission.k8s.io/v1beta1" || resp.Kind != "AdmissionReview" {
+				t.Errorf("response type = %s %s, want admission.k8s.io/v1beta1 AdmissionReview", resp.APIVersion, resp.Kind)
+			}
+			if resp.Response == nil || resp.Response.UID != "v1beta1-uid" {
+				t.Fatalf("response = %+v, want UID v1beta1-uid", resp.Response)
+			}
+			if resp.Response.Allowed != tt.allowed {
+				t.Errorf("allowed = %v, want %v, result %+v", resp.Response.Allowed, tt.allowed, resp.Response.Result)
+			}
+		})
+	}
+}
+
+func TestServeMethodAndContentType(t *testing.T) {
+	body, _ := json.Marshal(newAdmissionReview(t, "Pod", newPod("docker.io/nginx", nil)))
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		code        int
+	}{
+		{name: "bare application/json", method: http.MethodPost, contentType: "application/json", code: http.StatusOK},
+		{name: "charset suffix", method: http.MethodPost, contentType: "application/json; charset=utf-8", code: http.StatusOK},
+		{name: "upper case media type", method: http.MethodPost, contentType: "Application/JSON", code: http.StatusOK},
+		{name: "GET", method: http.MethodGet, contentType: "application/json", code: http.StatusMethodNotAllowed},
+		{name: "PUT", method: http.MethodPut, contentType: "application/json", code: http.StatusMethodNotAllowed},
+		{name: "text/plain", method: http.MethodPost, contentType: "text/plain", code: http.StatusBadRequest},
+		{name: "missing content type", method: http.MethodPost, code: http.StatusBadRequest},
+		{name: "malformed content type", method: http.MethodPost, contentType: "application/json; charset", code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, "/validate", strings.NewReader(string(body)))
+			if tt.contentType != "" {
+				request.Header.Set("Content-Type", tt.contentType)
+			}
+			recorder := httptest.NewRecorder()
+			newTestServer(t, "docker.io").ServeValidate(recorder, request)
+			if recorder.Code != tt.code {
+				t.Errorf("code = %d, want %d, body %s", recorder.Code, tt.code, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestMutateAnnotationsMultipleKeys(t *testing.T) {
+	added := map[string]string{"b.example.com/second": "2", "a.example.com/first": "1"}
+	tests := []struct {
+		name   string
+		target map[string]string
+		want   []patchOperation
+	}{
+		{
+			name:   "no annotations",
+			target: nil,
+			// 一次性添加完整的 map，不会出现后一个 key 覆盖前一个 key 的情况
+			want: []patchOperation{
+				{Op: "add", Path: "/metadata/annotations", Value: map[string]interface{}{"a.example.com/first": "1", "b.example.com/second": "2"}},
+			},
+		},
+		{
+			name:   "unrelated annotations",
+			target: map[string]string{"team": "infra"},
+			// 逐个添加 key，不会替换整个 annotations 对象
+			want: []patchOperation{
+				{Op: "add", Path: "/metadata/annotations/a.example.com~1first", Value: "1"},
+				{Op: "add", Path: "/metadata/annotations/b.example.com~1second", Value: "2"},
+			},
+		},
+		{
+			name:   "empty annotations",
+			target: map[string]string{},
+			want: []patchOperation{
+				{Op: "add", Path: "/metadata/annotations/a.example.com~1first", Value: "1"},
+				{Op: "add", Path: "/metadata/annotations/b.example.com~1second", Value: "2"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := json.Marshal(mutateAnnotations(tt.target, added))
+			want, _ := json.Marshal(tt.want)
+			if string(got) != string(want) {
+				t.Errorf("patch = %s, want %s", got, want)
+			}
+		})
+	}
+	if patch := mutateAnnotations(map[string]string{"team": "infra"}, nil); patch != nil {
+		t.Errorf("patch = %+v, want nil when nothing is added", patch)
+	}
+}
+
+func TestMutateKinds(t *testing.T) {
+	meta := metav1.ObjectMeta{Name: "test", Namespace: "default"}
+	template := corev1.PodTemplateSpec{
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx"}}},
+	}
+	tests := []struct {
+		kind string
+		obj  metav1.Object
+	}{
+		{kind: "Pod", obj: newPod("nginx", nil)},
+		{kind: "Deployment", obj: &appsv1.Deployment{ObjectMeta: meta, Spec: appsv1.DeploymentSpec{Template: template}}},
+		{kind: "StatefulSet", obj: &appsv1.StatefulSet{ObjectMeta: meta, Spec: appsv1.StatefulSetSpec{Template: template}}},
+		{kind: "DaemonSet", obj: &appsv1.DaemonSet{ObjectMeta: meta, Spec: appsv1.DaemonSetSpec{Template: template}}},
+		{kind: "Job", obj: &batchv1.Job{ObjectMeta: meta, Spec: batchv1.JobSpec{Template: template}}},
+		{kind: "CronJob", obj: &batchv1beta1.CronJob{ObjectMeta: meta, Spec: batchv1beta1.CronJobSpec{
+			JobTemplate: batchv1beta1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: template}},
+		}}},
+		{kind: "Service", obj: &corev1.Service{ObjectMeta: meta}},
+	}
+	want, _ := json.Marshal([]patchOperation{
+		{Op: "add", Path: "/metadata/annotations", Value: map[string]interface{}{AnnotationStatusKey: "mutated"}},
+	})
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			resp := newTestServer(t).mutate(newAdmissionReview(t, tt.kind, tt.obj))
+			if !resp.Allowed {
+				t.Fatalf("allowed = false, result %+v", resp.Result)
+			}
+			got, _ := json.Marshal(decodePatch(t, resp))
+			if string(got) != string(want) {
+				t.Errorf("patch = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestServeRequestBodyLimit(t *testing.T) {
+	body, _ := json.Marshal(newAdmissionReview(t, "Pod", newPod("docker.io/nginx", nil)))
+	tests := []struct {
+		name  string
+		limit int64
+		body  string
+		code  int
+	}{
+		{name: "under the limit", limit: int64(len(body)) + 1, body: string(body), code: http.StatusOK},
+		{name: "exactly the limit", limit: int64(len(body)), body: string(body), code: http.StatusOK},
+		{name: "over the limit", limit: int64(len(body)) - 1, body: string(body), code: http.StatusRequestEntityTooLarge},
+		// 没有设置 MaxRequestBytes 时使用 DefaultMaxRequestBytes
+		{name: "over the default limit", body: string(body) + strings.Repeat(" ", DefaultMaxRequestBytes), code: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.MaxRequestBytes = tt.limit
+			request := httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(tt.body))
+			request.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			s.ServeValidate(recorder, request)
+			if recorder.Code != tt.code {
+				t.Errorf("code = %d, want %d, body %.200s", recorder.Code, tt.code, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestServeValidateAndMutate(t *testing.T) {
+	s := newTestServer(t, "docker.io")
+	// 处理函数和注册的路径无关，可以注册在任意路径上
+	mux := http.NewServeMux()
+	mux.HandleFunc("/custom/validate/", s.ServeValidate)
+	mux.HandleFunc("/m", s.ServeMutate)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	post := func(path string, ar *admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+		t.Helper()
+		body, _ := json.Marshal(ar)
+		resp, err := http.Post(server.URL+path, "application/json", strings.NewReader(string(body)))
+		if err != nil {
+			t.Fatalf("post %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("post %s: code = %d", path, resp.StatusCode)
+		}
+		var review admissionv1.AdmissionReview
+		if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+			t.Fatalf("decode response of %s: %v", path, err)
+		}
+		if review.Response == nil || review.Response.UID != ar.Request.UID {
+			t.Fatalf("response = %+v, want uid %s", review.Response, ar.Request.UID)
+		}
+		return review.Response
+	}
+
+	if resp := post("/custom/validate/", newAdmissionReview(t, "Pod", newPod("gcr.io/google-containers/pause:3.2", nil))); resp.Allowed {
+		t.Errorf("validate allowed an untrusted registry")
+	}
+	if resp := post("/custom/validate/", newAdmissionReview(t, "Pod", newPod("docker.io/nginx:1.19", nil))); !resp.Allowed {
+		t.Errorf("validate denied a whitelisted registry, result %+v", resp.Result)
+	}
+	resp := post("/m", newAdmissionReview(t, "Pod", newPod("nginx", nil)))
+	if !resp.Allowed || len(decodePatch(t, resp)) == 0 {
+		t.Errorf("mutate response = %+v, want an allowed response with a patch", resp)
+	}
+	// mutate 不做镜像校验
+	if resp := post("/m", newAdmissionReview(t, "Pod", newPod("gcr.io/google-containers/pause:3.2", nil))); !resp.Allowed {
+		t.Errorf("mutate denied the request, result %+v", resp.Result)
+	}
+}
+
+func TestAuditAnnotations(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  string
+		image string
+		want  map[string]string
+	}{
+		{
+			name:  "allowed",
+			path:  "/validate",
+			image: "docker.io/nginx:1.19",
+			want:  map[string]string{AuditAnnotationDecision: "allow"},
+		},
+		{
+			name:  "denied",
+			path:  "/validate",
+			image: "gcr.io/google-containers/pause:3.2",
+			want: map[string]string{
+				AuditAnnotationDecision: "deny",
+				AuditAnnotationReason:   "gcr.io/google-containers/pause:3.2 image comes from an untrusted registry! Only images from [docker.io] are allowed.",
+			},
+		},
+		{
+			name:  "mutated",
+			path:  "/mutate",
+			image: "nginx",
+			want:  map[string]string{AuditAnnotationDecision: "allow", AuditAnnotationMutated: "true"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ar := newAdmissionReview(t, "Pod", newPod(tt.image, nil))
+			resp := newTestServer(t, "docker.io").admit(tt.path, ar)
+			// 关联 ID 总是等于请求的 UID
+			tt.want[AuditAnnotationCorrelationID] = string(ar.Request.UID)
+			if !reflect.DeepEqual(resp.AuditAnnotations, tt.want) {
+				t.Errorf("audit annotations = %v, want %v", resp.AuditAnnotations, tt.want)
+			}
+		})
+	}
+}
+
+func TestMutateExemptNamespaces(t *testing.T) {
+	tests := []struct {
+		namespace string
+		mutated   bool
+	}{
+		{namespace: "kube-system", mutated: false},
+		{namespace: "monitoring", mutated: false},
+		{namespace: "default", mutated: true},
+		// 只比较完整的命名空间名称
+		{namespace: "kube-system-apps", mutated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.namespace, func(t *testing.T) {
+			s := newTestServer(t)
+			s.MutateExemptNamespaces = []string{"kube-system", "monitoring"}
+			pod := newPod("nginx", nil)
+			pod.Namespace = tt.namespace
+			resp := s.mutate(newAdmissionReview(t, "Pod", pod))
+			if !resp.Allowed {
+				t.Fatalf("allowed = false, result %+v", resp.Result)
+			}
+			if mutated := len(resp.Patch) > 0; mutated != tt.mutated {
+				t.Errorf("mutated = %v, want %v, patch %s", mutated, tt.mutated, resp.Patch)
+			}
+		})
+	}
+}
+
+func TestValidatePodImage(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		allowed bool
+	}{
+		{name: "whitelisted registry", image: "docker.io/library/nginx:1.19", allowed: true},
+		{name: "implicit docker hub", image: "nginx:1.19", allowed: true},
+		{name: "untrusted registry", image: "gcr.io/google-containers/pause:3.2", allowed: false},
+		{name: "lookalike registry", image: "docker.io.evil.io/nginx:1.19", allowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			resp := s.validate(newAdmissionReview(t, "Pod", newPod(tt.image, nil)))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if tt.allowed {
+				return
+			}
+			if resp.Result.Code != http.StatusForbidden || resp.Result.Reason != metav1.StatusReasonForbidden {
+				t.Errorf("result = %d %s, want %d %s", resp.Result.Code, resp.Result.Reason, http.StatusForbidden, metav1.StatusReasonForbidden)
+			}
+			if resp.Result.Details == nil || len(resp.Result.Details.Causes) != 1 || resp.Result.Details.Causes[0].Field != "spec.containers[0].image" {
+				t.Errorf("details = %+v, want one cause for spec.containers[0].image", resp.Result.Details)
+			}
+		})
+	}
+}
+
+func TestValidateDenyList(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		allowed bool
+	}{
+		{name: "whitelisted host", image: "registry.example.com/team/app:1.0", allowed: true},
+		{name: "denied host matching whitelist glob", image: "legacy.example.com/team/app:1.0", allowed: false},
+		{name: "denied subdomain", image: "eu.legacy.example.com/team/app:1.0", allowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "*.example.com")
+			s.DenyListRegistries = []string{"legacy.example.com"}
+			resp := s.validate(newAdmissionReview(t, "Pod", newPod(tt.image, nil)))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if !tt.allowed && !strings.Contains(resp.Result.Message, "banned registry") {
+				t.Errorf("message = %q, want a banned registry message", resp.Result.Message)
+			}
+		})
+	}
+}
+
+func TestEmptyObject(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation admissionv1.Operation
+		policy    string
+		oldObject bool
+		allowed   bool
+		code      int32
+	}{
+		{name: "create without object", operation: admissionv1.Create, code: http.StatusBadRequest},
+		{name: "delete is allowed", operation: admissionv1.Delete, allowed: true, code: http.StatusOK},
+		{name: "delete validates old object", operation: admissionv1.Delete, policy: DeletePolicyValidate, oldObject: true, code: http.StatusForbidden},
+		{name: "delete without old object", operation: admissionv1.Delete, policy: DeletePolicyValidate, code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.DeletePolicy = tt.policy
+			ar := newAdmissionReview(t, "Pod", newPod("gcr.io/google-containers/pause:3.2", nil))
+			ar.Request.Operation = tt.operation
+			if tt.oldObject {
+				ar.Request.OldObject = ar.Request.Object
+			}
+			ar.Request.Object = runtime.RawExtension{}
+			resp := s.validate(ar)
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if resp.Result == nil || resp.Result.Code != tt.code {
+				t.Errorf("result = %+v, want code %d", resp.Result, tt.code)
+			}
+
+			resp = s.mutate(ar)
+			if wantAllowed := tt.operation == admissionv1.Delete; resp.Allowed != wantAllowed {
+				t.Errorf("mutate allowed = %v, want %v, result %+v", resp.Allowed, wantAllowed, resp.Result)
+			}
+		})
+	}
+}
+
+func TestServeMissingRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{name: "json without request", body: `{"foo":"bar"}`, code: http.StatusBadRequest},
+		{name: "kind without request", body: `{"kind":"AdmissionReview"}`, code: http.StatusBadRequest},
+		{name: "unknown apiVersion without request", body: `{"apiVersion":"example.com/v1","kind":"AdmissionReview"}`, code: http.StatusBadRequest},
+		{name: "other kind", body: `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"test"}}`, code: http.StatusBadRequest},
+		{name: "invalid json", body: `{"kind":`, code: http.StatusBadRequest},
+		// 显式声明了 apiVersion 和 kind 的探测请求放行
+		{name: "connectivity probe", body: `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview"}`, code: http.StatusOK},
+		{name: "v1beta1 connectivity probe", body: `{"apiVersion":"admission.k8s.io/v1beta1","kind":"AdmissionReview"}`, code: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			request := httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(tt.body))
+			request.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			s.ServeValidate(recorder, request)
+			if recorder.Code != tt.code {
+				t.Errorf("code = %d, want %d, body %s", recorder.Code, tt.code, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestServeRequestID(t *testing.T) {
+	body, _ := json.Marshal(newAdmissionReview(t, "Pod", newPod("nginx", nil)))
+	serve := func(requestID string) string {
+		s := newTestServer(t, "docker.io")
+		request := httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(string(body)))
+		request.Header.Set("Content-Type", "application/json")
+		if requestID != "" {
+			request.Header.Set("X-Request-Id", requestID)
+		}
+		recorder := httptest.NewRecorder()
+		s.ServeValidate(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("code = %d, body %s", recorder.Code, recorder.Body)
+		}
+		return recorder.Header().Get("X-Request-Id")
+	}
+
+	if got := serve("req-1"); got != "req-1" {
+		t.Errorf("X-Request-Id = %q, want the incoming req-1", got)
+	}
+	first, second := serve(""), serve("")
+	if first == "" || first == second {
+		t.Errorf("X-Request-Id = %q and %q, want distinct generated IDs", first, second)
+	}
+}
+
+func TestServeResponseHeaders(t *testing.T) {
+	body, _ := json.Marshal(newAdmissionReview(t, "Pod", newPod("nginx", nil)))
+	tests := []struct {
+		name   string
+		method string
+		code   int
+	}{
+		{name: "admission response", method: http.MethodPost, code: http.StatusOK},
+		// 错误响应同样携带配置的 header
+		{name: "error response", method: http.MethodGet, code: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.ResponseHeaders = map[string]string{"X-Served-By": "admission-registry", "Cache-Control": "no-store", "X-Request-Id": "static"}
+			request := httptest.NewRequest(tt.method, "/validate", strings.NewReader(string(body)))
+			request.Header.Set("Content-Type", "application/json")
+			request.Header.Set("X-Request-Id", "req-1")
+			recorder := httptest.NewRecorder()
+			s.ServeValidate(recorder, request)
+			if recorder.Code != tt.code {
+				t.Fatalf("code = %d, want %d, body %s", recorder.Code, tt.code, recorder.Body)
+			}
+			for key, want := range map[string]string{"X-Served-By": "admission-registry", "Cache-Control": "no-store", "X-Request-Id": "req-1"} {
+				if got := recorder.Header().Get(key); got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMutateUnknownKind(t *testing.T) {
+	s := newTestServer(t)
+	ar := newAdmissionReview(t, "ConfigMap", &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}})
+	resp := s.mutate(ar)
+	if resp.Allowed {
+		t.Fatalf("allowed = true, want false for an unknown kind")
+	}
+	if resp.Result == nil || resp.Result.Code != http.StatusBadRequest {
+		t.Errorf("result = %+v, want code %d", resp.Result, http.StatusBadRequest)
+	}
+}
+
+func TestMutateAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        []patchOperation
+	}{
+		{
+			name:        "add annotations map",
+			annotations: nil,
+			want: []patchOperation{
+				{Op: "add", Path: "/metadata/annotations", Value: map[string]interface{}{AnnotationStatusKey: "mutated"}},
+			},
+		},
+		{
+			name:        "add annotation key to existing annotations",
+			annotations: map[string]string{"team": "infra"},
+			want: []patchOperation{
+				{Op: "add", Path: "/metadata/annotations/io.ydzs.admission-registry~1status", Value: "mutated"},
+			},
+		},
+		{
+			name:        "replace existing status annotation",
+			annotations: map[string]string{AnnotationStatusKey: "pending"},
+			want: []patchOperation{
+				{Op: "add", Path: "/metadata/annotations/io.ydzs.admission-registry~1status", Value: "mutated"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			resp := s.mutate(newAdmissionReview(t, "Pod", newPod("nginx", tt.annotations)))
+			if !resp.Allowed {
+				t.Fatalf("allowed = false, result %+v", resp.Result)
+			}
+			got, _ := json.Marshal(decodePatch(t, resp))
+			want, _ := json.Marshal(tt.want)
+			if string(got) != string(want) {
+				t.Errorf("patch = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestMutateSkip(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+	}{
+		{name: "mutate off", annotations: map[string]string{AnnotationMutateKey: "off"}},
+		{name: "mutate no", annotations: map[string]string{AnnotationMutateKey: "no"}},
+		{name: "already mutated", annotations: map[string]string{AnnotationStatusKey: "mutated"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			resp := s.mutate(newAdmissionReview(t, "Pod", newPod("nginx", tt.annotations)))
+			if !resp.Allowed {
+				t.Fatalf("allowed = false, result %+v", resp.Result)
+			}
+			if len(resp.Patch) != 0 {
+				t.Errorf("patch = %s, want no patch", resp.Patch)
+			}
+		})
+	}
+}
+
+func TestMutateForce(t *testing.T) {
+	forcePath := "/metadata/annotations/" + escapeJSONPointer(AnnotationForceMutateKey)
+	statusPath := "/metadata/annotations/" + escapeJSONPointer(AnnotationStatusKey)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        []patchOperation
+	}{
+		{
+			name:        "force already mutated",
+			annotations: map[string]string{AnnotationStatusKey: "mutated", AnnotationForceMutateKey: "true"},
+			// 重新执行 mutate，并在同一个 patch 中移除 force-mutate 标记
+			want: []patchOperation{
+				{Op: "remove", Path: forcePath},
+				{Op: "add", Path: statusPath, Value: "mutated"},
+			},
+		},
+		{
+			name:        "force value is case insensitive",
+			annotations: map[string]string{AnnotationStatusKey: "mutated", AnnotationForceMutateKey: "TRUE"},
+			want: []patchOperation{
+				{Op: "remove", Path: forcePath},
+				{Op: "add", Path: statusPath, Value: "mutated"},
+			},
+		},
+		{
+			name:        "force on first mutation",
+			annotations: map[string]string{AnnotationForceMutateKey: "true"},
+			want: []patchOperation{
+				{Op: "remove", Path: forcePath},
+				{Op: "add", Path: statusPath, Value: "mutated"},
+			},
+		},
+		{
+			name:        "force false",
+			annotations: map[string]string{AnnotationStatusKey: "mutated", AnnotationForceMutateKey: "false"},
+		},
+		// force-mutate 不会覆盖用户显式关闭 mutate 的设置
+		{
+			name:        "force with mutate off",
+			annotations: map[string]string{AnnotationStatusKey: "mutated", AnnotationForceMutateKey: "true", AnnotationMutateKey: "off"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			resp := s.mutate(newAdmissionReview(t, "Pod", newPod("nginx", tt.annotations)))
+			if !resp.Allowed {
+				t.Fatalf("allowed = false, result %+v", resp.Result)
+			}
+			got, _ := json.Marshal(decodePatch(t, resp))
+			want, _ := json.Marshal(tt.want)
+			if string(got) != string(want) {
+				t.Errorf("patch = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestRemoveMutateTrigger(t *testing.T) {
+	triggerPath := "/metadata/annotations/" + escapeJSONPointer(AnnotationMutateKey)
+	statusPath := "/metadata/annotations/" + escapeJSONPointer(AnnotationStatusKey)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		disallowed  []string
+		want        []patchOperation
+	}{
+		{
+			name:        "trigger present",
+			annotations: map[string]string{AnnotationMutateKey: "yes", "team": "infra"},
+			// 移除触发注解，保留状态注解
+			want: []patchOperation{
+				{Op: "remove", Path: triggerPath},
+				{Op: "add", Path: statusPath, Value: "mutated"},
+			},
+		},
+		{
+			name:        "trigger absent",
+			annotations: map[string]string{"team": "infra"},
+			want: []patchOperation{
+				{Op: "add", Path: statusPath, Value: "mutated"},
+			},
+		},
+		// 触发注解同时是禁止的注解时只移除一次
+		{
+			name:        "trigger also disallowed",
+			annotations: map[string]string{AnnotationMutateKey: "yes", "team": "infra"},
+			disallowed:  []string{AnnotationMutateKey},
+			want: []patchOperation{
+				{Op: "remove", Path: triggerPath},
+				{Op: "add", Path: statusPath, Value: "mutated"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.RemoveMutateTrigger = true
+			s.DisallowedAnnotations = tt.disallowed
+			resp := s.mutate(newAdmissionReview(t, "Pod", newPod("nginx", tt.annotations)))
+			if !resp.Allowed {
+				t.Fatalf("allowed = false, result %+v", resp.Result)
+			}
+			assertPatch(t, decodePatch(t, resp), tt.want)
+		})
+	}
+
+	// 没有开启时保留触发注解
+	resp := newTestServer(t).mutate(newAdmissionReview(t, "Pod", newPod("nginx", map[string]string{AnnotationMutateKey: "yes"})))
+	assertPatch(t, decodePatch(t, resp), []patchOperation{{Op: "add", Path: statusPath, Value: "mutated"}})
+}
+
+func TestMutateDefaultLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   []patchOperation
+	}{
+		{
+			name:   "add labels map",
+			labels: nil,
+			want: []patchOperation{
+				{Op: "add", Path: "/metadata/labels", Value: map[string]interface{}{"app": "unknown", "team": "infra"}},
+			},
+		},
+		{
+			name:   "keep existing label",
+			labels: map[string]string{"app": "web"},
+			want: []patchOperation{
+				{Op: "add", Path: "/metadata/labels/team", Value: "infra"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.DefaultLabels = map[string]string{"team": "infra", "app": "unknown"}
+			pod := newPod("nginx", map[string]string{})
+			pod.Labels = tt.labels
+			patch := decodePatch(t, s.mutate(newAdmissionReview(t, "Pod", pod)))
+			// 第一个 patch 是状态注解
+			if len(patch) == 0 {
+				t.Fatalf("no patch")
+			}
+			got, _ := json.Marshal(patch[1:])
+			want, _ := json.Marshal(tt.want)
+			if string(got) != string(want) {
+				t.Errorf("patch = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestMutatePolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      string
+		annotations map[string]string
+		mutated     bool
+	}{
+		{name: "opt-out without annotation", policy: MutatePolicyOptOut, annotations: nil, mutated: true},
+		{name: "opt-out annotated off", policy: MutatePolicyOptOut, annotations: map[string]string{AnnotationMutateKey: "off"}, mutated: false},
+		{name: "default policy is opt-out", policy: "", annotations: map[string]string{"team": "infra"}, mutated: true},
+		{name: "opt-in without annotation", policy: MutatePolicyOptIn, annotations: nil, mutated: false},
+		{name: "opt-in annotated on", policy: MutatePolicyOptIn, annotations: map[string]string{AnnotationMutateKey: "on"}, mutated: true},
+		{name: "opt-in annotated yes", policy: MutatePolicyOptIn, annotations: map[string]string{AnnotationMutateKey: "Yes"}, mutated: true},
+		{name: "opt-in annotated off", policy: MutatePolicyOptIn, annotations: map[string]string{AnnotationMutateKey: "off"}, mutated: false},
+		{name: "opt-in already mutated", policy: MutatePolicyOptIn, annotations: map[string]string{AnnotationMutateKey: "on", AnnotationStatusKey: "mutated"}, mutated: false},
+		{name: "opt-out already mutated", policy: MutatePolicyOptOut, annotations: map[string]string{AnnotationStatusKey: "mutated"}, mutated: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.MutatePolicy = tt.policy
+			resp := s.mutate(newAdmissionReview(t, "Pod", newPod("nginx", tt.annotations)))
+			if !resp.Allowed {
+				t.Fatalf("allowed = false, result %+v", resp.Result)
+			}
+			if mutated := len(resp.Patch) != 0; mutated != tt.mutated {
+				t.Errorf("mutated = %v, want %v, patch %s", mutated, tt.mutated, resp.Patch)
+			}
+		})
+	}
+}
+
+// recordingPublisher 保存发布过的决策记录
+type recordingPublisher struct {
+	records []DecisionRecord
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, record DecisionRecord) error {
+	p.records = append(p.records, record)
+	return nil
+}
+
+// serveReview 通过 ServeValidate 处理 ar 并返回响应中的 AdmissionReview
+func serveReview(t *testing.T, s *WebhookServer, ar *admissionv1.AdmissionReview) *admissionv1.AdmissionReview {
+	t.Helper()
+	body, _ := json.Marshal(ar)
+	request := httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(string(body)))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	s.ServeValidate(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("code = %d, body %s", recorder.Code, recorder.Body)
+	}
+	var review admissionv1.AdmissionReview
+	if err := json.Unmarshal(recorder.Body.Bytes(), &review); err != nil {
+		t.Fatalf("unmarshal response %s: %v", recorder.Body, err)
+	}
+	return &review
+}
+
+func TestServeSlowWarning(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold time.Duration
+		warned    bool
+	}{
+		{name: "slow request", threshold: time.Millisecond, warned: true},
+		{name: "fast request", threshold: time.Minute, warned: false},
+		{name: "threshold disabled", threshold: 0, warned: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publisher := &recordingPublisher{}
+			s := newTestServer(t, "docker.io")
+			s.SlowThreshold = tt.threshold
+			s.Publisher = publisher
+			s.DecisionHooks = []DecisionHook{DecisionHookFunc(func(req *admissionv1.AdmissionRequest, decision *Decision) {
+				time.Sleep(10 * time.Millisecond)
+			})}
+			review := serveReview(t, s, newAdmissionReview(t, "Pod", newPod("docker.io/nginx", nil)))
+			warned := len(review.Response.Warnings) == 1 && strings.Contains(review.Response.Warnings[0], "took")
+			if warned != tt.warned {
+				t.Fatalf("warnings = %v, want slow warning %v", review.Response.Warnings, tt.warned)
+			}
+			// 发布的决策记录和返回给 apiserver 的响应包含相同的警告
+			if len(publisher.records) != 1 || !reflect.DeepEqual(publisher.records[0].Warnings, review.Response.Warnings) {
+				t.Errorf("published records = %+v, want warnings %v", publisher.records, review.Response.Warnings)
+			}
+		})
+	}
+}
+
+func TestServeDryRun(t *testing.T) {
+	for _, dryRun := range []bool{false, true} {
+		t.Run(fmt.Sprintf("dryRun=%v", dryRun), func(t *testing.T) {
+			publisher := &recordingPublisher{}
+			recorder := record.NewFakeRecorder(10)
+			s := newTestServer(t, "docker.io")
+			s.Publisher = publisher
+			s.RecentDecisions = NewDecisionRing(10)
+			s.EventRecorder = recorder
+			ar := newAdmissionReview(t, "Pod", newPod("gcr.io/google-containers/pause:3.2", nil))
+			ar.Request.DryRun = &dryRun
+
+			// dry-run 请求仍然返回准确的决策
+			review := serveReview(t, s, ar)
+			if review.Response.Allowed {
+				t.Fatalf("allowed = true, want false for an untrusted registry")
+			}
+			want := 1
+			if dryRun {
+				want = 0
+			}
+			if len(publisher.records) != want {
+				t.Errorf("published %d records, want %d", len(publisher.records), want)
+			}
+			if got := len(s.RecentDecisions.List()); got != want {
+				t.Errorf("recent decisions = %d, want %d", got, want)
+			}
+			if got := len(recorder.Events); got != want {
+				t.Errorf("events = %d, want %d", got, want)
+			}
+
+			// mutate 对 dry-run 请求返回同样的 patch
+			pod := newPod("nginx", nil)
+			mutateReview := newAdmissionReview(t, "Pod", pod)
+			mutateReview.Request.DryRun = &dryRun
+			if patch := decodePatch(t, s.mutate(mutateReview)); len(patch) != 1 || patch[0].Path != "/metadata/annotations" {
+				t.Errorf("patch = %+v, want the status annotation patch", patch)
+			}
+		})
+	}
+}
+
+func TestAdmitSubResource(t *testing.T) {
+	// 子资源请求携带的不是父资源对象，比如 pods/exec 是 PodExecOptions，deployments/scale 是 Scale
+	subResourceReview := func(resource, subResource, kind, raw string) *admissionv1.AdmissionReview {
+		return &admissionv1.AdmissionReview{
+			Request: &admissionv1.AdmissionRequest{
+				UID:         types.UID("test-" + subResource),
+				Kind:        metav1.GroupVersionKind{Version: "v1", Kind: kind},
+				Resource:    metav1.GroupVersionResource{Version: "v1", Resource: resource},
+				SubResource: subResource,
+				Namespace:   "default",
+				Name:        "test",
+				Operation:   admissionv1.Create,
+				Object:      runtime.RawExtension{Raw: []byte(raw)},
+			},
+		}
+	}
+	ephemeralPod, _ := json.Marshal(newPod("evil.example.com/debug", nil))
+	tests := []struct {
+		name    string
+		policy  string
+		review  *admissionv1.AdmissionReview
+		allowed bool
+	}{
+		{name: "pods/exec", review: subResourceReview("pods", "exec", "Pod", `{"kind":"PodExecOptions","apiVersion":"v1","command":["sh"]}`), allowed: true},
+		{name: "deployments/scale", review: subResourceReview("deployments", "scale", "Deployment", `{"kind":"Scale","apiVersion":"autoscaling/v1","metadata":{"name":"test"},"spec":{"replicas":3}}`), allowed: true},
+		{name: "pods/ephemeralcontainers skipped by default", review: subResourceReview("pods", "ephemeralcontainers", "Pod", string(ephemeralPod)), allowed: true},
+		// validate 策略下只校验携带完整 Pod 对象的 ephemeralcontainers，其他子资源仍然跳过
+		{name: "pods/exec with validate policy", policy: "validate", review: subResourceReview("pods", "exec", "Pod", `{"kind":"PodExecOptions","apiVersion":"v1","command":["sh"]}`), allowed: true},
+		{name: "deployments/scale with validate policy", policy: "validate", review: subResourceReview("deployments", "scale", "Deployment", `{"kind":"Scale","apiVersion":"autoscaling/v1","metadata":{"name":"test"},"spec":{"replicas":3}}`), allowed: true},
+		{name: "pods/ephemeralcontainers with validate policy", policy: "validate", review: subResourceReview("pods", "ephemeralcontainers", "Pod", string(ephemeralPod)), allowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.SubResourcePolicy = tt.policy
+			resp := s.admit("/validate", tt.review)
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			// mutate 总是跳过子资源请求，不会给子资源对象添加注解
+			resp = s.admit("/mutate", tt.review)
+			if !resp.Allowed || len(resp.Patch) != 0 {
+				t.Errorf("mutate allowed = %v, patch %s, want allowed without patch", resp.Allowed, resp.Patch)
+			}
+		})
+	}
+}
+
+// captureJSONLogs 把准入事件日志切换为 JSON 格式并输出到返回的 buffer 中，测试结束后恢复
+func captureJSONLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	logMu.Lock()
+	oldFormat, oldOutput := logFormat, logOutput
+	logFormat, logOutput = LogFormatJSON, &buf
+	logMu.Unlock()
+	t.Cleanup(func() {
+		logMu.Lock()
+		logFormat, logOutput = oldFormat, oldOutput
+		logMu.Unlock()
+	})
+	return &buf
+}
+
+func TestCorrelationID(t *testing.T) {
+	logs := captureJSONLogs(t)
+	s := newTestServer(t, "docker.io")
+	ar := newAdmissionReview(t, "Pod", newPod("docker.io/nginx:1.19", nil))
+	ar.Request.UID = "uid-correlated"
+	body, _ := json.Marshal(ar)
+	for _, path := range []string{"/mutate", "/validate"} {
+		request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(string(body)))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		s.Handler(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: code = %d, body %s", path, recorder.Code, recorder.Body)
+		}
+		var review admissionv1.AdmissionReview
+		if err := json.Unmarshal(recorder.Body.Bytes(), &review); err != nil {
+			t.Fatalf("%s: unmarshal response %s: %v", path, recorder.Body, err)
+		}
+		if got := review.Response.AuditAnnotations[AuditAnnotationCorrelationID]; got != "uid-correlated" {
+			t.Errorf("%s: correlation id annotation = %q, want uid-correlated", path, got)
+		}
+	}
+
+	// validate 和 mutate 的日志都使用请求的 UID 作为关联 ID
+	received := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("unmarshal log line %q: %v", line, err)
+		}
+		if entry["uid"] != "uid-correlated" {
+			t.Errorf("log entry %s has uid %v, want uid-correlated", line, entry["uid"])
+		}
+		if entry["msg"] == "AdmissionReview received" {
+			received[fmt.Sprint(entry["path"])] = true
+		}
+	}
+	if !received["/validate"] || !received["/mutate"] {
+		t.Errorf("received logs for %v, want both /validate and /mutate", received)
+	}
+}
+
+func TestCorrelationIDGenerated(t *testing.T) {
+	// 手工构造的没有 UID 的请求会生成一个关联 ID，并且和审计注解中的一致
+	ar := newAdmissionReview(t, "Pod", newPod("docker.io/nginx:1.19", nil))
+	ar.Request.UID = ""
+	review := serveReview(t, newTestServer(t, "docker.io"), ar)
+	if review.Response.UID == "" {
+		t.Fatalf("response UID is empty, want a generated correlation id")
+	}
+	if got := review.Response.AuditAnnotations[AuditAnnotationCorrelationID]; got != string(review.Response.UID) {
+		t.Errorf("correlation id annotation = %q, want %q", got, review.Response.UID)
+	}
+}
+
+func TestExemptUsers(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		groups   []string
+		reason   string
+	}{
+		{name: "exempt user", username: "system:serviceaccount:kube-system:break-glass", reason: "user system:serviceaccount:kube-system:break-glass is exempt"},
+		{name: "exempt group", username: "alice", groups: []string{"system:authenticated", "system:masters"}, reason: "user alice in group system:masters is exempt"},
+		{name: "non-exempt user", username: "bob", groups: []string{"system:authenticated"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.ExemptUsers = []string{"system:serviceaccount:kube-system:break-glass"}
+			s.ExemptGroups = []string{"system:masters"}
+			for _, path := range []string{"/validate", "/mutate"} {
+				ar := newAdmissionReview(t, "Pod", newPod("evil.example.com/nginx", nil))
+				ar.Request.UserInfo = authenticationv1.UserInfo{Username: tt.username, Groups: tt.groups}
+				resp := s.admit(path, ar)
+				if got := resp.AuditAnnotations[AuditAnnotationPolicyBypass]; got != tt.reason {
+					t.Errorf("%s: policy bypass annotation = %q, want %q", path, got, tt.reason)
+				}
+				switch {
+				case tt.reason != "":
+					// 豁免的请求直接放行，不校验也不修改
+					if !resp.Allowed || len(resp.Patch) != 0 {
+						t.Errorf("%s: allowed = %v, patch %s, want allowed without patch", path, resp.Allowed, resp.Patch)
+					}
+				case path == "/validate":
+					if resp.Allowed {
+						t.Errorf("allowed = true for a non-exempt user, want the untrusted image denied")
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestServeYAML(t *testing.T) {
+	yamlBody := func(image string) string {
+		data, _ := json.Marshal(newAdmissionReview(t, "Pod", newPod(image, nil)))
+		body, err := yaml.JSONToYAML(data)
+		if err != nil {
+			t.Fatalf("convert review to yaml: %v", err)
+		}
+		return string(body)
+	}
+	tests := []struct {
+		name        string
+		acceptYAML  bool
+		contentType string
+		body        string
+		code        int
+		allowed     bool
+	}{
+		{name: "trusted image", acceptYAML: true, contentType: "application/yaml", body: yamlBody("docker.io/nginx"), code: http.StatusOK, allowed: true},
+		{name: "untrusted image", acceptYAML: true, contentType: "application/yaml", body: yamlBody("evil.example.com/nginx"), code: http.StatusOK, allowed: false},
+		{name: "x-yaml", acceptYAML: true, contentType: "application/x-yaml; charset=utf-8", body: yamlBody("docker.io/nginx"), code: http.StatusOK, allowed: true},
+		{name: "invalid yaml", acceptYAML: true, contentType: "application/yaml", body: "request: [unterminated", code: http.StatusBadRequest},
+		// 没有开启时仍然只接受 JSON
+		{name: "yaml not enabled", contentType: "application/yaml", body: yamlBody("docker.io/nginx"), code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.AcceptYAML = tt.acceptYAML
+			request := httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(tt.body))
+			request.Header.Set("Content-Type", tt.contentType)
+			recorder := httptest.NewRecorder()
+			s.ServeValidate(recorder, request)
+			if recorder.Code != tt.code {
+				t.Fatalf("code = %d, want %d, body %s", recorder.Code, tt.code, recorder.Body)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			// 响应仍然使用 JSON
+			if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", contentType)
+			}
+			var review admissionv1.AdmissionReview
+			if err := json.Unmarshal(recorder.Body.Bytes(), &review); err != nil {
+				t.Fatalf("unmarshal response %s: %v", recorder.Body, err)
+			}
+			if review.Response.Allowed != tt.allowed {
+				t.Errorf("allowed = %v, want %v, result %+v", review.Response.Allowed, tt.allowed, review.Response.Result)
+			}
+		})
+	}
+}
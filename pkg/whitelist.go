@@ -0,0 +1,201 @@
+package pkg
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// WhiteListSource 获取镜像仓库白名单，比如从集中的策略服务获取
+type WhiteListSource interface {
+	Fetch(ctx context.Context) ([]string, error)
+}
+
+// HTTPWhiteListSource 从 HTTP(S) 接口获取白名单，接口返回 JSON 字符串数组，比如 ["docker.io","gcr.io"]
+type HTTPWhiteListSource struct {
+	URL           string
+	Authorization string // 请求时携带的 Authorization header，为空时不携带
+	Client        *http.Client
+}
+
+// NewHTTPWhiteListSource caFile 不为空时使用它校验服务端证书，否则使用系统的根证书
+func NewHTTPWhiteListSource(url, authorization, caFile string, timeout time.Duration) (*HTTPWhiteListSource, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile != "" {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &HTTPWhiteListSource{
+		URL:           url,
+		Authorization: authorization,
+		Client:        &http.Client{Timeout: timeout, Transport: transport},
+	}, nil
+}
+
+func (h *HTTPWhiteListSource) Fetch(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequest(http.MethodGet, h.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if h.Authorization != "" {
+		req.Header.Set("Authorization", h.Authorization)
+	}
+	resp, err := h.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("whitelist source %s returned %s", h.URL, resp.Status)
+	}
+	var registries []string
+	if err := json.NewDecoder(resp.Body).Decode(&registries); err != nil {
+		return nil, fmt.Errorf("decode whitelist from %s: %v", h.URL, err)
+	}
+	return registries, nil
+}
+
+// FileWhiteListSource 从文件读取白名单，每行一个镜像仓库，忽略空行和 # 开头的注释，
+// 文件可以通过 ConfigMap 挂载，配合 WatchWhiteList 定期重新读取
+type FileWhiteListSource struct {
+	Path string
+}
+
+func (f *FileWhiteListSource) Fetch(ctx context.Context) ([]string, error) {
+	data, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	registries := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		registries = append(registries, line)
+	}
+	return registries, nil
+}
+
+// LoadWhiteListFromFile 从文件加载白名单并替换当前使用的白名单
+func (s *WebhookServer) LoadWhiteListFromFile(path string) error {
+	registries, err := (&FileWhiteListSource{Path: path}).Fetch(context.Background())
+	if err != nil {
+		return err
+	}
+	return s.SetWhiteListRegistries(registries)
+}
+
+// WatchWhiteList 每隔 interval 从 source 刷新一次白名单，直到 ctx 结束；获取失败时继续使用上一次成功获取的白名单
+func (s *WebhookServer) WatchWhiteList(ctx context.Context, source WhiteListSource, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fetchCtx, cancel := context.WithTimeout(ctx, interval)
+			registries, err := source.Fetch(fetchCtx)
+			cancel()
+			if err != nil {
+				klog.Errorf("Failed to refresh whitelist, keep using the last known good whitelist: %v", err)
+				continue
+			}
+			if err := s.SetWhiteListRegistries(registries); err != nil {
+				klog.Errorf("Invalid whitelist, keep using the last known good whitelist: %v", err)
+			}
+		}
+	}
+}
+
+// WhiteListConfigMapKey 是 ConfigMap 中保存白名单的 key，值为逗号或者换行分隔的镜像仓库列表
+const WhiteListConfigMapKey = "registries"
+
+// ConfigMapWhiteListSource 从 ConfigMap 的 WhiteListConfigMapKey 中读取白名单
+type ConfigMapWhiteListSource struct {
+	Clientset kubernetes.Interface
+	Namespace string
+	Name      string
+}
+
+func (c *ConfigMapWhiteListSource) Fetch(ctx context.Context) ([]string, error) {
+	configMap, err := c.Clientset.CoreV1().ConfigMaps(c.Namespace).Get(ctx, c.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return parseWhiteListConfigMap(configMap)
+}
+
+func parseWhiteListConfigMap(configMap *corev1.ConfigMap) ([]string, error) {
+	data, ok := configMap.Data[WhiteListConfigMapKey]
+	if !ok {
+		return nil, fmt.Errorf("configmap %s/%s has no %q key", configMap.Namespace, configMap.Name, WhiteListConfigMapKey)
+	}
+	var registries []string
+	for _, line := range strings.Split(data, "\n") {
+		registries = append(registries, SplitList(line)...)
+	}
+	return registries, nil
+}
+
+// WatchWhiteListConfigMap 通过 informer 监听 ConfigMap 的变化并更新白名单，直到 ctx 结束；
+// ConfigMap 被删除或者内容无效时继续使用上一次成功加载的白名单
+func (s *WebhookServer) WatchWhiteListConfigMap(ctx context.Context, source *ConfigMapWhiteListSource) {
+	watchConfigMap(ctx, source.Clientset, source.Namespace, source.Name, func(configMap *corev1.ConfigMap) {
+		registries, err := parseWhiteListConfigMap(configMap)
+		if err == nil {
+			err = s.SetWhiteListRegistries(registries)
+		}
+		if err != nil {
+			klog.Errorf("Invalid whitelist, keep using the last known good whitelist: %v", err)
+			return
+		}
+		klog.Infof("Whitelist registries updated from configmap %s/%s: %v", source.Namespace, source.Name, registries)
+	})
+}
+
+// watchConfigMap 通过 informer 监听单个 ConfigMap，在它创建或者更新时调用 update，直到 ctx 结束；
+// ConfigMap 被删除时只记录日志，调用方继续使用上一次的配置
+func watchConfigMap(ctx context.Context, clientset kubernetes.Interface, namespace, name string, update func(configMap *corev1.ConfigMap)) {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+	handle := func(obj interface{}) {
+		if configMap, ok := obj.(*corev1.ConfigMap); ok {
+			update(configMap)
+		}
+	}
+	factory.Core().V1().ConfigMaps().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    handle,
+		UpdateFunc: func(_, obj interface{}) { handle(obj) },
+		DeleteFunc: func(interface{}) {
+			klog.Errorf("Configmap %s/%s deleted, keep using the last known good config", namespace, name)
+		},
+	})
+	factory.Start(ctx.Done())
+	<-ctx.Done()
+}
@@ -0,0 +1,260 @@
+package pkg
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newWhiteListServer 启动一个返回 body 的 HTTPS 服务，要求请求携带 Authorization: Bearer token，
+// 返回服务地址以及服务端证书的 CA 文件
+func newWhiteListServer(t *testing.T, status int, body string) (string, string) {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	dir, err := ioutil.TempDir("", "admission-registry-whitelist")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	caFile := filepath.Join(dir, "ca.crt")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, caPEM, 0644); err != nil {
+		t.Fatalf("write ca file: %v", err)
+	}
+	return server.URL, caFile
+}
+
+func TestHTTPWhiteListSource(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		body          string
+		authorization string
+		trustServer   bool
+		want          []string
+		wantErr       bool
+	}{
+		{name: "registries", status: http.StatusOK, body: `["docker.io","gcr.io"]`, authorization: "Bearer token", trustServer: true, want: []string{"docker.io", "gcr.io"}},
+		{name: "empty list", status: http.StatusOK, body: `[]`, authorization: "Bearer token", trustServer: true, want: []string{}},
+		{name: "missing authorization", status: http.StatusOK, body: `["docker.io"]`, trustServer: true, wantErr: true},
+		{name: "server error", status: http.StatusInternalServerError, body: `oops`, authorization: "Bearer token", trustServer: true, wantErr: true},
+		{name: "invalid json", status: http.StatusOK, body: `{"registries":"docker.io"}`, authorization: "Bearer token", trustServer: true, wantErr: true},
+		{name: "untrusted certificate", status: http.StatusOK, body: `["docker.io"]`, authorization: "Bearer token", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, caFile := newWhiteListServer(t, tt.status, tt.body)
+			if !tt.trustServer {
+				caFile = ""
+			}
+			source, err := NewHTTPWhiteListSource(url, tt.authorization, caFile, time.Second)
+			if err != nil {
+				t.Fatalf("new source: %v", err)
+			}
+			got, err := source.Fetch(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fetch err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fetch = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// scriptedWhiteListSource 依次返回 results 中的结果，用完之后一直返回最后一个
+type scriptedWhiteListSource struct {
+	mu      sync.Mutex
+	results []whiteListResult
+	calls   int
+}
+
+type whiteListResult struct {
+	registries []string
+	err        error
+}
+
+func (s *scriptedWhiteListSource) Fetch(ctx context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := s.results[len(s.results)-1]
+	if s.calls < len(s.results) {
+		result = s.results[s.calls]
+	}
+	s.calls++
+	return result.registries, result.err
+}
+
+func (s *scriptedWhiteListSource) Calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func TestWatchWhiteList(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []whiteListResult
+		want    []string
+	}{
+		{name: "refreshed", results: []whiteListResult{
+			{registries: []string{"gcr.io"}},
+			{registries: []string{"quay.io", "gcr.io"}},
+		}, want: []string{"quay.io", "gcr.io"}},
+		{name: "fetch failure keeps last known good", results: []whiteListResult{
+			{registries: []string{"gcr.io"}},
+			{err: errors.New("connection refused")},
+		}, want: []string{"gcr.io"}},
+		{name: "invalid whitelist keeps last known good", results: []whiteListResult{
+			{registries: []string{"gcr.io"}},
+			{registries: []string{"regex:[invalid"}},
+		}, want: []string{"gcr.io"}},
+		{name: "failure before first success keeps initial", results: []whiteListResult{
+			{err: errors.New("connection refused")},
+		}, want: []string{"docker.io"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			source := &scriptedWhiteListSource{results: tt.results}
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				s.WatchWhiteList(ctx, source, 5*time.Millisecond)
+				close(done)
+			}()
+			for deadline := time.Now().Add(5 * time.Second); source.Calls() < len(tt.results)+1; {
+				if time.Now().After(deadline) {
+					t.Fatalf("whitelist fetched %d times, want at least %d", source.Calls(), len(tt.results)+1)
+				}
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+			<-done
+			if got, _ := s.whiteListRegistries(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("whitelist = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// waitForWhiteList 等待 s 当前使用的白名单变为 want
+func waitForWhiteList(t *testing.T, s *WebhookServer, want []string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		got, _ := s.whiteListRegistries()
+		if reflect.DeepEqual(got, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("whitelist = %v, want %v", got, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWatchWhiteListConfigMap(t *testing.T) {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "whitelist", Namespace: "default"},
+		Data:       map[string]string{WhiteListConfigMapKey: "gcr.io"},
+	}
+	clientset := fake.NewSimpleClientset(configMap)
+	source := &ConfigMapWhiteListSource{Clientset: clientset, Namespace: "default", Name: "whitelist"}
+	if got, err := source.Fetch(context.Background()); err != nil || !reflect.DeepEqual(got, []string{"gcr.io"}) {
+		t.Fatalf("fetch = %v, %v, want [gcr.io]", got, err)
+	}
+
+	s := newTestServer(t, "docker.io")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.WatchWhiteListConfigMap(ctx, source)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	waitForWhiteList(t, s, []string{"gcr.io"})
+
+	// 更新 ConfigMap 之后不需要重启，新的镜像仓库立即生效
+	updated := configMap.DeepCopy()
+	updated.Data[WhiteListConfigMapKey] = "gcr.io\nquay.io, registry.example.com"
+	if _, err := clientset.CoreV1().ConfigMaps("default").Update(context.Background(), updated, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update configmap: %v", err)
+	}
+	waitForWhiteList(t, s, []string{"gcr.io", "quay.io", "registry.example.com"})
+	if resp := s.validate(newAdmissionReview(t, "Pod", newPod("quay.io/team/app:1.0", nil))); !resp.Allowed {
+		t.Errorf("allowed = false for a registry added to the configmap, result %+v", resp.Result)
+	}
+
+	// 内容无效时继续使用上一次成功加载的白名单
+	invalid := updated.DeepCopy()
+	invalid.Data = map[string]string{"other": "docker.io"}
+	if _, err := clientset.CoreV1().ConfigMaps("default").Update(context.Background(), invalid, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update configmap: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got, _ := s.whiteListRegistries(); !reflect.DeepEqual(got, []string{"gcr.io", "quay.io", "registry.example.com"}) {
+		t.Errorf("whitelist = %v, want the last known good whitelist", got)
+	}
+}
+
+func TestFileWhiteListSource(t *testing.T) {
+	path := writeConfig(t, `
+# 公共镜像仓库
+docker.io
+  gcr.io  
+
+# 内部镜像仓库
+*.example.com
+`)
+	s := newTestServer(t)
+	if err := s.LoadWhiteListFromFile(path); err != nil {
+		t.Fatalf("load whitelist: %v", err)
+	}
+	if got, _ := s.whiteListRegistries(); !reflect.DeepEqual(got, []string{"docker.io", "gcr.io", "*.example.com"}) {
+		t.Errorf("whitelist = %v, want [docker.io gcr.io *.example.com]", got)
+	}
+	if err := s.LoadWhiteListFromFile(path + ".missing"); err == nil {
+		t.Errorf("load missing file succeeded, want error")
+	}
+
+	// 修改文件之后，WatchWhiteList 在下一次刷新时更新内存中的白名单
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.WatchWhiteList(ctx, &FileWhiteListSource{Path: path}, 5*time.Millisecond)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	if err := ioutil.WriteFile(path, []byte("quay.io\n# gcr.io\n"), 0644); err != nil {
+		t.Fatalf("write whitelist: %v", err)
+	}
+	waitForWhiteList(t, s, []string{"quay.io"})
+}
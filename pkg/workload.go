@@ -0,0 +1,124 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// checkPodTemplate 对工作负载的 Pod 模板执行与 Pod 相同的校验，返回第一个不满足的策略以及所有的 cause，
+// cause 的字段路径加上了 templatePath 前缀，比如 spec.template.spec.containers[0].image
+func (s *WebhookServer) checkPodTemplate(namespace, what, templatePath string, spec *corev1.PodSpec) (string, []metav1.StatusCause) {
+	causes := s.podViolations(namespace, spec)
+	if len(causes) == 0 {
+		return "", nil
+	}
+	for i := range causes {
+		causes[i].Field = templatePath + "." + causes[i].Field
+	}
+	return fmt.Sprintf("%s: %s", what, causes[0].Message), causes
+}
+
+// forbidden 构造拒绝请求的响应，causes 不为空时放到 Status.Details 中，方便客户端解析具体违反的策略
+func forbidden(req *admissionv1.AdmissionRequest, message string, causes []metav1.StatusCause, warnings []string) *admissionv1.AdmissionResponse {
+	status := &metav1.Status{
+		Code:    http.StatusForbidden,
+		Reason:  metav1.StatusReasonForbidden,
+		Message: message,
+	}
+	if len(causes) > 0 {
+		status.Details = &metav1.StatusDetails{
+			Name:   req.Name,
+			Kind:   req.Kind.Kind,
+			Causes: causes,
+		}
+	}
+	return &admissionv1.AdmissionResponse{
+		Allowed:  false,
+		Warnings: warnings,
+		Result:   status,
+	}
+}
+
+// validateWorkload 校验 StatefulSet、DaemonSet、ReplicaSet 的 Pod 模板
+func (s *WebhookServer) validateWorkload(req *admissionv1.AdmissionRequest, mode EnforcementMode) *admissionv1.AdmissionResponse {
+	var (
+		template *corev1.PodTemplateSpec
+		err      error
+	)
+	switch req.Kind.Kind {
+	case "StatefulSet":
+		var statefulSet appsv1.StatefulSet
+		err = json.Unmarshal(req.Object.Raw, &statefulSet)
+		template = &statefulSet.Spec.Template
+	case "DaemonSet":
+		var daemonSet appsv1.DaemonSet
+		err = json.Unmarshal(req.Object.Raw, &daemonSet)
+		template = &daemonSet.Spec.Template
+	default:
+		var replicaSet appsv1.ReplicaSet
+		err = json.Unmarshal(req.Object.Raw, &replicaSet)
+		template = &replicaSet.Spec.Template
+	}
+	if err != nil {
+		klog.Errorf("Can't unmarshal object raw: %v", err)
+		s.recordDecodeFailure(req.Kind.Kind, "/validate")
+		return &admissionv1.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		}
+	}
+
+	var warnings []string
+	if msg, causes := s.checkPodTemplate(req.Namespace, fmt.Sprintf("%s %s", req.Kind.Kind, req.Name), "spec.template", &template.Spec); msg != "" {
+		if mode == ModeWarn {
+			warnings = append(warnings, msg)
+		} else {
+			return forbidden(req, msg, causes, nil)
+		}
+	}
+
+	return &admissionv1.AdmissionResponse{
+		Allowed:  true,
+		Warnings: warnings,
+		Result: &metav1.Status{
+			Code: http.StatusOK,
+		},
+	}
+}
+
+// requiredLabelKinds 是需要校验 RequiredLabels 的资源类型
+var requiredLabelKinds = []string{"Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "Job", "CronJob", "Service"}
+
+// checkRequiredLabels 要求工作负载和 Service 的 metadata.labels 包含所有 RequiredLabels，返回缺少的 label；
+// 对象无法解析时不在这里处理，由各个资源类型的校验返回解析错误
+func (s *WebhookServer) checkRequiredLabels(req *admissionv1.AdmissionRequest) string {
+	if len(s.RequiredLabels) == 0 || !containsString(requiredLabelKinds, req.Kind.Kind) {
+		return ""
+	}
+	var object struct {
+		metav1.ObjectMeta `json:"metadata"`
+	}
+	if err := json.Unmarshal(req.Object.Raw, &object); err != nil {
+		return ""
+	}
+	var missing []string
+	for _, key := range s.RequiredLabels {
+		if _, ok := object.Labels[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s %s is missing required labels %v!", req.Kind.Kind, req.Name, missing)
+}
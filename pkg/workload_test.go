@@ -0,0 +1,49 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRequiredLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		labels  map[string]string
+		allowed bool
+	}{
+		{name: "deployment with all labels", kind: "Deployment", labels: map[string]string{"team": "infra", "app": "web"}, allowed: true},
+		{name: "deployment missing a label", kind: "Deployment", labels: map[string]string{"app": "web"}, allowed: false},
+		{name: "service without labels", kind: "Service", allowed: false},
+		{name: "pod is not checked", kind: "Pod", allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, "docker.io")
+			s.RequiredLabels = []string{"team", "app"}
+			meta := metav1.ObjectMeta{Name: "test", Namespace: "default", Labels: tt.labels}
+			var obj metav1.Object
+			switch tt.kind {
+			case "Deployment":
+				obj = &appsv1.Deployment{ObjectMeta: meta, Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: newPod("nginx", nil).Spec}}}
+			case "Service":
+				obj = &corev1.Service{ObjectMeta: meta}
+			default:
+				pod := newPod("nginx", nil)
+				pod.ObjectMeta = meta
+				obj = pod
+			}
+			resp := s.validate(newAdmissionReview(t, tt.kind, obj))
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v, result %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if !tt.allowed && !strings.Contains(resp.Result.Message, "team") {
+				t.Errorf("message = %q, want it to name the missing label team", resp.Result.Message)
+			}
+		})
+	}
+}